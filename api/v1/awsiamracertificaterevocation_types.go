@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevocationReason is the reason a certificate is revoked, recorded in the CRL.
+// +kubebuilder:validation:Enum=Unspecified;KeyCompromise;Superseded;CessationOfOperation
+type RevocationReason string
+
+const (
+	RevocationReasonUnspecified          RevocationReason = "Unspecified"
+	RevocationReasonKeyCompromise        RevocationReason = "KeyCompromise"
+	RevocationReasonSuperseded           RevocationReason = "Superseded"
+	RevocationReasonCessationOfOperation RevocationReason = "CessationOfOperation"
+)
+
+// ReasonCode returns the CRL reason code of the RFC 5280 CRLReason.
+func (r RevocationReason) ReasonCode() int {
+	switch r {
+	case RevocationReasonKeyCompromise:
+		return 1
+	case RevocationReasonSuperseded:
+		return 4
+	case RevocationReasonCessationOfOperation:
+		return 5
+	default:
+		return 0
+	}
+}
+
+const (
+	// RevocationConditionResolved is true once the serial number of the
+	// revoked certificate is known.
+	RevocationConditionResolved = "Resolved"
+	// RevocationConditionPublished is true once the certificate is in the CRL
+	// published to Roles Anywhere.
+	RevocationConditionPublished = "Published"
+)
+
+// AwsIamRaCertificateRevocationSpec defines the desired state of AwsIamRaCertificateRevocation.
+// Exactly one of SecretName, PodName and SerialNumber is set.
+// +kubebuilder:validation:XValidation:rule="[has(self.secretName), has(self.podName), has(self.serialNumber)].filter(x, x).size() == 1",message="exactly one of secretName, podName and serialNumber must be set"
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type AwsIamRaCertificateRevocationSpec struct {
+	// SecretName is a kubernetes.io/tls Secret, in the same namespace, whose
+	// current certificate is revoked.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// PodName is a pod, in the same namespace, whose mounted certificate is
+	// revoked.
+	// +optional
+	PodName string `json:"podName,omitempty"`
+
+	// SerialNumber is the hexadecimal serial number of the revoked
+	// certificate, optionally with colons between bytes. It is assumed to be
+	// issued by the controller's CA.
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F:]+$`
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// Reason is recorded in the CRL.
+	// +kubebuilder:default=KeyCompromise
+	// +optional
+	Reason RevocationReason `json:"reason,omitempty"`
+}
+
+// AwsIamRaCertificateRevocationStatus defines the observed state of AwsIamRaCertificateRevocation.
+type AwsIamRaCertificateRevocationStatus struct {
+	// SerialNumber is the serial number of the revoked certificate, in
+	// lowercase hexadecimal. It is recorded once, so that renewing the
+	// certificate in the Secret doesn't change what is revoked.
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// Issuer is the issuer of the revoked certificate, if known.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// NotAfter is when the revoked certificate expires, if known. Expired
+	// certificates are left out of the CRL.
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+
+	// RevokedAt is when the serial number was resolved, recorded as the
+	// revocation time in the CRL.
+	// +optional
+	RevokedAt *metav1.Time `json:"revokedAt,omitempty"`
+
+	// NotifiedPods are the running pods whose sidecar has been told to stop
+	// serving credentials for the certificate.
+	// +optional
+	NotifiedPods []string `json:"notifiedPods,omitempty"`
+
+	// Conditions holds the Resolved and Published conditions.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Serial",type=string,JSONPath=`.status.serialNumber`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.spec.reason`
+// +kubebuilder:printcolumn:name="Published",type=string,JSONPath=`.status.conditions[?(@.type=="Published")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// AwsIamRaCertificateRevocation is the Schema for the awsIamRaCertificateRevocations
+// API. It revokes a certificate used by pods: sidecars holding it stop serving
+// credentials, and certificates issued by the controller's CA are added to the
+// CRL published to Roles Anywhere.
+type AwsIamRaCertificateRevocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsIamRaCertificateRevocationSpec   `json:"spec,omitempty"`
+	Status AwsIamRaCertificateRevocationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AwsIamRaCertificateRevocationList contains a list of AwsIamRaCertificateRevocation.
+type AwsIamRaCertificateRevocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsIamRaCertificateRevocation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsIamRaCertificateRevocation{}, &AwsIamRaCertificateRevocationList{})
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CredentialSecretFormat is the layout of the credentials in the target Secret.
+// +kubebuilder:validation:Enum=SharedCredentialsFile;Keys
+type CredentialSecretFormat string
+
+const (
+	// CredentialSecretFormatSharedCredentialsFile stores an AWS shared
+	// credentials file under the "credentials" key.
+	CredentialSecretFormatSharedCredentialsFile CredentialSecretFormat = "SharedCredentialsFile"
+	// CredentialSecretFormatKeys stores AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN as separate keys.
+	CredentialSecretFormatKeys CredentialSecretFormat = "Keys"
+)
+
+// AwsIamRaCredentialSecretSpec defines the desired state of AwsIamRaCredentialSecret.
+type AwsIamRaCredentialSecretSpec struct {
+	// ProfileName is the AwsIamRaRoleProfile, in the same namespace, to get credentials for.
+	// +kubebuilder:validation:Required
+	ProfileName string `json:"profileName"`
+
+	// CertSecretName is the kubernetes.io/tls Secret holding the certificate
+	// and private key used to call CreateSession.
+	// +kubebuilder:validation:Required
+	CertSecretName string `json:"certSecretName"`
+
+	// SecretName is the Secret to keep filled with credentials. Defaults to
+	// the name of the AwsIamRaCredentialSecret.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Format is the layout of the credentials in the Secret.
+	// +kubebuilder:default=Keys
+	// +optional
+	Format CredentialSecretFormat `json:"format,omitempty"`
+
+	// AwsProfileName is the profile section written to a shared credentials file.
+	// +kubebuilder:default=default
+	// +optional
+	AwsProfileName string `json:"awsProfileName,omitempty"`
+
+	// RefreshBefore is how long before expiry the credentials are refreshed. It
+	// is capped at half of the session duration.
+	// +kubebuilder:default="15m"
+	// +optional
+	RefreshBefore *metav1.Duration `json:"refreshBefore,omitempty"`
+}
+
+// AwsIamRaCredentialSecretStatus defines the observed state of AwsIamRaCredentialSecret.
+type AwsIamRaCredentialSecretStatus struct {
+	// Expiration is when the credentials in the Secret expire.
+	// +optional
+	Expiration *metav1.Time `json:"expiration,omitempty"`
+
+	// LastRefreshTime is when the credentials were last refreshed.
+	// +optional
+	LastRefreshTime *metav1.Time `json:"lastRefreshTime,omitempty"`
+
+	// LastRefreshError is the error of the last failed refresh, cleared once a
+	// refresh succeeds.
+	// +optional
+	LastRefreshError string `json:"lastRefreshError,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.spec.profileName`
+// +kubebuilder:printcolumn:name="Expiration",type=date,JSONPath=`.status.expiration`
+
+// AwsIamRaCredentialSecret is the Schema for the awsIamRaCredentialSecrets API.
+// It keeps a Secret filled with short-lived credentials for a role profile,
+// for consumers that can only read static keys from a Secret.
+type AwsIamRaCredentialSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsIamRaCredentialSecretSpec   `json:"spec,omitempty"`
+	Status AwsIamRaCredentialSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AwsIamRaCredentialSecretList contains a list of AwsIamRaCredentialSecret.
+type AwsIamRaCredentialSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsIamRaCredentialSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsIamRaCredentialSecret{}, &AwsIamRaCredentialSecretList{})
+}
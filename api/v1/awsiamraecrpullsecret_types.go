@@ -0,0 +1,114 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AwsIamRaEcrPullSecretSpec defines the desired state of AwsIamRaEcrPullSecret.
+type AwsIamRaEcrPullSecretSpec struct {
+	// ProfileName is the AwsIamRaRoleProfile, in the same namespace, whose
+	// role is allowed to call ecr:GetAuthorizationToken.
+	// +kubebuilder:validation:Required
+	ProfileName string `json:"profileName"`
+
+	// CertSecretName is the kubernetes.io/tls Secret holding the certificate
+	// and private key used to call CreateSession.
+	// +kubebuilder:validation:Required
+	CertSecretName string `json:"certSecretName"`
+
+	// Region of the registry. Defaults to the region of the profile's trust anchor.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// SecretName is the kubernetes.io/dockerconfigjson Secret written to each
+	// selected namespace. Defaults to the name of the AwsIamRaEcrPullSecret.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// NamespaceSelector selects the namespaces to write the Secret to. If
+	// unset, the Secret is only written to the namespace of the
+	// AwsIamRaEcrPullSecret.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ServiceAccountNames are the ServiceAccounts, in each selected
+	// namespace, to add the Secret to as an imagePullSecret.
+	// +optional
+	ServiceAccountNames []string `json:"serviceAccountNames,omitempty"`
+
+	// RefreshInterval is how often a new token is requested. It is capped at
+	// half of the token lifetime, which is 12 hours.
+	// +kubebuilder:default="6h"
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+}
+
+// AwsIamRaEcrPullSecretStatus defines the observed state of AwsIamRaEcrPullSecret.
+type AwsIamRaEcrPullSecretStatus struct {
+	// Expiration is when the token in the Secrets expires.
+	// +optional
+	Expiration *metav1.Time `json:"expiration,omitempty"`
+
+	// LastRefreshTime is when the token was last refreshed.
+	// +optional
+	LastRefreshTime *metav1.Time `json:"lastRefreshTime,omitempty"`
+
+	// LastRefreshError is the error of the last failed refresh, cleared once a
+	// refresh succeeds.
+	// +optional
+	LastRefreshError string `json:"lastRefreshError,omitempty"`
+
+	// Registry is the registry the token logs in to.
+	// +optional
+	Registry string `json:"registry,omitempty"`
+
+	// Namespaces the Secret is written to.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.spec.profileName`
+// +kubebuilder:printcolumn:name="Registry",type=string,JSONPath=`.status.registry`
+// +kubebuilder:printcolumn:name="Expiration",type=date,JSONPath=`.status.expiration`
+
+// AwsIamRaEcrPullSecret is the Schema for the awsIamRaEcrPullSecrets API. It
+// keeps image pull Secrets for ECR up to date in selected namespaces, using the
+// credentials of a role profile.
+type AwsIamRaEcrPullSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AwsIamRaEcrPullSecretSpec   `json:"spec,omitempty"`
+	Status AwsIamRaEcrPullSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AwsIamRaEcrPullSecretList contains a list of AwsIamRaEcrPullSecret.
+type AwsIamRaEcrPullSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AwsIamRaEcrPullSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AwsIamRaEcrPullSecret{}, &AwsIamRaEcrPullSecretList{})
+}
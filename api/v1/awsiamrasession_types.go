@@ -17,15 +17,263 @@ limitations under the License.
 package v1
 
 import (
+	"slices"
+
 	aws "github.com/aws/aws-sdk-go-v2/aws/arn"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
+	// RoleProfilePodAnnotationKey names the profile a pod uses, or a
+	// comma-separated list of profiles. The first one is the default.
 	RoleProfilePodAnnotationKey = "cloud.dancav.io/aws-iamra-role-profile"
 	CertSecretPodAnnotationKey  = "cloud.dancav.io/aws-iamra-cert-secret"
+	// ContainerProfilesPodAnnotationKey assigns profiles to individual
+	// containers, e.g. "app=writer,fluentbit=logs-reader".
+	ContainerProfilesPodAnnotationKey = "cloud.dancav.io/aws-iamra-container-profiles"
+	// ExcludedContainersPodAnnotationKey lists containers, separated by
+	// commas, that get no AWS environment at all.
+	ExcludedContainersPodAnnotationKey = "cloud.dancav.io/aws-iamra-excluded-containers"
+	// IssuedCertSecretPodAnnotationKey names the Secret the controller issues
+	// the pod's certificate into. It is set by the pod webhook.
+	IssuedCertSecretPodAnnotationKey = "cloud.dancav.io/aws-iamra-issued-cert-secret"
+	// CertManagerCertificatePodAnnotationKey names the cert-manager
+	// Certificate, and the Secret it issues into, that the pod mounts. It is
+	// set by the pod webhook.
+	CertManagerCertificatePodAnnotationKey = "cloud.dancav.io/aws-iamra-cert-manager-certificate"
+	// DeliveryPodAnnotationKey records how the pod gets its credentials. It is
+	// set by the pod webhook.
+	DeliveryPodAnnotationKey = "cloud.dancav.io/aws-iamra-delivery"
+	// ProfileHashesPodAnnotationKey records the settings of its profiles a pod
+	// runs with, as <profile>=<hash> entries separated by commas. It is set by
+	// the pod webhook, by the controller when it hot-reloads the sidecar, and
+	// on the pod templates of workloads it restarts.
+	ProfileHashesPodAnnotationKey = "cloud.dancav.io/aws-iamra-profile-hashes"
+	// DeliveryNamespaceLabelKey sets the delivery of profiles in the
+	// namespace that don't set their own.
+	DeliveryNamespaceLabelKey = "cloud.dancav.io/aws-iamra-delivery"
+	// DefaultProfileNamespaceKey, as an annotation or a label of a namespace,
+	// names the profile of pods in the namespace that don't name one.
+	DefaultProfileNamespaceKey = "cloud.dancav.io/aws-iamra-default-profile"
+	// DefaultCertSecretNamespaceKey, as an annotation or a label of a
+	// namespace, names the cert Secret of pods in the namespace whose profile
+	// uses the Secret source and that don't name one.
+	DefaultCertSecretNamespaceKey = "cloud.dancav.io/aws-iamra-default-cert-secret"
+	// InjectPodAnnotationKey set to "false" opts a pod out of the default
+	// profile of its namespace and of profiles selecting it.
+	InjectPodAnnotationKey = "cloud.dancav.io/aws-iamra-inject"
+	// SuspendedProfilesPodAnnotationKey lists, separated by commas, the
+	// profiles whose credentials the sidecar of a pod was told to stop
+	// serving. It is set by the pod webhook and by the controller.
+	SuspendedProfilesPodAnnotationKey = "cloud.dancav.io/aws-iamra-suspended-profiles"
+	// ActiveUntilPodAnnotationKey lists, separated by commas, the
+	// profile=time pairs of the profiles whose credentials the sidecar of a
+	// pod was told to serve only until the end of their activation window.
+	// It is set by the pod webhook and by the controller.
+	ActiveUntilPodAnnotationKey = "cloud.dancav.io/aws-iamra-active-until"
+	// SuspensionChangedByAnnotationKey records, on a profile, the user who
+	// last suspended or resumed it. It is set by the profile webhook.
+	SuspensionChangedByAnnotationKey = "cloud.dancav.io/aws-iamra-suspension-changed-by"
+	// RequesterAnnotationKey records, as JSON, the user who last changed the
+	// spec of an AwsIamRaCredentialSecret or AwsIamRaEcrPullSecret, or the pod
+	// template of a workload, so that only profiles they may use are used on
+	// their behalf. It is set by the webhooks of these resources.
+	RequesterAnnotationKey = "cloud.dancav.io/aws-iamra-requester"
+	// RequesterTemplateAnnotationKey records, on a workload, the hash of the
+	// pod template RequesterAnnotationKey was recorded for. The requester only
+	// vouches for the pods of the workload while its template has that hash,
+	// so that changes made while the workload webhook was unavailable don't
+	// pass for theirs. It is set by the workload webhook.
+	RequesterTemplateAnnotationKey = "cloud.dancav.io/aws-iamra-requester-template"
+	// ManagedSecretLabelKey marks the Secrets the controller writes. The
+	// controller only caches and watches Secrets with this label.
+	ManagedSecretLabelKey = "cloud.dancav.io/aws-iamra-managed"
+)
+
+// CredentialDelivery is how pods using a profile get their credentials.
+// +kubebuilder:validation:Enum=Sidecar;NodeAgent
+type CredentialDelivery string
+
+const (
+	// CredentialDeliverySidecar injects a credential server sidecar into
+	// every pod.
+	CredentialDeliverySidecar CredentialDelivery = "Sidecar"
+	// CredentialDeliveryNodeAgent has pods get their credentials from the node
+	// agent DaemonSet on their node, which tells pods apart by their IP and
+	// holds the certificate itself.
+	CredentialDeliveryNodeAgent CredentialDelivery = "NodeAgent"
 )
 
+// UpdateStrategyType is how pods using a profile pick up changes to it.
+// +kubebuilder:validation:Enum=HotReload;RollingRestart;None
+type UpdateStrategyType string
+
+const (
+	// UpdateStrategyHotReload pushes the new settings into the running
+	// sidecars. Their pod spec keeps the settings they were created with.
+	UpdateStrategyHotReload UpdateStrategyType = "HotReload"
+	// UpdateStrategyRollingRestart restarts the Deployments, StatefulSets and
+	// DaemonSets of the pods, so that they are recreated with the new settings.
+	UpdateStrategyRollingRestart UpdateStrategyType = "RollingRestart"
+	// UpdateStrategyNone leaves pods alone, only reporting those running with
+	// earlier settings.
+	UpdateStrategyNone UpdateStrategyType = "None"
+)
+
+// CertificateSource is where the certificates of pods using a profile come from.
+// +kubebuilder:validation:Enum=Secret;ControllerCA;CertificateSigningRequest;CertManager;Broker
+type CertificateSource string
+
+const (
+	// CertificateSourceSecret mounts the Secret named in the
+	// cloud.dancav.io/aws-iamra-cert-secret pod annotation.
+	CertificateSourceSecret CertificateSource = "Secret"
+	// CertificateSourceControllerCA has the controller issue a short-lived
+	// certificate per pod, signed by its certificate authority.
+	CertificateSourceControllerCA CertificateSource = "ControllerCA"
+	// CertificateSourceCertificateSigningRequest has the sidecar generate its
+	// private key and request a certificate from the controller's CA through a
+	// Kubernetes CertificateSigningRequest, so that the key never leaves the pod.
+	CertificateSourceCertificateSigningRequest CertificateSource = "CertificateSigningRequest"
+	// CertificateSourceCertManager has the controller create cert-manager
+	// Certificates, shared by the pods of a service account or workload.
+	CertificateSourceCertManager CertificateSource = "CertManager"
+	// CertificateSourceBroker has the sidecar get its credentials from the
+	// credential broker, which holds the certificate, so that pods mount no
+	// private key at all.
+	CertificateSourceBroker CertificateSource = "Broker"
+)
+
+// CertificateScope selects which pods share a certificate issued by cert-manager.
+// +kubebuilder:validation:Enum=ServiceAccount;Workload
+type CertificateScope string
+
+const (
+	// CertificateScopeServiceAccount issues a certificate per service account.
+	CertificateScopeServiceAccount CertificateScope = "ServiceAccount"
+	// CertificateScopeWorkload issues a certificate per workload, i.e. the
+	// Deployment, StatefulSet, DaemonSet, Job or other controller of the pod,
+	// or the pod itself if it has none.
+	CertificateScopeWorkload CertificateScope = "Workload"
+)
+
+// CertificateSignerName is the signerName of the CertificateSigningRequests
+// the controller approves and signs.
+const CertificateSignerName = "cloud.dancav.io/iamra"
+
+const (
+	// BrokerTokenAudience is the audience of the service account tokens
+	// sidecars authenticate to the credential broker with.
+	BrokerTokenAudience = "cloud.dancav.io/iamra-broker"
+	// BrokerCredentialsPath is where the credential broker serves
+	// credentials, for the profile named in the profile query parameter.
+	BrokerCredentialsPath = "/v1/credentials"
+)
+
+// CertificateSpec configures the certificates of pods using a profile.
+type CertificateSpec struct {
+	// Source of the certificates. A cert Secret named in the pod annotation
+	// always takes precedence.
+	// +kubebuilder:default=Secret
+	// +optional
+	Source CertificateSource `json:"source,omitempty"`
+
+	// Duration of the certificates issued by the controller. Defaults to the
+	// controller's --pod-certificate-duration.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// CertManager configures the Certificates of the CertManager source.
+	// +optional
+	CertManager *CertManagerCertificateSpec `json:"certManager,omitempty"`
+
+	// ChainKey is the key of the cert Secret holding PEM-encoded intermediate
+	// certificates, sent after any that follow the certificate in tls.crt.
+	// Secrets without the key are fine. Defaults to ca.crt.
+	// +optional
+	ChainKey string `json:"chainKey,omitempty"`
+
+	// PKCS12Key is the key of the cert Secret holding a PKCS#12 bundle with
+	// the certificate, its chain and private key, read instead of tls.crt and
+	// tls.key.
+	// +optional
+	PKCS12Key string `json:"pkcs12Key,omitempty"`
+
+	// PassphraseSecretRef selects the Secret holding the passphrase of an
+	// encrypted private key or PKCS#12 bundle. The webhook mounts it into the
+	// sidecar apart from the certificate.
+	// +optional
+	PassphraseSecretRef *SecretKeyReference `json:"passphraseSecretRef,omitempty"`
+}
+
+// SecretKeyReference selects a key of a Secret in the namespace of the profile.
+type SecretKeyReference struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:default=passphrase
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// CertManagerCertificateSpec configures the cert-manager Certificates the
+// controller creates for pods using a profile.
+type CertManagerCertificateSpec struct {
+	// IssuerRef references the Issuer, in the namespace of the profile, or
+	// the ClusterIssuer that signs the certificates.
+	IssuerRef IssuerReference `json:"issuerRef"`
+
+	// Scope selects which pods share a certificate.
+	// +kubebuilder:default=ServiceAccount
+	// +optional
+	Scope CertificateScope `json:"scope,omitempty"`
+
+	// Subject of the certificates. Every field is a Go template, executed with
+	// .Namespace, .ServiceAccount, .Workload (the workload name, empty with
+	// the ServiceAccount scope), .WorkloadKind, .Profile and .TrustDomain.
+	// Defaults to O={{.Namespace}}, OU={{.ServiceAccount}} and a CN of the
+	// workload or service account name, plus the SPIFFE ID of the service
+	// account as URI SAN.
+	// +optional
+	Subject *CertificateSubjectTemplate `json:"subject,omitempty"`
+
+	// RenewBefore is how long before expiry cert-manager renews the
+	// certificates. Defaults to cert-manager's default.
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// IssuerReference references a cert-manager issuer.
+type IssuerReference struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Kind of the issuer, Issuer or ClusterIssuer.
+	// +kubebuilder:default=Issuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer, for external issuers.
+	// +kubebuilder:default=cert-manager.io
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// CertificateSubjectTemplate holds templates for the subject and SANs of a certificate.
+type CertificateSubjectTemplate struct {
+	// +optional
+	CommonName string `json:"commonName,omitempty"`
+	// +optional
+	Organizations []string `json:"organizations,omitempty"`
+	// +optional
+	OrganizationalUnits []string `json:"organizationalUnits,omitempty"`
+	// +optional
+	URIs []string `json:"uris,omitempty"`
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
 type ARN string
 
 // AwsIamRaRoleProfileSpec defines the desired state of AwsIamRaRoleProfile.
@@ -46,6 +294,175 @@ type AwsIamRaRoleProfileSpec struct {
 	// +kubebuilder:validation:MinLength=2
 	// +kubebuilder:validation:MaxLength=64
 	RoleSessionName string `json:"roleSessionName,omitempty"`
+
+	// ImdsV2Only makes the credential server reject IMDSv1 requests, i.e.
+	// requests that don't carry a session token.
+	// +optional
+	ImdsV2Only bool `json:"imdsV2Only,omitempty"`
+
+	// Certificate configures where the certificates of pods using the
+	// profile come from. If several profiles are used by a pod, the first
+	// one's settings apply.
+	// +optional
+	Certificate *CertificateSpec `json:"certificate,omitempty"`
+
+	// Delivery selects whether pods using the profile get its credentials
+	// from a sidecar or from the node agent. Defaults to the
+	// cloud.dancav.io/aws-iamra-delivery label of the namespace, or Sidecar.
+	// Certificate settings don't apply to the NodeAgent delivery.
+	// +optional
+	Delivery CredentialDelivery `json:"delivery,omitempty"`
+
+	// UpdateStrategy selects how pods using the profile pick up changes to it.
+	// +kubebuilder:default=HotReload
+	// +optional
+	UpdateStrategy UpdateStrategyType `json:"updateStrategy,omitempty"`
+
+	// Sidecar overrides the controller's sidecar settings for pods using the
+	// profile. They take effect when pods are created, so changes are only
+	// picked up by pods restarted afterwards.
+	// +optional
+	Sidecar *SidecarOverrides `json:"sidecar,omitempty"`
+
+	// PodSelector selects pods in the namespace that use the profile without
+	// naming it in an annotation. Pods naming profiles of their own aren't
+	// selected, and a pod selected by several profiles is rejected.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// ServiceAccountNames limits the pods the profile selects to those of the
+	// listed service accounts. Without a podSelector, it selects every pod of
+	// the service accounts.
+	// +optional
+	ServiceAccountNames []string `json:"serviceAccountNames,omitempty"`
+
+	// AllowedServiceAccounts restricts the profile to pods of these service
+	// accounts. Pods of others are rejected, and the controller doesn't
+	// update pods that got past the webhook. All service accounts of the
+	// namespace are allowed if unset.
+	// +optional
+	AllowedServiceAccounts *AllowedServiceAccounts `json:"allowedServiceAccounts,omitempty"`
+
+	// RequireUsePermission admits pods using the profile only if the user
+	// creating them may "use" the profile, i.e. has the use verb on
+	// awsiamraroleprofiles/<name>. For pods that built-in controllers create
+	// for a workload, that is the user who last changed the workload's pod
+	// template. The IamRaManagerConfig can require it for every profile.
+	// +optional
+	RequireUsePermission bool `json:"requireUsePermission,omitempty"`
+
+	// Suspended cuts the AWS access of every pod using the profile: their
+	// sidecars stop serving credentials and drop those they cached, and new
+	// pods are admitted without credentials until it is unset.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// ActiveFrom is when pods using the profile start getting AWS
+	// credentials. Until then, they are admitted without.
+	// +optional
+	ActiveFrom *metav1.Time `json:"activeFrom,omitempty"`
+
+	// ActiveUntil is when pods using the profile stop getting AWS
+	// credentials. The credentials served before then expire by then.
+	// +optional
+	ActiveUntil *metav1.Time `json:"activeUntil,omitempty"`
+
+	// Schedules restrict, within activeFrom and activeUntil, when pods get
+	// AWS credentials to the windows of any of them.
+	// +optional
+	Schedules []ProfileSchedule `json:"schedules,omitempty"`
+}
+
+// Weekday is a day of the week.
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// ProfileSchedule is a recurring window during which a profile is active.
+type ProfileSchedule struct {
+	// Days are the days of the week the window opens on. Defaults to every
+	// day.
+	// +listType=set
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// Start is the time of day, as HH:MM, the window opens at.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// End is the time of day, as HH:MM, the window closes at. Windows ending
+	// at or before their start close on the next day.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+
+	// TimeZone is the IANA time zone of start and end, e.g. Europe/Paris.
+	// Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// HasActivationWindow reports whether the profile is only active at times.
+func (spec *AwsIamRaRoleProfileSpec) HasActivationWindow() bool {
+	return spec.ActiveFrom != nil || spec.ActiveUntil != nil || len(spec.Schedules) > 0
+}
+
+// CertificateSource returns the source of the certificates of pods using the profile.
+func (spec *AwsIamRaRoleProfileSpec) CertificateSource() CertificateSource {
+	if spec.Certificate == nil || spec.Certificate.Source == "" {
+		return CertificateSourceSecret
+	}
+	return spec.Certificate.Source
+}
+
+// AllowedServiceAccounts lists the service accounts, in the namespace of the
+// profile, whose pods may use it: those named, and those whose labels match
+// the selector.
+type AllowedServiceAccounts struct {
+	// Names of the allowed service accounts.
+	// +optional
+	Names []string `json:"names,omitempty"`
+
+	// Selector matches the labels of the allowed service accounts.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// Allows reports whether pods of the service account with the name and
+// labels may use the profile. A nil list allows every service account.
+func (allowed *AllowedServiceAccounts) Allows(name string, serviceAccountLabels map[string]string) bool {
+	if allowed == nil || slices.Contains(allowed.Names, name) {
+		return true
+	}
+	if allowed.Selector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(allowed.Selector)
+	return err == nil && selector.Matches(labels.Set(serviceAccountLabels))
+}
+
+// SelectsPods reports whether the profile selects pods by their labels or
+// service account.
+func (spec *AwsIamRaRoleProfileSpec) SelectsPods() bool {
+	return spec.PodSelector != nil || len(spec.ServiceAccountNames) > 0
+}
+
+// UpdateStrategyType returns how pods using the profile pick up changes to it.
+func (spec *AwsIamRaRoleProfileSpec) UpdateStrategyType() UpdateStrategyType {
+	if spec.UpdateStrategy == "" {
+		return UpdateStrategyHotReload
+	}
+	return spec.UpdateStrategy
+}
+
+// CredentialDelivery returns how pods using the profile get their
+// credentials, given the labels of its namespace.
+func (spec *AwsIamRaRoleProfileSpec) CredentialDelivery(namespaceLabels map[string]string) CredentialDelivery {
+	if spec.Delivery != "" {
+		return spec.Delivery
+	}
+	if CredentialDelivery(namespaceLabels[DeliveryNamespaceLabelKey]) == CredentialDeliveryNodeAgent {
+		return CredentialDeliveryNodeAgent
+	}
+	return CredentialDeliverySidecar
 }
 
 func (arn ARN) IsValid() bool {
@@ -56,14 +473,174 @@ func (arn ARN) Parse() (aws.ARN, error) {
 	return aws.Parse(string(arn))
 }
 
+// ProfileConditionCertificatesExpiring is True while certificates of pods
+// using the profile are expiring, expired, or expire before the sessions
+// obtained with them would end.
+const ProfileConditionCertificatesExpiring = "CertificatesExpiring"
+
+// ProfileUseVerb is the verb that allows pods to use a profile requiring the
+// use permission.
+const ProfileUseVerb = "use"
+
+// ProfileConditionServiceAccountDisallowed is True while pods using the
+// profile run as service accounts its allowedServiceAccounts don't allow.
+const ProfileConditionServiceAccountDisallowed = "ServiceAccountDisallowed"
+
+// ProfileConditionSuspended is True while the profile is suspended.
+const ProfileConditionSuspended = "Suspended"
+
+// ProfileConditionInactive is True while the profile is outside its
+// activation window.
+const ProfileConditionInactive = "Inactive"
+
+// ProfileConditionPodsUpToDate is True while every pod using the profile runs
+// with its current settings.
+const ProfileConditionPodsUpToDate = "PodsUpToDate"
+
+// ProfileConditionInjectionMissed is True while pods using the profile run
+// without the credentials the pod webhook injects, because they were admitted
+// while it was down, mis-configured or bypassed.
+const ProfileConditionInjectionMissed = "InjectionMissed"
+
+// CertificateExpiryReason is why a certificate is reported on a profile.
+type CertificateExpiryReason string
+
+const (
+	// CertificateExpired certificates are past their expiry.
+	CertificateExpired CertificateExpiryReason = "Expired"
+	// CertificateExpiring certificates expire within the controller's
+	// --certificate-expiry-window, or the last third of their lifetime if
+	// that is shorter.
+	CertificateExpiring CertificateExpiryReason = "Expiring"
+	// CertificateShorterThanSession certificates expire before a session of
+	// the profile's durationSeconds would.
+	CertificateShorterThanSession CertificateExpiryReason = "ShorterThanSession"
+)
+
+// CertificateExpiry reports a certificate of pods using a profile.
+type CertificateExpiry struct {
+	// SecretName is the kubernetes.io/tls Secret holding the certificate.
+	SecretName string `json:"secretName"`
+
+	// NotAfter is when the certificate expires.
+	NotAfter metav1.Time `json:"notAfter"`
+
+	Reason CertificateExpiryReason `json:"reason"`
+
+	// Pods using the certificate.
+	// +optional
+	Pods []string `json:"pods,omitempty"`
+}
+
+// ProfileRolloutStatus reports how pods using a profile are updated to its
+// current settings.
+type ProfileRolloutStatus struct {
+	// Strategy the pods are updated with.
+	Strategy UpdateStrategyType `json:"strategy"`
+
+	// ProfileHash identifies the current settings of the profile.
+	ProfileHash string `json:"profileHash"`
+
+	// UpdatedPods is the number of pods running with the current settings.
+	UpdatedPods int32 `json:"updatedPods"`
+
+	// OutdatedPods still run with earlier settings.
+	// +optional
+	OutdatedPods []string `json:"outdatedPods,omitempty"`
+
+	// RestartedWorkloads lists the workloads, as <kind>/<name>, restarted for
+	// the current settings.
+	// +optional
+	RestartedWorkloads []string `json:"restartedWorkloads,omitempty"`
+
+	// Failures of the last attempt to update pods.
+	// +optional
+	Failures []string `json:"failures,omitempty"`
+}
+
+// ProfileSuspensionStatus records the last time the profile was suspended or
+// resumed.
+type ProfileSuspensionStatus struct {
+	// Suspended is the state the controller last applied.
+	Suspended bool `json:"suspended"`
+
+	// Since is when the controller applied it.
+	Since metav1.Time `json:"since"`
+
+	// By is the user who suspended or resumed the profile.
+	// +optional
+	By string `json:"by,omitempty"`
+
+	// PendingPods are the pods, as namespace/name, whose sidecar hasn't been
+	// told yet.
+	// +optional
+	PendingPods []string `json:"pendingPods,omitempty"`
+}
+
+// ProfileActivationStatus reports where the profile stands in its activation
+// window.
+type ProfileActivationStatus struct {
+	// Active is whether the profile is within its activation window.
+	Active bool `json:"active"`
+
+	// NextTransition is when the profile next becomes active or inactive.
+	// Unset if it never does.
+	// +optional
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+
+	// PendingPods are the pods, as namespace/name, whose sidecar hasn't been
+	// told of the last transition yet.
+	// +optional
+	PendingPods []string `json:"pendingPods,omitempty"`
+}
+
 // AwsIamRaRoleProfileStatus defines the observed state of AwsIamRaRoleProfile.
 type AwsIamRaRoleProfileStatus struct {
 	ActivePods []string `json:"activePods,omitempty"`
+
+	// Conditions of the profile.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ExpiringCertificates lists the certificates of pods using the profile
+	// that need attention, and the pods affected.
+	// +optional
+	ExpiringCertificates []CertificateExpiry `json:"expiringCertificates,omitempty"`
+
+	// Rollout reports how pods using the profile are updated to its current
+	// settings.
+	// +optional
+	Rollout *ProfileRolloutStatus `json:"rollout,omitempty"`
+
+	// MissedInjections lists the pods, as namespace/name, using the profile
+	// that the pod webhook didn't inject.
+	// +optional
+	MissedInjections []string `json:"missedInjections,omitempty"`
+
+	// DisallowedPods lists the pods, as namespace/name, using the profile
+	// with a service account it doesn't allow. They aren't updated.
+	// +optional
+	DisallowedPods []string `json:"disallowedPods,omitempty"`
+
+	// Suspension reports when and by whom the profile was last suspended or
+	// resumed.
+	// +optional
+	Suspension *ProfileSuspensionStatus `json:"suspension,omitempty"`
+
+	// Activation reports whether the profile is within its activation
+	// window, and when that changes next. Unset for profiles without one.
+	// +optional
+	Activation *ProfileActivationStatus `json:"activation,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="RoleArn",type=string,JSONPath=`.spec.roleArn`
+// +kubebuilder:printcolumn:name="Suspended",type=boolean,JSONPath=`.spec.suspended`
+// +kubebuilder:printcolumn:name="Active",type=boolean,JSONPath=`.status.activation.active`
+// +kubebuilder:printcolumn:name="Next-Transition",type=string,JSONPath=`.status.activation.nextTransition`
 
 // AwsIamRaRoleProfile is the Schema for the awsIamRaRoleProfiles API.
 type AwsIamRaRoleProfile struct {
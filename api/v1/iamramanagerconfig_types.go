@@ -0,0 +1,264 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ManagerConfigConditionApplied is True while the controller uses the
+	// settings of the IamRaManagerConfig.
+	ManagerConfigConditionApplied = "Applied"
+	// ManagerConfigConditionSidecarsUpToDate is True while every sidecar runs
+	// the configured image.
+	ManagerConfigConditionSidecarsUpToDate = "SidecarsUpToDate"
+)
+
+// SidecarImagePodAnnotationKey records, in the pod template of a workload, the
+// sidecar image the controller restarted it for.
+const SidecarImagePodAnnotationKey = "cloud.dancav.io/aws-iamra-sidecar-image"
+
+// SidecarLogLevel is the verbosity of the sidecar: debug, info, error, or an
+// integer for more verbose debug logs.
+// +kubebuilder:validation:Pattern=`^(debug|info|error|[1-9][0-9]*)$`
+type SidecarLogLevel string
+
+// SidecarConfig configures the sidecar injected into pods. Unset fields keep
+// the value of the layer below: the resource overrides the file, which
+// overrides the controller's defaults.
+type SidecarConfig struct {
+	// Image of the sidecar. Defaults to the AWS_IAMRA_MANAGER_SIDECAR_IMAGE
+	// environment variable of the controller.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ImagePullPolicy of the sidecar image.
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// ImagePullSecrets are added to pods that don't list them already, so
+	// that they can pull the sidecar image from a private registry.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Resources of the sidecar container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// SecurityContext of the sidecar container. It must comply with the
+	// "restricted" Pod Security Standard on its own, so that pods in
+	// namespaces enforcing it are admitted. Defaults to running as user 65532
+	// without privilege escalation, capabilities or an unconfined seccomp
+	// profile.
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Port the sidecar serves the first profile of a pod on. Further profiles
+	// are served on the ports after it. Defaults to 9911.
+	// +kubebuilder:validation:Minimum=1024
+	// +kubebuilder:validation:Maximum=65000
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// LogLevel of the sidecar. Defaults to info.
+	// +optional
+	LogLevel SidecarLogLevel `json:"logLevel,omitempty"`
+}
+
+// SidecarOverrides are the sidecar settings a profile may change for the pods
+// using it. If several profiles are used by a pod, the first one's apply.
+type SidecarOverrides struct {
+	// Resources of the sidecar container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// LogLevel of the sidecar.
+	// +optional
+	LogLevel SidecarLogLevel `json:"logLevel,omitempty"`
+}
+
+// SidecarUpgradePolicy is what the controller does about pods whose sidecar
+// runs an image other than the configured one.
+// +kubebuilder:validation:Enum=Report;RollingRestart
+type SidecarUpgradePolicy string
+
+const (
+	// SidecarUpgradeReport only counts the pods.
+	SidecarUpgradeReport SidecarUpgradePolicy = "Report"
+	// SidecarUpgradeRollingRestart also restarts their Deployments,
+	// StatefulSets and DaemonSets, a few at a time.
+	SidecarUpgradeRollingRestart SidecarUpgradePolicy = "RollingRestart"
+)
+
+// SidecarUpgradeSpec configures how pods pick up a new sidecar image.
+type SidecarUpgradeSpec struct {
+	// Policy for pods running an outdated sidecar image. Defaults to Report.
+	// +optional
+	Policy SidecarUpgradePolicy `json:"policy,omitempty"`
+
+	// MaxPodsInFlight limits the outdated pods of the workloads being
+	// restarted at a time, along with their new pods that aren't ready yet.
+	// Workloads are restarted in waves that stay within the limit, except
+	// that a workload with more pods is restarted on its own. Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxPodsInFlight int32 `json:"maxPodsInFlight,omitempty"`
+}
+
+// MissedInjectionPolicy is what the controller does about pods using a
+// profile that the pod webhook didn't inject.
+// +kubebuilder:validation:Enum=Report;Evict
+type MissedInjectionPolicy string
+
+const (
+	// MissedInjectionReport only reports the pods, on their profile and with
+	// an Event.
+	MissedInjectionReport MissedInjectionPolicy = "Report"
+	// MissedInjectionEvict also evicts the pods that have a controller, so
+	// that the webhook injects the pods replacing them. Evictions respect
+	// PodDisruptionBudgets.
+	MissedInjectionEvict MissedInjectionPolicy = "Evict"
+)
+
+// MissedInjectionSpec configures how pods the pod webhook didn't inject are
+// handled.
+type MissedInjectionSpec struct {
+	// Policy for pods the webhook didn't inject. Defaults to Report.
+	// +optional
+	Policy MissedInjectionPolicy `json:"policy,omitempty"`
+}
+
+// EcrPullSecretsSpec configures AwsIamRaEcrPullSecrets.
+type EcrPullSecretsSpec struct {
+	// SourceNamespaces are the namespaces whose AwsIamRaEcrPullSecrets may
+	// write to the namespaces their namespaceSelector selects. Those of other
+	// namespaces may only write to their own.
+	// +optional
+	SourceNamespaces []string `json:"sourceNamespaces,omitempty"`
+}
+
+// IamRaManagerConfigSpec defines the desired state of IamRaManagerConfig.
+type IamRaManagerConfigSpec struct {
+	// Sidecar configures the sidecar injected into pods.
+	// +optional
+	Sidecar SidecarConfig `json:"sidecar,omitempty"`
+
+	// SidecarUpgrade configures how running pods pick up a new sidecar image.
+	// +optional
+	SidecarUpgrade SidecarUpgradeSpec `json:"sidecarUpgrade,omitempty"`
+
+	// MissedInjection configures how pods the pod webhook didn't inject are
+	// handled.
+	// +optional
+	MissedInjection MissedInjectionSpec `json:"missedInjection,omitempty"`
+
+	// RequireProfileUsePermission requires the use permission of every
+	// profile, as if they all set requireUsePermission. Defaults to false.
+	// +optional
+	RequireProfileUsePermission *bool `json:"requireProfileUsePermission,omitempty"`
+
+	// EcrPullSecrets configures AwsIamRaEcrPullSecrets.
+	// +optional
+	EcrPullSecrets EcrPullSecretsSpec `json:"ecrPullSecrets,omitempty"`
+}
+
+// OutdatedSidecars counts the pods of a namespace and profile whose sidecar
+// runs an outdated image. Pods are counted under their first profile.
+type OutdatedSidecars struct {
+	Namespace string `json:"namespace"`
+	Profile   string `json:"profile"`
+	Pods      int32  `json:"pods"`
+}
+
+// SidecarImageStatus reports the pods running an outdated sidecar image.
+type SidecarImageStatus struct {
+	// Image is the configured sidecar image.
+	Image string `json:"image,omitempty"`
+
+	// UpdatedPods run the configured image.
+	UpdatedPods int32 `json:"updatedPods"`
+
+	// OutdatedPods run another image.
+	OutdatedPods int32 `json:"outdatedPods"`
+
+	// Outdated counts the outdated pods by namespace and profile.
+	// +optional
+	Outdated []OutdatedSidecars `json:"outdated,omitempty"`
+
+	// RestartingWorkloads are the workloads, as namespace/kind/name, restarted
+	// for the configured image that haven't finished rolling out.
+	// +optional
+	RestartingWorkloads []string `json:"restartingWorkloads,omitempty"`
+
+	// BlockedWorkloads are the workloads with outdated pods that can't be
+	// restarted, and why.
+	// +optional
+	BlockedWorkloads []string `json:"blockedWorkloads,omitempty"`
+}
+
+// IamRaManagerConfigStatus defines the observed state of IamRaManagerConfig.
+type IamRaManagerConfigStatus struct {
+	// ObservedGeneration is the generation the Applied condition refers to.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Sidecars reports the pods running an outdated sidecar image.
+	// +optional
+	Sidecars *SidecarImageStatus `json:"sidecars,omitempty"`
+
+	// Conditions holds the Applied and SidecarsUpToDate conditions.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.sidecar.image`
+// +kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].status`
+// +kubebuilder:printcolumn:name="Outdated",type=integer,JSONPath=`.status.sidecars.outdatedPods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IamRaManagerConfig is the Schema for the iamRaManagerConfigs API. It
+// configures the controller at runtime: the one named by the controller's
+// --config-name flag is applied over its --config-file, and changes take
+// effect for pods created afterwards.
+type IamRaManagerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IamRaManagerConfigSpec   `json:"spec,omitempty"`
+	Status IamRaManagerConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IamRaManagerConfigList contains a list of IamRaManagerConfig.
+type IamRaManagerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IamRaManagerConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IamRaManagerConfig{}, &IamRaManagerConfigList{})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ARNPattern matches ARNs, with * standing for any sequence of characters,
+// "/" and ":" included.
+// +kubebuilder:validation:MinLength=1
+type ARNPattern string
+
+// IamRaPolicySpec defines the AwsIamRaRoleProfiles allowed in the namespaces
+// it selects. Unset fields don't restrict profiles.
+type IamRaPolicySpec struct {
+	// NamespaceSelector selects the namespaces whose profiles the policy
+	// applies to. Defaults to every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllowedTrustAnchors are patterns of the trust anchor ARNs profiles may
+	// use.
+	// +optional
+	AllowedTrustAnchors []ARNPattern `json:"allowedTrustAnchors,omitempty"`
+
+	// AllowedProfiles are patterns of the Roles Anywhere profile ARNs
+	// profiles may use.
+	// +optional
+	AllowedProfiles []ARNPattern `json:"allowedProfiles,omitempty"`
+
+	// AllowedRoles are patterns of the role ARNs profiles may assume.
+	// +optional
+	AllowedRoles []ARNPattern `json:"allowedRoles,omitempty"`
+
+	// AllowedAccounts are the AWS accounts the trust anchor, profile and role
+	// ARNs of profiles may belong to.
+	// +optional
+	AllowedAccounts []string `json:"allowedAccounts,omitempty"`
+
+	// MaxDurationSeconds caps the durationSeconds of profiles.
+	// +kubebuilder:validation:Minimum=900
+	// +kubebuilder:validation:Maximum=43200
+	// +optional
+	MaxDurationSeconds int32 `json:"maxDurationSeconds,omitempty"`
+
+	// RoleSessionNames requires profiles to set a roleSessionName matching
+	// one of these patterns. They are Go templates, executed with .Namespace
+	// and .Profile, whose output may use * like ARN patterns.
+	// +optional
+	RoleSessionNames []string `json:"roleSessionNames,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IamRaPolicy is the Schema for the iamRaPolicies API. It restricts the
+// AwsIamRaRoleProfiles of the namespaces it selects: the profile webhook
+// rejects profiles that any of the policies selecting their namespace
+// doesn't allow.
+type IamRaPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IamRaPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IamRaPolicyList contains a list of IamRaPolicy.
+type IamRaPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IamRaPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IamRaPolicy{}, &IamRaPolicyList{})
+}
@@ -21,11 +21,38 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AwsIamRaRoleProfile) DeepCopyInto(out *AwsIamRaRoleProfile) {
+func (in *AllowedServiceAccounts) DeepCopyInto(out *AllowedServiceAccounts) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AllowedServiceAccounts.
+func (in *AllowedServiceAccounts) DeepCopy() *AllowedServiceAccounts {
+	if in == nil {
+		return nil
+	}
+	out := new(AllowedServiceAccounts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaCertificateRevocation) DeepCopyInto(out *AwsIamRaCertificateRevocation) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -33,18 +60,18 @@ func (in *AwsIamRaRoleProfile) DeepCopyInto(out *AwsIamRaRoleProfile) {
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfile.
-func (in *AwsIamRaRoleProfile) DeepCopy() *AwsIamRaRoleProfile {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCertificateRevocation.
+func (in *AwsIamRaCertificateRevocation) DeepCopy() *AwsIamRaCertificateRevocation {
 	if in == nil {
 		return nil
 	}
-	out := new(AwsIamRaRoleProfile)
+	out := new(AwsIamRaCertificateRevocation)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *AwsIamRaRoleProfile) DeepCopyObject() runtime.Object {
+func (in *AwsIamRaCertificateRevocation) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -52,31 +79,31 @@ func (in *AwsIamRaRoleProfile) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AwsIamRaRoleProfileList) DeepCopyInto(out *AwsIamRaRoleProfileList) {
+func (in *AwsIamRaCertificateRevocationList) DeepCopyInto(out *AwsIamRaCertificateRevocationList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]AwsIamRaRoleProfile, len(*in))
+		*out = make([]AwsIamRaCertificateRevocation, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileList.
-func (in *AwsIamRaRoleProfileList) DeepCopy() *AwsIamRaRoleProfileList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCertificateRevocationList.
+func (in *AwsIamRaCertificateRevocationList) DeepCopy() *AwsIamRaCertificateRevocationList {
 	if in == nil {
 		return nil
 	}
-	out := new(AwsIamRaRoleProfileList)
+	out := new(AwsIamRaCertificateRevocationList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *AwsIamRaRoleProfileList) DeepCopyObject() runtime.Object {
+func (in *AwsIamRaCertificateRevocationList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -84,36 +111,1038 @@ func (in *AwsIamRaRoleProfileList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AwsIamRaRoleProfileSpec) DeepCopyInto(out *AwsIamRaRoleProfileSpec) {
+func (in *AwsIamRaCertificateRevocationSpec) DeepCopyInto(out *AwsIamRaCertificateRevocationSpec) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileSpec.
-func (in *AwsIamRaRoleProfileSpec) DeepCopy() *AwsIamRaRoleProfileSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCertificateRevocationSpec.
+func (in *AwsIamRaCertificateRevocationSpec) DeepCopy() *AwsIamRaCertificateRevocationSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(AwsIamRaRoleProfileSpec)
+	out := new(AwsIamRaCertificateRevocationSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AwsIamRaRoleProfileStatus) DeepCopyInto(out *AwsIamRaRoleProfileStatus) {
+func (in *AwsIamRaCertificateRevocationStatus) DeepCopyInto(out *AwsIamRaCertificateRevocationStatus) {
 	*out = *in
-	if in.ActivePods != nil {
-		in, out := &in.ActivePods, &out.ActivePods
+	if in.NotAfter != nil {
+		in, out := &in.NotAfter, &out.NotAfter
+		*out = (*in).DeepCopy()
+	}
+	if in.RevokedAt != nil {
+		in, out := &in.RevokedAt, &out.RevokedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.NotifiedPods != nil {
+		in, out := &in.NotifiedPods, &out.NotifiedPods
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileStatus.
-func (in *AwsIamRaRoleProfileStatus) DeepCopy() *AwsIamRaRoleProfileStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCertificateRevocationStatus.
+func (in *AwsIamRaCertificateRevocationStatus) DeepCopy() *AwsIamRaCertificateRevocationStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(AwsIamRaRoleProfileStatus)
+	out := new(AwsIamRaCertificateRevocationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaCredentialSecret) DeepCopyInto(out *AwsIamRaCredentialSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCredentialSecret.
+func (in *AwsIamRaCredentialSecret) DeepCopy() *AwsIamRaCredentialSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaCredentialSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaCredentialSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaCredentialSecretList) DeepCopyInto(out *AwsIamRaCredentialSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AwsIamRaCredentialSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCredentialSecretList.
+func (in *AwsIamRaCredentialSecretList) DeepCopy() *AwsIamRaCredentialSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaCredentialSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaCredentialSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaCredentialSecretSpec) DeepCopyInto(out *AwsIamRaCredentialSecretSpec) {
+	*out = *in
+	if in.RefreshBefore != nil {
+		in, out := &in.RefreshBefore, &out.RefreshBefore
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCredentialSecretSpec.
+func (in *AwsIamRaCredentialSecretSpec) DeepCopy() *AwsIamRaCredentialSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaCredentialSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaCredentialSecretStatus) DeepCopyInto(out *AwsIamRaCredentialSecretStatus) {
+	*out = *in
+	if in.Expiration != nil {
+		in, out := &in.Expiration, &out.Expiration
+		*out = (*in).DeepCopy()
+	}
+	if in.LastRefreshTime != nil {
+		in, out := &in.LastRefreshTime, &out.LastRefreshTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaCredentialSecretStatus.
+func (in *AwsIamRaCredentialSecretStatus) DeepCopy() *AwsIamRaCredentialSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaCredentialSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaEcrPullSecret) DeepCopyInto(out *AwsIamRaEcrPullSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaEcrPullSecret.
+func (in *AwsIamRaEcrPullSecret) DeepCopy() *AwsIamRaEcrPullSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaEcrPullSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaEcrPullSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaEcrPullSecretList) DeepCopyInto(out *AwsIamRaEcrPullSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AwsIamRaEcrPullSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaEcrPullSecretList.
+func (in *AwsIamRaEcrPullSecretList) DeepCopy() *AwsIamRaEcrPullSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaEcrPullSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaEcrPullSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaEcrPullSecretSpec) DeepCopyInto(out *AwsIamRaEcrPullSecretSpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServiceAccountNames != nil {
+		in, out := &in.ServiceAccountNames, &out.ServiceAccountNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaEcrPullSecretSpec.
+func (in *AwsIamRaEcrPullSecretSpec) DeepCopy() *AwsIamRaEcrPullSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaEcrPullSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaEcrPullSecretStatus) DeepCopyInto(out *AwsIamRaEcrPullSecretStatus) {
+	*out = *in
+	if in.Expiration != nil {
+		in, out := &in.Expiration, &out.Expiration
+		*out = (*in).DeepCopy()
+	}
+	if in.LastRefreshTime != nil {
+		in, out := &in.LastRefreshTime, &out.LastRefreshTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaEcrPullSecretStatus.
+func (in *AwsIamRaEcrPullSecretStatus) DeepCopy() *AwsIamRaEcrPullSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaEcrPullSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaRoleProfile) DeepCopyInto(out *AwsIamRaRoleProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfile.
+func (in *AwsIamRaRoleProfile) DeepCopy() *AwsIamRaRoleProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaRoleProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaRoleProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaRoleProfileList) DeepCopyInto(out *AwsIamRaRoleProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AwsIamRaRoleProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileList.
+func (in *AwsIamRaRoleProfileList) DeepCopy() *AwsIamRaRoleProfileList {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaRoleProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AwsIamRaRoleProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaRoleProfileSpec) DeepCopyInto(out *AwsIamRaRoleProfileSpec) {
+	*out = *in
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CertificateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sidecar != nil {
+		in, out := &in.Sidecar, &out.Sidecar
+		*out = new(SidecarOverrides)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServiceAccountNames != nil {
+		in, out := &in.ServiceAccountNames, &out.ServiceAccountNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedServiceAccounts != nil {
+		in, out := &in.AllowedServiceAccounts, &out.AllowedServiceAccounts
+		*out = new(AllowedServiceAccounts)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ActiveFrom != nil {
+		in, out := &in.ActiveFrom, &out.ActiveFrom
+		*out = (*in).DeepCopy()
+	}
+	if in.ActiveUntil != nil {
+		in, out := &in.ActiveUntil, &out.ActiveUntil
+		*out = (*in).DeepCopy()
+	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ProfileSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileSpec.
+func (in *AwsIamRaRoleProfileSpec) DeepCopy() *AwsIamRaRoleProfileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaRoleProfileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AwsIamRaRoleProfileStatus) DeepCopyInto(out *AwsIamRaRoleProfileStatus) {
+	*out = *in
+	if in.ActivePods != nil {
+		in, out := &in.ActivePods, &out.ActivePods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExpiringCertificates != nil {
+		in, out := &in.ExpiringCertificates, &out.ExpiringCertificates
+		*out = make([]CertificateExpiry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(ProfileRolloutStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MissedInjections != nil {
+		in, out := &in.MissedInjections, &out.MissedInjections
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DisallowedPods != nil {
+		in, out := &in.DisallowedPods, &out.DisallowedPods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Suspension != nil {
+		in, out := &in.Suspension, &out.Suspension
+		*out = new(ProfileSuspensionStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Activation != nil {
+		in, out := &in.Activation, &out.Activation
+		*out = new(ProfileActivationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AwsIamRaRoleProfileStatus.
+func (in *AwsIamRaRoleProfileStatus) DeepCopy() *AwsIamRaRoleProfileStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AwsIamRaRoleProfileStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerCertificateSpec) DeepCopyInto(out *CertManagerCertificateSpec) {
+	*out = *in
+	out.IssuerRef = in.IssuerRef
+	if in.Subject != nil {
+		in, out := &in.Subject, &out.Subject
+		*out = new(CertificateSubjectTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerCertificateSpec.
+func (in *CertManagerCertificateSpec) DeepCopy() *CertManagerCertificateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerCertificateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateExpiry) DeepCopyInto(out *CertificateExpiry) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateExpiry.
+func (in *CertificateExpiry) DeepCopy() *CertificateExpiry {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateExpiry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateSpec) DeepCopyInto(out *CertificateSpec) {
+	*out = *in
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerCertificateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PassphraseSecretRef != nil {
+		in, out := &in.PassphraseSecretRef, &out.PassphraseSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateSpec.
+func (in *CertificateSpec) DeepCopy() *CertificateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateSubjectTemplate) DeepCopyInto(out *CertificateSubjectTemplate) {
+	*out = *in
+	if in.Organizations != nil {
+		in, out := &in.Organizations, &out.Organizations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OrganizationalUnits != nil {
+		in, out := &in.OrganizationalUnits, &out.OrganizationalUnits
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.URIs != nil {
+		in, out := &in.URIs, &out.URIs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateSubjectTemplate.
+func (in *CertificateSubjectTemplate) DeepCopy() *CertificateSubjectTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateSubjectTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EcrPullSecretsSpec) DeepCopyInto(out *EcrPullSecretsSpec) {
+	*out = *in
+	if in.SourceNamespaces != nil {
+		in, out := &in.SourceNamespaces, &out.SourceNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EcrPullSecretsSpec.
+func (in *EcrPullSecretsSpec) DeepCopy() *EcrPullSecretsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EcrPullSecretsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaManagerConfig) DeepCopyInto(out *IamRaManagerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaManagerConfig.
+func (in *IamRaManagerConfig) DeepCopy() *IamRaManagerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaManagerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IamRaManagerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaManagerConfigList) DeepCopyInto(out *IamRaManagerConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IamRaManagerConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaManagerConfigList.
+func (in *IamRaManagerConfigList) DeepCopy() *IamRaManagerConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaManagerConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IamRaManagerConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaManagerConfigSpec) DeepCopyInto(out *IamRaManagerConfigSpec) {
+	*out = *in
+	in.Sidecar.DeepCopyInto(&out.Sidecar)
+	out.SidecarUpgrade = in.SidecarUpgrade
+	out.MissedInjection = in.MissedInjection
+	if in.RequireProfileUsePermission != nil {
+		in, out := &in.RequireProfileUsePermission, &out.RequireProfileUsePermission
+		*out = new(bool)
+		**out = **in
+	}
+	in.EcrPullSecrets.DeepCopyInto(&out.EcrPullSecrets)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaManagerConfigSpec.
+func (in *IamRaManagerConfigSpec) DeepCopy() *IamRaManagerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaManagerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaManagerConfigStatus) DeepCopyInto(out *IamRaManagerConfigStatus) {
+	*out = *in
+	if in.Sidecars != nil {
+		in, out := &in.Sidecars, &out.Sidecars
+		*out = new(SidecarImageStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaManagerConfigStatus.
+func (in *IamRaManagerConfigStatus) DeepCopy() *IamRaManagerConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaManagerConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaPolicy) DeepCopyInto(out *IamRaPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaPolicy.
+func (in *IamRaPolicy) DeepCopy() *IamRaPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IamRaPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaPolicyList) DeepCopyInto(out *IamRaPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IamRaPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaPolicyList.
+func (in *IamRaPolicyList) DeepCopy() *IamRaPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IamRaPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IamRaPolicySpec) DeepCopyInto(out *IamRaPolicySpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedTrustAnchors != nil {
+		in, out := &in.AllowedTrustAnchors, &out.AllowedTrustAnchors
+		*out = make([]ARNPattern, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedProfiles != nil {
+		in, out := &in.AllowedProfiles, &out.AllowedProfiles
+		*out = make([]ARNPattern, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedRoles != nil {
+		in, out := &in.AllowedRoles, &out.AllowedRoles
+		*out = make([]ARNPattern, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedAccounts != nil {
+		in, out := &in.AllowedAccounts, &out.AllowedAccounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoleSessionNames != nil {
+		in, out := &in.RoleSessionNames, &out.RoleSessionNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IamRaPolicySpec.
+func (in *IamRaPolicySpec) DeepCopy() *IamRaPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IamRaPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuerReference) DeepCopyInto(out *IssuerReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerReference.
+func (in *IssuerReference) DeepCopy() *IssuerReference {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MissedInjectionSpec) DeepCopyInto(out *MissedInjectionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MissedInjectionSpec.
+func (in *MissedInjectionSpec) DeepCopy() *MissedInjectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MissedInjectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutdatedSidecars) DeepCopyInto(out *OutdatedSidecars) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutdatedSidecars.
+func (in *OutdatedSidecars) DeepCopy() *OutdatedSidecars {
+	if in == nil {
+		return nil
+	}
+	out := new(OutdatedSidecars)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileActivationStatus) DeepCopyInto(out *ProfileActivationStatus) {
+	*out = *in
+	if in.NextTransition != nil {
+		in, out := &in.NextTransition, &out.NextTransition
+		*out = (*in).DeepCopy()
+	}
+	if in.PendingPods != nil {
+		in, out := &in.PendingPods, &out.PendingPods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileActivationStatus.
+func (in *ProfileActivationStatus) DeepCopy() *ProfileActivationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileActivationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileRolloutStatus) DeepCopyInto(out *ProfileRolloutStatus) {
+	*out = *in
+	if in.OutdatedPods != nil {
+		in, out := &in.OutdatedPods, &out.OutdatedPods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RestartedWorkloads != nil {
+		in, out := &in.RestartedWorkloads, &out.RestartedWorkloads
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileRolloutStatus.
+func (in *ProfileRolloutStatus) DeepCopy() *ProfileRolloutStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileRolloutStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileSchedule) DeepCopyInto(out *ProfileSchedule) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]Weekday, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileSchedule.
+func (in *ProfileSchedule) DeepCopy() *ProfileSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProfileSuspensionStatus) DeepCopyInto(out *ProfileSuspensionStatus) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+	if in.PendingPods != nil {
+		in, out := &in.PendingPods, &out.PendingPods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProfileSuspensionStatus.
+func (in *ProfileSuspensionStatus) DeepCopy() *ProfileSuspensionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProfileSuspensionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarConfig) DeepCopyInto(out *SidecarConfig) {
+	*out = *in
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(corev1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarConfig.
+func (in *SidecarConfig) DeepCopy() *SidecarConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarImageStatus) DeepCopyInto(out *SidecarImageStatus) {
+	*out = *in
+	if in.Outdated != nil {
+		in, out := &in.Outdated, &out.Outdated
+		*out = make([]OutdatedSidecars, len(*in))
+		copy(*out, *in)
+	}
+	if in.RestartingWorkloads != nil {
+		in, out := &in.RestartingWorkloads, &out.RestartingWorkloads
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BlockedWorkloads != nil {
+		in, out := &in.BlockedWorkloads, &out.BlockedWorkloads
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarImageStatus.
+func (in *SidecarImageStatus) DeepCopy() *SidecarImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarOverrides) DeepCopyInto(out *SidecarOverrides) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarOverrides.
+func (in *SidecarOverrides) DeepCopy() *SidecarOverrides {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarOverrides)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarUpgradeSpec) DeepCopyInto(out *SidecarUpgradeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarUpgradeSpec.
+func (in *SidecarUpgradeSpec) DeepCopy() *SidecarUpgradeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarUpgradeSpec)
 	in.DeepCopyInto(out)
 	return out
 }
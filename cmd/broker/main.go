@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/broker"
+	"dancav.io/aws-iamra-manager/internal/build"
+	"dancav.io/aws-iamra-manager/internal/sidecar"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("broker")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1.AddToScheme(scheme))
+}
+
+// The credential broker calls CreateSession on behalf of the sidecars of pods
+// whose profile uses the Broker certificate source, so that the certificate
+// and private key stay in the broker's namespace. Sidecars authenticate with a
+// projected service account token, checked with a TokenReview. Certificates
+// rotated in the mounted files are picked up without a restart, for both the
+// Roles Anywhere certificate and the serving certificate.
+func main() {
+	var certificate, privateKey, pkcs12, chain, passphraseFile string
+	var bindAddr, tlsCertFile, tlsKeyFile string
+	flag.StringVar(&certificate, "certificate", "", "Path to the PEM-encoded X.509 certificate.")
+	flag.StringVar(&privateKey, "private-key", "",
+		"Path to the PEM-encoded RSA or ECDSA private key, in PKCS#1, PKCS#8 or SEC1 form.")
+	flag.StringVar(&pkcs12, "pkcs12", "",
+		"Path to a PKCS#12 bundle holding the certificate, its chain and private key, "+
+			"read instead of --certificate and --private-key.")
+	flag.StringVar(&chain, "certificate-chain", "",
+		"Path to PEM-encoded intermediate certificates, sent after any that follow the certificate. "+
+			"Ignored if the file doesn't exist.")
+	flag.StringVar(&passphraseFile, "passphrase-file", "",
+		"Path to the passphrase of an encrypted private key or of the PKCS#12 bundle.")
+	flag.StringVar(&bindAddr, "bind-address", ":8443", "The address the broker binds to.")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "",
+		"Path to the PEM-encoded serving certificate. If unset, the broker serves plain HTTP.")
+	flag.StringVar(&tlsKeyFile, "tls-key-file", "", "Path to the PEM-encoded key of the serving certificate.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog.Info(fmt.Sprintf("AWS IAM RA Manager credential broker version %s", build.ReleaseVersion))
+
+	if pkcs12 == "" && (certificate == "" || privateKey == "") {
+		setupLog.Error(errors.New("missing required flags"), "--certificate and --private-key, or --pkcs12, are required")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	watcher := &sidecar.CertificateWatcher{
+		CertPath:       certificate,
+		KeyPath:        privateKey,
+		PKCS12Path:     pkcs12,
+		ChainPath:      chain,
+		PassphrasePath: passphraseFile,
+		Logger:         ctrl.Log.WithName("certificate"),
+	}
+	signer, err := watcher.Load()
+	if err != nil {
+		setupLog.Error(err, "unable to load certificate")
+		os.Exit(1)
+	}
+
+	config := ctrl.GetConfigOrDie()
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
+	// Reads go straight to the API server: sidecars cache the credentials they
+	// get, so the broker sees too few requests to be worth caching every pod.
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	b := broker.NewBroker(signer, clientset.AuthenticationV1().TokenReviews(), c, ctrl.Log.WithName("broker"))
+	go func() {
+		if err := watcher.Watch(ctx, b.SetSigner); err != nil {
+			setupLog.Error(err, "unable to watch certificate files, rotated certificates need a restart")
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle(v1.BrokerCredentialsPath, b)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := &http.Server{
+		Addr:              bindAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if tlsCertFile != "" {
+		servingCert, err := certwatcher.New(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load serving certificate")
+			os.Exit(1)
+		}
+		go func() {
+			if err := servingCert.Start(ctx); err != nil {
+				setupLog.Error(err, "unable to watch serving certificate")
+			}
+		}()
+		server.TLSConfig = &tls.Config{GetCertificate: servingCert.GetCertificate, MinVersion: tls.VersionTLS12}
+	} else {
+		setupLog.Info("no --tls-cert-file, serving plain HTTP: tokens can be intercepted on the network")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		setupLog.Info("starting credential broker", "address", bindAddr, "tls", server.TLSConfig != nil)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		setupLog.Info("shutting down")
+	case err := <-errs:
+		setupLog.Error(err, "problem running credential broker")
+		os.Exit(1)
+	}
+	_ = server.Shutdown(context.Background())
+}
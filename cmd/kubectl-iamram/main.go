@@ -0,0 +1,125 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/internal/build"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+}
+
+const usage = `Usage: kubectl iamram <command> [flags]
+
+Commands:
+  missed-injections  List the pods using a profile that the pod webhook didn't inject.
+  version            Print the version.
+
+Flags:
+`
+
+// kubectl-iamram reports on the pods of a cluster using AWS IAM RA Manager.
+// Installed on the PATH, it runs as the kubectl plugin "kubectl iamram".
+func main() {
+	var namespace string
+	flag.StringVar(&namespace, "namespace", "", "Only list pods in this namespace. Defaults to all namespaces.")
+	flag.StringVar(&namespace, "n", "", "Shorthand for --namespace.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	if len(os.Args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	if err := flag.CommandLine.Parse(os.Args[2:]); err != nil {
+		os.Exit(2)
+	}
+
+	switch command {
+	case "missed-injections":
+		c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+			os.Exit(1)
+		}
+		if err := missedInjections(context.Background(), c, namespace, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to list missed injections: %v\n", err)
+			os.Exit(1)
+		}
+	case "version":
+		fmt.Println(build.ReleaseVersion)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// missedInjections prints a table of the pods the pod webhook didn't inject.
+// Pods with a controller are recreated with credentials when evicted, which
+// the controller does with the Evict policy.
+func missedInjections(ctx context.Context, c client.Reader, namespace string, out io.Writer) error {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tPROFILES\tWORKLOAD\tAGE")
+	found := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !iamram.MissedInjection(pod) ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		workload := "<none>"
+		if kind, name, err := iamram.Workload(pod); err == nil && kind != "Pod" {
+			workload = kind + "/" + name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name,
+			strings.Join(iamram.ProfileNames(pod), ","), workload,
+			duration.HumanDuration(time.Since(pod.CreationTimestamp.Time)))
+		found++
+	}
+	if found == 0 {
+		_, err := fmt.Fprintln(out, "No pods missed injection.")
+		return err
+	}
+	return w.Flush()
+}
@@ -17,20 +17,33 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"dancav.io/aws-iamra-manager/internal/build"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -39,6 +52,7 @@ import (
 
 	"dancav.io/aws-iamra-manager/api/v1"
 	"dancav.io/aws-iamra-manager/internal/controller"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
 	webhookv1 "dancav.io/aws-iamra-manager/internal/webhook/v1"
 	// +kubebuilder:scaffold:imports
 )
@@ -52,6 +66,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(v1.AddToScheme(scheme))
+	utilruntime.Must(certmanagerv1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -62,6 +77,13 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
+	var caSecret, trustDomain string
+	var podCertificateDuration time.Duration
+	var certificateExpiryWindow time.Duration
+	var crlProfile, crlCertSecret string
+	var brokerURL, brokerCAFile string
+	var nodeAgentEndpoint string
+	var configFile, configName string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -72,6 +94,38 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&caSecret, "ca-secret", "",
+		"The kubernetes.io/tls Secret, as namespace/name, holding the intermediate CA that issues certificates "+
+			"to pods whose profile uses the ControllerCA or CertificateSigningRequest certificate source.")
+	flag.StringVar(&trustDomain, "trust-domain", "cluster.local",
+		"The trust domain of the SPIFFE IDs in certificates issued to pods.")
+	flag.DurationVar(&podCertificateDuration, "pod-certificate-duration", 24*time.Hour,
+		"The lifetime of certificates issued to pods, including cert-manager Certificates, "+
+			"unless their profile sets one.")
+	flag.DurationVar(&certificateExpiryWindow, "certificate-expiry-window", 7*24*time.Hour,
+		"How long before they expire the certificates of pods are reported on their profiles. "+
+			"Certificates are only reported in the last third of their lifetime.")
+	flag.StringVar(&crlProfile, "crl-profile", "",
+		"The AwsIamRaRoleProfile, as namespace/name, whose credentials import the CRL of the --ca-secret CA "+
+			"into its trust anchor. The role needs rolesanywhere:ImportCrl and rolesanywhere:UpdateCrl. "+
+			"If unset, the CRL is only written to a Secret.")
+	flag.StringVar(&crlCertSecret, "crl-cert-secret", "",
+		"The kubernetes.io/tls Secret, in the namespace of --crl-profile, used to call CreateSession "+
+			"for --crl-profile.")
+	flag.StringVar(&brokerURL, "broker-url", "",
+		"The URL sidecars reach the credential broker at, for pods whose profile uses the Broker certificate "+
+			"source. If unset, such pods are rejected.")
+	flag.StringVar(&brokerCAFile, "broker-ca-file", "",
+		"Path to the PEM-encoded CA certificates sidecars verify the broker's serving certificate with, "+
+			"if it isn't signed by a public CA.")
+	flag.StringVar(&nodeAgentEndpoint, "node-agent-endpoint", "",
+		"The metadata service endpoint pods reach the node agent at, for pods whose profile is delivered by it, "+
+			"e.g. http://$(IAMRAM_HOST_IP):9920 for the IP of the pod's node. If unset, such pods are rejected.")
+	flag.StringVar(&configFile, "config-file", "",
+		"Path to an IamRaManagerConfig, e.g. mounted from a ConfigMap, reloaded when it changes. "+
+			"The resource named by --config-name takes precedence over it.")
+	flag.StringVar(&configName, "config-name", "default",
+		"The cluster-scoped IamRaManagerConfig applied over the defaults and --config-file.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -130,6 +184,13 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "6b2e7301.dancav.io",
+		// Only the Secrets the controller writes are cached and watched, so
+		// that it doesn't hold every Secret of the cluster in memory. The
+		// others, like certificate Secrets, are read from the API server.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{v1.ManagedSecretLabelKey: "true"})},
+		}},
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -147,15 +208,183 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The manager's cache only holds the Secrets it writes. The controllers
+	// reacting to the rotation of certificate Secrets watch the metadata of
+	// every Secret in a cache of their own, without their data.
+	secretMetadata, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme: mgr.GetScheme(),
+		Mapper: mgr.GetRESTMapper(),
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create the Secret metadata cache")
+		os.Exit(1)
+	}
+	if err = mgr.Add(secretMetadata); err != nil {
+		setupLog.Error(err, "unable to add the Secret metadata cache")
+		os.Exit(1)
+	}
+
+	config := managerconfig.NewStore(managerconfig.Defaults())
+	if configFile != "" {
+		watcher := &managerconfig.FileWatcher{
+			Path:   configFile,
+			Store:  config,
+			Logger: ctrl.Log.WithName("config"),
+		}
+		if err := watcher.Load(); err != nil {
+			setupLog.Error(err, "unable to load --config-file")
+			os.Exit(1)
+		}
+		if err := mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to watch --config-file")
+			os.Exit(1)
+		}
+	}
+	if err = (&controller.IamRaManagerConfigReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("iamram-controller"),
+		Name:     configName,
+		Store:    config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IamRaManagerConfig")
+		os.Exit(1)
+	}
+
+	if err = (&controller.SidecarImageReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("iamram-controller"),
+		Name:     configName,
+		Store:    config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SidecarImage")
+		os.Exit(1)
+	}
+
 	if err = (&controller.AwsIamRaRoleProfileReconciler{
 		Client:     mgr.GetClient(),
 		Scheme:     mgr.GetScheme(),
 		Recorder:   mgr.GetEventRecorderFor("iamram-controller"),
 		KubeConfig: mgr.GetConfig(),
+		Store:      config,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AwsIamRaRoleProfile")
 		os.Exit(1)
 	}
+	if err = (&controller.AwsIamRaCredentialSecretReconciler{
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		Recorder:       mgr.GetEventRecorderFor("iamram-controller"),
+		Store:          config,
+		SecretMetadata: secretMetadata,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AwsIamRaCredentialSecret")
+		os.Exit(1)
+	}
+	if err = (&controller.AwsIamRaEcrPullSecretReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("iamram-controller"),
+		Store:    config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AwsIamRaEcrPullSecret")
+		os.Exit(1)
+	}
+	var caSecretName types.NamespacedName
+	if caSecret != "" {
+		namespace, name, ok := strings.Cut(caSecret, "/")
+		if !ok {
+			setupLog.Error(errors.New("expected namespace/name"), "invalid --ca-secret", "value", caSecret)
+			os.Exit(1)
+		}
+		caSecretName = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	if err = (&controller.PodCertificateReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("iamram-controller"),
+		CASecret:    caSecretName,
+		TrustDomain: trustDomain,
+		Duration:    podCertificateDuration,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PodCertificate")
+		os.Exit(1)
+	}
+	if err = (&controller.CertificateSigningRequestReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("iamram-controller"),
+		CASecret:    caSecretName,
+		TrustDomain: trustDomain,
+		Duration:    podCertificateDuration,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CertificateSigningRequest")
+		os.Exit(1)
+	}
+	if err = (&controller.CertificateExpiryReconciler{
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		Recorder:       mgr.GetEventRecorderFor("iamram-controller"),
+		Window:         certificateExpiryWindow,
+		SecretMetadata: secretMetadata,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CertificateExpiry")
+		os.Exit(1)
+	}
+	if err = (&controller.AwsIamRaCertificateRevocationReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("iamram-controller"),
+		KubeConfig: mgr.GetConfig(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AwsIamRaCertificateRevocation")
+		os.Exit(1)
+	}
+	if caSecret != "" {
+		var crlProfileName types.NamespacedName
+		if crlProfile != "" {
+			namespace, name, ok := strings.Cut(crlProfile, "/")
+			if !ok || crlCertSecret == "" {
+				setupLog.Error(errors.New("expected namespace/name and --crl-cert-secret"),
+					"invalid --crl-profile", "value", crlProfile)
+				os.Exit(1)
+			}
+			crlProfileName = types.NamespacedName{Namespace: namespace, Name: name}
+		}
+		if err = (&controller.CertificateRevocationListReconciler{
+			Client:         mgr.GetClient(),
+			Scheme:         mgr.GetScheme(),
+			Recorder:       mgr.GetEventRecorderFor("iamram-controller"),
+			CASecret:       caSecretName,
+			Profile:        crlProfileName,
+			CertSecretName: crlCertSecret,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertificateRevocationList")
+			os.Exit(1)
+		}
+	}
+	// cert-manager is optional: without its CRDs, the controller couldn't
+	// watch Certificates and the manager would fail to start.
+	certificateGK := schema.GroupKind{Group: certmanagerv1.SchemeGroupVersion.Group, Kind: certmanagerv1.CertificateKind}
+	_, err = mgr.GetRESTMapper().RESTMapping(certificateGK, certmanagerv1.SchemeGroupVersion.Version)
+	if err == nil {
+		if err = (&controller.CertManagerCertificateReconciler{
+			Client:      mgr.GetClient(),
+			Scheme:      mgr.GetScheme(),
+			Recorder:    mgr.GetEventRecorderFor("iamram-controller"),
+			TrustDomain: trustDomain,
+			Duration:    podCertificateDuration,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertManagerCertificate")
+			os.Exit(1)
+		}
+	} else if meta.IsNoMatchError(err) {
+		setupLog.Info("cert-manager is not installed, profiles can't use the CertManager certificate source")
+	} else {
+		setupLog.Error(err, "unable to discover the cert-manager API")
+		os.Exit(1)
+	}
 	// nolint:goconst
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		if err = webhookv1.SetupAwsIamRaRoleProfileWebhookWithManager(mgr); err != nil {
@@ -163,7 +392,39 @@ func main() {
 			os.Exit(1)
 		}
 
-		if err = webhookv1.SetupPodWebhookWithManager(mgr); err != nil {
+		if err = webhookv1.SetupAwsIamRaCredentialSecretWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AwsIamRaCredentialSecret")
+			os.Exit(1)
+		}
+		if err = webhookv1.SetupAwsIamRaEcrPullSecretWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AwsIamRaEcrPullSecret")
+			os.Exit(1)
+		}
+		if err = webhookv1.SetupWorkloadWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Workload")
+			os.Exit(1)
+		}
+
+		podWebhookOpts := webhookv1.PodWebhookOptions{
+			Broker:            webhookv1.BrokerOptions{URL: brokerURL},
+			NodeAgentEndpoint: nodeAgentEndpoint,
+			Config:            config,
+		}
+		// Only the manager may change the annotations its controllers set on
+		// pods, so the webhook needs to know who it authenticates as.
+		review := &authenticationv1.SelfSubjectReview{}
+		if err = mgr.GetClient().Create(context.Background(), review); err != nil {
+			setupLog.Error(err, "unable to look up the user the manager authenticates as")
+			os.Exit(1)
+		}
+		podWebhookOpts.ManagerUsername = review.Status.UserInfo.Username
+		if brokerCAFile != "" {
+			if podWebhookOpts.Broker.CA, err = os.ReadFile(brokerCAFile); err != nil {
+				setupLog.Error(err, "unable to read --broker-ca-file")
+				os.Exit(1)
+			}
+		}
+		if err = webhookv1.SetupPodWebhookWithManager(mgr, podWebhookOpts); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
 			os.Exit(1)
 		}
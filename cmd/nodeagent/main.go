@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/build"
+	"dancav.io/aws-iamra-manager/internal/nodeagent"
+	"dancav.io/aws-iamra-manager/internal/sidecar"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("node-agent")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1.AddToScheme(scheme))
+}
+
+// The node agent runs on every node, on the host network, and serves the
+// credentials of pods whose profile is delivered by it in place of a sidecar.
+// It emulates the instance metadata service and tells pods apart by the source
+// IP of their requests, looked up in a cache of the pods on its node. Like the
+// credential broker, it holds the certificate CreateSession is called with, so
+// that pods mount none.
+func main() {
+	var certificate, privateKey, pkcs12, chain, passphraseFile string
+	var bindAddr, probeAddr, nodeName string
+	flag.StringVar(&certificate, "certificate", "", "Path to the PEM-encoded X.509 certificate.")
+	flag.StringVar(&privateKey, "private-key", "",
+		"Path to the PEM-encoded RSA or ECDSA private key, in PKCS#1, PKCS#8 or SEC1 form.")
+	flag.StringVar(&pkcs12, "pkcs12", "",
+		"Path to a PKCS#12 bundle holding the certificate, its chain and private key, "+
+			"read instead of --certificate and --private-key.")
+	flag.StringVar(&chain, "certificate-chain", "",
+		"Path to PEM-encoded intermediate certificates, sent after any that follow the certificate. "+
+			"Ignored if the file doesn't exist.")
+	flag.StringVar(&passphraseFile, "passphrase-file", "",
+		"Path to the passphrase of an encrypted private key or of the PKCS#12 bundle.")
+	flag.StringVar(&bindAddr, "bind-address", ":9920",
+		"The address the metadata service binds to, e.g. a link-local address assigned to the node.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":9921", "The address the probe endpoint binds to.")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
+		"The node the agent runs on. Defaults to the NODE_NAME environment variable.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog.Info(fmt.Sprintf("AWS IAM RA Manager node agent version %s", build.ReleaseVersion))
+
+	if nodeName == "" {
+		setupLog.Error(errors.New("missing node name"), "--node-name or NODE_NAME is required")
+		os.Exit(1)
+	}
+	if pkcs12 == "" && (certificate == "" || privateKey == "") {
+		setupLog.Error(errors.New("missing required flags"), "--certificate and --private-key, or --pkcs12, are required")
+		os.Exit(1)
+	}
+
+	watcher := &sidecar.CertificateWatcher{
+		CertPath:       certificate,
+		KeyPath:        privateKey,
+		PKCS12Path:     pkcs12,
+		ChainPath:      chain,
+		PassphrasePath: passphraseFile,
+		Logger:         ctrl.Log.WithName("certificate"),
+	}
+	signer, err := watcher.Load()
+	if err != nil {
+		setupLog.Error(err, "unable to load certificate")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: probeAddr,
+		Cache: cache.Options{
+			// Only the pods on this node can reach the agent.
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}: {Field: fields.OneTermEqualSelector("spec.nodeName", nodeName)},
+			},
+		},
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create manager")
+		os.Exit(1)
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, nodeagent.PodIPField,
+		nodeagent.IndexPodIP); err != nil {
+		setupLog.Error(err, "unable to index pods by IP")
+		os.Exit(1)
+	}
+
+	agent := nodeagent.NewAgent(nodeName, signer, mgr.GetClient(), ctrl.Log.WithName("node-agent"))
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := watcher.Watch(ctx, agent.SetSigner); err != nil {
+			setupLog.Error(err, "unable to watch certificate files, rotated certificates need a restart")
+		}
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to watch certificate")
+		os.Exit(1)
+	}
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		server := &http.Server{
+			Addr:              bindAddr,
+			Handler:           agent,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			<-ctx.Done()
+			_ = server.Shutdown(context.Background())
+		}()
+		setupLog.Info("starting metadata service", "address", bindAddr, "node", nodeName)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add metadata service")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting node agent")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running node agent")
+		os.Exit(1)
+	}
+}
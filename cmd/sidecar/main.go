@@ -0,0 +1,318 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/internal/build"
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"dancav.io/aws-iamra-manager/internal/sidecar"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var setupLog = ctrl.Log.WithName("sidecar")
+
+// The sidecar emulates the EC2 instance metadata service, serving credentials
+// obtained from IAM Roles Anywhere CreateSession calls. The pod's default
+// profile is configured with flags and served on --port; any additional
+// profiles are read from the IAMRAM_ADDITIONAL_PROFILES environment variable.
+// On SIGHUP, every profile reloads the config file written by update-config,
+// the list of revoked certificates written by revoke-certificates, and the
+// state suspend-profile recorded for it.
+// Certificates rotated in the mounted files are picked up without a restart.
+// With --broker-url, the sidecar holds no certificate and gets credentials
+// from the credential broker instead.
+func main() {
+	var certificate, privateKey, pkcs12, chain, passphraseFile, configDir, metricsAddr string
+	var brokerURL, brokerTokenFile string
+	var requestCertificate bool
+	var defaultProfile sidecar.ProfileConfig
+	var durationSeconds int
+	var activeUntil string
+	flag.StringVar(&certificate, "certificate", "", "Path to the PEM-encoded X.509 certificate.")
+	flag.StringVar(&privateKey, "private-key", "",
+		"Path to the PEM-encoded RSA or ECDSA private key, in PKCS#1, PKCS#8 or SEC1 form.")
+	flag.StringVar(&pkcs12, "pkcs12", "",
+		"Path to a PKCS#12 bundle holding the certificate, its chain and private key, "+
+			"read instead of --certificate and --private-key.")
+	flag.StringVar(&chain, "certificate-chain", "",
+		"Path to PEM-encoded intermediate certificates, sent after any that follow the certificate. "+
+			"Ignored if the file doesn't exist.")
+	flag.StringVar(&passphraseFile, "passphrase-file", "",
+		"Path to the passphrase of an encrypted private key or of the PKCS#12 bundle.")
+	flag.BoolVar(&requestCertificate, "request-certificate", false,
+		"If set, generate a private key and request a certificate through a CertificateSigningRequest, "+
+			"instead of reading --certificate and --private-key.")
+	flag.StringVar(&brokerURL, "broker-url", "",
+		"If set, get credentials from the credential broker at this URL, instead of calling CreateSession. "+
+			"The broker's CA certificates are read from the "+sidecar.BrokerCAEnvVar+" environment variable.")
+	flag.StringVar(&brokerTokenFile, "broker-token-file", "/iamram/broker/token",
+		"Path to the projected service account token the sidecar authenticates to the broker with.")
+	flag.StringVar(&configDir, "config-dir", "/iamram", "Directory update-config writes config files to.")
+	flag.StringVar(&defaultProfile.Name, "profile-name", "default", "Name of the default profile.")
+	flag.StringVar(&defaultProfile.TrustAnchorArn, "trust-anchor-arn", "", "ARN of the Roles Anywhere trust anchor.")
+	flag.StringVar(&defaultProfile.ProfileArn, "profile-arn", "", "ARN of the Roles Anywhere profile.")
+	flag.StringVar(&defaultProfile.RoleArn, "role-arn", "", "ARN of the role to assume.")
+	flag.IntVar(&durationSeconds, "session-duration", 0, "Duration of the session in seconds.")
+	flag.StringVar(&defaultProfile.RoleSessionName, "role-session-name", "", "Name of the role session.")
+	flag.IntVar(&defaultProfile.Port, "port", sidecar.DefaultPort,
+		"Port the metadata server listens on, on the loopback interface.")
+	flag.BoolVar(&defaultProfile.ImdsV2Only, "imds-v2-only", false,
+		"If set, requests without an IMDSv2 session token are rejected.")
+	flag.BoolVar(&defaultProfile.Suspended, "suspended", false,
+		"If set, the default profile serves no credentials until suspend-profile resumes it.")
+	flag.StringVar(&activeUntil, "active-until", "",
+		"If set, the RFC 3339 time the default profile stops serving credentials at, "+
+			"until suspend-profile says otherwise.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", "127.0.0.1:9910",
+		"The address the metrics endpoint binds to, only reachable from within the pod by default. "+
+			"Use 0 to disable it.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog.Info(fmt.Sprintf("AWS IAM RA Manager sidecar version %s", build.ReleaseVersion))
+
+	if brokerURL == "" && !requestCertificate && pkcs12 == "" && (certificate == "" || privateKey == "") {
+		setupLog.Error(errors.New("missing required flags"),
+			"--certificate and --private-key, or --pkcs12, are required unless --request-certificate "+
+				"or --broker-url is set")
+		os.Exit(1)
+	}
+	defaultProfile.DurationSeconds = int32(durationSeconds)
+	if activeUntil != "" {
+		until, err := time.Parse(time.RFC3339, activeUntil)
+		if err != nil {
+			setupLog.Error(err, "invalid --active-until")
+			os.Exit(1)
+		}
+		defaultProfile.ActiveUntil = &until
+	}
+
+	var additionalProfiles []sidecar.ProfileConfig
+	if raw := os.Getenv(sidecar.AdditionalProfilesEnvVar); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &additionalProfiles); err != nil {
+			setupLog.Error(err, "unable to parse additional profiles", "env", sidecar.AdditionalProfilesEnvVar)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var signer *rolesanywhere.Signer
+	var requester *sidecar.CertificateRequester
+	var watcher *sidecar.CertificateWatcher
+	var brokerClient *sidecar.BrokerClient
+	var err error
+	if brokerURL != "" {
+		brokerClient, err = sidecar.NewBrokerClient(brokerURL, brokerTokenFile,
+			[]byte(os.Getenv(sidecar.BrokerCAEnvVar)))
+	} else if requestCertificate {
+		requester, err = newCertificateRequester()
+		if err == nil {
+			signer, err = requester.Request(ctx)
+		}
+	} else {
+		watcher = &sidecar.CertificateWatcher{
+			CertPath:       certificate,
+			KeyPath:        privateKey,
+			PKCS12Path:     pkcs12,
+			ChainPath:      chain,
+			PassphrasePath: passphraseFile,
+			Logger:         ctrl.Log.WithName("certificate"),
+		}
+		signer, err = watcher.Load()
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to load certificate")
+		os.Exit(1)
+	}
+	if signer != nil {
+		sidecar.ObserveCertificate(signer)
+	}
+
+	instance := imds.Options{
+		InstanceID: os.Getenv("POD_NAME"),
+		PrivateIP:  os.Getenv("POD_IP"),
+	}
+	var servers []*sidecar.ProfileServer
+	var httpServers []*http.Server
+	for i, cfg := range append([]sidecar.ProfileConfig{defaultProfile}, additionalProfiles...) {
+		configFile := sidecar.ConfigFilePath(configDir, "")
+		if i > 0 {
+			configFile = sidecar.ConfigFilePath(configDir, cfg.Name)
+		}
+		var server *sidecar.ProfileServer
+		if brokerClient != nil {
+			server, err = sidecar.NewBrokerProfileServer(cfg, configFile, brokerClient, instance,
+				ctrl.Log.WithName("imds"))
+		} else {
+			server, err = sidecar.NewProfileServer(cfg, configFile, signer, instance, ctrl.Log.WithName("imds"))
+		}
+		if err != nil {
+			setupLog.Error(err, "invalid profile config", "profile", cfg.Name)
+			os.Exit(1)
+		}
+		servers = append(servers, server)
+		httpServers = append(httpServers, &http.Server{
+			Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Port)),
+			Handler:           server,
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+	}
+
+	setSigner := func(renewed *rolesanywhere.Signer) {
+		for _, server := range servers {
+			if err := server.SetSigner(renewed); err != nil {
+				setupLog.Error(err, "unable to switch to renewed certificate", "profile", server.Config().Name)
+			}
+		}
+		sidecar.ObserveCertificate(renewed)
+	}
+	if requester != nil {
+		go requester.Renew(ctx, signer, setSigner)
+	}
+	if watcher != nil {
+		go func() {
+			if err := watcher.Watch(ctx, setSigner); err != nil {
+				setupLog.Error(err, "unable to watch certificate files, rotated certificates need a restart")
+			}
+		}()
+	}
+	if metricsAddr != "0" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(sidecar.Metrics, promhttp.HandlerOpts{}))
+		metricsServer := &http.Server{
+			Addr:              metricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			// Metrics are best effort: the port may be taken by another container of the pod.
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				setupLog.Error(err, "unable to serve metrics", "address", metricsAddr)
+			}
+		}()
+		defer func() { _ = metricsServer.Shutdown(context.Background()) }()
+	}
+
+	setRevoked := func() {
+		revoked, err := sidecar.ReadRevokedFile(sidecar.RevokedFilePath(configDir))
+		if err != nil {
+			setupLog.Error(err, "unable to read revoked certificates, keeping current list")
+			return
+		}
+		for _, server := range servers {
+			if err := server.SetRevoked(revoked); err != nil {
+				setupLog.Error(err, "unable to apply revoked certificates", "profile", server.Config().Name)
+			}
+		}
+	}
+	setRevoked()
+	setSuspended := func() {
+		suspended, err := sidecar.ReadSuspendedDir(sidecar.SuspendedDirPath(configDir))
+		if err != nil {
+			setupLog.Error(err, "unable to read suspended profiles, keeping current states")
+			return
+		}
+		for _, server := range servers {
+			if err := server.SetSuspended(suspended); err != nil {
+				setupLog.Error(err, "unable to apply suspended state", "profile", server.Config().Name)
+			}
+		}
+	}
+	setSuspended()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			setupLog.Info("caught SIGHUP, reloading config")
+			setRevoked()
+			setSuspended()
+			for _, server := range servers {
+				if err := server.Reload(); err != nil {
+					setupLog.Error(err, "unable to reload config, keeping current config",
+						"profile", server.Config().Name)
+				}
+			}
+		}
+	}()
+
+	errs := make(chan error, len(httpServers))
+	for _, httpServer := range httpServers {
+		go func(httpServer *http.Server) {
+			setupLog.Info("starting IMDS credential server", "address", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(httpServer)
+	}
+
+	select {
+	case <-ctx.Done():
+		setupLog.Info("shutting down")
+	case err := <-errs:
+		setupLog.Error(err, "problem running credential server")
+		os.Exit(1)
+	}
+	for _, httpServer := range httpServers {
+		_ = httpServer.Shutdown(context.Background())
+	}
+}
+
+// newCertificateRequester returns a requester for the identity of the pod the
+// sidecar runs in, authenticating with the pod's service account token.
+func newCertificateRequester() (*sidecar.CertificateRequester, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &sidecar.CertificateRequester{
+		Client: client,
+		Identity: pki.Identity{
+			Namespace:      os.Getenv("POD_NAMESPACE"),
+			ServiceAccount: os.Getenv("POD_SERVICE_ACCOUNT"),
+			Pod:            os.Getenv("POD_NAME"),
+		},
+		Logger: ctrl.Log.WithName("csr"),
+	}, nil
+}
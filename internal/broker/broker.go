@@ -0,0 +1,316 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxRoleSessionName is the longest role session name CreateSession accepts.
+	maxRoleSessionName = 64
+	// sessionIdleTimeout is how long a session nobody asked for stays cached.
+	// It is the longest session a profile can request, so that sidecars
+	// refreshing their credentials find the session still there.
+	sessionIdleTimeout = 12 * time.Hour
+)
+
+// Broker serves the credentials of role profiles to the sidecars of pods using
+// them, so that only the broker holds a certificate and private key. Sidecars
+// authenticate with a projected service account token for
+// v1.BrokerTokenAudience, checked with a TokenReview, that must be bound to
+// their pod. Pods of the same workload get the same session, which is cached
+// until it is close to expiring.
+type Broker struct {
+	tokenReviews authenticationv1client.TokenReviewInterface
+	client       client.Reader
+	logger       logr.Logger
+
+	// Endpoint overrides the Roles Anywhere endpoint derived from the trust
+	// anchor ARNs.
+	Endpoint string
+
+	mu       sync.Mutex
+	signer   *rolesanywhere.Signer
+	sessions map[sessionKey]*cachedSession
+}
+
+// sessionKey identifies a cached session. Sessions of profiles with an
+// activation window are only served until the window closes, as a Unix time.
+type sessionKey struct {
+	input rolesanywhere.SessionInput
+	until int64
+}
+
+type cachedSession struct {
+	provider *rolesanywhere.SessionProvider
+	lastUsed time.Time
+}
+
+// NewBroker returns a broker calling CreateSession with signer. Tokens are
+// reviewed with tokenReviews, and pods and profiles read with c.
+func NewBroker(
+	signer *rolesanywhere.Signer, tokenReviews authenticationv1client.TokenReviewInterface, c client.Reader,
+	logger logr.Logger,
+) *Broker {
+	return &Broker{
+		tokenReviews: tokenReviews,
+		client:       c,
+		logger:       logger,
+		signer:       signer,
+		sessions:     map[sessionKey]*cachedSession{},
+	}
+}
+
+// SetSigner switches the broker to a renewed certificate. Cached sessions are
+// dropped, so that no credentials obtained with the previous one are served.
+func (b *Broker) SetSigner(signer *rolesanywhere.Signer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.signer = signer
+	b.sessions = map[sessionKey]*cachedSession{}
+}
+
+// statusError is an error answered with an HTTP status other than 500.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func unauthorized(format string, args ...any) error {
+	return &statusError{status: http.StatusUnauthorized, err: fmt.Errorf(format, args...)}
+}
+
+func forbidden(format string, args ...any) error {
+	return &statusError{status: http.StatusForbidden, err: fmt.Errorf(format, args...)}
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != v1.BrokerCredentialsPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	profileName := r.URL.Query().Get("profile")
+	if profileName == "" {
+		http.Error(w, "missing profile", http.StatusBadRequest)
+		return
+	}
+
+	creds, err := b.credentials(r.Context(), r.Header.Get("Authorization"), profileName)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			status = statusErr.status
+		}
+		b.logger.Info("refusing credentials", "profile", profileName, "status", status, "reason", err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(creds)
+}
+
+func (b *Broker) credentials(
+	ctx context.Context, authorization, profileName string,
+) (*rolesanywhere.Credentials, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return nil, unauthorized("missing bearer token")
+	}
+	pod, err := b.authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	input, until, err := b.sessionInput(ctx, pod, profileName)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := b.session(input, until).Retrieve(ctx)
+	if err != nil {
+		return nil, &statusError{status: http.StatusBadGateway, err: err}
+	}
+	b.logger.V(1).Info("serving credentials", "pod", client.ObjectKeyFromObject(pod), "profile", profileName,
+		"roleSessionName", input.RoleSessionName)
+	return creds, nil
+}
+
+// authenticate returns the pod the token is bound to.
+func (b *Broker) authenticate(ctx context.Context, token string) (*corev1.Pod, error) {
+	review, err := b.tokenReviews.Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{v1.BrokerTokenAudience},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to review token: %w", err)
+	}
+	status := review.Status
+	if !status.Authenticated {
+		return nil, unauthorized("invalid token: %s", status.Error)
+	}
+	if !slices.Contains(status.Audiences, v1.BrokerTokenAudience) {
+		return nil, unauthorized("token isn't meant for audience %s", v1.BrokerTokenAudience)
+	}
+	namespace, serviceAccount, err := serviceaccount.SplitUsername(status.User.Username)
+	if err != nil {
+		return nil, unauthorized("token doesn't belong to a service account")
+	}
+	podName := status.User.Extra[serviceaccount.PodNameKey]
+	podUID := status.User.Extra[serviceaccount.PodUIDKey]
+	if len(podName) != 1 || len(podUID) != 1 {
+		return nil, unauthorized("token isn't bound to a pod")
+	}
+
+	var pod corev1.Pod
+	if err := b.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: podName[0]}, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, unauthorized("pod %s/%s no longer exists", namespace, podName[0])
+		}
+		return nil, fmt.Errorf("unable to fetch pod %s/%s: %w", namespace, podName[0], err)
+	}
+	if string(pod.UID) != podUID[0] || iamram.ServiceAccountName(&pod) != serviceAccount {
+		return nil, unauthorized("pod %s/%s no longer exists", namespace, podName[0])
+	}
+	return &pod, nil
+}
+
+// sessionInput checks that pod may use the profile through the broker, and
+// returns the session its credentials come from, and when they stop being
+// served if the profile has an activation window. The pod webhook checks the
+// profiles of pods when they are created, so the profile must be one the pod
+// was admitted with, as its profile hashes record, and must still allow the
+// pod's service account.
+func (b *Broker) sessionInput(
+	ctx context.Context, pod *corev1.Pod, profileName string,
+) (rolesanywhere.SessionInput, time.Time, error) {
+	var input rolesanywhere.SessionInput
+	var until time.Time
+	profileNames := iamram.ProfileNames(pod)
+	if !slices.Contains(profileNames, profileName) {
+		return input, until, forbidden("pod %s doesn't use profile %s", pod.Name, profileName)
+	}
+	// The certificate settings of the pod's first profile apply to all of them.
+	var first v1.AwsIamRaRoleProfile
+	if err := b.getProfile(ctx, pod.Namespace, profileNames[0], &first); err != nil {
+		return input, until, err
+	}
+	if _, ok := pod.Annotations[v1.CertSecretPodAnnotationKey]; ok ||
+		first.Spec.CertificateSource() != v1.CertificateSourceBroker {
+		return input, until, forbidden("pod %s doesn't get its credentials from the broker", pod.Name)
+	}
+	profile := first
+	if profileName != first.Name {
+		if err := b.getProfile(ctx, pod.Namespace, profileName, &profile); err != nil {
+			return input, until, err
+		}
+	}
+
+	if _, ok := iamram.ProfileHashes(pod.Annotations)[profile.Name]; !ok {
+		return input, until, forbidden("pod %s wasn't admitted with profile %s", pod.Name, profile.Name)
+	}
+	allowed, err := iamram.ServiceAccountAllowed(ctx, b.client, &profile, pod)
+	if err != nil {
+		return input, until, err
+	}
+	if !allowed {
+		return input, until, forbidden("profile %s doesn't allow service account %s", profile.Name,
+			iamram.ServiceAccountName(pod))
+	}
+	if profile.Spec.Suspended {
+		return input, until, forbidden("profile %s is suspended", profile.Name)
+	}
+	activation, err := iamram.ProfileActivation(&profile.Spec, time.Now())
+	if err != nil {
+		return input, until, fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+	if !activation.Active {
+		return input, until, forbidden("profile %s is outside its activation window", profile.Name)
+	}
+	until = activation.Until()
+	spec := profile.Spec
+	input = rolesanywhere.SessionInput{
+		TrustAnchorArn:  string(spec.TrustAnchorArn),
+		ProfileArn:      string(spec.ProfileArn),
+		RoleArn:         string(spec.RoleArn),
+		DurationSeconds: spec.DurationSeconds,
+		RoleSessionName: spec.RoleSessionName,
+	}
+	if input.RoleSessionName == "" {
+		// Sessions are named after the workload, rather than the pod, so that
+		// its replicas share one.
+		_, workload, err := iamram.Workload(pod)
+		if err != nil {
+			return input, until, err
+		}
+		input.RoleSessionName = pod.Namespace + "@" + workload
+		if len(input.RoleSessionName) > maxRoleSessionName {
+			input.RoleSessionName = input.RoleSessionName[:maxRoleSessionName]
+		}
+	}
+	return input, until, nil
+}
+
+func (b *Broker) getProfile(ctx context.Context, namespace, name string, profile *v1.AwsIamRaRoleProfile) error {
+	if err := b.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, profile); err != nil {
+		if apierrors.IsNotFound(err) {
+			return forbidden("profile %s not found", name)
+		}
+		return fmt.Errorf("unable to fetch profile %s: %w", name, err)
+	}
+	return nil
+}
+
+// session returns the cached session for input and until, dropping sessions
+// that haven't been used for a while.
+func (b *Broker) session(input rolesanywhere.SessionInput, until time.Time) *rolesanywhere.SessionProvider {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for key, session := range b.sessions {
+		if now.Sub(session.lastUsed) > sessionIdleTimeout {
+			delete(b.sessions, key)
+		}
+	}
+	key := sessionKey{input: input, until: until.Unix()}
+	session, ok := b.sessions[key]
+	if !ok {
+		session = &cachedSession{provider: &rolesanywhere.SessionProvider{
+			Client: &rolesanywhere.Client{Signer: b.signer, Endpoint: b.Endpoint},
+			Input:  input,
+			Until:  until,
+		}}
+		b.sessions[key] = session
+	}
+	session.lastUsed = now
+	return session.provider
+}
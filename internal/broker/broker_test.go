@@ -0,0 +1,304 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestSigner() *rolesanywhere.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "broker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return &rolesanywhere.Signer{Certificate: cert, PrivateKey: key}
+}
+
+// fakeCreateSession stands in for Roles Anywhere, recording the inputs of the
+// sessions it creates.
+func fakeCreateSession(inputs *[]rolesanywhere.SessionInput) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		Expect(r.URL.Path).To(Equal("/sessions"))
+		var input rolesanywhere.SessionInput
+		Expect(json.NewDecoder(r.Body).Decode(&input)).To(Succeed())
+		*inputs = append(*inputs, input)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"credentialSet": []any{map[string]any{"credentials": rolesanywhere.Credentials{
+				AccessKeyID:     "AKIA" + input.RoleSessionName,
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Expiration:      time.Now().Add(time.Hour),
+			}}},
+		})
+	}))
+}
+
+// fakeTokenReviews authenticates the tokens in pods, which map them to the
+// pod they are bound to.
+func fakeTokenReviews(pods map[string]*corev1.Pod) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			pod, ok := pods[review.Spec.Token]
+			if !ok {
+				review.Status.Error = "invalid bearer token"
+				return true, review, nil
+			}
+			review.Status.Authenticated = true
+			review.Status.Audiences = review.Spec.Audiences
+			review.Status.User = authenticationv1.UserInfo{
+				Username: serviceaccount.MakeUsername(pod.Namespace, pod.Spec.ServiceAccountName),
+				Extra: map[string]authenticationv1.ExtraValue{
+					serviceaccount.PodNameKey: {pod.Name},
+					serviceaccount.PodUIDKey:  {string(pod.UID)},
+				},
+			}
+			return true, review, nil
+		})
+	return clientset
+}
+
+func newTestPod(name, workload, profiles string) *corev1.Pod {
+	annotations := map[string]string{v1.RoleProfilePodAnnotationKey: profiles}
+	for _, profile := range strings.Split(profiles, ",") {
+		iamram.SetProfileHash(annotations, profile, "0123456789")
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "team-a",
+			UID:         types.UID("uid-" + name),
+			Labels:      map[string]string{"pod-template-hash": "5d8f7c"},
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: workload + "-5d8f7c", Controller: ptr.To(true),
+			}},
+		},
+		Spec: corev1.PodSpec{ServiceAccountName: "builder"},
+	}
+}
+
+func newTestProfile(name string, source v1.CertificateSource) *v1.AwsIamRaRoleProfile {
+	return &v1.AwsIamRaRoleProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+		Spec: v1.AwsIamRaRoleProfileSpec{
+			TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:        v1.ARN("arn:aws:iam::123456789012:role/" + name),
+			Certificate:    &v1.CertificateSpec{Source: source},
+		},
+	}
+}
+
+var _ = Describe("Broker", func() {
+	var (
+		inputs  []rolesanywhere.SessionInput
+		server  *httptest.Server
+		tokens  map[string]*corev1.Pod
+		c       client.Client
+		handler *Broker
+	)
+
+	BeforeEach(func() {
+		inputs = nil
+		server = fakeCreateSession(&inputs)
+		web1 := newTestPod("web-1", "web", "reader,writer")
+		web2 := newTestPod("web-2", "web", "reader,writer")
+		other := newTestPod("other-1", "other", "mounted")
+		tokens = map[string]*corev1.Pod{"web-1-token": web1, "web-2-token": web2, "other-token": other}
+
+		scheme := runtime.NewScheme()
+		Expect(v1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		c = clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			web1, web2, other,
+			newTestProfile("reader", v1.CertificateSourceBroker),
+			newTestProfile("writer", ""),
+			newTestProfile("mounted", v1.CertificateSourceSecret),
+		).Build()
+		handler = NewBroker(newTestSigner(), fakeTokenReviews(tokens).AuthenticationV1().TokenReviews(), c,
+			logr.Discard())
+		handler.Endpoint = server.URL
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(token, profile string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, v1.BrokerCredentialsPath+"?profile="+profile, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("should share the session of a workload between its pods", func() {
+		response := get("web-1-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		var creds rolesanywhere.Credentials
+		Expect(json.Unmarshal(response.Body.Bytes(), &creds)).To(Succeed())
+		Expect(creds.AccessKeyID).To(Equal("AKIAteam-a@web"))
+		Expect(inputs).To(ConsistOf(rolesanywhere.SessionInput{
+			TrustAnchorArn:  "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:      "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:         "arn:aws:iam::123456789012:role/reader",
+			RoleSessionName: "team-a@web",
+		}))
+
+		By("serving another replica from the cache")
+		Expect(get("web-2-token", "reader").Code).To(Equal(http.StatusOK))
+		Expect(inputs).To(HaveLen(1))
+
+		By("serving the other profiles of the pod with their own session")
+		Expect(get("web-2-token", "writer").Code).To(Equal(http.StatusOK))
+		Expect(inputs).To(HaveLen(2))
+		Expect(inputs[1].RoleArn).To(Equal("arn:aws:iam::123456789012:role/writer"))
+
+		By("creating new sessions with a renewed certificate")
+		handler.SetSigner(newTestSigner())
+		Expect(get("web-1-token", "reader").Code).To(Equal(http.StatusOK))
+		Expect(inputs).To(HaveLen(3))
+	})
+
+	It("should only serve authenticated pods the profiles they use through the broker", func() {
+		Expect(get("", "reader").Code).To(Equal(http.StatusUnauthorized))
+		Expect(get("stolen-token", "reader").Code).To(Equal(http.StatusUnauthorized))
+
+		response := get("web-1-token", "mounted")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("doesn't use profile mounted"))
+
+		response = get("other-token", "mounted")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("doesn't get its credentials from the broker"))
+
+		By("rejecting tokens of pods that were replaced")
+		tokens["web-1-token"] = tokens["web-1-token"].DeepCopy()
+		tokens["web-1-token"].UID = "uid-old"
+		Expect(get("web-1-token", "reader").Code).To(Equal(http.StatusUnauthorized))
+		Expect(inputs).To(BeEmpty())
+
+		By("refusing profiles while they are suspended")
+		profile := &v1.AwsIamRaRoleProfile{}
+		Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "reader"}, profile)).
+			To(Succeed())
+		profile.Spec.Suspended = true
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = get("web-2-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("profile reader is suspended"))
+		Expect(inputs).To(BeEmpty())
+	})
+
+	It("should only serve profiles the pod was admitted with and that allow its service account", func() {
+		By("refusing profiles added to the pod after it was admitted")
+		pod := &corev1.Pod{}
+		Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "web-1"}, pod)).
+			To(Succeed())
+		pod.Annotations[v1.ProfileHashesPodAnnotationKey] = "reader=0123456789"
+		Expect(c.Update(context.Background(), pod)).To(Succeed())
+		response := get("web-1-token", "writer")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("wasn't admitted with profile writer"))
+
+		By("refusing profiles that no longer allow the service account")
+		profile := &v1.AwsIamRaRoleProfile{}
+		Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "reader"}, profile)).
+			To(Succeed())
+		profile.Spec.AllowedServiceAccounts = &v1.AllowedServiceAccounts{Names: []string{"deployer"}}
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = get("web-2-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("doesn't allow service account builder"))
+		Expect(inputs).To(BeEmpty())
+	})
+
+	It("should only serve profiles within their activation window, until it closes", func() {
+		profile := &v1.AwsIamRaRoleProfile{}
+		Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "reader"}, profile)).
+			To(Succeed())
+		until := time.Now().Add(20 * time.Minute).Truncate(time.Second)
+		profile.Spec.ActiveUntil = &metav1.Time{Time: until}
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response := get("web-1-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		var creds rolesanywhere.Credentials
+		Expect(json.Unmarshal(response.Body.Bytes(), &creds)).To(Succeed())
+		Expect(creds.Expiration).To(BeTemporally("==", until))
+		Expect(inputs).To(HaveLen(1))
+		Expect(inputs[0].DurationSeconds).To(BeNumerically("~", 20*60, 2))
+
+		By("refusing profiles outside their window")
+		profile.Spec.ActiveFrom = &metav1.Time{Time: until.Add(time.Hour)}
+		profile.Spec.ActiveUntil = &metav1.Time{Time: until.Add(2 * time.Hour)}
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = get("web-1-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("profile reader is outside its activation window"))
+		Expect(inputs).To(HaveLen(1))
+	})
+
+	It("should report CreateSession failures", func() {
+		server.Close()
+		response := get("web-1-token", "reader")
+		Expect(response.Code).To(Equal(http.StatusBadGateway))
+		Expect(strings.TrimSpace(response.Body.String())).NotTo(BeEmpty())
+	})
+})
@@ -0,0 +1,246 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"slices"
+	"sort"
+)
+
+// AwsIamRaCertificateRevocationReconciler reconciles a AwsIamRaCertificateRevocation object
+type AwsIamRaCertificateRevocationReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	Recorder   record.EventRecorder
+	KubeConfig *rest.Config
+
+	// notify sends the revoked serial numbers to the sidecar of a pod. It
+	// defaults to exec'ing revoke-certificates in the sidecar.
+	notify func(ctx context.Context, pod corev1.Pod, serials []string) error
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracertificaterevocations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracertificaterevocations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracertificaterevocations/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile records the serial number of the certificate an
+// AwsIamRaCertificateRevocation revokes, and sends the serial numbers revoked
+// in its namespace to the sidecars of the running pods there, so that those
+// holding a revoked certificate stop serving credentials. Revocations that
+// were deleted are withdrawn from the sidecars the same way.
+func (r *AwsIamRaCertificateRevocationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var revocation v1.AwsIamRaCertificateRevocation
+	if err := r.Get(ctx, req.NamespacedName, &revocation); apierrors.IsNotFound(err) {
+		return ctrl.Result{}, r.notifyPods(ctx, req.Namespace, nil)
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if revocation.Status.SerialNumber == "" {
+		if err := r.resolve(ctx, &revocation); err != nil {
+			log.FromContext(ctx).Error(err, "unable to resolve revoked certificate")
+			r.Recorder.Event(&revocation, corev1.EventTypeWarning, "ResolutionFailed", err.Error())
+			meta.SetStatusCondition(&revocation.Status.Conditions, metav1.Condition{
+				Type:    v1.RevocationConditionResolved,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ResolutionFailed",
+				Message: err.Error(),
+			})
+			if err := r.Status().Update(ctx, &revocation); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: credentialRetryInterval}, nil
+		}
+		meta.SetStatusCondition(&revocation.Status.Conditions, metav1.Condition{
+			Type:    v1.RevocationConditionResolved,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Resolved",
+			Message: fmt.Sprintf("revoked certificate %s", revocation.Status.SerialNumber),
+		})
+		if err := r.Status().Update(ctx, &revocation); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&revocation, corev1.EventTypeNormal, "Revoked",
+			"Revoked certificate %s", revocation.Status.SerialNumber)
+	}
+
+	if err := r.notifyPods(ctx, req.Namespace, &revocation); err != nil {
+		r.Recorder.Event(&revocation, corev1.EventTypeWarning, "NotifyFailed", err.Error())
+		return ctrl.Result{RequeueAfter: credentialRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// resolve records the serial number, issuer and expiry of the revoked
+// certificate in the status.
+func (r *AwsIamRaCertificateRevocationReconciler) resolve(
+	ctx context.Context, revocation *v1.AwsIamRaCertificateRevocation,
+) error {
+	spec := revocation.Spec
+	now := metav1.Now()
+	if spec.SerialNumber != "" {
+		serial, err := pki.ParseSerial(spec.SerialNumber)
+		if err != nil {
+			return err
+		}
+		revocation.Status.SerialNumber = pki.FormatSerial(serial)
+		revocation.Status.RevokedAt = &now
+		return nil
+	}
+
+	secretName := spec.SecretName
+	if spec.PodName != "" {
+		var pod corev1.Pod
+		if err := r.Get(ctx, types.NamespacedName{Namespace: revocation.Namespace, Name: spec.PodName}, &pod); err != nil {
+			return fmt.Errorf("unable to fetch pod %s: %w", spec.PodName, err)
+		}
+		if secretName = iamram.CertSecretName(&pod); secretName == "" {
+			return fmt.Errorf("pod %s has no certificate Secret, revoke its certificate by serial number", pod.Name)
+		}
+	}
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: revocation.Namespace, Name: secretName}, &secret); err != nil {
+		return fmt.Errorf("unable to fetch certificate secret %s: %w", secretName, err)
+	}
+	cert := issuedCertificate(&secret)
+	if cert == nil {
+		return fmt.Errorf("secret %s holds no certificate", secretName)
+	}
+	revocation.Status.SerialNumber = pki.FormatSerial(cert.SerialNumber)
+	revocation.Status.Issuer = cert.Issuer.String()
+	revocation.Status.NotAfter = &metav1.Time{Time: cert.NotAfter}
+	revocation.Status.RevokedAt = &now
+	return nil
+}
+
+// notifyPods sends the serial numbers revoked in namespace to the sidecars of
+// its running pods. When revocation is set, only pods it hasn't notified yet
+// are sent the list, and they are recorded in its status; otherwise every
+// pod is.
+func (r *AwsIamRaCertificateRevocationReconciler) notifyPods(
+	ctx context.Context, namespace string, revocation *v1.AwsIamRaCertificateRevocation,
+) error {
+	var revocations v1.AwsIamRaCertificateRevocationList
+	if err := r.List(ctx, &revocations, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	var serials []string
+	if revocation != nil {
+		// The cache may not have the serial number just resolved yet.
+		revocations.Items = append(revocations.Items, *revocation)
+	}
+	for _, item := range revocations.Items {
+		if serial := item.Status.SerialNumber; serial != "" && item.DeletionTimestamp.IsZero() &&
+			!slices.Contains(serials, serial) {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	var notified []string
+	var failed error
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || !pod.DeletionTimestamp.IsZero() ||
+			len(iamram.ProfileNames(&pod)) == 0 || iamram.UsesNodeAgent(&pod) {
+			continue
+		}
+		if revocation != nil && slices.Contains(revocation.Status.NotifiedPods, pod.Name) {
+			notified = append(notified, pod.Name)
+			continue
+		}
+		if err := r.notifyPod(ctx, pod, serials); err != nil {
+			failed = fmt.Errorf("unable to notify pod %s: %w", pod.Name, err)
+			continue
+		}
+		notified = append(notified, pod.Name)
+	}
+	if revocation == nil {
+		return failed
+	}
+
+	sort.Strings(notified)
+	if !slices.Equal(notified, revocation.Status.NotifiedPods) {
+		revocation.Status.NotifiedPods = notified
+		if err := r.Status().Update(ctx, revocation); err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+func (r *AwsIamRaCertificateRevocationReconciler) notifyPod(ctx context.Context, pod corev1.Pod, serials []string) error {
+	if r.notify != nil {
+		return r.notify(ctx, pod, serials)
+	}
+	k, err := kubernetes.NewForConfig(r.KubeConfig)
+	if err != nil {
+		return err
+	}
+	return iamram.RevokeCertificates(ctx, k, r.KubeConfig, pod, serials)
+}
+
+// revocationsInNamespace maps an object to the revocations in its namespace.
+func (r *AwsIamRaCertificateRevocationReconciler) revocationsInNamespace(
+	ctx context.Context, obj client.Object,
+) []reconcile.Request {
+	var revocations v1.AwsIamRaCertificateRevocationList
+	if err := r.List(ctx, &revocations, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list revocations")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(revocations.Items))
+	for _, revocation := range revocations.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&revocation)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AwsIamRaCertificateRevocationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.AwsIamRaCertificateRevocation{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.revocationsInNamespace)).
+		Named("awsiamracertificaterevocation").
+		Complete(r)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+)
+
+var _ = Describe("AwsIamRaCertificateRevocation Controller", func() {
+	Context("When reconciling a resource", func() {
+		ctx := context.Background()
+		var (
+			recorder   *record.FakeRecorder
+			reconciler *AwsIamRaCertificateRevocationReconciler
+			notified   map[string][]string
+			certSecret *corev1.Secret
+			pod        *corev1.Pod
+			objects    []client.Object
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			notified = map[string][]string{}
+			reconciler = &AwsIamRaCertificateRevocationReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				notify: func(_ context.Context, pod corev1.Pod, serials []string) error {
+					notified[pod.Name] = serials
+					return nil
+				},
+			}
+			certSecret = newTestCertSecret("leaked-cert")
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "leaky-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						v1.RoleProfilePodAnnotationKey: "leaky-profile",
+						v1.CertSecretPodAnnotationKey:  "leaked-cert",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			objects = []client.Object{certSecret, pod}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+			pod.Status.Phase = corev1.PodRunning
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should revoke the certificate of a pod and tell its sidecar", func() {
+			revocation := &v1.AwsIamRaCertificateRevocation{
+				ObjectMeta: metav1.ObjectMeta{Name: "leak", Namespace: "default"},
+				Spec:       v1.AwsIamRaCertificateRevocationSpec{PodName: "leaky-pod"},
+			}
+			Expect(k8sClient.Create(ctx, revocation)).To(Succeed())
+			objects = append(objects, revocation)
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(revocation)}
+
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(revocation), revocation)).To(Succeed())
+			cert := issuedCertificate(certSecret)
+			serial := pki.FormatSerial(cert.SerialNumber)
+			Expect(revocation.Spec.Reason).To(Equal(v1.RevocationReasonKeyCompromise))
+			Expect(revocation.Status.SerialNumber).To(Equal(serial))
+			Expect(revocation.Status.Issuer).To(Equal(cert.Issuer.String()))
+			Expect(revocation.Status.RevokedAt).NotTo(BeNil())
+			Expect(meta.IsStatusConditionTrue(revocation.Status.Conditions, v1.RevocationConditionResolved)).To(BeTrue())
+			Expect(revocation.Status.NotifiedPods).To(Equal([]string{"leaky-pod"}))
+			Expect(notified).To(HaveKeyWithValue("leaky-pod", []string{serial}))
+			Expect(recorder.Events).To(Receive(ContainSubstring("Revoked")))
+
+			By("reconciling again once the pod is notified")
+			delete(notified, "leaky-pod")
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notified).To(BeEmpty())
+
+			By("deleting the revocation")
+			Expect(k8sClient.Delete(ctx, revocation)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notified).To(HaveKeyWithValue("leaky-pod", BeEmpty()))
+		})
+
+		It("should report revocations that can't be resolved", func() {
+			revocation := &v1.AwsIamRaCertificateRevocation{
+				ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "default"},
+				Spec:       v1.AwsIamRaCertificateRevocationSpec{SecretName: "missing-cert"},
+			}
+			Expect(k8sClient.Create(ctx, revocation)).To(Succeed())
+			objects = append(objects, revocation)
+
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(revocation)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(credentialRetryInterval))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(revocation), revocation)).To(Succeed())
+			Expect(revocation.Status.SerialNumber).To(BeEmpty())
+			condition := meta.FindStatusCondition(revocation.Status.Conditions, v1.RevocationConditionResolved)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(Equal("ResolutionFailed"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("ResolutionFailed")))
+			Expect(notified).To(BeEmpty())
+		})
+
+		It("should reject revocations naming more than one certificate", func() {
+			revocation := &v1.AwsIamRaCertificateRevocation{
+				ObjectMeta: metav1.ObjectMeta{Name: "ambiguous", Namespace: "default"},
+				Spec: v1.AwsIamRaCertificateRevocationSpec{
+					SecretName:   "leaked-cert",
+					SerialNumber: "0a:1b",
+				},
+			}
+			Expect(k8sClient.Create(ctx, revocation)).To(MatchError(ContainSubstring("exactly one of")))
+		})
+	})
+})
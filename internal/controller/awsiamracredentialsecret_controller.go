@@ -0,0 +1,305 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+const (
+	// credentialSecretHashAnnotationKey records the inputs the credentials in
+	// a Secret were issued for, so that changes to them trigger a refresh.
+	credentialSecretHashAnnotationKey = "cloud.dancav.io/aws-iamra-credential-inputs"
+	// credentialSecretExpirationAnnotationKey records when the credentials in
+	// a Secret expire.
+	credentialSecretExpirationAnnotationKey = "cloud.dancav.io/aws-iamra-credential-expiration"
+
+	sharedCredentialsFileKey = "credentials"
+	defaultRefreshBefore     = 15 * time.Minute
+	credentialRetryInterval  = time.Minute
+)
+
+// AwsIamRaCredentialSecretReconciler reconciles a AwsIamRaCredentialSecret object
+type AwsIamRaCredentialSecretReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Endpoint overrides the Roles Anywhere endpoint derived from the trust
+	// anchor ARN.
+	Endpoint string
+	// Store says whether every profile requires the use permission. If nil,
+	// only those setting requireUsePermission do.
+	Store *managerconfig.Store
+	// SecretMetadata caches the metadata of every Secret, so that rotating the
+	// certificate Secret, which the manager's cache doesn't hold, refreshes
+	// the credentials.
+	SecretMetadata cache.Cache
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracredentialsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracredentialsecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracredentialsecrets/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Reconcile keeps the target Secret of an AwsIamRaCredentialSecret filled with
+// credentials for its role profile, refreshing them before they expire. The
+// user who last changed the AwsIamRaCredentialSecret must be allowed to use
+// the profile, and the target Secret must not exist or be controlled by it.
+// While the profile is suspended or outside its activation window, the Secret
+// is emptied rather than refreshed, and credentials issued within the window
+// expire when it closes.
+func (r *AwsIamRaCredentialSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var credSecret v1.AwsIamRaCredentialSecret
+	if err := r.Get(ctx, req.NamespacedName, &credSecret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	session, err := getProfileSession(ctx, r.Client, req.Namespace, credSecret.Spec.ProfileName,
+		credSecret.Spec.CertSecretName, fmt.Sprintf("%s@%s", credSecret.Namespace, credSecret.Name))
+	if err != nil {
+		return r.refreshFailed(ctx, &credSecret, err)
+	}
+	requireAll := r.Store != nil && r.Store.RequireProfileUsePermission()
+	if err := checkProfileUse(ctx, r.Client, &credSecret, &session.Profile, requireAll); err != nil {
+		return r.refreshFailed(ctx, &credSecret, err)
+	}
+	activation, err := iamram.ProfileActivation(&session.Profile.Spec, time.Now())
+	if err != nil {
+		return r.refreshFailed(ctx, &credSecret, fmt.Errorf("profile %s: %w", session.Profile.Name, err))
+	}
+	// The end of the window is part of the hash, since the profile doesn't
+	// change when it moves to the next window of a schedule.
+	until := activation.Until()
+	inputHash, err := session.InputHash(credSecret.Spec.Format, credSecret.Spec.AwsProfileName, until)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	target := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: credSecret.Namespace,
+		Name:      targetSecretName(&credSecret),
+	}}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(target), target); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	if target.ResourceVersion != "" && !metav1.IsControlledBy(target, &credSecret) {
+		return r.refreshFailed(ctx, &credSecret, notControlledError(target, "AwsIamRaCredentialSecret"))
+	}
+	if session.Profile.Spec.Suspended {
+		return ctrl.Result{}, r.withholdCredentials(ctx, &credSecret, target,
+			fmt.Sprintf("profile %s is suspended", session.Profile.Name))
+	}
+	if !activation.Active {
+		return untilNextTransition(activation), r.withholdCredentials(ctx, &credSecret, target,
+			fmt.Sprintf("profile %s is outside its activation window", session.Profile.Name))
+	}
+	if target.Annotations[credentialSecretHashAnnotationKey] == inputHash {
+		expiration, err := time.Parse(time.RFC3339, target.Annotations[credentialSecretExpirationAnnotationKey])
+		if err == nil {
+			if wait := time.Until(refreshAt(&credSecret, expiration, until)); wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+	}
+
+	rolesAnywhere, err := session.Client(r.Endpoint)
+	if err != nil {
+		return r.refreshFailed(ctx, &credSecret, err)
+	}
+	provider := &rolesanywhere.SessionProvider{Client: rolesAnywhere, Input: session.Input, Until: until}
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return r.refreshFailed(ctx, &credSecret, err)
+	}
+
+	data, err := credentialSecretData(&credSecret.Spec, creds)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, target, func() error {
+		if target.ResourceVersion != "" && !metav1.IsControlledBy(target, &credSecret) {
+			return notControlledError(target, "AwsIamRaCredentialSecret")
+		}
+		if target.Labels == nil {
+			target.Labels = map[string]string{}
+		}
+		if target.Annotations == nil {
+			target.Annotations = map[string]string{}
+		}
+		target.Labels[v1.ManagedSecretLabelKey] = "true"
+		target.Annotations[credentialSecretHashAnnotationKey] = inputHash
+		target.Annotations[credentialSecretExpirationAnnotationKey] = creds.Expiration.UTC().Format(time.RFC3339)
+		target.Type = corev1.SecretTypeOpaque
+		target.Data = data
+		return controllerutil.SetControllerReference(&credSecret, target, r.Scheme)
+	}); err != nil {
+		return r.refreshFailed(ctx, &credSecret, fmt.Errorf("unable to write secret %s: %w", target.Name, err))
+	}
+
+	now := metav1.Now()
+	credSecret.Status.Expiration = &metav1.Time{Time: creds.Expiration}
+	credSecret.Status.LastRefreshTime = &now
+	credSecret.Status.LastRefreshError = ""
+	if err := r.Status().Update(ctx, &credSecret); err != nil {
+		logger.Error(err, "unable to update AwsIamRaCredentialSecret status")
+		return ctrl.Result{}, err
+	}
+	logger.Info("Refreshed credentials", "secret", target.Name, "expiration", creds.Expiration)
+	return ctrl.Result{RequeueAfter: time.Until(refreshAt(&credSecret, creds.Expiration, until))}, nil
+}
+
+func (r *AwsIamRaCredentialSecretReconciler) refreshFailed(
+	ctx context.Context, credSecret *v1.AwsIamRaCredentialSecret, cause error,
+) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(cause, "unable to refresh credentials")
+	r.Recorder.Event(credSecret, corev1.EventTypeWarning, "RefreshFailed", cause.Error())
+	credSecret.Status.LastRefreshError = cause.Error()
+	if err := r.Status().Update(ctx, credSecret); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: credentialRetryInterval}, nil
+}
+
+// withholdCredentials empties the target Secret, if it exists, so that
+// credentials of a profile that may not be used are no longer served, and
+// reports why in the status.
+func (r *AwsIamRaCredentialSecretReconciler) withholdCredentials(
+	ctx context.Context, credSecret *v1.AwsIamRaCredentialSecret, target *corev1.Secret, reason string,
+) error {
+	if target.ResourceVersion != "" && (len(target.Data) > 0 ||
+		target.Annotations[credentialSecretHashAnnotationKey] != "") {
+		target.Data = nil
+		delete(target.Annotations, credentialSecretHashAnnotationKey)
+		delete(target.Annotations, credentialSecretExpirationAnnotationKey)
+		if err := r.Update(ctx, target); err != nil {
+			return fmt.Errorf("unable to empty secret %s: %w", target.Name, err)
+		}
+		log.FromContext(ctx).Info("Withheld credentials", "secret", target.Name, "reason", reason)
+		r.Recorder.Eventf(credSecret, corev1.EventTypeNormal, "CredentialsWithheld",
+			"Emptied secret %s: %s", target.Name, reason)
+	}
+	credSecret.Status.Expiration = nil
+	credSecret.Status.LastRefreshError = reason
+	return r.Status().Update(ctx, credSecret)
+}
+
+func targetSecretName(credSecret *v1.AwsIamRaCredentialSecret) string {
+	if credSecret.Spec.SecretName != "" {
+		return credSecret.Spec.SecretName
+	}
+	return credSecret.Name
+}
+
+// refreshAt returns when credentials expiring at expiration should be
+// refreshed. RefreshBefore is capped at half of the session duration, so that
+// short sessions aren't refreshed continuously. Credentials expiring at the
+// end of the activation window, until, aren't refreshed before it closes.
+func refreshAt(credSecret *v1.AwsIamRaCredentialSecret, expiration, until time.Time) time.Time {
+	if !until.IsZero() && !expiration.Before(until) {
+		// The Secret is emptied when the activation window closes.
+		return until
+	}
+	before := defaultRefreshBefore
+	if credSecret.Spec.RefreshBefore != nil {
+		before = credSecret.Spec.RefreshBefore.Duration
+	}
+	if credSecret.Status.LastRefreshTime != nil {
+		if half := expiration.Sub(credSecret.Status.LastRefreshTime.Time) / 2; before > half {
+			before = half
+		}
+	}
+	return expiration.Add(-before)
+}
+
+func credentialSecretData(spec *v1.AwsIamRaCredentialSecretSpec, creds *rolesanywhere.Credentials) (map[string][]byte, error) {
+	switch spec.Format {
+	case v1.CredentialSecretFormatSharedCredentialsFile:
+		awsProfile := spec.AwsProfileName
+		if awsProfile == "" {
+			awsProfile = "default"
+		}
+		return map[string][]byte{
+			sharedCredentialsFileKey: []byte(fmt.Sprintf(
+				"[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\naws_session_token = %s\n",
+				awsProfile, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)),
+		}, nil
+	case v1.CredentialSecretFormatKeys, "":
+		return map[string][]byte{
+			"AWS_ACCESS_KEY_ID":     []byte(creds.AccessKeyID),
+			"AWS_SECRET_ACCESS_KEY": []byte(creds.SecretAccessKey),
+			"AWS_SESSION_TOKEN":     []byte(creds.SessionToken),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", spec.Format)
+	}
+}
+
+// credentialSecretsFor maps a role profile or certificate Secret to the
+// AwsIamRaCredentialSecrets in its namespace that use it.
+func (r *AwsIamRaCredentialSecretReconciler) credentialSecretsFor(
+	matches func(spec *v1.AwsIamRaCredentialSecretSpec, name string) bool,
+) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var list v1.AwsIamRaCredentialSecretList
+		if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace())); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list AwsIamRaCredentialSecrets")
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, item := range list.Items {
+			if matches(&item.Spec, obj.GetName()) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+			}
+		}
+		return requests
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AwsIamRaCredentialSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.AwsIamRaCredentialSecret{}).
+		Owns(&corev1.Secret{}).
+		Watches(&v1.AwsIamRaRoleProfile{}, handler.EnqueueRequestsFromMapFunc(r.credentialSecretsFor(
+			func(spec *v1.AwsIamRaCredentialSecretSpec, name string) bool { return spec.ProfileName == name }))).
+		WatchesRawSource(secretMetadataSource(r.SecretMetadata, r.credentialSecretsFor(
+			func(spec *v1.AwsIamRaCredentialSecretSpec, name string) bool { return spec.CertSecretName == name }))).
+		Named("awsiamracredentialsecret").
+		Complete(r)
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+)
+
+// reviewingClient answers SubjectAccessReviews itself, allowing the users
+// that allowed returns true for.
+type reviewingClient struct {
+	client.Client
+	allowed func(spec authorizationv1.SubjectAccessReviewSpec) bool
+}
+
+func (c reviewingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		review.Status.Allowed = c.allowed(review.Spec)
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// newTestCertSecret returns a kubernetes.io/tls Secret with a self-signed
+// certificate, good enough to sign requests to a fake Roles Anywhere.
+func newTestCertSecret(name string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+// fakeCreateSession returns a server answering CreateSession with numbered
+// credentials, counting the calls it receives.
+func fakeCreateSession(calls *int, expiration time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"credentialSet":[{"credentials":{"accessKeyId":"AKID%d",`+
+			`"secretAccessKey":"secret","sessionToken":"token","expiration":%q}}]}`,
+			*calls, expiration.UTC().Format(time.RFC3339))
+	}))
+}
+
+var _ = Describe("AwsIamRaCredentialSecret Controller", func() {
+	Context("When reconciling a resource", func() {
+		ctx := context.Background()
+		var (
+			calls      int
+			server     *httptest.Server
+			reconciler *AwsIamRaCredentialSecretReconciler
+			objects    []client.Object
+		)
+
+		BeforeEach(func() {
+			calls = 0
+			server = fakeCreateSession(&calls, time.Now().Add(time.Hour))
+			reconciler = &AwsIamRaCredentialSecretReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+				Endpoint: server.URL,
+			}
+			objects = []client.Object{
+				newTestCertSecret("credential-cert"),
+				&v1.AwsIamRaRoleProfile{
+					ObjectMeta: metav1.ObjectMeta{Name: "credential-profile", Namespace: "default"},
+					Spec: v1.AwsIamRaRoleProfileSpec{
+						TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+						ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+						RoleArn:        "arn:aws:iam::123:role/baz",
+					},
+				},
+			}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		reconcileOnce := func(name string) reconcile.Result {
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: name, Namespace: "default"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return result
+		}
+
+		It("should write a shared credentials file and refresh it only when due", func() {
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared-file", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "credential-profile",
+					CertSecretName: "credential-cert",
+					SecretName:     "shared-file-credentials",
+					Format:         v1.CredentialSecretFormatSharedCredentialsFile,
+					AwsProfileName: "reader",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+
+			result := reconcileOnce(credSecret.Name)
+			Expect(result.RequeueAfter).To(BeNumerically("~", 45*time.Minute, time.Minute))
+
+			var target corev1.Secret
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "shared-file-credentials", Namespace: "default"},
+				&target)).To(Succeed())
+			objects = append(objects, &target)
+			Expect(string(target.Data["credentials"])).To(Equal("[reader]\naws_access_key_id = AKID1\n" +
+				"aws_secret_access_key = secret\naws_session_token = token\n"))
+			Expect(target.OwnerReferences).To(HaveLen(1))
+
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.Expiration).NotTo(BeNil())
+			Expect(credSecret.Status.LastRefreshError).To(BeEmpty())
+
+			By("reconciling again before the refresh is due")
+			reconcileOnce(credSecret.Name)
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should empty the Secret while the profile is suspended", func() {
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "suspended", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "credential-profile",
+					CertSecretName: "credential-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+			reconcileOnce(credSecret.Name)
+			var target corev1.Secret
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), &target)).To(Succeed())
+			objects = append(objects, &target)
+			Expect(target.Data).NotTo(BeEmpty())
+
+			var profile v1.AwsIamRaRoleProfile
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "credential-profile", Namespace: "default"},
+				&profile)).To(Succeed())
+			profile.Spec.Suspended = true
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			Expect(reconcileOnce(credSecret.Name)).To(Equal(reconcile.Result{}))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&target), &target)).To(Succeed())
+			Expect(target.Data).To(BeEmpty())
+			Expect(target.Annotations).NotTo(HaveKey(credentialSecretHashAnnotationKey))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.LastRefreshError).To(Equal("profile credential-profile is suspended"))
+			Expect(credSecret.Status.Expiration).To(BeNil())
+			Expect(calls).To(Equal(1))
+
+			By("issuing credentials again once it's resumed")
+			profile.Spec.Suspended = false
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			reconcileOnce(credSecret.Name)
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&target), &target)).To(Succeed())
+			Expect(target.Data).NotTo(BeEmpty())
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should only issue credentials within the profile's activation window", func() {
+			now := time.Now().Truncate(time.Second)
+			var profile v1.AwsIamRaRoleProfile
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "credential-profile", Namespace: "default"},
+				&profile)).To(Succeed())
+			profile.Spec.ActiveFrom = &metav1.Time{Time: now.Add(time.Hour)}
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "windowed", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "credential-profile",
+					CertSecretName: "credential-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+
+			Expect(reconcileOnce(credSecret.Name).RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.LastRefreshError).To(Equal(
+				"profile credential-profile is outside its activation window"))
+			var target corev1.Secret
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), &target)
+			Expect(client.IgnoreNotFound(err)).To(Succeed())
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(BeZero())
+
+			By("capping the credentials at the end of the window once it is open")
+			profile.Spec.ActiveFrom = &metav1.Time{Time: now.Add(-time.Hour)}
+			profile.Spec.ActiveUntil = &metav1.Time{Time: now.Add(30 * time.Minute)}
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			Expect(reconcileOnce(credSecret.Name).RequeueAfter).To(BeNumerically("~", 30*time.Minute, time.Minute))
+			Expect(calls).To(Equal(1))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), &target)).To(Succeed())
+			objects = append(objects, &target)
+			Expect(target.Annotations[credentialSecretExpirationAnnotationKey]).To(
+				Equal(now.Add(30 * time.Minute).UTC().Format(time.RFC3339)))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.Expiration.Time).To(BeTemporally("==", now.Add(30*time.Minute)))
+			Expect(credSecret.Status.LastRefreshError).To(BeEmpty())
+
+			By("emptying the Secret once the window has closed")
+			profile.Spec.ActiveUntil = &metav1.Time{Time: now.Add(-time.Minute)}
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			Expect(reconcileOnce(credSecret.Name)).To(Equal(reconcile.Result{}))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&target), &target)).To(Succeed())
+			Expect(target.Data).To(BeEmpty())
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should report refresh failures in the status", func() {
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "missing-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "does-not-exist",
+					CertSecretName: "credential-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+
+			result := reconcileOnce(credSecret.Name)
+			Expect(result.RequeueAfter).To(Equal(credentialRetryInterval))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.LastRefreshError).To(ContainSubstring("does-not-exist"))
+			Expect(calls).To(BeZero())
+		})
+
+		It("should leave a Secret it doesn't control untouched", func() {
+			foreign := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("hunter2")},
+			}
+			Expect(k8sClient.Create(ctx, foreign)).To(Succeed())
+			objects = append(objects, foreign)
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "takeover", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "credential-profile",
+					CertSecretName: "credential-cert",
+					SecretName:     "foreign",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+
+			Expect(reconcileOnce(credSecret.Name).RequeueAfter).To(Equal(credentialRetryInterval))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+			Expect(credSecret.Status.LastRefreshError).To(Equal(
+				"secret default/foreign already exists and isn't controlled by this AwsIamRaCredentialSecret"))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(foreign), foreign)).To(Succeed())
+			Expect(foreign.Data).To(Equal(map[string][]byte{"password": []byte("hunter2")}))
+			Expect(foreign.OwnerReferences).To(BeEmpty())
+			Expect(calls).To(BeZero())
+		})
+
+		It("should only issue credentials of profiles the requester may use", func() {
+			reconciler.Client = reviewingClient{Client: k8sClient,
+				allowed: func(spec authorizationv1.SubjectAccessReviewSpec) bool {
+					return spec.User == "alice" && spec.ResourceAttributes.Verb == "use" &&
+						spec.ResourceAttributes.Name == "restricted-profile"
+				}}
+			restricted := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "restricted-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn:       "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:           "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:              "arn:aws:iam::123:role/admin",
+					RequireUsePermission: true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, restricted)).To(Succeed())
+			objects = append(objects, restricted)
+			credSecret := &v1.AwsIamRaCredentialSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "restricted", Namespace: "default"},
+				Spec: v1.AwsIamRaCredentialSecretSpec{
+					ProfileName:    "restricted-profile",
+					CertSecretName: "credential-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, credSecret)).To(Succeed())
+			objects = append(objects, credSecret)
+			refreshError := func() string {
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(credSecret), credSecret)).To(Succeed())
+				return credSecret.Status.LastRefreshError
+			}
+			requestedBy := func(username string) {
+				Expect(iamram.SetRequester(credSecret, authenticationv1.UserInfo{Username: username})).To(Succeed())
+				Expect(k8sClient.Update(ctx, credSecret)).To(Succeed())
+			}
+
+			Expect(reconcileOnce(credSecret.Name).RequeueAfter).To(Equal(credentialRetryInterval))
+			Expect(refreshError()).To(ContainSubstring("no requester is recorded"))
+
+			requestedBy("mallory")
+			reconcileOnce(credSecret.Name)
+			Expect(refreshError()).To(Equal("mallory may not use profile restricted-profile: grant them the " +
+				`"use" verb on awsiamraroleprofiles/restricted-profile`))
+			Expect(calls).To(BeZero())
+
+			requestedBy("alice")
+			reconcileOnce(credSecret.Name)
+			Expect(refreshError()).To(BeEmpty())
+			Expect(calls).To(Equal(1))
+			objects = append(objects, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "restricted",
+				Namespace: "default"}})
+
+			By("refusing profiles restricted to service accounts")
+			restricted.Spec.AllowedServiceAccounts = &v1.AllowedServiceAccounts{Names: []string{"billing"}}
+			Expect(k8sClient.Update(ctx, restricted)).To(Succeed())
+			reconcileOnce(credSecret.Name)
+			Expect(refreshError()).To(ContainSubstring("restricted to the service accounts"))
+		})
+	})
+})
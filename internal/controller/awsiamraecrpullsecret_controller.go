@@ -0,0 +1,498 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/ecr"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// ecrOwnerNamespaceLabelKey and ecrOwnerNameLabelKey mark the pull
+	// Secrets written for an AwsIamRaEcrPullSecret. Secrets in other
+	// namespaces can't have an owner reference, so they are found and cleaned
+	// up through these labels.
+	ecrOwnerNamespaceLabelKey = "cloud.dancav.io/aws-iamra-ecr-owner-namespace"
+	ecrOwnerNameLabelKey      = "cloud.dancav.io/aws-iamra-ecr-owner-name"
+
+	ecrPullSecretFinalizer = "cloud.dancav.io/ecr-pull-secrets"
+	defaultRefreshInterval = 6 * time.Hour
+	// emptyDockerConfig logs in to no registry.
+	emptyDockerConfig = `{"auths":{}}`
+)
+
+// AwsIamRaEcrPullSecretReconciler reconciles a AwsIamRaEcrPullSecret object
+type AwsIamRaEcrPullSecretReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Endpoint overrides the Roles Anywhere endpoint derived from the trust
+	// anchor ARN.
+	Endpoint string
+	// EcrEndpoint overrides the regional ECR endpoint.
+	EcrEndpoint string
+	// Store holds the namespaces whose AwsIamRaEcrPullSecrets may write to
+	// other namespaces, and whether every profile requires the use
+	// permission. If nil, none may and only those setting
+	// requireUsePermission do.
+	Store *managerconfig.Store
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraecrpullsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraecrpullsecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraecrpullsecrets/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Reconcile writes an ECR pull Secret to every namespace selected by an
+// AwsIamRaEcrPullSecret, requesting a new token when the current one is due
+// for refresh, and removes the Secrets from namespaces no longer selected.
+// Only AwsIamRaEcrPullSecrets of the source namespaces of the
+// IamRaManagerConfig may select namespaces other than their own, and Secrets
+// written by someone else are left alone. While the profile is suspended or
+// outside its activation window, the Secrets hold no token, and tokens fetched
+// within the window are withdrawn when it closes.
+func (r *AwsIamRaEcrPullSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var pullSecret v1.AwsIamRaEcrPullSecret
+	if err := r.Get(ctx, req.NamespacedName, &pullSecret); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !pullSecret.DeletionTimestamp.IsZero() {
+		if err := r.removeStaleSecrets(ctx, &pullSecret, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(&pullSecret, ecrPullSecretFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &pullSecret)
+	}
+	if controllerutil.AddFinalizer(&pullSecret, ecrPullSecretFinalizer) {
+		if err := r.Update(ctx, &pullSecret); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if pullSecret.Spec.NamespaceSelector != nil && !r.maySelectNamespaces(&pullSecret) {
+		own := []string{pullSecret.Namespace}
+		if err := r.removeStaleSecrets(ctx, &pullSecret, own); err != nil {
+			return ctrl.Result{}, err
+		}
+		pullSecret.Status.Namespaces = slices.DeleteFunc(pullSecret.Status.Namespaces,
+			func(namespace string) bool { return namespace != pullSecret.Namespace })
+		return r.refreshFailed(ctx, &pullSecret, fmt.Errorf("namespace %s isn't one of the "+
+			"ecrPullSecrets.sourceNamespaces of the IamRaManagerConfig, so its AwsIamRaEcrPullSecrets "+
+			"can't use a namespaceSelector", pullSecret.Namespace))
+	}
+	namespaces, err := r.targetNamespaces(ctx, &pullSecret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	session, err := getProfileSession(ctx, r.Client, req.Namespace, pullSecret.Spec.ProfileName,
+		pullSecret.Spec.CertSecretName, fmt.Sprintf("%s@%s", pullSecret.Namespace, pullSecret.Name))
+	if err != nil {
+		return r.refreshFailed(ctx, &pullSecret, err)
+	}
+	requireAll := r.Store != nil && r.Store.RequireProfileUsePermission()
+	if err := checkProfileUse(ctx, r.Client, &pullSecret, &session.Profile, requireAll); err != nil {
+		return r.refreshFailed(ctx, &pullSecret, err)
+	}
+	if session.Profile.Spec.Suspended {
+		return ctrl.Result{}, r.withholdToken(ctx, &pullSecret,
+			fmt.Sprintf("profile %s is suspended", session.Profile.Name))
+	}
+	activation, err := iamram.ProfileActivation(&session.Profile.Spec, time.Now())
+	if err != nil {
+		return r.refreshFailed(ctx, &pullSecret, fmt.Errorf("profile %s: %w", session.Profile.Name, err))
+	}
+	if !activation.Active {
+		return untilNextTransition(activation), r.withholdToken(ctx, &pullSecret,
+			fmt.Sprintf("profile %s is outside its activation window", session.Profile.Name))
+	}
+	until := activation.Until()
+	region := pullSecret.Spec.Region
+	if region == "" {
+		if region, err = rolesanywhere.Region(session.Input.TrustAnchorArn); err != nil {
+			return r.refreshFailed(ctx, &pullSecret, err)
+		}
+	}
+	// The end of the window is part of the hash, since the profile doesn't
+	// change when it moves to the next window of a schedule.
+	inputHash, err := session.InputHash(region, until)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	dockerConfig, expiration, err := r.currentToken(ctx, &pullSecret, inputHash, until)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if dockerConfig == nil {
+		rolesAnywhere, err := session.Client(r.Endpoint)
+		if err != nil {
+			return r.refreshFailed(ctx, &pullSecret, err)
+		}
+		registry := &ecr.Client{
+			Credentials: &rolesanywhere.SessionProvider{Client: rolesAnywhere, Input: session.Input, Until: until},
+			Endpoint:    r.EcrEndpoint,
+		}
+		login, err := registry.GetAuthorizationToken(ctx, region)
+		if err != nil {
+			return r.refreshFailed(ctx, &pullSecret, err)
+		}
+		if dockerConfig, err = login.DockerConfigJSON(); err != nil {
+			return ctrl.Result{}, err
+		}
+		expiration = login.ExpiresAt
+		if !until.IsZero() && expiration.After(until) {
+			expiration = until
+		}
+		now := metav1.Now()
+		pullSecret.Status.LastRefreshTime = &now
+		pullSecret.Status.Registry = login.ProxyEndpoint
+		logger.Info("Refreshed ECR token", "registry", login.ProxyEndpoint, "expiration", expiration)
+	}
+
+	for _, namespace := range namespaces {
+		if err := r.writeSecret(ctx, &pullSecret, namespace, dockerConfig, inputHash, expiration); err != nil {
+			return r.refreshFailed(ctx, &pullSecret, err)
+		}
+	}
+	if err := r.removeStaleSecrets(ctx, &pullSecret, namespaces); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pullSecret.Status.Expiration = &metav1.Time{Time: expiration}
+	pullSecret.Status.Namespaces = namespaces
+	pullSecret.Status.LastRefreshError = ""
+	if err := r.Status().Update(ctx, &pullSecret); err != nil {
+		logger.Error(err, "unable to update AwsIamRaEcrPullSecret status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: time.Until(ecrRefreshAt(&pullSecret, expiration, until))}, nil
+}
+
+func (r *AwsIamRaEcrPullSecretReconciler) refreshFailed(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret, cause error,
+) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(cause, "unable to refresh ECR pull secrets")
+	r.Recorder.Event(pullSecret, corev1.EventTypeWarning, "RefreshFailed", cause.Error())
+	pullSecret.Status.LastRefreshError = cause.Error()
+	if err := r.Status().Update(ctx, pullSecret); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: credentialRetryInterval}, nil
+}
+
+// withholdToken empties the Secrets written for pullSecret, so that the token
+// of a profile that may not be used is no longer served, and reports why in
+// the status. The Secrets keep an empty Docker config, as their type requires
+// one, and stay in the ServiceAccounts they were added to.
+func (r *AwsIamRaEcrPullSecretReconciler) withholdToken(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret, reason string,
+) error {
+	secrets, err := r.managedSecrets(ctx, pullSecret)
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		if !writtenFor(secret, pullSecret) || secret.Annotations[credentialSecretHashAnnotationKey] == "" {
+			continue
+		}
+		secret.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(emptyDockerConfig)}
+		delete(secret.Annotations, credentialSecretHashAnnotationKey)
+		delete(secret.Annotations, credentialSecretExpirationAnnotationKey)
+		if err := r.Update(ctx, secret); err != nil {
+			return fmt.Errorf("unable to empty secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		log.FromContext(ctx).Info("Withheld ECR token", "namespace", secret.Namespace, "secret", secret.Name,
+			"reason", reason)
+		r.Recorder.Eventf(pullSecret, corev1.EventTypeNormal, "TokenWithheld", "Emptied secret %s/%s: %s",
+			secret.Namespace, secret.Name, reason)
+	}
+	pullSecret.Status.Expiration = nil
+	pullSecret.Status.LastRefreshError = reason
+	return r.Status().Update(ctx, pullSecret)
+}
+
+// maySelectNamespaces reports whether pullSecret may write to namespaces
+// other than its own. Namespace owners could otherwise overwrite the Secrets
+// and ServiceAccounts of every other team.
+func (r *AwsIamRaEcrPullSecretReconciler) maySelectNamespaces(pullSecret *v1.AwsIamRaEcrPullSecret) bool {
+	return r.Store != nil && slices.Contains(r.Store.EcrPullSecrets().SourceNamespaces, pullSecret.Namespace)
+}
+
+// targetNamespaces returns the sorted names of the namespaces selected by
+// pullSecret, skipping namespaces being deleted.
+func (r *AwsIamRaEcrPullSecretReconciler) targetNamespaces(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret,
+) ([]string, error) {
+	if pullSecret.Spec.NamespaceSelector == nil {
+		return []string{pullSecret.Namespace}, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pullSecret.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	var list corev1.NamespaceList
+	if err := r.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, namespace := range list.Items {
+		if namespace.DeletionTimestamp.IsZero() {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+func (r *AwsIamRaEcrPullSecretReconciler) managedSecrets(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret,
+) ([]corev1.Secret, error) {
+	var list corev1.SecretList
+	err := r.List(ctx, &list, client.MatchingLabels{
+		ecrOwnerNamespaceLabelKey: pullSecret.Namespace,
+		ecrOwnerNameLabelKey:      pullSecret.Name,
+	})
+	return list.Items, err
+}
+
+// currentToken returns the token already written for the same inputs, unless
+// it is due for refresh.
+func (r *AwsIamRaEcrPullSecretReconciler) currentToken(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret, inputHash string, until time.Time,
+) ([]byte, time.Time, error) {
+	secrets, err := r.managedSecrets(ctx, pullSecret)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, secret := range secrets {
+		if secret.Annotations[credentialSecretHashAnnotationKey] != inputHash {
+			continue
+		}
+		expiration, err := time.Parse(time.RFC3339, secret.Annotations[credentialSecretExpirationAnnotationKey])
+		if err != nil || !time.Now().Before(ecrRefreshAt(pullSecret, expiration, until)) {
+			continue
+		}
+		if dockerConfig := secret.Data[corev1.DockerConfigJsonKey]; len(dockerConfig) > 0 {
+			return dockerConfig, expiration, nil
+		}
+	}
+	return nil, time.Time{}, nil
+}
+
+func (r *AwsIamRaEcrPullSecretReconciler) writeSecret(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret, namespace string,
+	dockerConfig []byte, inputHash string, expiration time.Time,
+) error {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      ecrSecretName(pullSecret),
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.ResourceVersion != "" && !writtenFor(secret, pullSecret) {
+			return notControlledError(secret, "AwsIamRaEcrPullSecret")
+		}
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Labels[v1.ManagedSecretLabelKey] = "true"
+		secret.Labels[ecrOwnerNamespaceLabelKey] = pullSecret.Namespace
+		secret.Labels[ecrOwnerNameLabelKey] = pullSecret.Name
+		secret.Annotations[credentialSecretHashAnnotationKey] = inputHash
+		secret.Annotations[credentialSecretExpirationAnnotationKey] = expiration.UTC().Format(time.RFC3339)
+		secret.Type = corev1.SecretTypeDockerConfigJson
+		secret.Data = map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig}
+		if namespace == pullSecret.Namespace {
+			return controllerutil.SetControllerReference(pullSecret, secret, r.Scheme)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("unable to write secret %s/%s: %w", namespace, secret.Name, err)
+	}
+
+	for _, name := range pullSecret.Spec.ServiceAccountNames {
+		var serviceAccount corev1.ServiceAccount
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &serviceAccount)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil || slices.Contains(serviceAccount.ImagePullSecrets, corev1.LocalObjectReference{Name: secret.Name}) {
+			continue
+		}
+		serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets,
+			corev1.LocalObjectReference{Name: secret.Name})
+		if err := r.Update(ctx, &serviceAccount); err != nil {
+			return fmt.Errorf("unable to patch service account %s/%s: %w", namespace, name, err)
+		}
+	}
+	return nil
+}
+
+// removeStaleSecrets deletes the Secrets written for pullSecret outside of
+// namespaces, and removes them from the ServiceAccounts they were added to.
+func (r *AwsIamRaEcrPullSecretReconciler) removeStaleSecrets(
+	ctx context.Context, pullSecret *v1.AwsIamRaEcrPullSecret, namespaces []string,
+) error {
+	secrets, err := r.managedSecrets(ctx, pullSecret)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		if slices.Contains(namespaces, secret.Namespace) {
+			continue
+		}
+		for _, name := range pullSecret.Spec.ServiceAccountNames {
+			var serviceAccount corev1.ServiceAccount
+			err := r.Get(ctx, client.ObjectKey{Namespace: secret.Namespace, Name: name}, &serviceAccount)
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			ref := corev1.LocalObjectReference{Name: secret.Name}
+			if err != nil || !slices.Contains(serviceAccount.ImagePullSecrets, ref) {
+				continue
+			}
+			serviceAccount.ImagePullSecrets = slices.DeleteFunc(serviceAccount.ImagePullSecrets,
+				func(existing corev1.LocalObjectReference) bool { return existing == ref })
+			if err := r.Update(ctx, &serviceAccount); err != nil {
+				return err
+			}
+		}
+		if err := r.Delete(ctx, &secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Removed ECR pull secret", "namespace", secret.Namespace, "secret", secret.Name)
+	}
+	return nil
+}
+
+// writtenFor reports whether the Secret was written for pullSecret: it has
+// its owner labels and, in its namespace, its controller reference.
+func writtenFor(secret *corev1.Secret, pullSecret *v1.AwsIamRaEcrPullSecret) bool {
+	if secret.Labels[ecrOwnerNamespaceLabelKey] != pullSecret.Namespace ||
+		secret.Labels[ecrOwnerNameLabelKey] != pullSecret.Name {
+		return false
+	}
+	return secret.Namespace != pullSecret.Namespace || metav1.IsControlledBy(secret, pullSecret)
+}
+
+func ecrSecretName(pullSecret *v1.AwsIamRaEcrPullSecret) string {
+	if pullSecret.Spec.SecretName != "" {
+		return pullSecret.Spec.SecretName
+	}
+	return pullSecret.Name
+}
+
+// ecrRefreshAt returns when a token expiring at expiration should be
+// refreshed: RefreshInterval after the last refresh, but no later than half
+// way through the token's lifetime. Tokens expiring at the end of the
+// activation window, until, aren't refreshed before it closes.
+func ecrRefreshAt(pullSecret *v1.AwsIamRaEcrPullSecret, expiration, until time.Time) time.Time {
+	if !until.IsZero() && !expiration.Before(until) {
+		// The token is withdrawn when the activation window closes.
+		return until
+	}
+	interval := defaultRefreshInterval
+	if pullSecret.Spec.RefreshInterval != nil {
+		interval = pullSecret.Spec.RefreshInterval.Duration
+	}
+	if pullSecret.Status.LastRefreshTime == nil {
+		return expiration.Add(-expiration.Sub(time.Now()) / 2)
+	}
+	refreshed := pullSecret.Status.LastRefreshTime.Time
+	if half := expiration.Sub(refreshed) / 2; interval > half {
+		interval = half
+	}
+	return refreshed.Add(interval)
+}
+
+// ecrPullSecretsFor maps an object to the AwsIamRaEcrPullSecrets matching it.
+func (r *AwsIamRaEcrPullSecretReconciler) ecrPullSecretsFor(
+	matches func(pullSecret *v1.AwsIamRaEcrPullSecret, obj client.Object) bool,
+) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var list v1.AwsIamRaEcrPullSecretList
+		if err := r.List(ctx, &list); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list AwsIamRaEcrPullSecrets")
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, item := range list.Items {
+			if matches(&item, obj) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+			}
+		}
+		return requests
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AwsIamRaEcrPullSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.AwsIamRaEcrPullSecret{}).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.ecrPullSecretsFor(
+			func(pullSecret *v1.AwsIamRaEcrPullSecret, _ client.Object) bool {
+				return pullSecret.Spec.NamespaceSelector != nil
+			}))).
+		Watches(&v1.AwsIamRaRoleProfile{}, handler.EnqueueRequestsFromMapFunc(r.ecrPullSecretsFor(
+			func(pullSecret *v1.AwsIamRaEcrPullSecret, obj client.Object) bool {
+				return pullSecret.Namespace == obj.GetNamespace() && pullSecret.Spec.ProfileName == obj.GetName()
+			}))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.ecrPullSecretsFor(
+			func(pullSecret *v1.AwsIamRaEcrPullSecret, obj client.Object) bool {
+				if pullSecret.Namespace == obj.GetNamespace() && pullSecret.Spec.CertSecretName == obj.GetName() {
+					return true
+				}
+				labels := obj.GetLabels()
+				return labels[ecrOwnerNamespaceLabelKey] == pullSecret.Namespace &&
+					labels[ecrOwnerNameLabelKey] == pullSecret.Name
+			}))).
+		Watches(&corev1.ServiceAccount{}, handler.EnqueueRequestsFromMapFunc(r.ecrPullSecretsFor(
+			func(pullSecret *v1.AwsIamRaEcrPullSecret, obj client.Object) bool {
+				return slices.Contains(pullSecret.Spec.ServiceAccountNames, obj.GetName())
+			}))).
+		Named("awsiamraecrpullsecret").
+		Complete(r)
+}
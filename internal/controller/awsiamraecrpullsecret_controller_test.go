@@ -0,0 +1,302 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+)
+
+// fakeEcr stands in for both Roles Anywhere and the ECR API, counting the
+// authorization tokens it hands out.
+func fakeEcr(tokens *int) *httptest.Server {
+	var sessions int
+	rolesAnywhere := fakeCreateSession(&sessions, time.Now().Add(time.Hour))
+	mux := http.NewServeMux()
+	mux.Handle("/sessions", rolesAnywhere.Config.Handler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		*tokens++
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"authorizationData": []map[string]any{{
+				"authorizationToken": base64.StdEncoding.EncodeToString([]byte("AWS:password")),
+				"expiresAt":          float64(time.Now().Add(12 * time.Hour).Unix()),
+				"proxyEndpoint":      "https://123.dkr.ecr.us-west-2.amazonaws.com",
+			}},
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+var _ = Describe("AwsIamRaEcrPullSecret Controller", func() {
+	Context("When reconciling a resource", func() {
+		ctx := context.Background()
+		var (
+			tokens     int
+			server     *httptest.Server
+			reconciler *AwsIamRaEcrPullSecretReconciler
+			objects    []client.Object
+		)
+
+		BeforeEach(func() {
+			tokens = 0
+			server = fakeEcr(&tokens)
+			reconciler = &AwsIamRaEcrPullSecretReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				Recorder:    record.NewFakeRecorder(10),
+				Endpoint:    server.URL,
+				EcrEndpoint: server.URL,
+				Store: managerconfig.NewStore(v1.IamRaManagerConfigSpec{
+					EcrPullSecrets: v1.EcrPullSecretsSpec{SourceNamespaces: []string{"default"}},
+				}),
+			}
+			objects = []client.Object{
+				newTestCertSecret("ecr-cert"),
+				&v1.AwsIamRaRoleProfile{
+					ObjectMeta: metav1.ObjectMeta{Name: "ecr-profile", Namespace: "default"},
+					Spec: v1.AwsIamRaRoleProfileSpec{
+						TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+						ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+						RoleArn:        "arn:aws:iam::123:role/ecr-reader",
+					},
+				},
+			}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should keep pull secrets in the selected namespaces", func() {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "ecr-team",
+				Labels: map[string]string{"ecr-pull": "true"},
+			}}
+			Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+			serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "ecr-team"}}
+			Expect(k8sClient.Create(ctx, serviceAccount)).To(Succeed())
+			pullSecret := &v1.AwsIamRaEcrPullSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "ecr", Namespace: "default"},
+				Spec: v1.AwsIamRaEcrPullSecretSpec{
+					ProfileName:    "ecr-profile",
+					CertSecretName: "ecr-cert",
+					SecretName:     "ecr-login",
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"ecr-pull": "true"},
+					},
+					ServiceAccountNames: []string{"builder"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pullSecret)).To(Succeed())
+			objects = append(objects, serviceAccount, namespace)
+
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pullSecret)}
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			var secret corev1.Secret
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "ecr-login", Namespace: "ecr-team"}, &secret)).To(Succeed())
+			Expect(secret.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(ContainSubstring("123.dkr.ecr.us-west-2.amazonaws.com"))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(serviceAccount), serviceAccount)).To(Succeed())
+			Expect(serviceAccount.ImagePullSecrets).To(ConsistOf(corev1.LocalObjectReference{Name: "ecr-login"}))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)).To(Succeed())
+			Expect(pullSecret.Status.Namespaces).To(Equal([]string{"ecr-team"}))
+			Expect(pullSecret.Status.Expiration).NotTo(BeNil())
+
+			By("reconciling again before the refresh is due")
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(Equal(1))
+
+			By("unselecting the namespace")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(namespace), namespace)).To(Succeed())
+			namespace.Labels = nil
+			Expect(k8sClient.Update(ctx, namespace)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)
+			Expect(client.IgnoreNotFound(err)).To(Succeed())
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(serviceAccount), serviceAccount)).To(Succeed())
+			Expect(serviceAccount.ImagePullSecrets).To(BeEmpty())
+
+			By("deleting the resource")
+			Expect(k8sClient.Delete(ctx, pullSecret)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)
+			Expect(client.IgnoreNotFound(err)).To(Succeed())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should empty the pull secrets while the profile is suspended", func() {
+			pullSecret := &v1.AwsIamRaEcrPullSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "suspended-login", Namespace: "default"},
+				Spec: v1.AwsIamRaEcrPullSecretSpec{
+					ProfileName:    "ecr-profile",
+					CertSecretName: "ecr-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, pullSecret)).To(Succeed())
+			objects = append(objects, pullSecret)
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pullSecret)}
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			var secret corev1.Secret
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), &secret)).To(Succeed())
+			objects = append(objects, &secret)
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(ContainSubstring("123.dkr.ecr.us-west-2.amazonaws.com"))
+
+			var profile v1.AwsIamRaRoleProfile
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "ecr-profile", Namespace: "default"},
+				&profile)).To(Succeed())
+			profile.Spec.Suspended = true
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			result, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reconcile.Result{}))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)).To(Succeed())
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(MatchJSON(`{"auths":{}}`))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)).To(Succeed())
+			Expect(pullSecret.Status.LastRefreshError).To(Equal("profile ecr-profile is suspended"))
+			Expect(pullSecret.Status.Expiration).To(BeNil())
+			Expect(tokens).To(Equal(1))
+
+			By("fetching a token again once it's resumed")
+			profile.Spec.Suspended = false
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)).To(Succeed())
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(ContainSubstring("123.dkr.ecr.us-west-2.amazonaws.com"))
+			Expect(tokens).To(Equal(2))
+		})
+
+		It("should withdraw the token when the profile's activation window closes", func() {
+			now := time.Now().Truncate(time.Second)
+			var profile v1.AwsIamRaRoleProfile
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "ecr-profile", Namespace: "default"},
+				&profile)).To(Succeed())
+			profile.Spec.ActiveUntil = &metav1.Time{Time: now.Add(2 * time.Hour)}
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			pullSecret := &v1.AwsIamRaEcrPullSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "windowed-login", Namespace: "default"},
+				Spec: v1.AwsIamRaEcrPullSecretSpec{
+					ProfileName:    "ecr-profile",
+					CertSecretName: "ecr-cert",
+				},
+			}
+			Expect(k8sClient.Create(ctx, pullSecret)).To(Succeed())
+			objects = append(objects, pullSecret)
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pullSecret)}
+
+			result, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 2*time.Hour, time.Minute))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)).To(Succeed())
+			Expect(pullSecret.Status.Expiration.Time).To(BeTemporally("==", now.Add(2*time.Hour)))
+			var secret corev1.Secret
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), &secret)).To(Succeed())
+			objects = append(objects, &secret)
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(ContainSubstring("123.dkr.ecr.us-west-2.amazonaws.com"))
+
+			By("emptying the Secret once the window has closed")
+			profile.Spec.ActiveUntil = &metav1.Time{Time: now.Add(-time.Minute)}
+			Expect(k8sClient.Update(ctx, &profile)).To(Succeed())
+			result, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reconcile.Result{}))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)).To(Succeed())
+			Expect(secret.Data[corev1.DockerConfigJsonKey]).To(MatchJSON(`{"auths":{}}`))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)).To(Succeed())
+			Expect(pullSecret.Status.LastRefreshError).To(Equal("profile ecr-profile is outside its activation window"))
+			Expect(tokens).To(Equal(1))
+		})
+
+		It("should leave Secrets and namespaces of others alone", func() {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "ecr-tenant",
+				Labels: map[string]string{"ecr-tenant": "true"},
+			}}
+			foreign := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-login", Namespace: "ecr-tenant"},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+			}
+			for _, obj := range []client.Object{namespace, foreign} {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+			objects = append(objects, foreign, namespace)
+			pullSecret := &v1.AwsIamRaEcrPullSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-login", Namespace: "default"},
+				Spec: v1.AwsIamRaEcrPullSecretSpec{
+					ProfileName:    "ecr-profile",
+					CertSecretName: "ecr-cert",
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"ecr-tenant": "true"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pullSecret)).To(Succeed())
+			objects = append(objects, pullSecret)
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pullSecret)}
+			reconcileOnce := func() string {
+				result, err := reconciler.Reconcile(ctx, request)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(credentialRetryInterval))
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pullSecret), pullSecret)).To(Succeed())
+				return pullSecret.Status.LastRefreshError
+			}
+
+			Expect(reconcileOnce()).To(ContainSubstring(
+				"secret ecr-tenant/tenant-login already exists and isn't controlled by this AwsIamRaEcrPullSecret"))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(foreign), foreign)).To(Succeed())
+			Expect(foreign.Data[corev1.DockerConfigJsonKey]).To(MatchJSON(`{"auths":{}}`))
+			Expect(foreign.Labels).To(BeEmpty())
+
+			By("refusing the namespaceSelector of namespaces that aren't sources")
+			reconciler.Store = managerconfig.NewStore(managerconfig.Defaults())
+			tokens = 0
+			Expect(reconcileOnce()).To(ContainSubstring("namespace default isn't one of the " +
+				"ecrPullSecrets.sourceNamespaces"))
+			Expect(tokens).To(BeZero())
+		})
+	})
+})
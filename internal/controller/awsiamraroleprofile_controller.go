@@ -20,8 +20,14 @@ import (
 	"context"
 	"dancav.io/aws-iamra-manager/api/v1"
 	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
 	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -29,26 +35,40 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"slices"
 	"strings"
 	"time"
 )
 
+// rolloutRequeueDelay is how often the progress of restarts is checked.
+const rolloutRequeueDelay = 30 * time.Second
+
 // AwsIamRaRoleProfileReconciler reconciles a AwsIamRaRoleProfile object
 type AwsIamRaRoleProfileReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	Recorder   record.EventRecorder
 	KubeConfig *rest.Config
+	// Store holds the policy for pods the pod webhook didn't inject. If nil,
+	// they are only reported.
+	Store *managerconfig.Store
 }
 
 // +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=list;watch;get
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=list;watch;get;patch
 // +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -65,56 +85,458 @@ func (r *AwsIamRaRoleProfileReconciler) Reconcile(ctx context.Context, req ctrl.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	k, err := kubernetes.NewForConfig(r.KubeConfig)
-	if err != nil {
-		logger.Error(err, "unable to create client")
-		return ctrl.Result{}, err
-	}
-
-	podList, err := k.CoreV1().Pods(req.Namespace).List(ctx, metav1.ListOptions{})
-	if err != nil {
+	var podList corev1.PodList
+	if err := r.List(ctx, &podList, client.InNamespace(req.Namespace)); err != nil {
 		logger.Error(err, "unable to query API for pods")
 		return ctrl.Result{}, err
 	}
 
-	var updatablePods []corev1.Pod
+	var updatablePods, missedPods, disallowedPods []corev1.Pod
 	var updatablePodNames []string
 	for _, pod := range podList.Items {
 		if podNeedsUpdate(pod, profile) {
-			updatablePods = append(updatablePods, pod)
+			allowed, err := iamram.ServiceAccountAllowed(ctx, r, &profile, &pod)
+			if err != nil {
+				logger.Error(err, "unable to check the service account of pod", "pod", pod.Name)
+				return ctrl.Result{}, err
+			}
+			// Pods the webhook missed have nothing to update. Those the
+			// profile selects were all missed, since the webhook records the
+			// profile of the pods it injects in their annotations. Pods whose
+			// service account the profile doesn't allow get no settings, and
+			// aren't evicted since the webhook would reject their replacements.
+			switch {
+			case !allowed:
+				disallowedPods = append(disallowedPods, pod)
+			case iamram.MissedInjection(&pod) || iamram.SelectsPod(&profile, &pod):
+				missedPods = append(missedPods, pod)
+			default:
+				updatablePods = append(updatablePods, pod)
+			}
 			updatablePodNames = append(updatablePodNames, types.NamespacedName{
 				Namespace: pod.Namespace,
 				Name:      pod.Name,
 			}.String())
 		}
 	}
-
 	logger.Info("Found pods using this profile", "pods", updatablePodNames)
+
+	rollout := &v1.ProfileRolloutStatus{
+		Strategy:    profile.Spec.UpdateStrategyType(),
+		ProfileHash: iamram.ProfileHash(&profile),
+	}
+	var result ctrl.Result
+	var err error
+	switch rollout.Strategy {
+	case v1.UpdateStrategyHotReload:
+		result, err = r.hotReload(ctx, &profile, updatablePods, rollout)
+	case v1.UpdateStrategyRollingRestart:
+		result, err = r.restartWorkloads(ctx, &profile, updatablePods, rollout)
+	default:
+		for _, pod := range updatablePods {
+			recordPodRollout(&pod, &profile, rollout)
+		}
+	}
+
+	missedResult, missedErr := r.handleMissedInjections(ctx, &profile, missedPods)
+	result = sooner(result, missedResult)
+	// Pods with a disallowed service account may still hold credentials.
+	servingResult, servingErr := r.applyServingState(ctx, &profile, slices.Concat(updatablePods, disallowedPods))
+	result = sooner(result, servingResult)
+
+	r.reportDisallowedPods(&profile, disallowedPods)
+
 	profile.Status.ActivePods = updatablePodNames
-	if err := r.Status().Update(ctx, &profile); err != nil {
-		logger.Error(err, "unable to update AwsIamRaRoleProfile status")
+	profile.Status.Rollout = rollout
+	meta.SetStatusCondition(&profile.Status.Conditions, rolloutCondition(rollout))
+	if statusErr := r.Status().Update(ctx, &profile); statusErr != nil {
+		logger.Error(statusErr, "unable to update AwsIamRaRoleProfile status")
+		return ctrl.Result{}, statusErr
+	}
+	return result, errors.Join(err, missedErr, servingErr)
+}
+
+// sooner returns the result that requeues first.
+func sooner(a, b ctrl.Result) ctrl.Result {
+	if b.RequeueAfter > 0 && (a.RequeueAfter == 0 || b.RequeueAfter < a.RequeueAfter) {
+		return b
+	}
+	return a
+}
+
+// handleMissedInjections reports the pods using the profile that the pod
+// webhook didn't inject, with an Event on each when it is first found and in
+// the InjectionMissed condition. With the Evict policy, pods that have a
+// controller are evicted so that their replacements are injected; evictions
+// a PodDisruptionBudget refuses are retried later.
+func (r *AwsIamRaRoleProfileReconciler) handleMissedInjections(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	policy := v1.MissedInjectionReport
+	if r.Store != nil {
+		policy = r.Store.MissedInjection().Policy
+	}
+
+	known := map[string]bool{}
+	for _, name := range profile.Status.MissedInjections {
+		known[name] = true
+	}
+	profile.Status.MissedInjections = nil
+	var blocked []string
+	var errs []error
+	for i := range pods {
+		pod := &pods[i]
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}.String()
+		profile.Status.MissedInjections = append(profile.Status.MissedInjections, name)
+		if !known[name] {
+			logger.Info("Found pod the webhook didn't inject", "pod", name)
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, "MissedInjection",
+				"Pod uses profile %s but was admitted without the pod webhook injecting it, so it has no "+
+					"AWS credentials", profile.Name)
+		}
+		if policy != v1.MissedInjectionEvict || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if metav1.GetControllerOf(pod) == nil {
+			blocked = append(blocked, fmt.Sprintf("%s has no controller to recreate it", name))
+			continue
+		}
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		err := r.SubResource("eviction").Create(ctx, pod, eviction)
+		switch {
+		case apierrors.IsTooManyRequests(err):
+			blocked = append(blocked, fmt.Sprintf("%s: eviction refused by a PodDisruptionBudget", name))
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			errs = append(errs, fmt.Errorf("unable to evict pod %s: %w", name, err))
+			blocked = append(blocked, fmt.Sprintf("%s: %v", name, err))
+		default:
+			logger.Info("Evicted pod the webhook didn't inject", "pod", name)
+			r.Recorder.Eventf(pod, corev1.EventTypeNormal, "EvictedForInjection",
+				"Evicted so that the pod webhook injects the pod replacing it")
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               v1.ProfileConditionInjectionMissed,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Injected",
+		Message:            "the pod webhook injected every pod using the profile",
+		ObservedGeneration: profile.Generation,
+	}
+	var result ctrl.Result
+	switch {
+	case len(pods) == 0:
+	case policy != v1.MissedInjectionEvict:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NotInjected"
+		condition.Message = fmt.Sprintf("%d pods weren't injected and have no AWS credentials, recreate them "+
+			"to inject them: %s", len(pods), strings.Join(profile.Status.MissedInjections, ", "))
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Evicting"
+		condition.Message = fmt.Sprintf("%d pods weren't injected and are evicted", len(pods))
+		if len(blocked) > 0 {
+			condition.Message += ": " + strings.Join(blocked, "; ")
+		}
+		result.RequeueAfter = rolloutRequeueDelay
+	}
+	meta.SetStatusCondition(&profile.Status.Conditions, condition)
+	return result, errors.Join(errs...)
+}
+
+// applyServingState tells the sidecars of pods whether to serve the profile's
+// credentials, whatever its update strategy: not while it is suspended or
+// outside its activation window, and not beyond the end of the window. Their
+// annotations record what they were told, and pods whose sidecar isn't
+// running yet are retried. The profile is requeued at its next transition.
+func (r *AwsIamRaRoleProfileReconciler) applyServingState(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod,
+) (ctrl.Result, error) {
+	now := time.Now()
+	activation, err := iamram.ProfileActivation(&profile.Spec, now)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	pending, err := r.tellSidecars(ctx, profile, pods, profile.Spec.Suspended || !activation.Active,
+		activation.Until())
+	r.reportSuspension(ctx, profile, pending)
+	result := r.reportActivation(ctx, profile, activation, now, pending)
+	if len(pending) > 0 {
+		result = sooner(result, ctrl.Result{RequeueAfter: 15 * time.Second})
+	}
+	return result, err
+}
 
+// tellSidecars tells the sidecars of pods whose annotations don't say so yet
+// whether to serve the profile's credentials, and until when. It returns the
+// pods, as namespace/name, that couldn't be told.
+func (r *AwsIamRaRoleProfileReconciler) tellSidecars(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod, suspended bool, until time.Time,
+) ([]string, error) {
+	logger := log.FromContext(ctx)
+	var k *kubernetes.Clientset
+	var pending []string
+	var errs []error
+	for i := range pods {
+		pod := &pods[i]
+		// The node agent and the broker read the profile themselves.
+		if iamram.UsesNodeAgent(pod) || (iamram.ProfileSuspended(pod.Annotations, profile.Name) == suspended &&
+			iamram.ProfileActiveUntil(pod.Annotations, profile.Name).Equal(until)) {
+			continue
+		}
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}.String()
+		if pod.Status.Phase == corev1.PodPending {
+			pending = append(pending, name)
+			continue
+		}
+		if k == nil {
+			var err error
+			if k, err = kubernetes.NewForConfig(r.KubeConfig); err != nil {
+				logger.Error(err, "unable to create client")
+				return nil, err
+			}
+		}
+		err := iamram.SuspendProfile(ctx, k, r.KubeConfig, *pod, profile.Name, suspended, until)
+		if err == nil {
+			patch := client.MergeFrom(pod.DeepCopy())
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			iamram.SetProfileSuspended(pod.Annotations, profile.Name, suspended)
+			iamram.SetProfileActiveUntil(pod.Annotations, profile.Name, until)
+			err = r.Patch(ctx, pod, patch)
+		}
+		if err != nil {
+			pending = append(pending, name)
+			if !strings.Contains(err.Error(), "container not found") {
+				errs = append(errs, fmt.Errorf("unable to tell the sidecar of pod %s: %w", name, err))
+			}
+		}
+	}
+	return pending, errors.Join(errs...)
+}
+
+// reportSuspension records in the suspension status and the Suspended
+// condition when and by whom the profile was last suspended or resumed, with
+// an Event on each change. The user is the one the profile webhook saw making
+// it.
+func (r *AwsIamRaRoleProfileReconciler) reportSuspension(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pending []string,
+) {
+	logger := log.FromContext(ctx)
+	suspended := profile.Spec.Suspended
+	status := profile.Status.Suspension
+	if status == nil && !suspended {
+		// Never suspended, so every sidecar serves the profile.
+		meta.SetStatusCondition(&profile.Status.Conditions, metav1.Condition{
+			Type:               v1.ProfileConditionSuspended,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Serving",
+			Message:            "the profile isn't suspended",
+			ObservedGeneration: profile.Generation,
+		})
+		return
+	}
+	if status == nil || status.Suspended != suspended {
+		status = &v1.ProfileSuspensionStatus{
+			Suspended: suspended,
+			Since:     metav1.Now(),
+			By:        profile.Annotations[v1.SuspensionChangedByAnnotationKey],
+		}
+		by := status.By
+		if by == "" {
+			by = "an unknown user"
+		}
+		if suspended {
+			logger.Info("Profile suspended", "by", status.By)
+			r.Recorder.Eventf(profile, corev1.EventTypeWarning, "Suspended",
+				"Suspended by %s, pods using the profile stop getting AWS credentials", by)
+		} else {
+			logger.Info("Profile resumed", "by", status.By)
+			r.Recorder.Eventf(profile, corev1.EventTypeNormal, "Resumed",
+				"Resumed by %s, pods using the profile get AWS credentials again", by)
+		}
+	}
+	status.PendingPods = pending
+	profile.Status.Suspension = status
+
+	condition := metav1.Condition{
+		Type:               v1.ProfileConditionSuspended,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Serving",
+		Message:            "the profile was resumed",
+		ObservedGeneration: profile.Generation,
+	}
+	if suspended {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Suspended"
+		condition.Message = "the profile is suspended, pods using it get no AWS credentials"
+	}
+	if status.By != "" {
+		condition.Message += " (by " + status.By + ")"
+	}
+	condition.Message += pendingSidecarsMessage(pending)
+	meta.SetStatusCondition(&profile.Status.Conditions, condition)
+}
+
+// reportActivation records in the activation status and the Inactive
+// condition where the profile stands in its activation window at now, with an
+// Event when it becomes active or inactive, but none for profiles created
+// outside it. The profile is requeued at its next transition.
+func (r *AwsIamRaRoleProfileReconciler) reportActivation(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, activation iamram.Activation, now time.Time,
+	pending []string,
+) ctrl.Result {
+	logger := log.FromContext(ctx)
+	spec := &profile.Spec
+	condition := metav1.Condition{
+		Type:               v1.ProfileConditionInactive,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Active",
+		Message:            "the profile has no activation window",
+		ObservedGeneration: profile.Generation,
+	}
+	if !spec.HasActivationWindow() {
+		profile.Status.Activation = nil
+		meta.SetStatusCondition(&profile.Status.Conditions, condition)
+		return ctrl.Result{}
+	}
+
+	previous := profile.Status.Activation
+	status := &v1.ProfileActivationStatus{Active: activation.Active, PendingPods: pending}
+	var until string
+	if !activation.Next.IsZero() {
+		status.NextTransition = &metav1.Time{Time: activation.Next}
+		until = " until " + activation.Next.UTC().Format(time.RFC3339)
+	}
+	profile.Status.Activation = status
+	switch {
+	case activation.Active && previous != nil && !previous.Active:
+		logger.Info("Profile entered its activation window", "next", activation.Next)
+		r.Recorder.Eventf(profile, corev1.EventTypeNormal, "Activated",
+			"Entered its activation window, pods using the profile get AWS credentials%s", until)
+	case !activation.Active && previous != nil && previous.Active:
+		logger.Info("Profile left its activation window", "next", activation.Next)
+		r.Recorder.Eventf(profile, corev1.EventTypeNormal, "Deactivated",
+			"Outside its activation window, pods using the profile get no AWS credentials%s", until)
+	}
+
+	condition.Message = "the profile is within its activation window" + until
+	if !activation.Active {
+		condition.Status = metav1.ConditionTrue
+		switch {
+		case spec.ActiveFrom != nil && now.Before(spec.ActiveFrom.Time):
+			condition.Reason = "NotYetActive"
+			condition.Message = "the profile isn't active yet, pods using it get no AWS credentials" + until
+		case spec.ActiveUntil != nil && !now.Before(spec.ActiveUntil.Time):
+			condition.Reason = "Expired"
+			condition.Message = "the profile's activation window has ended, pods using it get no AWS credentials"
+		default:
+			condition.Reason = "OutsideSchedule"
+			condition.Message = "the profile is outside its schedules, pods using it get no AWS credentials" + until
+		}
+	}
+	condition.Message += pendingSidecarsMessage(pending)
+	meta.SetStatusCondition(&profile.Status.Conditions, condition)
+
+	if activation.Next.IsZero() {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: activation.Next.Sub(now)}
+}
+
+func pendingSidecarsMessage(pending []string) string {
+	if len(pending) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d pods haven't been told yet: %s", len(pending), strings.Join(pending, ", "))
+}
+
+// reportDisallowedPods records the pods using the profile whose service
+// account its allowedServiceAccounts doesn't allow, with an Event on each when
+// it is first found and in the ServiceAccountDisallowed condition. These are
+// pods admitted before the profile restricted its service accounts.
+func (r *AwsIamRaRoleProfileReconciler) reportDisallowedPods(profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod) {
+	known := map[string]bool{}
+	for _, name := range profile.Status.DisallowedPods {
+		known[name] = true
+	}
+	profile.Status.DisallowedPods = nil
+	for i := range pods {
+		pod := &pods[i]
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}.String()
+		profile.Status.DisallowedPods = append(profile.Status.DisallowedPods, name)
+		if !known[name] {
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, "ServiceAccountDisallowed",
+				"Profile %s doesn't allow service account %s, its settings aren't pushed to the pod",
+				profile.Name, iamram.ServiceAccountName(pod))
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               v1.ProfileConditionServiceAccountDisallowed,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Allowed",
+		Message:            "every pod using the profile runs with an allowed service account",
+		ObservedGeneration: profile.Generation,
+	}
+	if len(pods) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Disallowed"
+		condition.Message = fmt.Sprintf("%d pods run with a service account the profile doesn't allow and "+
+			"don't get its settings: %s", len(pods), strings.Join(profile.Status.DisallowedPods, ", "))
+	}
+	meta.SetStatusCondition(&profile.Status.Conditions, condition)
+}
+
+// hotReload pushes the profile's settings into the sidecars of pods that
+// don't run with them yet, and records in their annotations that they do.
+func (r *AwsIamRaRoleProfileReconciler) hotReload(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod, rollout *v1.ProfileRolloutStatus,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	var k *kubernetes.Clientset
 	anyFailures := false
 	anyRetries := false
-	for _, pod := range updatablePods {
+	for _, pod := range pods {
+		if iamram.UsesNodeAgent(&pod) || iamram.ProfileHashes(pod.Annotations)[profile.Name] == rollout.ProfileHash {
+			// The node agent reads the profile itself, and other pods already
+			// run with its settings.
+			recordPodRollout(&pod, profile, rollout)
+			continue
+		}
 		if pod.Status.Phase == corev1.PodPending {
 			anyRetries = true
+			recordPodRollout(&pod, profile, rollout)
 			continue
 		}
+		if k == nil {
+			var err error
+			if k, err = kubernetes.NewForConfig(r.KubeConfig); err != nil {
+				logger.Error(err, "unable to create client")
+				return ctrl.Result{}, err
+			}
+		}
 		logger.Info("Updating config for pod", "pod", pod.Name, "podStatus", pod.Status.Phase)
-		if err := iamram.ReconcilePod(ctx, k, r.KubeConfig, &profile, pod); err != nil {
+		err := iamram.ReconcilePod(ctx, k, r.KubeConfig, profile, pod)
+		if err == nil {
+			err = r.recordProfileHash(ctx, &pod, profile.Name, rollout.ProfileHash)
+		}
+		if err != nil {
 			if strings.Contains(err.Error(), "container not found") {
-				// Unless something is wrong, this should only happen when the pod is
-				// just starting up and the sidecar hasn't been injected yet. So we'll
-				// requeue this to be retried.
+				// Pods the webhook missed were set aside, so this should only
+				// happen when the pod is just starting up and the sidecar isn't
+				// running yet. So we'll requeue this to be retried.
 				logger.Info("container not found, will requeue and try again shortly")
 				anyRetries = true
 			} else {
 				anyFailures = true
+				rollout.Failures = append(rollout.Failures, fmt.Sprintf("pod %s: %v", pod.Name, err))
 			}
 		}
+		recordPodRollout(&pod, profile, rollout)
 	}
 
 	var finalError error
@@ -128,16 +550,216 @@ func (r *AwsIamRaRoleProfileReconciler) Reconcile(ctx context.Context, req ctrl.
 	return result, finalError
 }
 
+// recordProfileHash records in the annotations of a pod that its sidecar runs
+// with the settings of the profile with the hash.
+func (r *AwsIamRaRoleProfileReconciler) recordProfileHash(
+	ctx context.Context, pod *corev1.Pod, profileName, hash string,
+) error {
+	if iamram.ProfileHashes(pod.Annotations)[profileName] == hash {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	iamram.SetProfileHash(pod.Annotations, profileName, hash)
+	return r.Patch(ctx, pod, patch)
+}
+
+// restartWorkloads restarts the Deployments, StatefulSets and DaemonSets of
+// pods running with earlier settings of the profile, by recording the hash of
+// its settings in their pod template. Workloads already restarted for them
+// are left alone while they roll out.
+func (r *AwsIamRaRoleProfileReconciler) restartWorkloads(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile, pods []corev1.Pod, rollout *v1.ProfileRolloutStatus,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	seen := map[string]bool{}
+	anyFailures := false
+	for _, pod := range pods {
+		if recordPodRollout(&pod, profile, rollout) {
+			continue
+		}
+		kind, name, err := iamram.Workload(&pod)
+		if err != nil {
+			anyFailures = true
+			rollout.Failures = append(rollout.Failures, fmt.Sprintf("pod %s: %v", pod.Name, err))
+			continue
+		}
+		workload := kind + "/" + name
+		if seen[workload] {
+			continue
+		}
+		seen[workload] = true
+		restarted, err := r.restartWorkload(ctx, pod.Namespace, kind, name, profile.Name, rollout.ProfileHash)
+		if err != nil {
+			logger.Error(err, "unable to restart workload", "workload", workload)
+			anyFailures = true
+			rollout.Failures = append(rollout.Failures, fmt.Sprintf("%s of pod %s: %v", workload, pod.Name, err))
+			r.Recorder.Eventf(profile, corev1.EventTypeWarning, "RestartFailed",
+				"Unable to restart %s of pod %s: %v", workload, pod.Name, err)
+			continue
+		}
+		rollout.RestartedWorkloads = append(rollout.RestartedWorkloads, workload)
+		if restarted {
+			logger.Info("Restarted workload for the updated profile", "workload", workload)
+			r.Recorder.Eventf(profile, corev1.EventTypeNormal, "RestartedWorkload",
+				"Restarted %s to pick up the updated profile", workload)
+		}
+	}
+
+	var finalError error
+	if anyFailures {
+		finalError = errors.New("failed to restart one or more workloads")
+	}
+	if len(rollout.OutdatedPods) > 0 {
+		return ctrl.Result{RequeueAfter: rolloutRequeueDelay}, finalError
+	}
+	return ctrl.Result{}, finalError
+}
+
+// restartWorkload records the hash of the profile in the pod template of a
+// workload, unless it already is, and reports whether it did.
+func (r *AwsIamRaRoleProfileReconciler) restartWorkload(
+	ctx context.Context, namespace, kind, name, profileName, hash string,
+) (bool, error) {
+	obj, template, err := getWorkload(ctx, r.Client, namespace, kind, name)
+	if err != nil {
+		return false, err
+	}
+	if iamram.ProfileHashes(template.Annotations)[profileName] == hash {
+		return false, nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	iamram.SetProfileHash(template.Annotations, profileName, hash)
+	return true, r.Patch(ctx, obj, patch)
+}
+
+// errNotRestartable is returned for workloads that have no pod template the
+// controller can restart them through.
+var errNotRestartable = errors.New("can't be restarted")
+
+// getWorkload fetches a Deployment, StatefulSet or DaemonSet along with its
+// pod template, which restarts the workload when its annotations change.
+func getWorkload(
+	ctx context.Context, c client.Reader, namespace, kind, name string,
+) (client.Object, *corev1.PodTemplateSpec, error) {
+	var obj client.Object
+	var template *corev1.PodTemplateSpec
+	switch kind {
+	case "Deployment":
+		deployment := &appsv1.Deployment{}
+		obj, template = deployment, &deployment.Spec.Template
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		obj, template = statefulSet, &statefulSet.Spec.Template
+	case "DaemonSet":
+		daemonSet := &appsv1.DaemonSet{}
+		obj, template = daemonSet, &daemonSet.Spec.Template
+	default:
+		return nil, nil, fmt.Errorf("a %s %w", kind, errNotRestartable)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, obj); err != nil {
+		return nil, nil, err
+	}
+	return obj, template, nil
+}
+
+// recordPodRollout counts the pod as updated or outdated, and reports whether
+// it is updated. Pods using the node agent always are, since the agent reads
+// the profile itself.
+func recordPodRollout(pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile, rollout *v1.ProfileRolloutStatus) bool {
+	if iamram.UsesNodeAgent(pod) || iamram.ProfileHashes(pod.Annotations)[profile.Name] == rollout.ProfileHash {
+		rollout.UpdatedPods++
+		return true
+	}
+	rollout.OutdatedPods = append(rollout.OutdatedPods, types.NamespacedName{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+	}.String())
+	return false
+}
+
+// rolloutCondition summarizes the rollout in the PodsUpToDate condition.
+func rolloutCondition(rollout *v1.ProfileRolloutStatus) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    v1.ProfileConditionPodsUpToDate,
+		Status:  metav1.ConditionTrue,
+		Reason:  "UpToDate",
+		Message: fmt.Sprintf("%d pods run with the current settings", rollout.UpdatedPods),
+	}
+	switch {
+	case len(rollout.Failures) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "UpdateFailed"
+		condition.Message = fmt.Sprintf("%d pods run with earlier settings: %s",
+			len(rollout.OutdatedPods), strings.Join(rollout.Failures, "; "))
+	case len(rollout.OutdatedPods) == 0:
+	case rollout.Strategy == v1.UpdateStrategyNone:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Drifted"
+		condition.Message = fmt.Sprintf("%d pods run with earlier settings, which the None update strategy "+
+			"leaves alone", len(rollout.OutdatedPods))
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "UpdateInProgress"
+		condition.Message = fmt.Sprintf("%d of %d pods run with the current settings", rollout.UpdatedPods,
+			int(rollout.UpdatedPods)+len(rollout.OutdatedPods))
+	}
+	return condition
+}
+
+// podNeedsUpdate reports whether the running pod uses the profile, as its
+// annotations or the profile's podSelector and serviceAccountNames say.
 func podNeedsUpdate(pod corev1.Pod, profile v1.AwsIamRaRoleProfile) bool {
-	return metav1.HasAnnotation(pod.ObjectMeta, v1.RoleProfilePodAnnotationKey) &&
-		pod.Annotations[v1.RoleProfilePodAnnotationKey] == profile.Name &&
+	return (iamram.ProfileIndex(&pod, profile.Name) >= 0 || iamram.SelectsPod(&profile, &pod)) &&
 		pod.Status.Phase != corev1.PodFailed && pod.Status.Phase != corev1.PodSucceeded
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. Only changes of
+// the spec or annotations of profiles trigger them, not those of their status.
+// Pods the webhook didn't inject trigger the profiles they name or that select
+// them, so that they are handled as soon as they are created, and the status
+// is updated once they are gone.
 func (r *AwsIamRaRoleProfileReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	profilesOfPod := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		pod := obj.(*corev1.Pod)
+		names := iamram.ProfileNames(pod)
+		if len(names) == 0 {
+			var profiles v1.AwsIamRaRoleProfileList
+			if err := r.List(ctx, &profiles, client.InNamespace(pod.Namespace)); err != nil {
+				log.FromContext(ctx).Error(err, "unable to list profiles selecting pod", "pod", pod.Name)
+				return nil
+			}
+			for i := range profiles.Items {
+				if iamram.SelectsPod(&profiles.Items[i], pod) {
+					names = append(names, profiles.Items[i].Name)
+				}
+			}
+		}
+		var requests []reconcile.Request
+		for _, name := range names {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: name},
+			})
+		}
+		return requests
+	})
+	missedInjection := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return false
+		}
+		_, injected := iamram.SidecarImage(pod)
+		return !injected && !iamram.UsesNodeAgent(pod)
+	})
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.AwsIamRaRoleProfile{}).
+		For(&v1.AwsIamRaRoleProfile{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&corev1.Pod{}, profilesOfPod, builder.WithPredicates(missedInjection)).
 		Named("awsiamraroleprofile").
 		Complete(r)
 }
@@ -18,16 +18,23 @@ package controller
 
 import (
 	"context"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -96,4 +103,529 @@ var _ = Describe("AwsIamRaRoleProfile Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When the profile changes", func() {
+		ctx := context.Background()
+
+		newPod := func(name, hash string, owner *metav1.OwnerReference) *corev1.Pod {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+					Labels:    map[string]string{"app": "web", "pod-template-hash": "5d8f7c"},
+					Annotations: map[string]string{
+						v1.RoleProfilePodAnnotationKey:   "rolling",
+						v1.ProfileHashesPodAnnotationKey: "rolling=" + hash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: iamram.SidecarContainerName, Image: "sidecar"}},
+					Containers:     []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+			if owner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*owner}
+			}
+			return pod
+		}
+
+		It("should restart the workloads of outdated pods and report the rollout", func() {
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "rolling", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyRollingRestart,
+				},
+			}
+			Expect(k8sClient.Create(ctx, profile)).To(Succeed())
+			hash := iamram.ProfileHash(profile)
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+			owner := &metav1.OwnerReference{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7c", UID: "rs-uid", Controller: ptr.To(true),
+			}
+			pods := []*corev1.Pod{
+				newPod("web-5d8f7c-updated", hash, owner),
+				newPod("web-5d8f7c-outdated", "0123456789", owner),
+			}
+			for _, pod := range pods {
+				Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			}
+			DeferCleanup(func() {
+				for _, pod := range pods {
+					Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+				}
+				Expect(k8sClient.Delete(ctx, deployment)).To(Succeed())
+				Expect(k8sClient.Delete(ctx, profile)).To(Succeed())
+			})
+
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+			reconcileProfile := func() (reconcile.Result, error) {
+				return controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: "rolling", Namespace: "default"},
+				})
+			}
+			result, err := reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(rolloutRequeueDelay))
+
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: "default"}, deployment)).To(Succeed())
+			Expect(iamram.ProfileHashes(deployment.Spec.Template.Annotations)).To(HaveKeyWithValue("rolling", hash))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rolling", Namespace: "default"}, profile)).
+				To(Succeed())
+			Expect(profile.Status.Rollout).To(Equal(&v1.ProfileRolloutStatus{
+				Strategy:           v1.UpdateStrategyRollingRestart,
+				ProfileHash:        hash,
+				UpdatedPods:        1,
+				OutdatedPods:       []string{"default/web-5d8f7c-outdated"},
+				RestartedWorkloads: []string{"Deployment/web"},
+			}))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionPodsUpToDate)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("UpdateInProgress"))
+
+			By("leaving the workload alone while it rolls out")
+			resourceVersion := deployment.ResourceVersion
+			_, err = reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "web", Namespace: "default"}, deployment)).To(Succeed())
+			Expect(deployment.ResourceVersion).To(Equal(resourceVersion))
+
+			By("only reporting drift with the None strategy")
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rolling", Namespace: "default"}, profile)).
+				To(Succeed())
+			profile.Spec.UpdateStrategy = v1.UpdateStrategyNone
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			result, err = reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rolling", Namespace: "default"}, profile)).
+				To(Succeed())
+			Expect(profile.Status.Rollout.ProfileHash).To(Equal(hash))
+			Expect(profile.Status.Rollout.OutdatedPods).To(HaveLen(1))
+			Expect(profile.Status.Rollout.RestartedWorkloads).To(BeEmpty())
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionPodsUpToDate)
+			Expect(condition.Reason).To(Equal("Drifted"))
+
+			By("reporting pods without a workload that can be restarted")
+			profile.Spec.UpdateStrategy = v1.UpdateStrategyRollingRestart
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			bare := newPod("bare", "0123456789", nil)
+			Expect(k8sClient.Create(ctx, bare)).To(Succeed())
+			pods = append(pods, bare)
+			_, err = reconcileProfile()
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rolling", Namespace: "default"}, profile)).
+				To(Succeed())
+			Expect(profile.Status.Rollout.Failures).To(ConsistOf(ContainSubstring("a Pod can't be restarted")))
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionPodsUpToDate)
+			Expect(condition.Reason).To(Equal("UpdateFailed"))
+		})
+		It("should only hot reload the sidecars of outdated pods", func() {
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "rolling", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyHotReload,
+				},
+			}
+			Expect(k8sClient.Create(ctx, profile)).To(Succeed())
+			pod := newPod("web-5d8f7c-updated", iamram.ProfileHash(profile), nil)
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+				Expect(k8sClient.Delete(ctx, profile)).To(Succeed())
+			})
+
+			// Without a KubeConfig, execing into the sidecar would fail.
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "rolling", Namespace: "default"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "rolling", Namespace: "default"}, profile)).
+				To(Succeed())
+			Expect(profile.Status.Rollout.UpdatedPods).To(BeEquivalentTo(1))
+			Expect(profile.Status.Rollout.Failures).To(BeEmpty())
+		})
+	})
+
+	Context("When pods weren't injected", func() {
+		ctx := context.Background()
+
+		newPod := func(name string, owner *metav1.OwnerReference) *corev1.Pod {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   "default",
+					Annotations: map[string]string{v1.RoleProfilePodAnnotationKey: "missed"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			if owner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*owner}
+			}
+			return pod
+		}
+
+		It("should report them, and evict those that will be recreated", func() {
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "missed", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyNone,
+					PodSelector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": "selected"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, profile)).To(Succeed())
+			owner := &metav1.OwnerReference{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-5d8f7c", UID: "rs-uid", Controller: ptr.To(true),
+			}
+			injected := newPod("api-5d8f7c-injected", owner)
+			injected.Spec.InitContainers = []corev1.Container{{Name: iamram.SidecarContainerName, Image: "sidecar"}}
+			selected := newPod("selected", nil)
+			selected.Annotations = nil
+			selected.Labels = map[string]string{"app": "selected"}
+			pods := []*corev1.Pod{newPod("api-5d8f7c-missed", owner), newPod("bare", nil), injected, selected}
+			for _, pod := range pods {
+				Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			}
+			DeferCleanup(func() {
+				for _, pod := range pods {
+					Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+				}
+				Expect(k8sClient.Delete(ctx, profile)).To(Succeed())
+			})
+
+			recorder := record.NewFakeRecorder(10)
+			store := managerconfig.NewStore(managerconfig.Defaults())
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				Store:    store,
+			}
+			reconcileProfile := func() (reconcile.Result, error) {
+				return controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: "missed", Namespace: "default"},
+				})
+			}
+			_, err := reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "missed", Namespace: "default"}, profile)).
+				To(Succeed())
+			Expect(profile.Status.MissedInjections).To(ConsistOf("default/api-5d8f7c-missed", "default/bare",
+				"default/selected"))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionInjectionMissed)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal("NotInjected"))
+			Expect(recorder.Events).To(HaveLen(3))
+			Expect(<-recorder.Events).To(ContainSubstring("MissedInjection"))
+			<-recorder.Events
+			<-recorder.Events
+
+			By("reporting each pod once")
+			_, err = reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.Events).To(BeEmpty())
+
+			By("evicting the pods that have a controller")
+			store.SetResource(&v1.IamRaManagerConfigSpec{
+				MissedInjection: v1.MissedInjectionSpec{Policy: v1.MissedInjectionEvict},
+			})
+			result, err := reconcileProfile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(rolloutRequeueDelay))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pods[0]), &corev1.Pod{})).
+				To(MatchError(errors.IsNotFound, "IsNotFound"))
+			Expect(<-recorder.Events).To(ContainSubstring("EvictedForInjection"))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "missed", Namespace: "default"}, profile)).
+				To(Succeed())
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionInjectionMissed)
+			Expect(condition.Reason).To(Equal("Evicting"))
+			Expect(condition.Message).To(ContainSubstring("default/bare has no controller to recreate it"))
+		})
+	})
+
+	Context("When pods run with a service account the profile doesn't allow", func() {
+		ctx := context.Background()
+
+		It("should leave them out of the rollout and report them", func() {
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "restricted", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyNone,
+					AllowedServiceAccounts: &v1.AllowedServiceAccounts{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+					},
+				},
+			}
+			serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name: "billing", Namespace: "default", Labels: map[string]string{"team": "payments"},
+			}}
+			newPod := func(name, serviceAccount string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        name,
+						Namespace:   "default",
+						Annotations: map[string]string{v1.RoleProfilePodAnnotationKey: "restricted"},
+					},
+					Spec: corev1.PodSpec{
+						ServiceAccountName: serviceAccount,
+						InitContainers:     []corev1.Container{{Name: iamram.SidecarContainerName, Image: "sidecar"}},
+						Containers:         []corev1.Container{{Name: "app", Image: "app"}},
+					},
+				}
+			}
+			objs := []client.Object{profile, serviceAccount, newPod("billing", "billing"), newPod("batch", "batch")}
+			for _, obj := range objs {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+			DeferCleanup(func() {
+				for _, obj := range objs {
+					Expect(k8sClient.Delete(ctx, obj)).To(Succeed())
+				}
+			})
+
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+			reconcileProfile := func() {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: "restricted", Namespace: "default"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "restricted", Namespace: "default"}, profile)).
+					To(Succeed())
+			}
+			reconcileProfile()
+			Expect(profile.Status.DisallowedPods).To(ConsistOf("default/batch"))
+			Expect(profile.Status.MissedInjections).To(BeEmpty())
+			Expect(profile.Status.Rollout.OutdatedPods).To(ConsistOf("default/billing"))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionServiceAccountDisallowed)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Message).To(ContainSubstring("default/batch"))
+			Expect(recorder.Events).To(HaveLen(1))
+			Expect(<-recorder.Events).To(ContainSubstring("doesn't allow service account batch"))
+
+			By("reporting each pod once")
+			reconcileProfile()
+			Expect(recorder.Events).To(BeEmpty())
+
+			By("clearing the condition once the service account is allowed")
+			profile.Spec.AllowedServiceAccounts.Names = []string{"batch"}
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			reconcileProfile()
+			Expect(profile.Status.DisallowedPods).To(BeEmpty())
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionServiceAccountDisallowed)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		})
+	})
+
+	Context("When the profile is suspended", func() {
+		ctx := context.Background()
+
+		It("should record who suspended it and the pods whose sidecar wasn't told yet", func() {
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "suspended",
+					Namespace:   "default",
+					Annotations: map[string]string{v1.SuspensionChangedByAnnotationKey: "alice"},
+				},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyNone,
+					Suspended:      true,
+				},
+			}
+			newPod := func(name string, phase corev1.PodPhase, annotations map[string]string) *corev1.Pod {
+				annotations[v1.RoleProfilePodAnnotationKey] = "suspended"
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+					Spec: corev1.PodSpec{
+						InitContainers: []corev1.Container{{Name: iamram.SidecarContainerName, Image: "sidecar"}},
+						Containers:     []corev1.Container{{Name: "app", Image: "app"}},
+					},
+					Status: corev1.PodStatus{Phase: phase},
+				}
+			}
+			told := newPod("told", corev1.PodRunning,
+				map[string]string{v1.SuspendedProfilesPodAnnotationKey: "other,suspended"})
+			starting := newPod("starting", corev1.PodPending, map[string]string{})
+			for _, obj := range []client.Object{profile, told, starting} {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+			DeferCleanup(func() {
+				for _, obj := range []client.Object{profile, told, starting} {
+					Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+				}
+			})
+
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+			reconcileProfile := func() reconcile.Result {
+				result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: "suspended", Namespace: "default"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "suspended", Namespace: "default"}, profile)).
+					To(Succeed())
+				return result
+			}
+			Expect(reconcileProfile().RequeueAfter).To(BeNumerically(">", 0))
+			Expect(profile.Status.Suspension.Suspended).To(BeTrue())
+			Expect(profile.Status.Suspension.By).To(Equal("alice"))
+			Expect(profile.Status.Suspension.PendingPods).To(ConsistOf("default/starting"))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionSuspended)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Message).To(ContainSubstring("by alice"))
+			Expect(recorder.Events).To(HaveLen(1))
+			Expect(<-recorder.Events).To(ContainSubstring("Suspended by alice"))
+			since := profile.Status.Suspension.Since
+
+			By("recording the suspension once")
+			reconcileProfile()
+			Expect(recorder.Events).To(BeEmpty())
+			Expect(profile.Status.Suspension.Since).To(Equal(since))
+
+			By("recording who resumed it")
+			Expect(k8sClient.Delete(ctx, told)).To(Succeed())
+			profile.Spec.Suspended = false
+			profile.Annotations[v1.SuspensionChangedByAnnotationKey] = "bob"
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			Expect(reconcileProfile().RequeueAfter).To(BeZero())
+			Expect(profile.Status.Suspension.Suspended).To(BeFalse())
+			Expect(profile.Status.Suspension.By).To(Equal("bob"))
+			Expect(profile.Status.Suspension.PendingPods).To(BeEmpty())
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionSuspended)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(<-recorder.Events).To(ContainSubstring("Resumed by bob"))
+		})
+	})
+	Context("When the profile has an activation window", func() {
+		ctx := context.Background()
+
+		It("should suspend its pods outside the window and requeue at the next transition", func() {
+			now := time.Now().Truncate(time.Second)
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "windowed", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					UpdateStrategy: v1.UpdateStrategyNone,
+					ActiveFrom:     &metav1.Time{Time: now.Add(time.Hour)},
+					ActiveUntil:    &metav1.Time{Time: now.Add(2 * time.Hour)},
+				},
+			}
+			newPod := func(name string, phase corev1.PodPhase, annotations map[string]string) *corev1.Pod {
+				annotations[v1.RoleProfilePodAnnotationKey] = "windowed"
+				return &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+					Spec: corev1.PodSpec{
+						InitContainers: []corev1.Container{{Name: iamram.SidecarContainerName, Image: "sidecar"}},
+						Containers:     []corev1.Container{{Name: "app", Image: "app"}},
+					},
+					Status: corev1.PodStatus{Phase: phase},
+				}
+			}
+			told := newPod("told", corev1.PodRunning,
+				map[string]string{v1.SuspendedProfilesPodAnnotationKey: "windowed"})
+			starting := newPod("starting", corev1.PodPending, map[string]string{})
+			for _, obj := range []client.Object{profile, told, starting} {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+			DeferCleanup(func() {
+				for _, obj := range []client.Object{profile, told, starting} {
+					Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+				}
+			})
+
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &AwsIamRaRoleProfileReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+			reconcileProfile := func() reconcile.Result {
+				result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: "windowed", Namespace: "default"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "windowed", Namespace: "default"}, profile)).
+					To(Succeed())
+				return result
+			}
+			Expect(reconcileProfile().RequeueAfter).To(Equal(15 * time.Second))
+			Expect(profile.Status.Activation.Active).To(BeFalse())
+			Expect(profile.Status.Activation.NextTransition.Time).To(BeTemporally("==", now.Add(time.Hour)))
+			Expect(profile.Status.Activation.PendingPods).To(ConsistOf("default/starting"))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionInactive)
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal("NotYetActive"))
+			Expect(recorder.Events).To(BeEmpty())
+
+			By("requeueing when the window closes once it is open")
+			Expect(k8sClient.Delete(ctx, told)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, starting)).To(Succeed())
+			profile.Spec.ActiveFrom = &metav1.Time{Time: now.Add(-time.Hour)}
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			Expect(reconcileProfile().RequeueAfter).To(BeNumerically("~", 2*time.Hour, time.Minute))
+			Expect(profile.Status.Activation.Active).To(BeTrue())
+			Expect(profile.Status.Activation.NextTransition.Time).To(BeTemporally("==", now.Add(2*time.Hour)))
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionInactive)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(<-recorder.Events).To(ContainSubstring("Entered its activation window"))
+
+			By("following its schedules")
+			opens := now.UTC().Add(2 * time.Hour).Truncate(time.Minute)
+			profile.Spec.ActiveFrom = nil
+			profile.Spec.ActiveUntil = nil
+			profile.Spec.Schedules = []v1.ProfileSchedule{{
+				Start: opens.Format("15:04"),
+				End:   opens.Add(time.Hour).Format("15:04"),
+			}}
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			Expect(reconcileProfile().RequeueAfter).To(BeNumerically("~", 2*time.Hour, time.Minute))
+			Expect(profile.Status.Activation.NextTransition.Time).To(BeTemporally("==", opens))
+			condition = meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionInactive)
+			Expect(condition.Reason).To(Equal("OutsideSchedule"))
+			Expect(<-recorder.Events).To(ContainSubstring("Outside its activation window"))
+		})
+	})
 })
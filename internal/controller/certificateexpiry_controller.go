@@ -0,0 +1,297 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertificateExpiryReconciler watches the certificates of pods using a
+// profile, publishes their expiry as a metric, and reports those that need
+// attention on the profile.
+type CertificateExpiryReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Window is how long before expiry certificates are reported. Certificates
+	// are only reported in the last third of their lifetime, so that those
+	// renewed on schedule never are.
+	Window time.Duration
+	// SecretMetadata caches the metadata of every Secret, so that rotating the
+	// certificate Secret of pods, which the manager's cache doesn't hold,
+	// triggers their profiles.
+	SecretMetadata cache.Cache
+
+	// mu guards secrets, the Secrets each profile published metrics for.
+	mu      sync.Mutex
+	secrets map[types.NamespacedName]map[string]bool
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile checks the certificates of the pods using a profile.
+func (r *CertificateExpiryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var profile v1.AwsIamRaRoleProfile
+	if err := r.Get(ctx, req.NamespacedName, &profile); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.publish(req.NamespacedName, nil)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(profile.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	podsBySecret := map[string][]string{}
+	for _, pod := range pods.Items {
+		if !podNeedsUpdate(pod, profile) || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if secretName := iamram.CertSecretName(&pod); secretName != "" {
+			podsBySecret[secretName] = append(podsBySecret[secretName], pod.Name)
+		}
+	}
+
+	sessionDuration := time.Duration(profile.Spec.DurationSeconds) * time.Second
+	if sessionDuration == 0 {
+		// The default of IAM Roles Anywhere.
+		sessionDuration = time.Hour
+	}
+	now := time.Now()
+	var expiring []v1.CertificateExpiry
+	var nextCheck time.Time
+	published := map[string]*corev1.Secret{}
+	for secretName, podNames := range podsBySecret {
+		var secret corev1.Secret
+		if err := r.Get(ctx, types.NamespacedName{Namespace: profile.Namespace, Name: secretName},
+			&secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				// Issued certificates don't exist until the pods do.
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		cert := issuedCertificate(&secret)
+		if cert == nil {
+			continue
+		}
+		published[secretName] = &secret
+
+		window := r.Window
+		if lastThird := cert.NotAfter.Sub(cert.NotBefore) / 3; lastThird < window {
+			window = lastThird
+		}
+		var reason v1.CertificateExpiryReason
+		switch remaining := cert.NotAfter.Sub(now); {
+		case remaining <= 0:
+			reason = v1.CertificateExpired
+		case remaining < window:
+			reason = v1.CertificateExpiring
+		case remaining < sessionDuration:
+			reason = v1.CertificateShorterThanSession
+		}
+		for _, at := range []time.Time{cert.NotAfter.Add(-sessionDuration), cert.NotAfter.Add(-window), cert.NotAfter} {
+			if at.After(now) && (nextCheck.IsZero() || at.Before(nextCheck)) {
+				nextCheck = at
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		sort.Strings(podNames)
+		expiring = append(expiring, v1.CertificateExpiry{
+			SecretName: secretName,
+			NotAfter:   metav1.NewTime(cert.NotAfter),
+			Reason:     reason,
+			Pods:       podNames,
+		})
+	}
+	r.publish(req.NamespacedName, published)
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].SecretName < expiring[j].SecretName })
+
+	reported := map[string]v1.CertificateExpiryReason{}
+	for _, previous := range profile.Status.ExpiringCertificates {
+		reported[previous.SecretName] = previous.Reason
+	}
+	condition := metav1.Condition{
+		Type:               v1.ProfileConditionCertificatesExpiring,
+		Status:             metav1.ConditionFalse,
+		Reason:             "CertificatesValid",
+		Message:            "no certificate of pods using the profile needs attention",
+		ObservedGeneration: profile.Generation,
+	}
+	if len(expiring) > 0 {
+		var messages []string
+		for _, certificate := range expiring {
+			message := describeCertificateExpiry(certificate, sessionDuration)
+			messages = append(messages, message)
+			if reported[certificate.SecretName] != certificate.Reason {
+				r.Recorder.Event(&profile, corev1.EventTypeWarning, "Certificate"+string(certificate.Reason), message)
+			}
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = string(expiring[0].Reason)
+		condition.Message = strings.Join(messages, "; ")
+	}
+
+	before := profile.Status.DeepCopy()
+	meta.SetStatusCondition(&profile.Status.Conditions, condition)
+	profile.Status.ExpiringCertificates = expiring
+	if !equality.Semantic.DeepEqual(before, &profile.Status) {
+		if err := r.Status().Update(ctx, &profile); err != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("Updated certificate expiry status", "expiring", len(expiring))
+	}
+
+	if nextCheck.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(nextCheck)}, nil
+}
+
+func describeCertificateExpiry(certificate v1.CertificateExpiry, sessionDuration time.Duration) string {
+	var what string
+	switch certificate.Reason {
+	case v1.CertificateExpired:
+		what = "expired at %s"
+	case v1.CertificateExpiring:
+		what = "expires at %s"
+	default:
+		what = "expires at %s, before a session of " + sessionDuration.String() + " would end"
+	}
+	return fmt.Sprintf("certificate in Secret %s "+what+", used by pods %s", certificate.SecretName,
+		certificate.NotAfter.UTC().Format(time.RFC3339), strings.Join(certificate.Pods, ", "))
+}
+
+// publish sets the expiry metric of the certificates in secrets, and removes
+// the ones the profile published before that no other profile uses.
+func (r *CertificateExpiryReconciler) publish(profile types.NamespacedName, secrets map[string]*corev1.Secret) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.secrets == nil {
+		r.secrets = map[types.NamespacedName]map[string]bool{}
+	}
+	for secretName, secret := range secrets {
+		certificateExpiry.WithLabelValues(profile.Namespace, secretName).
+			Set(float64(issuedCertificate(secret).NotAfter.Unix()))
+	}
+	for secretName := range r.secrets[profile] {
+		if _, ok := secrets[secretName]; ok {
+			continue
+		}
+		inUse := false
+		for other, otherSecrets := range r.secrets {
+			inUse = inUse || (other != profile && other.Namespace == profile.Namespace && otherSecrets[secretName])
+		}
+		if !inUse {
+			certificateExpiry.DeleteLabelValues(profile.Namespace, secretName)
+		}
+	}
+	if len(secrets) == 0 {
+		delete(r.secrets, profile)
+		return
+	}
+	r.secrets[profile] = map[string]bool{}
+	for secretName := range secrets {
+		r.secrets[profile][secretName] = true
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CertificateExpiryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.AwsIamRaRoleProfile{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(profilesOfPod)).
+		WatchesRawSource(secretMetadataSource(r.SecretMetadata, r.profilesOfCertSecret)).
+		Named("certificateexpiry").
+		Complete(r)
+}
+
+// profilesOfPod maps a pod to every profile it uses.
+func profilesOfPod(_ context.Context, obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, name := range iamram.ProfileNames(obj) {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name},
+		})
+	}
+	return requests
+}
+
+// secretMetadataSource watches the metadata of Secrets in c, which is updated
+// along with their data, mapping them to requests with mapFunc.
+func secretMetadataSource(c cache.Cache, mapFunc handler.MapFunc) source.Source {
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	return source.Kind(c, secret, handler.TypedEnqueueRequestsFromMapFunc(
+		func(ctx context.Context, secret *metav1.PartialObjectMetadata) []reconcile.Request {
+			return mapFunc(ctx, secret)
+		}))
+}
+
+// profilesOfCertSecret maps a Secret to the profiles of the pods mounting it
+// as their certificate.
+func (r *CertificateExpiryReconciler) profilesOfCertSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var requests []reconcile.Request
+	for i := range pods.Items {
+		if iamram.CertSecretName(&pods.Items[i]) != obj.GetName() {
+			continue
+		}
+		for _, request := range profilesOfPod(ctx, &pods.Items[i]) {
+			if !seen[request.Name] {
+				seen[request.Name] = true
+				requests = append(requests, request)
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+)
+
+func newTestExpiringCertSecret(name string, notBefore, notAfter time.Time) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+var _ = Describe("CertificateExpiry Controller", func() {
+	Context("When reconciling a profile", func() {
+		ctx := context.Background()
+		var (
+			recorder   *record.FakeRecorder
+			reconciler *CertificateExpiryReconciler
+			profile    *v1.AwsIamRaRoleProfile
+			objects    []client.Object
+		)
+
+		newPod := func(name, secretName string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+					Annotations: map[string]string{
+						v1.RoleProfilePodAnnotationKey: "expiry-profile",
+						v1.CertSecretPodAnnotationKey:  secretName,
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+		}
+		create := func(objs ...client.Object) {
+			for _, obj := range objs {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+				objects = append(objects, obj)
+			}
+		}
+		reconcileProfile := func() reconcile.Result {
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(profile)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(profile), profile)).To(Succeed())
+			return result
+		}
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			reconciler = &CertificateExpiryReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				Window:   7 * 24 * time.Hour,
+			}
+			profile = &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "expiry-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn:  "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:      "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:         "arn:aws:iam::123:role/baz",
+					DurationSeconds: 3600,
+				},
+			}
+			objects = nil
+			create(profile)
+		})
+
+		AfterEach(func() {
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should report certificates in the last part of their lifetime", func() {
+			now := time.Now()
+			create(newTestExpiringCertSecret("expiring-cert", now.Add(-10*time.Hour), now.Add(2*time.Hour)),
+				newPod("expiring-pod-2", "expiring-cert"), newPod("expiring-pod-1", "expiring-cert"))
+
+			result := reconcileProfile()
+			Expect(profile.Status.ExpiringCertificates).To(HaveLen(1))
+			expiry := profile.Status.ExpiringCertificates[0]
+			Expect(expiry.SecretName).To(Equal("expiring-cert"))
+			Expect(expiry.Reason).To(Equal(v1.CertificateExpiring))
+			Expect(expiry.Pods).To(Equal([]string{"expiring-pod-1", "expiring-pod-2"}))
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionCertificatesExpiring)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(recorder.Events).To(Receive(ContainSubstring("CertificateExpiring")))
+			// The certificate becomes shorter than a session an hour before it expires.
+			Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+			Expect(testutil.ToFloat64(certificateExpiry.WithLabelValues("default", "expiring-cert"))).
+				To(BeNumerically("~", float64(now.Add(2*time.Hour).Unix()), 1))
+
+			By("reconciling again without changes")
+			reconcileProfile()
+			Expect(recorder.Events).NotTo(Receive())
+
+			By("deleting the pods that use it")
+			for _, obj := range objects[1:] {
+				Expect(k8sClient.Delete(ctx, obj)).To(Succeed())
+			}
+			reconcileProfile()
+			Expect(profile.Status.ExpiringCertificates).To(BeEmpty())
+			Expect(meta.IsStatusConditionFalse(profile.Status.Conditions,
+				v1.ProfileConditionCertificatesExpiring)).To(BeTrue())
+			Expect(certificateExpiry.DeleteLabelValues("default", "expiring-cert")).To(BeFalse())
+		})
+
+		It("should report certificates expiring before a session would end", func() {
+			profile.Spec.DurationSeconds = 43200
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			now := time.Now()
+			create(newTestExpiringCertSecret("short-cert", now.Add(-time.Hour), now.Add(3*time.Hour)),
+				newPod("short-pod", "short-cert"))
+
+			reconcileProfile()
+			Expect(profile.Status.ExpiringCertificates).To(HaveLen(1))
+			Expect(profile.Status.ExpiringCertificates[0].Reason).To(Equal(v1.CertificateShorterThanSession))
+			Expect(recorder.Events).To(Receive(ContainSubstring("CertificateShorterThanSession")))
+		})
+
+		It("should not report certificates far from expiry", func() {
+			now := time.Now()
+			create(newTestExpiringCertSecret("valid-cert", now.Add(-time.Hour), now.Add(90*24*time.Hour)),
+				newPod("valid-pod", "valid-cert"))
+
+			result := reconcileProfile()
+			Expect(profile.Status.ExpiringCertificates).To(BeEmpty())
+			condition := meta.FindStatusCondition(profile.Status.Conditions, v1.ProfileConditionCertificatesExpiring)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(recorder.Events).NotTo(Receive())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 83*24*time.Hour, time.Minute))
+		})
+
+		It("should notice the rotation of certificate Secrets the manager doesn't cache", func() {
+			By("running the controller in a manager caching only the Secrets it writes, as cmd/main.go does")
+			mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+				Scheme:     k8sClient.Scheme(),
+				Metrics:    metricsserver.Options{BindAddress: "0"},
+				Controller: config.Controller{SkipNameValidation: ptr.To(true)},
+				Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+					&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{v1.ManagedSecretLabelKey: "true"})},
+				}},
+				Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			secretMetadata, err := cache.New(mgr.GetConfig(), cache.Options{
+				Scheme: mgr.GetScheme(),
+				Mapper: mgr.GetRESTMapper(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mgr.Add(secretMetadata)).To(Succeed())
+			Expect((&CertificateExpiryReconciler{
+				Client:         mgr.GetClient(),
+				Scheme:         mgr.GetScheme(),
+				Recorder:       record.NewFakeRecorder(100),
+				Window:         7 * 24 * time.Hour,
+				SecretMetadata: secretMetadata,
+			}).SetupWithManager(mgr)).To(Succeed())
+			mgrCtx, stop := context.WithCancel(ctx)
+			DeferCleanup(stop)
+			go func() {
+				defer GinkgoRecover()
+				Expect(mgr.Start(mgrCtx)).To(Succeed())
+			}()
+
+			now := time.Now()
+			secret := newTestExpiringCertSecret("rotated-cert", now.Add(-10*time.Hour), now.Add(2*time.Hour))
+			create(secret, newPod("rotated-pod", "rotated-cert"))
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(profile), profile)).To(Succeed())
+				g.Expect(profile.Status.ExpiringCertificates).To(HaveLen(1))
+			}).Should(Succeed())
+
+			By("rotating the certificate")
+			secret.Data = newTestExpiringCertSecret("rotated-cert", now.Add(-time.Hour), now.Add(90*24*time.Hour)).Data
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(profile), profile)).To(Succeed())
+				g.Expect(profile.Status.ExpiringCertificates).To(BeEmpty())
+			}).Should(Succeed())
+		})
+	})
+})
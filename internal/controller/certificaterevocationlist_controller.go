@@ -0,0 +1,322 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"math/big"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"time"
+)
+
+const (
+	// CRLSecretKey is the key of the PEM-encoded CRL in the CRL Secret.
+	CRLSecretKey = "ca.crl"
+
+	// crlEntriesAnnotationKey holds a digest of the entries of the CRL in the
+	// CRL Secret, crlPublishedAnnotationKey the digest of the CRL last
+	// published to Roles Anywhere, and crlIDAnnotationKey its ID there.
+	crlEntriesAnnotationKey   = "cloud.dancav.io/crl-entries"
+	crlPublishedAnnotationKey = "cloud.dancav.io/crl-published"
+	crlIDAnnotationKey        = "cloud.dancav.io/crl-id"
+
+	// crlValidity is how long a CRL is valid for. It is signed again a third
+	// of the way before it expires, even without changes.
+	crlValidity = 7 * 24 * time.Hour
+)
+
+// CertificateRevocationListReconciler keeps a CRL of the certificates issued
+// by the controller's CA and revoked by AwsIamRaCertificateRevocations, and
+// publishes it to Roles Anywhere.
+type CertificateRevocationListReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// CASecret is the kubernetes.io/tls Secret holding the CA certificate and
+	// key. The CRL is written to a Secret next to it, named after it with a
+	// -crl suffix.
+	CASecret types.NamespacedName
+	// Profile is the role profile whose credentials import and update the CRL
+	// in the profile's trust anchor, and CertSecretName the certificate
+	// Secret, in the profile's namespace, used to call CreateSession. The CRL
+	// isn't published if Profile is unset.
+	Profile        types.NamespacedName
+	CertSecretName string
+	// Endpoint overrides the Roles Anywhere endpoint derived from the trust
+	// anchor ARN.
+	Endpoint string
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracertificaterevocations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamracertificaterevocations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile signs a new CRL when the revoked certificates change or the
+// current CRL nears its next update, publishes it, and records on each
+// revocation whether its certificate is in the published CRL.
+func (r *CertificateRevocationListReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var revocations v1.AwsIamRaCertificateRevocationList
+	if err := r.List(ctx, &revocations); err != nil {
+		return ctrl.Result{}, err
+	}
+	ca, err := loadCA(ctx, r.Client, r.CASecret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	entries, reasons := r.entries(ca, revocations.Items)
+
+	secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: r.CASecret.Namespace,
+		Name:      r.CASecret.Name + "-crl",
+	}}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&secret), &secret); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	exists := secret.ResourceVersion != ""
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+
+	crl := parseCRL(secret.Data[CRLSecretKey])
+	digest := entriesDigest(entries)
+	if crl == nil || secret.Annotations[crlEntriesAnnotationKey] != digest ||
+		time.Until(crl.NextUpdate) < crlValidity/3 {
+		number := big.NewInt(1)
+		if crl != nil && crl.Number != nil {
+			number.Add(crl.Number, number)
+		}
+		crlPEM, err := ca.RevocationList(entries, number, time.Now().Add(crlValidity))
+		if err != nil {
+			r.setPublished(ctx, revocations.Items, reasons, metav1.ConditionFalse, "SigningFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[CRLSecretKey] = crlPEM
+		secret.Annotations[crlEntriesAnnotationKey] = digest
+		if exists {
+			err = r.Update(ctx, &secret)
+		} else {
+			err = r.Create(ctx, &secret)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		crl = parseCRL(crlPEM)
+		logger.Info("Signed CRL", "secret", secret.Name, "number", number, "entries", len(entries))
+	}
+	result := ctrl.Result{RequeueAfter: time.Until(crl.NextUpdate.Add(-crlValidity / 3))}
+
+	if r.Profile.Name == "" {
+		r.setPublished(ctx, revocations.Items, reasons, metav1.ConditionFalse, "PublishingDisabled",
+			fmt.Sprintf("CRL %s is in Secret %s, the controller doesn't publish it", crl.Number, secret.Name))
+		return result, nil
+	}
+	crlID, err := r.publish(ctx, &secret)
+	if err != nil {
+		logger.Error(err, "unable to publish CRL")
+		r.Recorder.Event(&secret, corev1.EventTypeWarning, "PublishFailed", err.Error())
+		r.setPublished(ctx, revocations.Items, reasons, metav1.ConditionFalse, "PublishFailed", err.Error())
+		return ctrl.Result{RequeueAfter: credentialRetryInterval}, nil
+	}
+	r.setPublished(ctx, revocations.Items, reasons, metav1.ConditionTrue, "Published",
+		fmt.Sprintf("in CRL %s, number %s", crlID, crl.Number))
+	return result, nil
+}
+
+// entries returns the CRL entries of the revocations of unexpired
+// certificates issued by ca. reasons holds, by revocation, why a certificate
+// is left out.
+func (r *CertificateRevocationListReconciler) entries(
+	ca *pki.CA, revocations []v1.AwsIamRaCertificateRevocation,
+) ([]x509.RevocationListEntry, map[types.NamespacedName]string) {
+	var entries []x509.RevocationListEntry
+	reasons := map[types.NamespacedName]string{}
+	seen := map[string]bool{}
+	for _, revocation := range revocations {
+		status := revocation.Status
+		key := client.ObjectKeyFromObject(&revocation)
+		switch {
+		case status.SerialNumber == "" || status.RevokedAt == nil || !revocation.DeletionTimestamp.IsZero():
+			continue
+		case status.Issuer != "" && status.Issuer != ca.Certificate.Subject.String():
+			reasons[key] = "NotIssuedByController"
+			continue
+		case status.NotAfter != nil && status.NotAfter.Time.Before(time.Now()):
+			reasons[key] = "Expired"
+			continue
+		case seen[status.SerialNumber]:
+			continue
+		}
+		serial, err := pki.ParseSerial(status.SerialNumber)
+		if err != nil {
+			continue
+		}
+		seen[status.SerialNumber] = true
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: status.RevokedAt.UTC(),
+			ReasonCode:     revocation.Spec.Reason.ReasonCode(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SerialNumber.Cmp(entries[j].SerialNumber) < 0
+	})
+	return entries, reasons
+}
+
+// publish imports the CRL in secret into the profile's trust anchor, or
+// updates the CRL imported before, unless it has already been published. It
+// returns the ID of the CRL in Roles Anywhere.
+func (r *CertificateRevocationListReconciler) publish(ctx context.Context, secret *corev1.Secret) (string, error) {
+	crlPEM := secret.Data[CRLSecretKey]
+	sum := sha256.Sum256(crlPEM)
+	digest := hex.EncodeToString(sum[:])
+	crlID := secret.Annotations[crlIDAnnotationKey]
+	if crlID != "" && secret.Annotations[crlPublishedAnnotationKey] == digest {
+		return crlID, nil
+	}
+
+	session, err := getProfileSession(ctx, r.Client, r.Profile.Namespace, r.Profile.Name, r.CertSecretName,
+		"aws-iamra-manager-crl")
+	if err != nil {
+		return "", err
+	}
+	rolesAnywhere, err := session.Client(r.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	crls := &rolesanywhere.CrlClient{
+		Credentials: &rolesanywhere.SessionProvider{Client: rolesAnywhere, Input: session.Input},
+		Endpoint:    r.Endpoint,
+	}
+	trustAnchorArn := session.Input.TrustAnchorArn
+	var crl *rolesanywhere.Crl
+	if crlID == "" {
+		crl, err = crls.ImportCrl(ctx, secret.Name, trustAnchorArn, crlPEM)
+	} else {
+		crl, err = crls.UpdateCrl(ctx, crlID, trustAnchorArn, crlPEM)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret.Annotations[crlIDAnnotationKey] = crl.CrlID
+	secret.Annotations[crlPublishedAnnotationKey] = digest
+	if err := r.Update(ctx, secret); err != nil {
+		return "", err
+	}
+	log.FromContext(ctx).Info("Published CRL", "crlId", crl.CrlID, "trustAnchorArn", trustAnchorArn)
+	return crl.CrlID, nil
+}
+
+// setPublished sets the Published condition of the resolved revocations:
+// to status for those in the CRL, and to false with their reason for the
+// others.
+func (r *CertificateRevocationListReconciler) setPublished(
+	ctx context.Context, revocations []v1.AwsIamRaCertificateRevocation, reasons map[types.NamespacedName]string,
+	status metav1.ConditionStatus, reason, message string,
+) {
+	for i := range revocations {
+		revocation := &revocations[i]
+		if revocation.Status.SerialNumber == "" || !revocation.DeletionTimestamp.IsZero() {
+			continue
+		}
+		condition := metav1.Condition{
+			Type:    v1.RevocationConditionPublished,
+			Status:  status,
+			Reason:  reason,
+			Message: message,
+		}
+		switch reasons[client.ObjectKeyFromObject(revocation)] {
+		case "NotIssuedByController":
+			condition.Status, condition.Reason = metav1.ConditionFalse, "NotIssuedByController"
+			condition.Message = fmt.Sprintf("the certificate is issued by %s, not by the controller's CA",
+				revocation.Status.Issuer)
+		case "Expired":
+			condition.Status, condition.Reason = metav1.ConditionFalse, "Expired"
+			condition.Message = "the certificate has expired and is left out of the CRL"
+		}
+		if !meta.SetStatusCondition(&revocation.Status.Conditions, condition) {
+			continue
+		}
+		if err := r.Status().Update(ctx, revocation); err != nil && !apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "unable to update revocation status", "revocation", revocation.Name)
+		}
+	}
+}
+
+// parseCRL returns the CRL in crlPEM, or nil if there is none.
+func parseCRL(crlPEM []byte) *x509.RevocationList {
+	block, _ := pem.Decode(crlPEM)
+	if block == nil {
+		return nil
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return crl
+}
+
+// entriesDigest identifies the entries of a CRL, so that changes to them
+// trigger a new CRL.
+func entriesDigest(entries []x509.RevocationListEntry) string {
+	hash := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s %d %d\n", pki.FormatSerial(entry.SerialNumber),
+			entry.RevocationTime.Unix(), entry.ReasonCode)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// SetupWithManager sets up the controller with the Manager. Every change to a
+// revocation reconciles the single CRL of the CA.
+func (r *CertificateRevocationListReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Watches(&v1.AwsIamRaCertificateRevocation{}, handler.EnqueueRequestsFromMapFunc(
+			func(context.Context, client.Object) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: r.CASecret}}
+			})).
+		Named("certificaterevocationlist").
+		Complete(r)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+)
+
+// fakeCrlAPI stands in for Roles Anywhere, recording the CRL calls it
+// receives by operation.
+func fakeCrlAPI(calls map[string]int) *httptest.Server {
+	var sessions int
+	rolesAnywhere := fakeCreateSession(&sessions, time.Now().Add(time.Hour))
+	mux := http.NewServeMux()
+	mux.Handle("/sessions", rolesAnywhere.Config.Handler)
+	mux.HandleFunc("POST /crls", func(w http.ResponseWriter, r *http.Request) {
+		calls["ImportCrl"]++
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"crl": map[string]any{"crlId": "crl-1", "enabled": true}})
+	})
+	mux.HandleFunc("PATCH /crl/{id}", func(w http.ResponseWriter, r *http.Request) {
+		calls["UpdateCrl"]++
+		_ = json.NewEncoder(w).Encode(map[string]any{"crl": map[string]any{"crlId": r.PathValue("id")}})
+	})
+	return httptest.NewServer(mux)
+}
+
+var _ = Describe("CertificateRevocationList Controller", func() {
+	Context("When reconciling the CRL", func() {
+		ctx := context.Background()
+		var (
+			calls      map[string]int
+			server     *httptest.Server
+			reconciler *CertificateRevocationListReconciler
+			ca         *pki.CA
+			objects    []client.Object
+		)
+
+		create := func(objs ...client.Object) {
+			for _, obj := range objs {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+				objects = append(objects, obj)
+			}
+		}
+		// revoke creates a revocation of serial, as resolved by the
+		// revocation controller.
+		revoke := func(name, serial, issuer string) *v1.AwsIamRaCertificateRevocation {
+			revocation := &v1.AwsIamRaCertificateRevocation{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: v1.AwsIamRaCertificateRevocationSpec{
+					SerialNumber: serial,
+					Reason:       v1.RevocationReasonKeyCompromise,
+				},
+			}
+			create(revocation)
+			now := metav1.Now()
+			revocation.Status.SerialNumber = serial
+			revocation.Status.Issuer = issuer
+			revocation.Status.RevokedAt = &now
+			Expect(k8sClient.Status().Update(ctx, revocation)).To(Succeed())
+			return revocation
+		}
+		reconcileCRL := func() *corev1.Secret {
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: reconciler.CASecret})
+			Expect(err).NotTo(HaveOccurred())
+			var secret corev1.Secret
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "crl-ca-crl", Namespace: "default"}, &secret)).
+				To(Succeed())
+			return &secret
+		}
+		published := func(revocation *v1.AwsIamRaCertificateRevocation) *metav1.Condition {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(revocation), revocation)).To(Succeed())
+			return meta.FindStatusCondition(revocation.Status.Conditions, v1.RevocationConditionPublished)
+		}
+
+		BeforeEach(func() {
+			calls = map[string]int{}
+			server = fakeCrlAPI(calls)
+			caSecret := newTestCASecret("crl-ca")
+			var err error
+			ca, err = pki.ParseCA(caSecret.Data[corev1.TLSCertKey], caSecret.Data[corev1.TLSPrivateKeyKey])
+			Expect(err).NotTo(HaveOccurred())
+			reconciler = &CertificateRevocationListReconciler{
+				Client:         k8sClient,
+				Scheme:         k8sClient.Scheme(),
+				Recorder:       record.NewFakeRecorder(10),
+				CASecret:       client.ObjectKeyFromObject(caSecret),
+				Profile:        types.NamespacedName{Name: "crl-profile", Namespace: "default"},
+				CertSecretName: "crl-cert",
+				Endpoint:       server.URL,
+			}
+			objects = nil
+			create(caSecret, newTestCertSecret("crl-cert"), &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "crl-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/crl-publisher",
+				},
+			})
+			objects = append(objects, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "crl-ca-crl", Namespace: "default"}})
+		})
+
+		AfterEach(func() {
+			server.Close()
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should publish a CRL of the certificates issued by the CA", func() {
+			issuer := ca.Certificate.Subject.String()
+			first := revoke("first", "1a2b", issuer)
+			foreign := revoke("foreign", "3c4d", "CN=someone else")
+
+			secret := reconcileCRL()
+			crl := parseCRL(secret.Data[CRLSecretKey])
+			Expect(crl).NotTo(BeNil())
+			Expect(crl.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+			Expect(crl.Number.Int64()).To(Equal(int64(1)))
+			Expect(crl.RevokedCertificateEntries).To(HaveLen(1))
+			Expect(pki.FormatSerial(crl.RevokedCertificateEntries[0].SerialNumber)).To(Equal("1a2b"))
+			Expect(crl.RevokedCertificateEntries[0].ReasonCode).To(Equal(1))
+			Expect(secret.Annotations).To(HaveKeyWithValue(crlIDAnnotationKey, "crl-1"))
+			Expect(calls).To(Equal(map[string]int{"ImportCrl": 1}))
+			Expect(published(first).Status).To(Equal(metav1.ConditionTrue))
+			Expect(published(foreign).Reason).To(Equal("NotIssuedByController"))
+
+			By("reconciling again without changes")
+			reconcileCRL()
+			Expect(calls).To(Equal(map[string]int{"ImportCrl": 1}))
+
+			By("revoking another certificate")
+			second := revoke("second", "5e6f", "")
+			secret = reconcileCRL()
+			crl = parseCRL(secret.Data[CRLSecretKey])
+			Expect(crl.Number.Int64()).To(Equal(int64(2)))
+			Expect(crl.RevokedCertificateEntries).To(HaveLen(2))
+			Expect(calls).To(Equal(map[string]int{"ImportCrl": 1, "UpdateCrl": 1}))
+			Expect(published(second).Message).To(ContainSubstring("crl-1"))
+		})
+
+		It("should only write the CRL to a Secret without a publishing profile", func() {
+			reconciler.Profile = types.NamespacedName{}
+			revocation := revoke("unpublished", "77", "")
+
+			secret := reconcileCRL()
+			Expect(strings.HasPrefix(string(secret.Data[CRLSecretKey]), "-----BEGIN X509 CRL-----")).To(BeTrue())
+			Expect(calls).To(BeEmpty())
+			condition := published(revocation)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("PublishingDisabled"))
+		})
+	})
+})
@@ -0,0 +1,229 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"errors"
+	"fmt"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"slices"
+	"time"
+)
+
+const (
+	// podNameExtraKey and podUIDExtraKey are set in the user info of requests
+	// authenticated with a pod-bound service account token.
+	podNameExtraKey = "authentication.kubernetes.io/pod-name"
+	podUIDExtraKey  = "authentication.kubernetes.io/pod-uid"
+)
+
+// allowedUsages are the key usages sidecars may request.
+var allowedUsages = []certificatesv1.KeyUsage{
+	certificatesv1.UsageDigitalSignature,
+	certificatesv1.UsageKeyEncipherment,
+	certificatesv1.UsageClientAuth,
+}
+
+// CertificateSigningRequestReconciler approves and signs the
+// CertificateSigningRequests sidecars submit for the cloud.dancav.io/iamra
+// signer, once it has checked that the requesting pod is the one named in the
+// subject.
+type CertificateSigningRequestReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// CASecret is the kubernetes.io/tls Secret holding the CA certificate and key.
+	CASecret types.NamespacedName
+	// TrustDomain is the trust domain of the SPIFFE IDs in issued certificates.
+	TrustDomain string
+	// Duration is the lifetime of issued certificates, unless the pod's
+	// profile sets one.
+	Duration time.Duration
+}
+
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/approval,verbs=update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/status,verbs=update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames=cloud.dancav.io/iamra,verbs=approve;sign
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile approves or denies a pending request, then signs approved ones.
+func (r *CertificateSigningRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var csr certificatesv1.CertificateSigningRequest
+	if err := r.Get(ctx, req.NamespacedName, &csr); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if csr.Spec.SignerName != v1.CertificateSignerName || len(csr.Status.Certificate) > 0 ||
+		hasCSRCondition(&csr, certificatesv1.CertificateDenied) || hasCSRCondition(&csr, certificatesv1.CertificateFailed) {
+		return ctrl.Result{}, nil
+	}
+
+	pod, id, validationErr := r.validate(ctx, &csr)
+	if !hasCSRCondition(&csr, certificatesv1.CertificateApproved) {
+		if validationErr != nil {
+			logger.Info("Denying certificate signing request", "reason", validationErr.Error())
+			r.Recorder.Event(&csr, corev1.EventTypeWarning, "Denied", validationErr.Error())
+			setCSRCondition(&csr, certificatesv1.CertificateDenied, "IdentityMismatch", validationErr.Error())
+			return ctrl.Result{}, r.SubResource("approval").Update(ctx, &csr)
+		}
+		setCSRCondition(&csr, certificatesv1.CertificateApproved, "PodIdentityVerified",
+			fmt.Sprintf("requested by pod %s/%s", id.Namespace, id.Pod))
+		if err := r.SubResource("approval").Update(ctx, &csr); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if validationErr != nil {
+		// Approved by someone else, but the certificate would misrepresent
+		// the requester.
+		setCSRCondition(&csr, certificatesv1.CertificateFailed, "IdentityMismatch", validationErr.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, &csr)
+	}
+
+	if r.CASecret.Name == "" {
+		setCSRCondition(&csr, certificatesv1.CertificateFailed, "CertificateAuthorityNotConfigured",
+			"the controller has no --ca-secret")
+		return ctrl.Result{}, r.Status().Update(ctx, &csr)
+	}
+	ca, err := loadCA(ctx, r.Client, r.CASecret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	request, err := pki.ParseCertificateRequest(csr.Spec.Request)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	duration := certificateDuration(ctx, r.Client, pod, r.Duration)
+	if seconds := csr.Spec.ExpirationSeconds; seconds != nil && time.Duration(*seconds)*time.Second < duration {
+		duration = time.Duration(*seconds) * time.Second
+	}
+	certPEM, err := ca.Sign(id, r.TrustDomain, request.PublicKey, duration)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	csr.Status.Certificate = certPEM
+	if err := r.Status().Update(ctx, &csr); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("Signed certificate", "pod", types.NamespacedName{Namespace: id.Namespace, Name: id.Pod})
+	return ctrl.Result{}, nil
+}
+
+// validate checks that csr was submitted by the pod named in its subject,
+// running as the service account named in its subject, and that the pod's
+// profile gets its certificates through CertificateSigningRequests. Only
+// pod-bound tokens tell which pod made a request, so requests lacking the pod
+// name or UID are refused: any other pod of the service account could make
+// them.
+func (r *CertificateSigningRequestReconciler) validate(
+	ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
+) (*corev1.Pod, pki.Identity, error) {
+	request, err := pki.ParseCertificateRequest(csr.Spec.Request)
+	if err != nil {
+		return nil, pki.Identity{}, err
+	}
+	id, err := pki.IdentityFromSubject(request.Subject)
+	if err != nil {
+		return nil, id, err
+	}
+	for _, usage := range csr.Spec.Usages {
+		if !slices.Contains(allowedUsages, usage) {
+			return nil, id, fmt.Errorf("usage %q is not allowed", usage)
+		}
+	}
+
+	username := fmt.Sprintf("system:serviceaccount:%s:%s", id.Namespace, id.ServiceAccount)
+	if csr.Spec.Username != username {
+		return nil, id, fmt.Errorf("requested by %s, not by %s", csr.Spec.Username, username)
+	}
+	podNames, podUIDs := csr.Spec.Extra[podNameExtraKey], csr.Spec.Extra[podUIDExtraKey]
+	if len(podNames) == 0 || len(podUIDs) == 0 {
+		return nil, id, errors.New("not requested with a pod-bound service account token")
+	}
+	if !slices.Equal(podNames, []string{id.Pod}) {
+		return nil, id, fmt.Errorf("requested by pod %v, not by pod %s", podNames, id.Pod)
+	}
+
+	var pod corev1.Pod
+	if err := r.Get(ctx, types.NamespacedName{Namespace: id.Namespace, Name: id.Pod}, &pod); err != nil {
+		return nil, id, fmt.Errorf("unable to fetch pod %s/%s: %w", id.Namespace, id.Pod, err)
+	}
+	if !slices.Equal(podUIDs, []string{string(pod.UID)}) {
+		return nil, id, fmt.Errorf("requested by another pod named %s", id.Pod)
+	}
+	if pod.Spec.ServiceAccountName != id.ServiceAccount {
+		return nil, id, fmt.Errorf("pod %s runs as service account %s, not %s",
+			id.Pod, pod.Spec.ServiceAccountName, id.ServiceAccount)
+	}
+	profile, err := podProfile(ctx, r.Client, &pod)
+	if err != nil {
+		return nil, id, err
+	}
+	if _, ok := pod.Annotations[v1.CertSecretPodAnnotationKey]; ok ||
+		profile.Spec.CertificateSource() != v1.CertificateSourceCertificateSigningRequest {
+		return nil, id, errors.New("pod doesn't get its certificate through a certificate signing request")
+	}
+	return &pod, id, nil
+}
+
+func hasCSRCondition(
+	csr *certificatesv1.CertificateSigningRequest, conditionType certificatesv1.RequestConditionType,
+) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func setCSRCondition(csr *certificatesv1.CertificateSigningRequest,
+	conditionType certificatesv1.RequestConditionType, reason, message string) {
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           conditionType,
+		Status:         corev1.ConditionTrue,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: metav1.Now(),
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CertificateSigningRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	forSigner := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		csr, ok := obj.(*certificatesv1.CertificateSigningRequest)
+		return ok && csr.Spec.SignerName == v1.CertificateSignerName
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&certificatesv1.CertificateSigningRequest{}, builder.WithPredicates(forSigner)).
+		Named("certificatesigningrequest").
+		Complete(r)
+}
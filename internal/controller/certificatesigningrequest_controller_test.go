@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+)
+
+// clientAs returns a client impersonating username, so that the API server
+// records it as the requester of the CertificateSigningRequests it creates.
+func clientAs(username string, extra map[string][]string) client.Client {
+	impersonated := rest.CopyConfig(cfg)
+	impersonated.Impersonate = rest.ImpersonationConfig{
+		UserName: username,
+		Groups:   []string{"system:serviceaccounts", "system:authenticated"},
+		Extra:    extra,
+	}
+	c, err := client.New(impersonated, client.Options{Scheme: k8sClient.Scheme()})
+	Expect(err).NotTo(HaveOccurred())
+	return c
+}
+
+func newTestCSR(id pki.Identity) *certificatesv1.CertificateSigningRequest {
+	_, request, err := pki.NewCertificateRequest(id)
+	Expect(err).NotTo(HaveOccurred())
+	return &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "iamra-" + id.Pod + "-"},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    request,
+			SignerName: v1.CertificateSignerName,
+			Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+		},
+	}
+}
+
+var _ = Describe("CertificateSigningRequest Controller", func() {
+	Context("When reconciling a certificate signing request", func() {
+		ctx := context.Background()
+		var (
+			reconciler *CertificateSigningRequestReconciler
+			objects    []client.Object
+			pod        *corev1.Pod
+		)
+
+		BeforeEach(func() {
+			reconciler = &CertificateSigningRequestReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				Recorder:    record.NewFakeRecorder(10),
+				CASecret:    types.NamespacedName{Name: "iamra-csr-ca", Namespace: "default"},
+				TrustDomain: "cluster.local",
+				Duration:    time.Hour,
+			}
+			profile := &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "csr-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					Certificate:    &v1.CertificateSpec{Source: v1.CertificateSourceCertificateSigningRequest},
+				},
+			}
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "csr-pod",
+					Namespace:   "default",
+					Annotations: map[string]string{v1.RoleProfilePodAnnotationKey: "csr-profile"},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "builder",
+					Containers:         []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+			objects = []client.Object{newTestCASecret("iamra-csr-ca"), profile, pod}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should approve and sign requests from the pod named in the subject", func() {
+			csr := newTestCSR(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "csr-pod"})
+			requester := clientAs("system:serviceaccount:default:builder", map[string][]string{
+				podNameExtraKey: {"csr-pod"},
+				podUIDExtraKey:  {string(pod.UID)},
+			})
+			Expect(requester.Create(ctx, csr)).To(Succeed())
+			objects = append(objects, csr)
+
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(csr)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(csr), csr)).To(Succeed())
+			Expect(hasCSRCondition(csr, certificatesv1.CertificateApproved)).To(BeTrue())
+			block, _ := pem.Decode(csr.Status.Certificate)
+			Expect(block).NotTo(BeNil())
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.Subject.CommonName).To(Equal("csr-pod"))
+			Expect(cert.URIs[0].String()).To(Equal("spiffe://cluster.local/ns/default/sa/builder"))
+		})
+
+		It("should deny requests for another pod's identity", func() {
+			csr := newTestCSR(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "csr-pod"})
+			requester := clientAs("system:serviceaccount:default:intruder", nil)
+			Expect(requester.Create(ctx, csr)).To(Succeed())
+			objects = append(objects, csr)
+
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(csr)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(csr), csr)).To(Succeed())
+			Expect(hasCSRCondition(csr, certificatesv1.CertificateDenied)).To(BeTrue())
+			Expect(csr.Status.Certificate).To(BeEmpty())
+		})
+
+		It("should deny requests that don't name the pod and its UID", func() {
+			for _, extra := range []map[string][]string{
+				nil,
+				{podNameExtraKey: {"csr-pod"}},
+				{podUIDExtraKey: {string(pod.UID)}},
+			} {
+				csr := newTestCSR(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "csr-pod"})
+				requester := clientAs("system:serviceaccount:default:builder", extra)
+				Expect(requester.Create(ctx, csr)).To(Succeed())
+				objects = append(objects, csr)
+
+				_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(csr)})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(csr), csr)).To(Succeed())
+				Expect(hasCSRCondition(csr, certificatesv1.CertificateDenied)).To(BeTrue())
+				Expect(csr.Status.Certificate).To(BeEmpty())
+			}
+		})
+	})
+})
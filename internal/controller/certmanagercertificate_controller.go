@@ -0,0 +1,200 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+// CertManagerCertificateReconciler keeps a cert-manager Certificate for every
+// service account or workload whose pods use a profile with the CertManager
+// certificate source, and deletes the ones no pod uses anymore.
+type CertManagerCertificateReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// TrustDomain is the trust domain of the SPIFFE IDs in the subjects.
+	TrustDomain string
+	// Duration is the lifetime of the certificates, unless the profile sets one.
+	Duration time.Duration
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile makes the Certificates owned by a profile match the pods using it.
+func (r *CertManagerCertificateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var profile v1.AwsIamRaRoleProfile
+	if err := r.Get(ctx, req.NamespacedName, &profile); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	desired := map[string]iamram.CertificateSubjectData{}
+	if profile.DeletionTimestamp.IsZero() && profile.Spec.CertificateSource() == v1.CertificateSourceCertManager {
+		if profile.Spec.Certificate.CertManager == nil {
+			r.Recorder.Event(&profile, corev1.EventTypeWarning, "CertManagerNotConfigured",
+				"the profile uses the CertManager certificate source, but has no certificate.certManager")
+			return ctrl.Result{}, nil
+		}
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(profile.Namespace)); err != nil {
+			return ctrl.Result{}, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			name := pod.Annotations[v1.CertManagerCertificatePodAnnotationKey]
+			if name == "" || !pod.DeletionTimestamp.IsZero() ||
+				pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			if names := iamram.ProfileNames(pod); len(names) == 0 || names[0] != profile.Name {
+				continue
+			}
+			data, err := iamram.CertificateSubjectDataFor(pod, &profile, r.TrustDomain)
+			if err != nil {
+				r.Recorder.Event(pod, corev1.EventTypeWarning, "CertificateSubjectInvalid", err.Error())
+				continue
+			}
+			desired[name] = data
+		}
+	}
+
+	for name, data := range desired {
+		subject, err := iamram.RenderCertificateSubject(&profile, data)
+		if err != nil {
+			r.Recorder.Event(&profile, corev1.EventTypeWarning, "CertificateSubjectInvalid", err.Error())
+			return ctrl.Result{}, nil
+		}
+		certificate := &certmanagerv1.Certificate{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: profile.Namespace},
+		}
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, certificate, func() error {
+			r.setCertificateSpec(certificate, &profile, name, subject)
+			return controllerutil.SetControllerReference(&profile, certificate, r.Scheme)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if result != controllerutil.OperationResultNone {
+			logger.Info("Reconciled cert-manager Certificate", "certificate", name, "operation", result)
+		}
+	}
+
+	var certificates certmanagerv1.CertificateList
+	if err := r.List(ctx, &certificates, client.InNamespace(profile.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	for i := range certificates.Items {
+		certificate := &certificates.Items[i]
+		if _, ok := desired[certificate.Name]; ok || !metav1.IsControlledBy(certificate, &profile) {
+			continue
+		}
+		if err := r.Delete(ctx, certificate); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		logger.Info("Deleted unused cert-manager Certificate", "certificate", certificate.Name)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// setCertificateSpec sets the spec of a Certificate issuing a client
+// certificate into the Secret of the same name.
+func (r *CertManagerCertificateReconciler) setCertificateSpec(
+	certificate *certmanagerv1.Certificate, profile *v1.AwsIamRaRoleProfile,
+	name string, subject *iamram.CertificateSubject,
+) {
+	spec := profile.Spec.Certificate
+	duration := r.Duration
+	if spec.Duration != nil {
+		duration = spec.Duration.Duration
+	}
+	issuerRef := spec.CertManager.IssuerRef
+	if issuerRef.Kind == "" {
+		issuerRef.Kind = certmanagerv1.IssuerKind
+	}
+	certificate.Spec = certmanagerv1.CertificateSpec{
+		SecretName: name,
+		CommonName: subject.CommonName,
+		Subject: &certmanagerv1.X509Subject{
+			Organizations:       subject.Organizations,
+			OrganizationalUnits: subject.OrganizationalUnits,
+		},
+		URIs:        subject.URIs,
+		DNSNames:    subject.DNSNames,
+		Duration:    &metav1.Duration{Duration: duration},
+		RenewBefore: spec.CertManager.RenewBefore,
+		IssuerRef: cmmeta.ObjectReference{
+			Name:  issuerRef.Name,
+			Kind:  issuerRef.Kind,
+			Group: issuerRef.Group,
+		},
+		PrivateKey: &certmanagerv1.CertificatePrivateKey{
+			Algorithm: certmanagerv1.ECDSAKeyAlgorithm,
+			Encoding:  certmanagerv1.PKCS8,
+		},
+		Usages: []certmanagerv1.KeyUsage{
+			certmanagerv1.UsageDigitalSignature,
+			certmanagerv1.UsageClientAuth,
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CertManagerCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.AwsIamRaRoleProfile{}).
+		Owns(&certmanagerv1.Certificate{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(certManagerProfileOf)).
+		Named("certmanagercertificate").
+		Complete(r)
+}
+
+// certManagerProfileOf maps a pod mounting a cert-manager Certificate to the
+// profile its certificate settings come from.
+func certManagerProfileOf(_ context.Context, obj client.Object) []reconcile.Request {
+	if _, ok := obj.GetAnnotations()[v1.CertManagerCertificatePodAnnotationKey]; !ok {
+		return nil
+	}
+	names := iamram.ProfileNames(obj)
+	if len(names) == 0 {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: names[0]}},
+	}
+}
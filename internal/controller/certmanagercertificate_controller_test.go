@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+)
+
+var _ = Describe("CertManagerCertificate Controller", func() {
+	Context("When reconciling a profile using cert-manager", func() {
+		ctx := context.Background()
+		const certificateName = "aws-iamra-cm-profile-sa-builder"
+		var (
+			reconciler *CertManagerCertificateReconciler
+			profile    *v1.AwsIamRaRoleProfile
+			objects    []client.Object
+		)
+
+		newPod := func(name string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+					Annotations: map[string]string{
+						v1.RoleProfilePodAnnotationKey:            "cm-profile",
+						v1.CertManagerCertificatePodAnnotationKey: certificateName,
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "builder",
+					Containers:         []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			reconciler = &CertManagerCertificateReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				Recorder:    record.NewFakeRecorder(10),
+				TrustDomain: "cluster.local",
+				Duration:    24 * time.Hour,
+			}
+			profile = &v1.AwsIamRaRoleProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "cm-profile", Namespace: "default"},
+				Spec: v1.AwsIamRaRoleProfileSpec{
+					TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+					ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+					RoleArn:        "arn:aws:iam::123:role/baz",
+					Certificate: &v1.CertificateSpec{
+						Source: v1.CertificateSourceCertManager,
+						CertManager: &v1.CertManagerCertificateSpec{
+							IssuerRef: v1.IssuerReference{Name: "iamra-ca", Kind: "ClusterIssuer"},
+							Subject: &v1.CertificateSubjectTemplate{
+								DNSNames: []string{"{{.ServiceAccount}}.{{.Namespace}}.svc"},
+							},
+							RenewBefore: &metav1.Duration{Duration: 8 * time.Hour},
+						},
+					},
+				},
+			}
+			objects = []client.Object{profile, newPod("cm-pod-1"), newPod("cm-pod-2")}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+			Expect(k8sClient.DeleteAllOf(ctx, &certmanagerv1.Certificate{}, client.InNamespace("default"))).
+				To(Succeed())
+		})
+
+		It("should create one Certificate per service account and delete it once unused", func() {
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(profile)}
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			var certificate certmanagerv1.Certificate
+			certificateKey := types.NamespacedName{Name: certificateName, Namespace: "default"}
+			Expect(k8sClient.Get(ctx, certificateKey, &certificate)).To(Succeed())
+			Expect(metav1.IsControlledBy(&certificate, profile)).To(BeTrue())
+			Expect(certificate.Spec.SecretName).To(Equal(certificateName))
+			Expect(certificate.Spec.IssuerRef.Name).To(Equal("iamra-ca"))
+			Expect(certificate.Spec.IssuerRef.Kind).To(Equal("ClusterIssuer"))
+			Expect(certificate.Spec.CommonName).To(Equal("builder"))
+			Expect(certificate.Spec.Subject.Organizations).To(Equal([]string{"default"}))
+			Expect(certificate.Spec.URIs).To(Equal([]string{"spiffe://cluster.local/ns/default/sa/builder"}))
+			Expect(certificate.Spec.DNSNames).To(Equal([]string{"builder.default.svc"}))
+			Expect(certificate.Spec.Duration.Duration).To(Equal(24 * time.Hour))
+			Expect(certificate.Spec.RenewBefore.Duration).To(Equal(8 * time.Hour))
+			Expect(certificate.Spec.Usages).To(ContainElement(certmanagerv1.UsageClientAuth))
+
+			By("deleting the pods that use it")
+			// Pods that aren't bound to a node are deleted right away.
+			for _, pod := range objects[1:3] {
+				Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+			}
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apierrors.IsNotFound(k8sClient.Get(ctx, certificateKey, &certificate))).To(BeTrue())
+		})
+
+		It("should name the workload in the subject with the Workload scope", func() {
+			profile.Spec.Certificate.CertManager.Scope = v1.CertificateScopeWorkload
+			Expect(k8sClient.Update(ctx, profile)).To(Succeed())
+			pod := newPod("cm-workload-pod")
+			pod.Annotations[v1.CertManagerCertificatePodAnnotationKey] = "aws-iamra-cm-profile-statefulset-db"
+			pod.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", UID: "1234", Controller: ptr.To(true),
+			}}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			objects = append(objects, pod)
+
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(profile)})
+			Expect(err).NotTo(HaveOccurred())
+			var certificate certmanagerv1.Certificate
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "aws-iamra-cm-profile-statefulset-db",
+				Namespace: "default"}, &certificate)).To(Succeed())
+			Expect(certificate.Spec.CommonName).To(Equal("db"))
+		})
+	})
+})
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// IamRaManagerConfigReconciler loads the IamRaManagerConfig named Name into
+// Store, for the pod webhook to inject sidecars with. Invalid settings are
+// reported on the resource and skipped, so that the ones in use stay.
+type IamRaManagerConfigReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Name     string
+	Store    *managerconfig.Store
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=iamramanagerconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=iamramanagerconfigs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile applies the settings of the IamRaManagerConfig.
+func (r *IamRaManagerConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var config v1.IamRaManagerConfig
+	if err := r.Get(ctx, req.NamespacedName, &config); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("IamRaManagerConfig deleted, falling back to the configuration file")
+			r.Store.SetResource(nil)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               v1.ManagerConfigConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            "The settings are in use",
+		ObservedGeneration: config.Generation,
+	}
+	if err := managerconfig.Validate(&config.Spec); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	} else {
+		r.Store.SetResource(&config.Spec)
+		logger.Info("applied IamRaManagerConfig", "generation", config.Generation)
+	}
+
+	// Every replica of the controller applies the settings, and reports the
+	// same outcome, so the status only changes with the generation.
+	if config.Status.ObservedGeneration == config.Generation &&
+		meta.IsStatusConditionPresentAndEqual(config.Status.Conditions, condition.Type, condition.Status) {
+		return ctrl.Result{}, nil
+	}
+	config.Status.ObservedGeneration = config.Generation
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, &config); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if condition.Status == metav1.ConditionFalse {
+		r.Recorder.Event(&config, corev1.EventTypeWarning, "InvalidConfig", condition.Message)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. It runs on every
+// replica, leader or not, since each of them serves the pod webhook.
+func (r *IamRaManagerConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.IamRaManagerConfig{}, builder.WithPredicates(
+			predicate.NewPredicateFuncs(func(obj client.Object) bool { return obj.GetName() == r.Name }),
+			predicate.GenerationChangedPredicate{},
+		)).
+		WithOptions(controller.Options{NeedLeaderElection: ptr.To(false)}).
+		Named("iamramanagerconfig").
+		Complete(r)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+)
+
+var _ = Describe("IamRaManagerConfig Controller", func() {
+	Context("When reconciling a resource", func() {
+		ctx := context.Background()
+		name := types.NamespacedName{Name: "default"}
+		var (
+			recorder   *record.FakeRecorder
+			store      *managerconfig.Store
+			reconciler *IamRaManagerConfigReconciler
+			config     *v1.IamRaManagerConfig
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			defaults := managerconfig.Defaults()
+			defaults.Sidecar.Image = "ghcr.io/dancavio/aws-iamra-manager/sidecar:1.0.0"
+			store = managerconfig.NewStore(defaults)
+			reconciler = &IamRaManagerConfigReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+				Name:     name.Name,
+				Store:    store,
+			}
+			config = &v1.IamRaManagerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: name.Name},
+				Spec: v1.IamRaManagerConfigSpec{Sidecar: v1.SidecarConfig{
+					Image: "registry.example.com/iamram/sidecar:1.2.0",
+					Port:  10911,
+				}},
+			}
+			Expect(k8sClient.Create(ctx, config)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, config))).To(Succeed())
+		})
+
+		It("should apply valid settings and skip invalid ones", func() {
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: name})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Sidecar(nil).Image).To(Equal("registry.example.com/iamram/sidecar:1.2.0"))
+			Expect(store.Sidecar(nil).Port).To(BeEquivalentTo(10911))
+			Expect(k8sClient.Get(ctx, name, config)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(config.Status.Conditions, v1.ManagerConfigConditionApplied)).To(BeTrue())
+
+			By("keeping the settings in use when the new ones aren't restricted")
+			config.Spec.Sidecar.Image = "registry.example.com/iamram/sidecar:1.3.0"
+			config.Spec.Sidecar.SecurityContext = &corev1.SecurityContext{}
+			Expect(k8sClient.Update(ctx, config)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: name})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Sidecar(nil).Image).To(Equal("registry.example.com/iamram/sidecar:1.2.0"))
+			Expect(k8sClient.Get(ctx, name, config)).To(Succeed())
+			condition := meta.FindStatusCondition(config.Status.Conditions, v1.ManagerConfigConditionApplied)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring("runAsNonRoot must be true"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("InvalidConfig")))
+
+			By("falling back to the defaults once the resource is deleted")
+			Expect(k8sClient.Delete(ctx, config)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: name})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Sidecar(nil).Image).To(Equal("ghcr.io/dancavio/aws-iamra-manager/sidecar:1.0.0"))
+		})
+	})
+})
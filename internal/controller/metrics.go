@@ -0,0 +1,36 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "iamram_certificate_expiration_timestamp_seconds",
+	Help: "Time the certificate in a Secret used by pods with a role profile expires, in seconds since the epoch.",
+}, []string{"namespace", "secret"})
+
+var outdatedSidecars = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "iamram_outdated_sidecar_pods",
+	Help: "Pods whose sidecar runs an image other than the configured one, by namespace and first profile.",
+}, []string{"namespace", "profile"})
+
+func init() {
+	metrics.Registry.MustRegister(certificateExpiry, outdatedSidecars)
+}
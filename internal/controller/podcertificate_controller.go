@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"encoding/pem"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"time"
+)
+
+// PodCertificateReconciler issues short-lived certificates to pods whose
+// profile uses the controller's CA, and renews them before they expire.
+type PodCertificateReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// CASecret is the kubernetes.io/tls Secret holding the CA certificate and
+	// key. It is read on every issuance, so that the CA can be rotated.
+	CASecret types.NamespacedName
+	// TrustDomain is the trust domain of the SPIFFE IDs in issued certificates.
+	TrustDomain string
+	// Duration is the lifetime of issued certificates, unless the pod's
+	// profile sets one.
+	Duration time.Duration
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile makes sure the Secret named in a pod's issued cert secret
+// annotation holds a valid certificate for the pod. The annotation is set by
+// whoever creates the pod, so the controller only creates the Secret or
+// updates it while the pod controls it, rather than taking over and later
+// garbage collecting a Secret it didn't write.
+func (r *PodCertificateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var pod corev1.Pod
+	if err := r.Get(ctx, req.NamespacedName, &pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	secretName := pod.Annotations[v1.IssuedCertSecretPodAnnotationKey]
+	if secretName == "" || !pod.DeletionTimestamp.IsZero() ||
+		pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return ctrl.Result{}, nil
+	}
+	id := pki.Identity{Namespace: pod.Namespace, ServiceAccount: pod.Spec.ServiceAccountName, Pod: pod.Name}
+	if id.ServiceAccount == "" {
+		id.ServiceAccount = "default"
+	}
+
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{Namespace: pod.Namespace, Name: secretName}
+	if err := r.Get(ctx, secretKey, secret); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	if secret.ResourceVersion != "" && !metav1.IsControlledBy(secret, &pod) {
+		r.Recorder.Eventf(&pod, corev1.EventTypeWarning, "CertificateSecretConflict",
+			"Secret %s already exists and isn't controlled by the pod, so no certificate is issued into it",
+			secretName)
+		return ctrl.Result{}, nil
+	}
+	cert := issuedCertificate(secret)
+	if cert != nil && cert.Subject.CommonName == id.Pod {
+		if wait := time.Until(pki.RenewAt(cert)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	if r.CASecret.Name == "" {
+		r.Recorder.Event(&pod, corev1.EventTypeWarning, "CertificateAuthorityNotConfigured",
+			"the pod's profile uses the controller's CA, but the controller has no --ca-secret")
+		return ctrl.Result{}, nil
+	}
+	ca, err := loadCA(ctx, r.Client, r.CASecret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	certPEM, keyPEM, err := ca.Issue(id, r.TrustDomain, certificateDuration(ctx, r.Client, &pod, r.Duration))
+	if err != nil {
+		r.Recorder.Event(&pod, corev1.EventTypeWarning, "CertificateIssueFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	secret.Namespace = secretKey.Namespace
+	secret.Name = secretKey.Name
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.ResourceVersion != "" && !metav1.IsControlledBy(secret, &pod) {
+			return notControlledError(secret, "Pod")
+		}
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[v1.ManagedSecretLabelKey] = "true"
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		}
+		return controllerutil.SetControllerReference(&pod, secret, r.Scheme)
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cert = issuedCertificate(secret)
+	logger.Info("Issued pod certificate", "secret", secretName, "notAfter", cert.NotAfter)
+	return ctrl.Result{RequeueAfter: time.Until(pki.RenewAt(cert))}, nil
+}
+
+// loadCA reads the controller's CA from its Secret.
+func loadCA(ctx context.Context, c client.Client, name types.NamespacedName) (*pki.CA, error) {
+	var caSecret corev1.Secret
+	if err := c.Get(ctx, name, &caSecret); err != nil {
+		return nil, fmt.Errorf("unable to fetch CA secret: %w", err)
+	}
+	ca, err := pki.ParseCA(caSecret.Data[corev1.TLSCertKey], caSecret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA secret: %w", err)
+	}
+	return ca, nil
+}
+
+// podProfile returns the first profile of pod, whose settings apply to the
+// pod's certificate.
+func podProfile(ctx context.Context, c client.Client, pod *corev1.Pod) (*v1.AwsIamRaRoleProfile, error) {
+	names := iamram.ProfileNames(pod)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("pod %s uses no profile", pod.Name)
+	}
+	var profile v1.AwsIamRaRoleProfile
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: names[0]}, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// certificateDuration returns the lifetime of the certificates of pod, as set
+// by its profile, or def.
+func certificateDuration(ctx context.Context, c client.Client, pod *corev1.Pod, def time.Duration) time.Duration {
+	profile, err := podProfile(ctx, c, pod)
+	if err != nil {
+		return def
+	}
+	if certificate := profile.Spec.Certificate; certificate != nil && certificate.Duration != nil {
+		return certificate.Duration.Duration
+	}
+	return def
+}
+
+// issuedCertificate returns the leaf certificate in secret, or nil if it has
+// none.
+func issuedCertificate(secret *corev1.Secret) *x509.Certificate {
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PodCertificateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	hasIssuedCert := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetAnnotations()[v1.IssuedCertSecretPodAnnotationKey]
+		return ok
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Pod{}, builder.WithPredicates(hasIssuedCert)).
+		Owns(&corev1.Secret{}).
+		Named("podcertificate").
+		Complete(r)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+)
+
+func newTestCASecret(name string) *corev1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "iamra intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+var _ = Describe("PodCertificate Controller", func() {
+	Context("When reconciling a pod", func() {
+		ctx := context.Background()
+		var (
+			recorder   *record.FakeRecorder
+			reconciler *PodCertificateReconciler
+			objects    []client.Object
+			pod        *corev1.Pod
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			reconciler = &PodCertificateReconciler{
+				Client:      k8sClient,
+				Scheme:      k8sClient.Scheme(),
+				Recorder:    recorder,
+				CASecret:    types.NamespacedName{Name: "iamra-ca", Namespace: "default"},
+				TrustDomain: "cluster.local",
+				Duration:    time.Hour,
+			}
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "issued-cert-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						v1.IssuedCertSecretPodAnnotationKey: "aws-iamra-cert-test",
+					},
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "builder",
+					Containers:         []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+			objects = []client.Object{newTestCASecret("iamra-ca"), pod}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			for _, obj := range objects {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+			}
+		})
+
+		It("should issue a certificate for the pod and keep it until renewal", func() {
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+			result, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("~", 38*time.Minute, time.Minute))
+
+			var secret corev1.Secret
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "aws-iamra-cert-test", Namespace: "default"},
+				&secret)).To(Succeed())
+			objects = append(objects, &secret)
+			Expect(metav1.IsControlledBy(&secret, pod)).To(BeTrue())
+			cert := issuedCertificate(&secret)
+			Expect(cert).NotTo(BeNil())
+			Expect(cert.Subject.CommonName).To(Equal("issued-cert-pod"))
+			Expect(cert.URIs[0].String()).To(Equal("spiffe://cluster.local/ns/default/sa/builder"))
+
+			By("reconciling again before renewal is due")
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)).To(Succeed())
+			Expect(issuedCertificate(&secret).SerialNumber).To(Equal(cert.SerialNumber))
+		})
+
+		It("should leave a Secret the pod doesn't control untouched", func() {
+			foreign := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "aws-iamra-cert-test", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("hunter2")},
+			}
+			Expect(k8sClient.Create(ctx, foreign)).To(Succeed())
+			objects = append(objects, foreign)
+
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reconcile.Result{}))
+			Expect(recorder.Events).To(Receive(ContainSubstring("CertificateSecretConflict")))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(foreign), foreign)).To(Succeed())
+			Expect(foreign.Data).To(Equal(map[string][]byte{"password": []byte("hunter2")}))
+			Expect(foreign.OwnerReferences).To(BeEmpty())
+		})
+
+		It("should report pods waiting for a certificate when no CA is configured", func() {
+			reconciler.CASecret = types.NamespacedName{}
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.Events).To(Receive(ContainSubstring("CertificateAuthorityNotConfigured")))
+		})
+	})
+})
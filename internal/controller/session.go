@@ -0,0 +1,142 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// profileSession holds what the controller needs to call CreateSession on
+// behalf of a role profile, for resources that consume credentials directly.
+type profileSession struct {
+	Profile    v1.AwsIamRaRoleProfile
+	CertSecret corev1.Secret
+	Input      rolesanywhere.SessionInput
+}
+
+// getProfileSession fetches a role profile and a certificate Secret from
+// namespace. The session is named sessionName unless the profile sets one.
+func getProfileSession(
+	ctx context.Context, c client.Client, namespace, profileName, certSecretName, sessionName string,
+) (*profileSession, error) {
+	var session profileSession
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: profileName}, &session.Profile); err != nil {
+		return nil, fmt.Errorf("unable to fetch profile %s: %w", profileName, err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: certSecretName},
+		&session.CertSecret); err != nil {
+		return nil, fmt.Errorf("unable to fetch certificate secret %s: %w", certSecretName, err)
+	}
+
+	spec := session.Profile.Spec
+	session.Input = rolesanywhere.SessionInput{
+		TrustAnchorArn:  string(spec.TrustAnchorArn),
+		ProfileArn:      string(spec.ProfileArn),
+		RoleArn:         string(spec.RoleArn),
+		DurationSeconds: spec.DurationSeconds,
+		RoleSessionName: sessionName,
+	}
+	if spec.RoleSessionName != "" {
+		session.Input.RoleSessionName = spec.RoleSessionName
+	}
+	return &session, nil
+}
+
+// Client returns a Roles Anywhere client signing with the certificate Secret.
+func (s *profileSession) Client(endpoint string) (*rolesanywhere.Client, error) {
+	signer, err := rolesanywhere.ParseSigner(s.CertSecret.Data[corev1.TLSCertKey],
+		s.CertSecret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate secret %s: %w", s.CertSecret.Name, err)
+	}
+	return &rolesanywhere.Client{Signer: signer, Endpoint: endpoint}, nil
+}
+
+// InputHash identifies the session inputs and certificate, plus any extra
+// settings of the consumer, so that changes to them trigger a refresh.
+func (s *profileSession) InputHash(extra ...any) (string, error) {
+	encoded, err := json.Marshal(append([]any{
+		s.Input, s.CertSecret.Data[corev1.TLSCertKey], s.CertSecret.Data[corev1.TLSPrivateKeyKey],
+	}, extra...))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkProfileUse refuses to issue credentials of the profile to obj unless
+// the user recorded as its requester may use the profile, when it requires
+// the use permission or requireAll does. Only pods run as service accounts,
+// so profiles restricted to some are refused outright.
+func checkProfileUse(
+	ctx context.Context, c client.Client, obj client.Object, profile *v1.AwsIamRaRoleProfile, requireAll bool,
+) error {
+	if profile.Spec.AllowedServiceAccounts != nil {
+		return fmt.Errorf("profile %s is restricted to the service accounts of its spec.allowedServiceAccounts",
+			profile.Name)
+	}
+	if !iamram.UsePermissionRequired(profile, requireAll) {
+		return nil
+	}
+	user, err := iamram.Requester(obj)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("profile %s requires the use permission, but no requester is recorded in annotation %s",
+			profile.Name, v1.RequesterAnnotationKey)
+	}
+	allowed, err := iamram.MayUseProfile(ctx, c, *user, profile)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%s may not use profile %s: grant them the %q verb on awsiamraroleprofiles/%s",
+			user.Username, profile.Name, v1.ProfileUseVerb, profile.Name)
+	}
+	return nil
+}
+
+// notControlledError is the error of writing to an existing Secret a resource
+// of the kind doesn't control. Such Secrets are left alone, rather than taken
+// over from whoever wrote them.
+func notControlledError(secret *corev1.Secret, kind string) error {
+	return fmt.Errorf("secret %s/%s already exists and isn't controlled by this %s", secret.Namespace,
+		secret.Name, kind)
+}
+
+// untilNextTransition requeues a consumer of a profile outside its activation
+// window when the window opens, if it ever does.
+func untilNextTransition(activation iamram.Activation) ctrl.Result {
+	if activation.Next.IsZero() {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: time.Until(activation.Next)}
+}
@@ -0,0 +1,340 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sidecarCheckInterval is how often sidecar images are checked while none is
+// outdated, so that changes to the configuration file are noticed.
+const sidecarCheckInterval = 5 * time.Minute
+
+// SidecarImageReconciler compares the sidecar image of every pod with the
+// configured one. It reports outdated pods as a metric and on the
+// IamRaManagerConfig named Name, and restarts their workloads if the
+// RollingRestart policy says so.
+type SidecarImageReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Name     string
+	Store    *managerconfig.Store
+}
+
+// sidecarWorkload holds the pods of a workload that aren't done picking up
+// the configured sidecar image.
+type sidecarWorkload struct {
+	namespace, kind, name string
+	outdated              []*corev1.Pod
+	// unready counts the pods with the configured image that aren't ready yet.
+	unready int
+}
+
+func (w *sidecarWorkload) String() string {
+	return w.namespace + "/" + w.kind + "/" + w.name
+}
+
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=iamramanagerconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=iamramanagerconfigs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+
+// Reconcile checks the sidecar images of all pods. Every request is handled
+// the same, whatever object triggered it.
+func (r *SidecarImageReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	image := r.Store.Sidecar(nil).Image
+	if image == "" {
+		return ctrl.Result{}, nil
+	}
+	upgrade := r.Store.SidecarUpgrade()
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods); err != nil {
+		return ctrl.Result{}, err
+	}
+	status := &v1.SidecarImageStatus{Image: image}
+	outdated := map[v1.OutdatedSidecars]int32{}
+	workloads := map[string]*sidecarWorkload{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		podImage, ok := iamram.SidecarImage(pod)
+		if !ok || !pod.DeletionTimestamp.IsZero() ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		upToDate := podImage == image
+		if upToDate {
+			status.UpdatedPods++
+		} else {
+			status.OutdatedPods++
+			var profile string
+			if names := iamram.ProfileNames(pod); len(names) > 0 {
+				profile = names[0]
+			}
+			outdated[v1.OutdatedSidecars{Namespace: pod.Namespace, Profile: profile}]++
+		}
+		if upToDate && isPodReady(pod) {
+			continue
+		}
+		kind, name, err := iamram.Workload(pod)
+		if err != nil {
+			continue
+		}
+		key := pod.Namespace + "/" + kind + "/" + name
+		workload, ok := workloads[key]
+		if !ok {
+			workload = &sidecarWorkload{namespace: pod.Namespace, kind: kind, name: name}
+			workloads[key] = workload
+		}
+		if upToDate {
+			workload.unready++
+		} else {
+			workload.outdated = append(workload.outdated, pod)
+		}
+	}
+
+	outdatedSidecars.Reset()
+	for key, pods := range outdated {
+		outdatedSidecars.WithLabelValues(key.Namespace, key.Profile).Set(float64(pods))
+		key.Pods = pods
+		status.Outdated = append(status.Outdated, key)
+	}
+	sort.Slice(status.Outdated, func(i, j int) bool {
+		a, b := status.Outdated[i], status.Outdated[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Profile < b.Profile
+	})
+
+	var restartErr error
+	if upgrade.Policy == v1.SidecarUpgradeRollingRestart && status.OutdatedPods > 0 {
+		restartErr = r.restartWorkloads(ctx, image, int(upgrade.MaxPodsInFlight), workloads, status)
+		if restartErr != nil {
+			logger.Error(restartErr, "unable to restart workloads for the sidecar image")
+		}
+	}
+
+	if err := r.updateStatus(ctx, status, upgrade.Policy); err != nil {
+		return ctrl.Result{}, errors.Join(restartErr, err)
+	}
+	if status.OutdatedPods > 0 {
+		return ctrl.Result{RequeueAfter: rolloutRequeueDelay}, restartErr
+	}
+	return ctrl.Result{RequeueAfter: sidecarCheckInterval}, restartErr
+}
+
+// restartWorkloads restarts the next wave of workloads with outdated pods, by
+// recording the image in their pod template. Workloads already restarted for
+// it count towards maxPodsInFlight until all their pods run it and are ready,
+// and workloads whose PodDisruptionBudget allows no disruption are skipped.
+func (r *SidecarImageReconciler) restartWorkloads(
+	ctx context.Context, image string, maxPodsInFlight int, workloads map[string]*sidecarWorkload,
+	status *v1.SidecarImageStatus,
+) error {
+	logger := log.FromContext(ctx)
+	keys := make([]string, 0, len(workloads))
+	for key := range workloads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	inFlight := 0
+	var pending []*sidecarWorkload
+	for _, key := range keys {
+		workload := workloads[key]
+		_, template, err := getWorkload(ctx, r.Client, workload.namespace, workload.kind, workload.name)
+		switch {
+		case errors.Is(err, errNotRestartable):
+			if len(workload.outdated) > 0 {
+				status.BlockedWorkloads = append(status.BlockedWorkloads, fmt.Sprintf("%s: %v", workload, err))
+			}
+			continue
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return err
+		}
+		if template.Annotations[v1.SidecarImagePodAnnotationKey] == image {
+			inFlight += len(workload.outdated) + workload.unready
+			status.RestartingWorkloads = append(status.RestartingWorkloads, workload.String())
+		} else if len(workload.outdated) > 0 {
+			pending = append(pending, workload)
+		}
+	}
+
+	full := false
+	for _, workload := range pending {
+		budget, err := r.blockingBudget(ctx, workload.outdated[0])
+		if err != nil {
+			return err
+		}
+		if budget != "" {
+			status.BlockedWorkloads = append(status.BlockedWorkloads,
+				fmt.Sprintf("%s: PodDisruptionBudget %s allows no disruptions", workload, budget))
+			continue
+		}
+		if full || inFlight > 0 && inFlight+len(workload.outdated) > maxPodsInFlight {
+			// Later workloads are still checked for budgets, so that the
+			// status names every blocked one.
+			full = true
+			continue
+		}
+		obj, template, err := getWorkload(ctx, r.Client, workload.namespace, workload.kind, workload.name)
+		if err != nil {
+			return err
+		}
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[v1.SidecarImagePodAnnotationKey] = image
+		if err := r.Patch(ctx, obj, patch); err != nil {
+			return fmt.Errorf("unable to restart %s: %w", workload, err)
+		}
+		logger.Info("Restarted workload for the sidecar image", "workload", workload.String(), "image", image)
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, "RestartedForSidecarImage",
+			"Restarted %d pods running an outdated sidecar image for %s", len(workload.outdated), image)
+		inFlight += len(workload.outdated)
+		status.RestartingWorkloads = append(status.RestartingWorkloads, workload.String())
+	}
+	sort.Strings(status.RestartingWorkloads)
+	return nil
+}
+
+// blockingBudget returns the name of a PodDisruptionBudget covering the pod
+// that allows no disruption, if there is one.
+func (r *SidecarImageReconciler) blockingBudget(ctx context.Context, pod *corev1.Pod) (string, error) {
+	var budgets policyv1.PodDisruptionBudgetList
+	if err := r.List(ctx, &budgets, client.InNamespace(pod.Namespace)); err != nil {
+		return "", err
+	}
+	for _, budget := range budgets.Items {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if budget.Status.DisruptionsAllowed < 1 {
+			return budget.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// updateStatus reports the outdated pods on the IamRaManagerConfig, if there
+// is one.
+func (r *SidecarImageReconciler) updateStatus(
+	ctx context.Context, status *v1.SidecarImageStatus, policy v1.SidecarUpgradePolicy,
+) error {
+	var config v1.IamRaManagerConfig
+	if err := r.Get(ctx, types.NamespacedName{Name: r.Name}, &config); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	condition := metav1.Condition{
+		Type:    v1.ManagerConfigConditionSidecarsUpToDate,
+		Status:  metav1.ConditionTrue,
+		Reason:  "UpToDate",
+		Message: fmt.Sprintf("%d pods run sidecar image %s", status.UpdatedPods, status.Image),
+	}
+	switch {
+	case status.OutdatedPods == 0:
+	case policy != v1.SidecarUpgradeRollingRestart:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Outdated"
+		condition.Message = fmt.Sprintf("%d pods run an outdated sidecar image, which the Report policy "+
+			"leaves alone", status.OutdatedPods)
+	case len(status.RestartingWorkloads) == 0 && len(status.BlockedWorkloads) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RolloutBlocked"
+		condition.Message = fmt.Sprintf("%d pods run an outdated sidecar image: %s", status.OutdatedPods,
+			strings.Join(status.BlockedWorkloads, "; "))
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RolloutInProgress"
+		condition.Message = fmt.Sprintf("%d of %d pods run sidecar image %s", status.UpdatedPods,
+			status.UpdatedPods+status.OutdatedPods, status.Image)
+	}
+
+	previous := config.Status.DeepCopy()
+	config.Status.Sidecars = status
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+	if equality.Semantic.DeepEqual(previous, &config.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, &config)
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SidecarImageReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// All events map to the same request, so that checks of every pod don't
+	// pile up.
+	check := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: r.Name}}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.IamRaManagerConfig{}, builder.WithPredicates(
+			predicate.NewPredicateFuncs(func(obj client.Object) bool { return obj.GetName() == r.Name }),
+			predicate.GenerationChangedPredicate{},
+		)).
+		Watches(&corev1.Pod{}, check, builder.WithPredicates(predicate.NewPredicateFuncs(
+			func(obj client.Object) bool {
+				pod, ok := obj.(*corev1.Pod)
+				if !ok {
+					return false
+				}
+				_, ok = iamram.SidecarImage(pod)
+				return ok
+			}))).
+		Named("sidecarimage").
+		Complete(r)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+)
+
+var _ = Describe("SidecarImage Controller", func() {
+	Context("When pods run an outdated sidecar image", func() {
+		ctx := context.Background()
+		const (
+			namespace = "sidecars"
+			current   = "ghcr.io/dancavio/aws-iamra-manager/sidecar:1.1.0"
+			outdated  = "ghcr.io/dancavio/aws-iamra-manager/sidecar:1.0.0"
+		)
+		var (
+			store      *managerconfig.Store
+			reconciler *SidecarImageReconciler
+			config     *v1.IamRaManagerConfig
+		)
+
+		newDeployment := func(name string) *appsv1.Deployment {
+			labels := map[string]string{"app": name}
+			return &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+					},
+				},
+			}
+		}
+		newPod := func(deployment, name, image string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        deployment + "-5d8f7c-" + name,
+					Namespace:   namespace,
+					Labels:      map[string]string{"app": deployment, "pod-template-hash": "5d8f7c"},
+					Annotations: map[string]string{v1.RoleProfilePodAnnotationKey: deployment},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1", Kind: "ReplicaSet", Name: deployment + "-5d8f7c", UID: "rs-uid",
+						Controller: ptr.To(true),
+					}},
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "aws-iamra-manager", Image: image}},
+					Containers:     []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+		}
+		create := func(objs ...client.Object) {
+			for _, obj := range objs {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+				DeferCleanup(func() { Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed()) })
+			}
+		}
+		templateImage := func(name string) string {
+			var deployment appsv1.Deployment
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &deployment)).
+				To(Succeed())
+			return deployment.Spec.Template.Annotations[v1.SidecarImagePodAnnotationKey]
+		}
+
+		BeforeEach(func() {
+			defaults := managerconfig.Defaults()
+			defaults.Sidecar.Image = current
+			store = managerconfig.NewStore(defaults)
+			reconciler = &SidecarImageReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+				Name:     "default",
+				Store:    store,
+			}
+			config = &v1.IamRaManagerConfig{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			create(config)
+
+			create(newDeployment("api"), newDeployment("web"), newDeployment("worker"))
+			create(
+				newPod("api", "a", outdated), newPod("api", "b", outdated),
+				newPod("web", "a", outdated),
+				newPod("worker", "a", outdated), newPod("worker", "b", current),
+			)
+			create(&policyv1.PodDisruptionBudget{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+				Spec: policyv1.PodDisruptionBudgetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				},
+			})
+		})
+
+		It("should only report them by default", func() {
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(rolloutRequeueDelay))
+			Expect(templateImage("api")).To(BeEmpty())
+
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "default"}, config)).To(Succeed())
+			Expect(config.Status.Sidecars).To(Equal(&v1.SidecarImageStatus{
+				Image:        current,
+				UpdatedPods:  1,
+				OutdatedPods: 4,
+				Outdated: []v1.OutdatedSidecars{
+					{Namespace: namespace, Profile: "api", Pods: 2},
+					{Namespace: namespace, Profile: "web", Pods: 1},
+					{Namespace: namespace, Profile: "worker", Pods: 1},
+				},
+			}))
+			condition := meta.FindStatusCondition(config.Status.Conditions, v1.ManagerConfigConditionSidecarsUpToDate)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("Outdated"))
+		})
+
+		It("should restart workloads in waves that respect disruption budgets", func() {
+			store.SetResource(&v1.IamRaManagerConfigSpec{SidecarUpgrade: v1.SidecarUpgradeSpec{
+				Policy:          v1.SidecarUpgradeRollingRestart,
+				MaxPodsInFlight: 2,
+			}})
+
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templateImage("api")).To(Equal(current))
+			Expect(templateImage("web")).To(BeEmpty())
+			Expect(templateImage("worker")).To(BeEmpty())
+
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "default"}, config)).To(Succeed())
+			Expect(config.Status.Sidecars.RestartingWorkloads).To(Equal([]string{namespace + "/Deployment/api"}))
+			Expect(config.Status.Sidecars.BlockedWorkloads).To(Equal([]string{
+				namespace + "/Deployment/web: PodDisruptionBudget web allows no disruptions",
+			}))
+			condition := meta.FindStatusCondition(config.Status.Conditions, v1.ManagerConfigConditionSidecarsUpToDate)
+			Expect(condition.Reason).To(Equal("RolloutInProgress"))
+
+			By("waiting for the wave to finish before restarting the next workload")
+			_, err = reconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templateImage("worker")).To(BeEmpty())
+
+			By("restarting the next workload once the pods of the wave are replaced")
+			var pods corev1.PodList
+			Expect(k8sClient.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabels{"app": "api"})).
+				To(Succeed())
+			for i := range pods.Items {
+				Expect(k8sClient.Delete(ctx, &pods.Items[i])).To(Succeed())
+			}
+			_, err = reconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templateImage("worker")).To(Equal(current))
+		})
+	})
+})
@@ -0,0 +1,147 @@
+package ecr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	serviceName                 = "ecr"
+	getAuthorizationTokenTarget = "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"
+)
+
+// AuthorizationData is a registry login returned by GetAuthorizationToken.
+type AuthorizationData struct {
+	Username      string
+	Password      string
+	ProxyEndpoint string
+	ExpiresAt     time.Time
+}
+
+type getAuthorizationTokenOutput struct {
+	AuthorizationData []struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		ExpiresAt          float64 `json:"expiresAt"`
+		ProxyEndpoint      string  `json:"proxyEndpoint"`
+	} `json:"authorizationData"`
+}
+
+// Client calls the ECR GetAuthorizationToken API with temporary credentials.
+type Client struct {
+	Credentials rolesanywhere.CredentialsProvider
+	HTTPClient  *http.Client
+	// Endpoint overrides the regional endpoint.
+	Endpoint string
+}
+
+func endpointFor(region string) string {
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://api.ecr.%s.%s", region, domain)
+}
+
+// GetAuthorizationToken returns a login for the default registry of the
+// account the credentials belong to, in the given region.
+func (c *Client) GetAuthorizationToken(ctx context.Context, region string) (*AuthorizationData, error) {
+	if c.Credentials == nil {
+		return nil, errors.New("client has no credentials")
+	}
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := endpointFor(region)
+	if c.Endpoint != "" {
+		endpoint = c.Endpoint
+	}
+
+	payload := []byte("{}")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", getAuthorizationTokenTarget)
+	payloadHash := sha256.Sum256(payload)
+	if err := v4.NewSigner().SignHTTP(ctx, aws.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, req, hex.EncodeToString(payloadHash[:]), serviceName, region, time.Now()); err != nil {
+		return nil, fmt.Errorf("unable to sign request: %w", err)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetAuthorizationToken failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var output getAuthorizationTokenOutput
+	if err := json.Unmarshal(body, &output); err != nil {
+		return nil, fmt.Errorf("unable to parse GetAuthorizationToken response: %w", err)
+	}
+	if len(output.AuthorizationData) == 0 {
+		return nil, errors.New("GetAuthorizationToken returned no authorization data")
+	}
+	data := output.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization token: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, errors.New("invalid authorization token: expected user:password")
+	}
+	seconds, fraction := math.Modf(data.ExpiresAt)
+	return &AuthorizationData{
+		Username:      username,
+		Password:      password,
+		ProxyEndpoint: data.ProxyEndpoint,
+		ExpiresAt:     time.Unix(int64(seconds), int64(fraction*1e9)).UTC(),
+	}, nil
+}
+
+// DockerConfigJSON renders the login as the content of a
+// kubernetes.io/dockerconfigjson Secret.
+func (d *AuthorizationData) DockerConfigJSON() ([]byte, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(d.Username + ":" + d.Password))
+	return json.Marshal(map[string]any{
+		"auths": map[string]any{
+			strings.TrimPrefix(d.ProxyEndpoint, "https://"): map[string]string{
+				"username": d.Username,
+				"password": d.Password,
+				"auth":     auth,
+			},
+		},
+	})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ecr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type staticProvider struct {
+	creds rolesanywhere.Credentials
+}
+
+func (p staticProvider) Retrieve(context.Context) (*rolesanywhere.Credentials, error) {
+	return &p.creds, nil
+}
+
+// fakeECR stands in for the ECR API: it checks the request signature by
+// signing the request again with the expected credentials, and returns a login
+// for AWS:password.
+func fakeECR(creds rolesanywhere.Credentials, expiresAt time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		Expect(r.Header.Get("X-Amz-Target")).To(Equal(getAuthorizationTokenTarget))
+		Expect(r.Header.Get("X-Amz-Security-Token")).To(Equal(creds.SessionToken))
+		body, err := io.ReadAll(r.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		Expect(err).NotTo(HaveOccurred())
+		expected, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.Path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		expected.ContentLength = r.ContentLength
+		for _, name := range []string{"Content-Type", "X-Amz-Target"} {
+			expected.Header.Set(name, r.Header.Get(name))
+		}
+		payloadHash := sha256.Sum256(body)
+		Expect(v4.NewSigner().SignHTTP(context.Background(), aws.Credentials{
+			AccessKeyID:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			SessionToken:    creds.SessionToken,
+		}, expected, hex.EncodeToString(payloadHash[:]), "ecr", "us-west-2", signedAt)).To(Succeed())
+		if r.Header.Get("Authorization") != expected.Header.Get("Authorization") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"authorizationData": []map[string]any{{
+				"authorizationToken": base64.StdEncoding.EncodeToString([]byte("AWS:password")),
+				"expiresAt":          float64(expiresAt.Unix()),
+				"proxyEndpoint":      "https://123456789012.dkr.ecr.us-west-2.amazonaws.com",
+			}},
+		})
+	}))
+}
+
+var _ = Describe("GetAuthorizationToken", func() {
+	creds := rolesanywhere.Credentials{AccessKeyID: "AKIA", SecretAccessKey: "secret", SessionToken: "token"}
+
+	It("Should return the registry login", func() {
+		expiresAt := time.Now().Add(12 * time.Hour).Truncate(time.Second).UTC()
+		server := fakeECR(creds, expiresAt)
+		defer server.Close()
+
+		client := &Client{Credentials: staticProvider{creds}, Endpoint: server.URL}
+		login, err := client.GetAuthorizationToken(context.Background(), "us-west-2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(login.Username).To(Equal("AWS"))
+		Expect(login.Password).To(Equal("password"))
+		Expect(login.ExpiresAt).To(Equal(expiresAt))
+
+		config, err := login.DockerConfigJSON()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(MatchJSON(`{"auths":{"123456789012.dkr.ecr.us-west-2.amazonaws.com":{` +
+			`"username":"AWS","password":"password","auth":"QVdTOnBhc3N3b3Jk"}}}`))
+	})
+
+	It("Should fail when the request is signed with other credentials", func() {
+		server := fakeECR(creds, time.Now().Add(time.Hour))
+		defer server.Close()
+
+		other := creds
+		other.SecretAccessKey = "other"
+		client := &Client{Credentials: staticProvider{other}, Endpoint: server.URL}
+		_, err := client.GetAuthorizationToken(context.Background(), "us-west-2")
+		Expect(err).To(MatchError(ContainSubstring("status 403")))
+	})
+})
@@ -0,0 +1,178 @@
+package iamram
+
+import (
+	"dancav.io/aws-iamra-manager/api/v1"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Activation is where a profile stands in its activation window at some
+// time.
+type Activation struct {
+	// Active is whether the profile is within its activation window.
+	Active bool
+	// Next is when Active changes next, or the zero time if it never does.
+	Next time.Time
+}
+
+// Until returns when an active profile becomes inactive, or the zero time if
+// it is inactive or stays active.
+func (a Activation) Until() time.Time {
+	if !a.Active {
+		return time.Time{}
+	}
+	return a.Next
+}
+
+var weekdays = map[v1.Weekday]time.Weekday{
+	"Sunday":    time.Sunday,
+	"Monday":    time.Monday,
+	"Tuesday":   time.Tuesday,
+	"Wednesday": time.Wednesday,
+	"Thursday":  time.Thursday,
+	"Friday":    time.Friday,
+	"Saturday":  time.Saturday,
+}
+
+// schedule is a parsed v1.ProfileSchedule.
+type schedule struct {
+	// days the window opens on, nil for every day.
+	days       map[time.Weekday]bool
+	start, end time.Duration
+	location   *time.Location
+}
+
+// ValidateSchedule checks that the days, times and time zone of the schedule
+// can be parsed.
+func ValidateSchedule(s v1.ProfileSchedule) error {
+	_, err := parseSchedule(s)
+	return err
+}
+
+func parseSchedule(s v1.ProfileSchedule) (schedule, error) {
+	parsed := schedule{location: time.UTC}
+	if s.TimeZone != "" {
+		location, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return parsed, fmt.Errorf("unknown time zone %q", s.TimeZone)
+		}
+		parsed.location = location
+	}
+	for _, day := range s.Days {
+		weekday, ok := weekdays[day]
+		if !ok {
+			return parsed, fmt.Errorf("unknown day %q", day)
+		}
+		if parsed.days == nil {
+			parsed.days = map[time.Weekday]bool{}
+		}
+		parsed.days[weekday] = true
+	}
+	for _, t := range []struct {
+		value string
+		into  *time.Duration
+	}{{s.Start, &parsed.start}, {s.End, &parsed.end}} {
+		clock, err := time.Parse("15:04", t.value)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid time of day %q, expected HH:MM", t.value)
+		}
+		*t.into = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+	return parsed, nil
+}
+
+// window returns the window opening on the given day, if the schedule has one.
+func (s schedule) window(year int, month time.Month, day int) (time.Time, time.Time, bool) {
+	// Times of day are set in the time zone, so that they stay put across
+	// daylight saving time changes.
+	at := func(day int, clock time.Duration) time.Time {
+		return time.Date(year, month, day, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, s.location)
+	}
+	start := at(day, s.start)
+	if s.days != nil && !s.days[start.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	end := at(day, s.end)
+	if s.end <= s.start {
+		end = at(day+1, s.end)
+	}
+	return start, end, true
+}
+
+func (s schedule) activeAt(t time.Time) bool {
+	local := t.In(s.location)
+	// Only windows opening on the day or the day before can span t.
+	for _, offset := range []int{-1, 0} {
+		start, end, ok := s.window(local.Year(), local.Month(), local.Day()+offset)
+		if ok && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// bounds returns when the windows opening in the week following from open
+// and close.
+func (s schedule) bounds(from time.Time) []time.Time {
+	local := from.In(s.location)
+	var bounds []time.Time
+	for offset := -1; offset <= 8; offset++ {
+		if start, end, ok := s.window(local.Year(), local.Month(), local.Day()+offset); ok {
+			bounds = append(bounds, start, end)
+		}
+	}
+	return bounds
+}
+
+// ProfileActivation returns where the profile stands in its activation window
+// at now: within activeFrom and activeUntil, and within a window of one of
+// its schedules if it has any.
+func ProfileActivation(spec *v1.AwsIamRaRoleProfileSpec, now time.Time) (Activation, error) {
+	var schedules []schedule
+	for i, s := range spec.Schedules {
+		parsed, err := parseSchedule(s)
+		if err != nil {
+			return Activation{}, fmt.Errorf("invalid schedule %d: %w", i, err)
+		}
+		schedules = append(schedules, parsed)
+	}
+	activeAt := func(t time.Time) bool {
+		if spec.ActiveFrom != nil && t.Before(spec.ActiveFrom.Time) {
+			return false
+		}
+		if spec.ActiveUntil != nil && !t.Before(spec.ActiveUntil.Time) {
+			return false
+		}
+		return len(schedules) == 0 || slices.ContainsFunc(schedules, func(s schedule) bool { return s.activeAt(t) })
+	}
+	activation := Activation{Active: activeAt(now)}
+
+	// The profile only changes state at the bounds of its windows. Schedules
+	// repeat every week, so their next change, if any, is within the week
+	// following now or activeFrom.
+	var bounds []time.Time
+	origins := []time.Time{now}
+	if spec.ActiveFrom != nil {
+		bounds = append(bounds, spec.ActiveFrom.Time)
+		if spec.ActiveFrom.After(now) {
+			origins = append(origins, spec.ActiveFrom.Time)
+		}
+	}
+	if spec.ActiveUntil != nil {
+		bounds = append(bounds, spec.ActiveUntil.Time)
+	}
+	for _, s := range schedules {
+		for _, origin := range origins {
+			bounds = append(bounds, s.bounds(origin)...)
+		}
+	}
+	slices.SortFunc(bounds, func(a, b time.Time) int { return a.Compare(b) })
+	for _, t := range bounds {
+		if t.After(now) && activeAt(t) != activation.Active {
+			activation.Next = t
+			break
+		}
+	}
+	return activation, nil
+}
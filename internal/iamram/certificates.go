@@ -0,0 +1,195 @@
+package iamram
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+	"text/template"
+)
+
+// podTemplateHashLabel is set by the Deployment controller on the pods of its
+// ReplicaSets, whose names end with the hash.
+const podTemplateHashLabel = "pod-template-hash"
+
+// CertificateSubjectData is what the subject templates of a profile's
+// cert-manager Certificates are executed with.
+type CertificateSubjectData struct {
+	Namespace      string
+	ServiceAccount string
+	Workload       string
+	WorkloadKind   string
+	Profile        string
+	TrustDomain    string
+}
+
+// CertificateSubject is the rendered subject and SANs of a certificate.
+type CertificateSubject struct {
+	CommonName          string
+	Organizations       []string
+	OrganizationalUnits []string
+	URIs                []string
+	DNSNames            []string
+}
+
+// defaultSubjectTemplate matches the subject of the certificates issued by the
+// controller's CA.
+var defaultSubjectTemplate = v1.CertificateSubjectTemplate{
+	CommonName:          "{{if .Workload}}{{.Workload}}{{else}}{{.ServiceAccount}}{{end}}",
+	Organizations:       []string{"{{.Namespace}}"},
+	OrganizationalUnits: []string{"{{.ServiceAccount}}"},
+	URIs:                []string{"spiffe://{{.TrustDomain}}/ns/{{.Namespace}}/sa/{{.ServiceAccount}}"},
+}
+
+// Workload returns the kind and name of the workload a pod belongs to: the
+// Deployment of its ReplicaSet, its other controller, or the pod itself.
+func Workload(pod *corev1.Pod) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		if pod.Name == "" {
+			return "", "", errors.New("pods without a controller need a name to be a workload")
+		}
+		return "Pod", pod.Name, nil
+	}
+	if hash := pod.Labels[podTemplateHashLabel]; owner.Kind == "ReplicaSet" && hash != "" &&
+		strings.HasSuffix(owner.Name, "-"+hash) {
+		return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash), nil
+	}
+	return owner.Kind, owner.Name, nil
+}
+
+// CertSecretName returns the kubernetes.io/tls Secret the pod mounts its
+// certificate from, or an empty string if the sidecar requests it.
+func CertSecretName(pod metav1.Object) string {
+	annotations := pod.GetAnnotations()
+	for _, key := range []string{
+		v1.CertSecretPodAnnotationKey,
+		v1.IssuedCertSecretPodAnnotationKey,
+		v1.CertManagerCertificatePodAnnotationKey,
+	} {
+		if name := annotations[key]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ServiceAccountName returns the name of the pod's service account.
+func ServiceAccountName(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return pod.Spec.ServiceAccountName
+}
+
+// CertificateSubjectDataFor returns the data the subject templates of the
+// profile are executed with for the pod.
+func CertificateSubjectDataFor(
+	pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile, trustDomain string,
+) (CertificateSubjectData, error) {
+	data := CertificateSubjectData{
+		Namespace:      pod.Namespace,
+		ServiceAccount: ServiceAccountName(pod),
+		Profile:        profile.Name,
+		TrustDomain:    trustDomain,
+	}
+	if certManagerScope(profile) == v1.CertificateScopeWorkload {
+		var err error
+		if data.WorkloadKind, data.Workload, err = Workload(pod); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// CertManagerCertificateName returns the name of the cert-manager Certificate,
+// and of its Secret, shared by the pods with the same subject data.
+func CertManagerCertificateName(data CertificateSubjectData) string {
+	name := fmt.Sprintf("aws-iamra-%s-sa-%s", data.Profile, data.ServiceAccount)
+	if data.Workload != "" {
+		name = fmt.Sprintf("aws-iamra-%s-%s-%s", data.Profile, strings.ToLower(data.WorkloadKind), data.Workload)
+	}
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		sum := sha256.Sum256([]byte(name))
+		name = name[:validation.DNS1123SubdomainMaxLength-17] + "-" + hex.EncodeToString(sum[:8])
+	}
+	return name
+}
+
+// RenderCertificateSubject executes the subject templates of the profile,
+// falling back to the default subject for fields it doesn't set.
+func RenderCertificateSubject(
+	profile *v1.AwsIamRaRoleProfile, data CertificateSubjectData,
+) (*CertificateSubject, error) {
+	tmpl := defaultSubjectTemplate
+	if spec := profile.Spec.Certificate; spec != nil && spec.CertManager != nil && spec.CertManager.Subject != nil {
+		custom := spec.CertManager.Subject
+		if custom.CommonName != "" {
+			tmpl.CommonName = custom.CommonName
+		}
+		if custom.Organizations != nil {
+			tmpl.Organizations = custom.Organizations
+		}
+		if custom.OrganizationalUnits != nil {
+			tmpl.OrganizationalUnits = custom.OrganizationalUnits
+		}
+		if custom.URIs != nil {
+			tmpl.URIs = custom.URIs
+		}
+		if custom.DNSNames != nil {
+			tmpl.DNSNames = custom.DNSNames
+		}
+	}
+
+	var subject CertificateSubject
+	var err error
+	if subject.CommonName, err = renderTemplate("commonName", tmpl.CommonName, data); err != nil {
+		return nil, err
+	}
+	for _, field := range []struct {
+		name      string
+		templates []string
+		values    *[]string
+	}{
+		{"organizations", tmpl.Organizations, &subject.Organizations},
+		{"organizationalUnits", tmpl.OrganizationalUnits, &subject.OrganizationalUnits},
+		{"uris", tmpl.URIs, &subject.URIs},
+		{"dnsNames", tmpl.DNSNames, &subject.DNSNames},
+	} {
+		for _, text := range field.templates {
+			value, err := renderTemplate(field.name, text, data)
+			if err != nil {
+				return nil, err
+			}
+			if value != "" {
+				*field.values = append(*field.values, value)
+			}
+		}
+	}
+	return &subject, nil
+}
+
+func renderTemplate(name, text string, data CertificateSubjectData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("unable to render %s template: %w", name, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func certManagerScope(profile *v1.AwsIamRaRoleProfile) v1.CertificateScope {
+	if spec := profile.Spec.Certificate; spec != nil && spec.CertManager != nil && spec.CertManager.Scope != "" {
+		return spec.CertManager.Scope
+	}
+	return v1.CertificateScopeServiceAccount
+}
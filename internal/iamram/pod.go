@@ -13,14 +13,38 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// SidecarContainerName is the name of the init container the webhook injects.
+const SidecarContainerName = "aws-iamra-manager"
+
+// SidecarImage returns the image of the pod's sidecar, and false if the pod
+// has none.
+func SidecarImage(pod *corev1.Pod) (string, bool) {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == SidecarContainerName {
+			return container.Image, true
+		}
+	}
+	return "", false
+}
+
+// MissedInjection reports whether the pod uses a profile but was admitted
+// without the pod webhook injecting it: it has neither a sidecar nor the
+// node agent delivery the webhook records. Such pods have no credentials.
+func MissedInjection(pod *corev1.Pod) bool {
+	if len(ProfileNames(pod)) == 0 || UsesNodeAgent(pod) {
+		return false
+	}
+	_, ok := SidecarImage(pod)
+	return !ok
+}
+
 func ReconcilePod(
 	ctx context.Context, k *kubernetes.Clientset, kcfg *rest.Config,
 	profile *v1.AwsIamRaRoleProfile, pod corev1.Pod,
 ) error {
-	logger := log.FromContext(ctx)
-
 	command := []string{
 		"update-config",
 		"-t", string(profile.Spec.TrustAnchorArn),
@@ -33,6 +57,46 @@ func ReconcilePod(
 		roleSessionName = profile.Spec.RoleSessionName
 	}
 	command = append(command, "-n", roleSessionName)
+	if profile.Spec.ImdsV2Only {
+		command = append(command, "-v")
+	}
+	// Only additional profiles are named, so that pods with a single profile
+	// keep working with sidecars that predate multiple profiles.
+	if ProfileIndex(&pod, profile.Name) > 0 {
+		command = append(command, "-P", profile.Name)
+	}
+
+	return ExecSidecar(ctx, k, kcfg, pod, command)
+}
+
+// RevokeCertificates tells the sidecar of pod to stop serving credentials
+// for certificates with the given serial numbers. The list replaces the one
+// sent before.
+func RevokeCertificates(
+	ctx context.Context, k *kubernetes.Clientset, kcfg *rest.Config, pod corev1.Pod, serials []string,
+) error {
+	return ExecSidecar(ctx, k, kcfg, pod, append([]string{"revoke-certificates"}, serials...))
+}
+
+// SuspendProfile tells the sidecar of pod whether to stop serving the
+// credentials of the profile, dropping those it cached, and, unless until is
+// zero, to stop serving them at until.
+func SuspendProfile(
+	ctx context.Context, k *kubernetes.Clientset, kcfg *rest.Config, pod corev1.Pod, profileName string,
+	suspended bool, until time.Time,
+) error {
+	command := []string{"suspend-profile", profileName, strconv.FormatBool(suspended)}
+	if !until.IsZero() {
+		command = append(command, until.UTC().Format(time.RFC3339))
+	}
+	return ExecSidecar(ctx, k, kcfg, pod, command)
+}
+
+// ExecSidecar runs command in the sidecar container of pod.
+func ExecSidecar(
+	ctx context.Context, k *kubernetes.Clientset, kcfg *rest.Config, pod corev1.Pod, command []string,
+) error {
+	logger := log.FromContext(ctx)
 
 	logger.Info("Executing remote command", "command", command)
 	execReq := k.CoreV1().RESTClient().
@@ -42,7 +106,7 @@ func ReconcilePod(
 		Namespace(pod.Namespace).
 		SubResource("exec").
 		VersionedParams(&corev1.PodExecOptions{
-			Container: "aws-iamra-manager",
+			Container: SidecarContainerName,
 			Command:   command,
 			Stdout:    true,
 			Stderr:    true,
@@ -0,0 +1,127 @@
+package iamram
+
+import (
+	"bytes"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// RoleSessionNameData is what the roleSessionNames templates of an
+// IamRaPolicy are executed with.
+type RoleSessionNameData struct {
+	Namespace string
+	Profile   string
+}
+
+// PolicySelectsNamespace reports whether the policy applies to the profiles
+// of the namespace with the given labels.
+func PolicySelectsNamespace(policy *v1.IamRaPolicy, namespaceLabels map[string]string) (bool, error) {
+	if policy.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector of IamRaPolicy %s: %w", policy.Name, err)
+	}
+	return selector.Matches(labels.Set(namespaceLabels)), nil
+}
+
+// MatchesPattern reports whether value matches pattern, in which * stands for
+// any sequence of characters.
+func MatchesPattern(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(value)
+}
+
+// PolicyViolations lists what of the profile the policy doesn't allow. The
+// errors name the policy, so that users know which one to look up.
+func PolicyViolations(policy *v1.IamRaPolicy, profile *v1.AwsIamRaRoleProfile) []*field.Error {
+	spec := &policy.Spec
+	path := field.NewPath("spec")
+	var allErrs []*field.Error
+	forbid := func(path *field.Path, value any, allowed string) {
+		allErrs = append(allErrs, field.Invalid(path, value,
+			fmt.Sprintf("IamRaPolicy %s only allows %s", policy.Name, allowed)))
+	}
+
+	arns := []struct {
+		name    string
+		arn     v1.ARN
+		allowed []v1.ARNPattern
+	}{
+		{"trustAnchorArn", profile.Spec.TrustAnchorArn, spec.AllowedTrustAnchors},
+		{"profileArn", profile.Spec.ProfileArn, spec.AllowedProfiles},
+		{"roleArn", profile.Spec.RoleArn, spec.AllowedRoles},
+	}
+	for _, arn := range arns {
+		if len(arn.allowed) > 0 && !slices.ContainsFunc(arn.allowed, func(pattern v1.ARNPattern) bool {
+			return MatchesPattern(string(pattern), string(arn.arn))
+		}) {
+			forbid(path.Child(arn.name), arn.arn, joinPatterns(arn.allowed))
+		}
+		if len(spec.AllowedAccounts) == 0 {
+			continue
+		}
+		// Unparsable ARNs are reported by the profile's own validation.
+		if parsed, err := arn.arn.Parse(); err == nil && !slices.Contains(spec.AllowedAccounts, parsed.AccountID) {
+			forbid(path.Child(arn.name), arn.arn, "the accounts "+strings.Join(spec.AllowedAccounts, ", "))
+		}
+	}
+
+	if spec.MaxDurationSeconds > 0 && profile.Spec.DurationSeconds > spec.MaxDurationSeconds {
+		forbid(path.Child("durationSeconds"), profile.Spec.DurationSeconds,
+			fmt.Sprintf("up to %d seconds", spec.MaxDurationSeconds))
+	}
+
+	if len(spec.RoleSessionNames) > 0 {
+		data := RoleSessionNameData{Namespace: profile.Namespace, Profile: profile.Name}
+		var patterns []string
+		matched := false
+		for _, text := range spec.RoleSessionNames {
+			pattern, err := renderRoleSessionName(text, data)
+			if err != nil {
+				allErrs = append(allErrs, field.InternalError(path.Child("roleSessionName"),
+					fmt.Errorf("IamRaPolicy %s: %w", policy.Name, err)))
+				return allErrs
+			}
+			patterns = append(patterns, pattern)
+			matched = matched || (profile.Spec.RoleSessionName != "" &&
+				MatchesPattern(pattern, profile.Spec.RoleSessionName))
+		}
+		if !matched {
+			forbid(path.Child("roleSessionName"), profile.Spec.RoleSessionName,
+				"session names matching "+strings.Join(patterns, ", "))
+		}
+	}
+	return allErrs
+}
+
+func renderRoleSessionName(text string, data RoleSessionNameData) (string, error) {
+	tmpl, err := template.New("roleSessionNames").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid roleSessionNames template: %w", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("unable to render roleSessionNames template: %w", err)
+	}
+	return out.String(), nil
+}
+
+func joinPatterns(patterns []v1.ARNPattern) string {
+	var out []string
+	for _, pattern := range patterns {
+		out = append(out, string(pattern))
+	}
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,268 @@
+package iamram
+
+import (
+	"context"
+	"crypto/sha256"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContainerProfile assigns a profile to a single container of a pod.
+type ContainerProfile struct {
+	Container string
+	Profile   string
+}
+
+// RoleProfileNames returns the profiles listed in the pod's role profile
+// annotation, in order and without duplicates. The first one is the pod's
+// default profile.
+func RoleProfileNames(pod metav1.Object) []string {
+	return splitList(pod.GetAnnotations()[v1.RoleProfilePodAnnotationKey])
+}
+
+// ContainerProfiles parses the pod's container profiles annotation, e.g.
+// "app=writer,fluentbit=logs-reader". Malformed entries are skipped and
+// reported in the returned error.
+func ContainerProfiles(pod metav1.Object) ([]ContainerProfile, error) {
+	var mappings []ContainerProfile
+	var invalid []string
+	seen := map[string]bool{}
+	for _, entry := range splitList(pod.GetAnnotations()[v1.ContainerProfilesPodAnnotationKey]) {
+		container, profile, ok := strings.Cut(entry, "=")
+		container, profile = strings.TrimSpace(container), strings.TrimSpace(profile)
+		if !ok || container == "" || profile == "" || seen[container] {
+			invalid = append(invalid, entry)
+			continue
+		}
+		seen[container] = true
+		mappings = append(mappings, ContainerProfile{Container: container, Profile: profile})
+	}
+	if len(invalid) > 0 {
+		return mappings, fmt.Errorf("annotation %s has invalid or duplicate entries %v, expected <container>=<profile>",
+			v1.ContainerProfilesPodAnnotationKey, invalid)
+	}
+	return mappings, nil
+}
+
+// ExcludedContainers returns the containers that opted out of AWS credentials.
+func ExcludedContainers(pod metav1.Object) map[string]bool {
+	excluded := map[string]bool{}
+	for _, name := range splitList(pod.GetAnnotations()[v1.ExcludedContainersPodAnnotationKey]) {
+		excluded[name] = true
+	}
+	return excluded
+}
+
+// ProfileNames returns every profile the pod's sidecar serves: the role
+// profiles first, followed by profiles that are only assigned to individual
+// containers. A profile's position in this list determines its port.
+func ProfileNames(pod metav1.Object) []string {
+	names := RoleProfileNames(pod)
+	mappings, _ := ContainerProfiles(pod)
+	for _, mapping := range mappings {
+		if !contains(names, mapping.Profile) {
+			names = append(names, mapping.Profile)
+		}
+	}
+	return names
+}
+
+// SelectsPod reports whether the profile selects the pod by its podSelector
+// and serviceAccountNames. Pods that name profiles of their own or opt out of
+// injection aren't selected.
+func SelectsPod(profile *v1.AwsIamRaRoleProfile, pod *corev1.Pod) bool {
+	if !profile.Spec.SelectsPods() || profile.Namespace != pod.Namespace || len(ProfileNames(pod)) > 0 ||
+		pod.Annotations[v1.InjectPodAnnotationKey] == "false" {
+		return false
+	}
+	if names := profile.Spec.ServiceAccountNames; len(names) > 0 && !slices.Contains(names, ServiceAccountName(pod)) {
+		return false
+	}
+	if profile.Spec.PodSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(profile.Spec.PodSelector)
+	return err == nil && selector.Matches(labels.Set(pod.Labels))
+}
+
+// ServiceAccountAllowed reports whether the profile allows the pod's service
+// account. The service account is only fetched if the profile selects
+// allowed ones by their labels.
+func ServiceAccountAllowed(
+	ctx context.Context, c client.Reader, profile *v1.AwsIamRaRoleProfile, pod *corev1.Pod,
+) (bool, error) {
+	allowed := profile.Spec.AllowedServiceAccounts
+	name := ServiceAccountName(pod)
+	if allowed == nil || allowed.Selector == nil || slices.Contains(allowed.Names, name) {
+		return allowed.Allows(name, nil), nil
+	}
+	var serviceAccount corev1.ServiceAccount
+	if err := c.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: name}, &serviceAccount); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to fetch service account %s: %w", name, err)
+	}
+	return allowed.Allows(name, serviceAccount.Labels), nil
+}
+
+// ProfileIndex returns the position of the named profile in ProfileNames, or
+// -1 if the pod doesn't use it.
+func ProfileIndex(pod metav1.Object, profileName string) int {
+	for i, name := range ProfileNames(pod) {
+		if name == profileName {
+			return i
+		}
+	}
+	return -1
+}
+
+// UsesNodeAgent reports whether the pod gets its credentials from the node
+// agent, in which case it has no sidecar.
+func UsesNodeAgent(pod metav1.Object) bool {
+	return v1.CredentialDelivery(pod.GetAnnotations()[v1.DeliveryPodAnnotationKey]) == v1.CredentialDeliveryNodeAgent
+}
+
+// ProfileHash identifies the settings pods using the profile are created
+// with. The update strategy isn't one of them, nor are suspension and the
+// activation window, which are pushed to pods whatever the strategy.
+func ProfileHash(profile *v1.AwsIamRaRoleProfile) string {
+	spec := profile.Spec.DeepCopy()
+	spec.UpdateStrategy = ""
+	spec.Suspended = false
+	spec.ActiveFrom = nil
+	spec.ActiveUntil = nil
+	spec.Schedules = nil
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:5])
+}
+
+// ProfileHashes parses the profile hashes annotation of a pod or pod
+// template, mapping profile names to the hash of their settings.
+func ProfileHashes(annotations map[string]string) map[string]string {
+	hashes := map[string]string{}
+	for _, entry := range splitList(annotations[v1.ProfileHashesPodAnnotationKey]) {
+		if name, hash, ok := strings.Cut(entry, "="); ok {
+			hashes[name] = hash
+		}
+	}
+	return hashes
+}
+
+// SetProfileHash records the hash of a profile's settings in the profile
+// hashes annotation, keeping those of other profiles.
+func SetProfileHash(annotations map[string]string, profileName, hash string) {
+	hashes := ProfileHashes(annotations)
+	hashes[profileName] = hash
+	entries := make([]string, 0, len(hashes))
+	for name, hash := range hashes {
+		entries = append(entries, name+"="+hash)
+	}
+	sort.Strings(entries)
+	annotations[v1.ProfileHashesPodAnnotationKey] = strings.Join(entries, ",")
+}
+
+// ProfileSuspended reports whether the sidecar of the pod with the annotations
+// was told to stop serving the profile's credentials.
+func ProfileSuspended(annotations map[string]string, profileName string) bool {
+	return contains(splitList(annotations[v1.SuspendedProfilesPodAnnotationKey]), profileName)
+}
+
+// SetProfileSuspended records in the suspended profiles annotation whether
+// the sidecar serves the profile's credentials.
+func SetProfileSuspended(annotations map[string]string, profileName string, suspended bool) {
+	var names []string
+	for _, name := range splitList(annotations[v1.SuspendedProfilesPodAnnotationKey]) {
+		if name != profileName {
+			names = append(names, name)
+		}
+	}
+	if suspended {
+		names = append(names, profileName)
+	}
+	if len(names) == 0 {
+		delete(annotations, v1.SuspendedProfilesPodAnnotationKey)
+		return
+	}
+	sort.Strings(names)
+	annotations[v1.SuspendedProfilesPodAnnotationKey] = strings.Join(names, ",")
+}
+
+// ProfileActiveUntil returns when the sidecar of the pod with the annotations
+// was told to stop serving the profile's credentials, or the zero time if it
+// wasn't.
+func ProfileActiveUntil(annotations map[string]string, profileName string) time.Time {
+	for _, entry := range splitList(annotations[v1.ActiveUntilPodAnnotationKey]) {
+		if name, value, ok := strings.Cut(entry, "="); ok && name == profileName {
+			until, _ := time.Parse(time.RFC3339, value)
+			return until
+		}
+	}
+	return time.Time{}
+}
+
+// SetProfileActiveUntil records in the active until annotation when the
+// sidecar stops serving the profile's credentials. The zero time removes the
+// profile from it.
+func SetProfileActiveUntil(annotations map[string]string, profileName string, until time.Time) {
+	var entries []string
+	for _, entry := range splitList(annotations[v1.ActiveUntilPodAnnotationKey]) {
+		if name, _, _ := strings.Cut(entry, "="); name != profileName {
+			entries = append(entries, entry)
+		}
+	}
+	if !until.IsZero() {
+		entries = append(entries, profileName+"="+until.UTC().Format(time.RFC3339))
+	}
+	if len(entries) == 0 {
+		delete(annotations, v1.ActiveUntilPodAnnotationKey)
+		return
+	}
+	sort.Strings(entries)
+	annotations[v1.ActiveUntilPodAnnotationKey] = strings.Join(entries, ",")
+}
+
+// ProfilePort returns the port the sidecar serves the profile at index i on,
+// given the port of the first one.
+func ProfilePort(basePort, i int) int {
+	return basePort + i
+}
+
+// ImdsEndpoint returns the metadata service endpoint of the profile at index
+// i, given the port of the first one.
+func ImdsEndpoint(basePort, i int) string {
+	return fmt.Sprintf("http://127.0.0.1:%d/", ProfilePort(basePort, i))
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, existing := range items {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package iamram
+
+import (
+	"context"
+	"crypto/sha256"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Requester returns the user recorded in the requester annotation of obj, or
+// nil if there is none.
+func Requester(obj metav1.Object) (*authenticationv1.UserInfo, error) {
+	value, ok := obj.GetAnnotations()[v1.RequesterAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var user authenticationv1.UserInfo
+	if err := json.Unmarshal([]byte(value), &user); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %w", v1.RequesterAnnotationKey, err)
+	}
+	return &user, nil
+}
+
+// SetRequester records the user in the requester annotation of obj.
+func SetRequester(obj metav1.Object, user authenticationv1.UserInfo) error {
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[v1.RequesterAnnotationKey] = string(encoded)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// PodTemplateHash identifies a pod template, so that the requester recorded
+// for one doesn't pass for the requester of another.
+func PodTemplateHash(template *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetWorkloadRequester records the user as the requester of a workload with
+// the pod template.
+func SetWorkloadRequester(obj metav1.Object, template *corev1.PodTemplateSpec, user authenticationv1.UserInfo) error {
+	if err := SetRequester(obj, user); err != nil {
+		return err
+	}
+	obj.GetAnnotations()[v1.RequesterTemplateAnnotationKey] = PodTemplateHash(template)
+	return nil
+}
+
+// UsePermissionRequired reports whether the profile may only be used by those
+// who may "use" it, because it says so or requireAll does.
+func UsePermissionRequired(profile *v1.AwsIamRaRoleProfile, requireAll bool) bool {
+	return requireAll || profile.Spec.RequireUsePermission
+}
+
+// MayUseProfile asks the API server whether the user may use the profile.
+func MayUseProfile(
+	ctx context.Context, c client.Writer, user authenticationv1.UserInfo, profile *v1.AwsIamRaRoleProfile,
+) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: profile.Namespace,
+				Verb:      v1.ProfileUseVerb,
+				Group:     v1.GroupVersion.Group,
+				Resource:  "awsiamraroleprofiles",
+				Name:      profile.Name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("unable to check whether %s may use profile %s: %w", user.Username, profile.Name, err)
+	}
+	return review.Status.Allowed, nil
+}
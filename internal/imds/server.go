@@ -0,0 +1,244 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/go-logr/logr"
+)
+
+const (
+	tokenPath          = "/latest/api/token"
+	credentialsPath    = "/latest/meta-data/iam/security-credentials/"
+	regionPath         = "/latest/meta-data/placement/region"
+	instanceIDPath     = "/latest/meta-data/instance-id"
+	identityDocPath    = "/latest/dynamic/instance-identity/document"
+	tokenHeader        = "X-aws-ec2-metadata-token"
+	tokenTTLHeader     = "X-aws-ec2-metadata-token-ttl-seconds"
+	maxTokenTTLSeconds = 21600
+)
+
+// Options describes the instance the server pretends to be.
+type Options struct {
+	RoleName   string
+	Region     string
+	AccountID  string
+	InstanceID string
+	PrivateIP  string
+	// TokensRequired rejects IMDSv1 requests that don't carry a session token.
+	TokensRequired bool
+}
+
+// Server is an http.Handler that emulates the subset of the EC2 instance
+// metadata service used by the AWS SDKs.
+type Server struct {
+	logger  logr.Logger
+	started time.Time
+
+	mu       sync.Mutex
+	provider rolesanywhere.CredentialsProvider
+	opts     Options
+	tokens   map[string]time.Time
+}
+
+var _ http.Handler = &Server{}
+
+// NewServer returns an IMDS emulator serving credentials from provider.
+func NewServer(provider rolesanywhere.CredentialsProvider, opts Options, logger logr.Logger) *Server {
+	return &Server{
+		provider: provider,
+		opts:     opts,
+		logger:   logger,
+		started:  time.Now().UTC(),
+		tokens:   map[string]time.Time{},
+	}
+}
+
+// Reconfigure swaps the credentials provider and instance options, keeping
+// the session tokens that were already handed out.
+func (s *Server) Reconfigure(provider rolesanywhere.CredentialsProvider, opts Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.provider = provider
+	s.opts = opts
+}
+
+func (s *Server) current() (rolesanywhere.CredentialsProvider, Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.provider, s.opts
+}
+
+// OptionsFromRoleArn fills in the role name and account ID from a role ARN.
+func OptionsFromRoleArn(roleArn string, opts Options) Options {
+	parsed, err := arn.Parse(roleArn)
+	if err != nil {
+		return opts
+	}
+	opts.AccountID = parsed.AccountID
+	opts.RoleName = parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+	return opts
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == tokenPath {
+		s.serveToken(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	provider, opts := s.current()
+	if !s.authorized(r, opts) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == credentialsPath:
+		writeText(w, opts.RoleName)
+	case strings.HasPrefix(r.URL.Path, credentialsPath):
+		if strings.TrimPrefix(r.URL.Path, credentialsPath) != opts.RoleName {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveCredentials(w, r, provider)
+	case r.URL.Path == regionPath:
+		writeText(w, opts.Region)
+	case r.URL.Path == instanceIDPath:
+		writeText(w, opts.InstanceID)
+	case r.URL.Path == identityDocPath:
+		s.serveIdentityDocument(w, opts)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Like the real IMDS, refuse tokens to requests that went through a proxy.
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get(tokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > maxTokenTTLSeconds {
+		http.Error(w, "invalid token TTL", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "unable to generate token", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	s.mu.Lock()
+	for t, expiry := range s.tokens {
+		if now.After(expiry) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	s.mu.Unlock()
+
+	w.Header().Set(tokenTTLHeader, strconv.Itoa(ttl))
+	writeText(w, token)
+}
+
+func (s *Server) authorized(r *http.Request, opts Options) bool {
+	token := r.Header.Get(tokenHeader)
+	if token == "" {
+		return !opts.TokensRequired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+func (s *Server) serveCredentials(w http.ResponseWriter, r *http.Request, provider rolesanywhere.CredentialsProvider) {
+	creds, err := provider.Retrieve(r.Context())
+	if err != nil {
+		s.logger.Error(err, "unable to retrieve credentials")
+		http.Error(w, "unable to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{
+		"Code":            "Success",
+		"LastUpdated":     time.Now().UTC().Format(time.RFC3339),
+		"Type":            "AWS-HMAC",
+		"AccessKeyId":     creds.AccessKeyID,
+		"SecretAccessKey": creds.SecretAccessKey,
+		"Token":           creds.SessionToken,
+		"Expiration":      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *Server) serveIdentityDocument(w http.ResponseWriter, opts Options) {
+	writeJSON(w, map[string]any{
+		"accountId":               opts.AccountID,
+		"architecture":            architecture(),
+		"availabilityZone":        "",
+		"billingProducts":         nil,
+		"devpayProductCodes":      nil,
+		"marketplaceProductCodes": nil,
+		"imageId":                 "",
+		"instanceId":              opts.InstanceID,
+		"instanceType":            "",
+		"kernelId":                nil,
+		"pendingTime":             s.started.Format(time.RFC3339),
+		"privateIp":               opts.PrivateIP,
+		"ramdiskId":               nil,
+		"region":                  opts.Region,
+		"version":                 "2017-09-30",
+	})
+}
+
+func architecture() string {
+	if runtime.GOARCH == "amd64" {
+		return "x86_64"
+	}
+	return runtime.GOARCH
+}
+
+func writeText(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(body))
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type staticProvider struct {
+	creds *rolesanywhere.Credentials
+}
+
+func (p *staticProvider) Retrieve(_ context.Context) (*rolesanywhere.Credentials, error) {
+	return p.creds, nil
+}
+
+var _ = Describe("IMDS Server", func() {
+	var (
+		server *Server
+		opts   Options
+	)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	getToken := func() string {
+		req := httptest.NewRequest(http.MethodPut, tokenPath, nil)
+		req.Header.Set(tokenTTLHeader, "60")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		return rec.Body.String()
+	}
+
+	BeforeEach(func() {
+		opts = OptionsFromRoleArn("arn:aws:iam::123456789012:role/path/my-role", Options{
+			Region:     "us-east-1",
+			InstanceID: "my-pod",
+			PrivateIP:  "10.0.0.1",
+		})
+	})
+
+	JustBeforeEach(func() {
+		server = NewServer(&staticProvider{creds: &rolesanywhere.Credentials{
+			AccessKeyID:     "AKIA",
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Expiration:      time.Now().Add(time.Hour),
+		}}, opts, logr.Discard())
+	})
+
+	It("Should derive the role name and account from the role ARN", func() {
+		Expect(opts.RoleName).To(Equal("my-role"))
+		Expect(opts.AccountID).To(Equal("123456789012"))
+	})
+
+	It("Should list the real role name and serve its credentials", func() {
+		rec := get(credentialsPath, "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("my-role"))
+
+		rec = get(credentialsPath+"my-role", "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var creds map[string]string
+		Expect(json.Unmarshal(rec.Body.Bytes(), &creds)).To(Succeed())
+		Expect(creds).To(HaveKeyWithValue("AccessKeyId", "AKIA"))
+		Expect(creds).To(HaveKeyWithValue("Token", "token"))
+
+		Expect(get(credentialsPath+"other-role", "").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should serve the region and a synthetic identity document", func() {
+		rec := get(regionPath, "")
+		Expect(rec.Body.String()).To(Equal("us-east-1"))
+
+		rec = get(identityDocPath, "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var doc map[string]any
+		Expect(json.Unmarshal(rec.Body.Bytes(), &doc)).To(Succeed())
+		Expect(doc).To(HaveKeyWithValue("instanceId", "my-pod"))
+		Expect(doc).To(HaveKeyWithValue("region", "us-east-1"))
+		Expect(doc).To(HaveKeyWithValue("accountId", "123456789012"))
+		Expect(doc).To(HaveKeyWithValue("privateIp", "10.0.0.1"))
+	})
+
+	It("Should reject invalid tokens", func() {
+		Expect(get(regionPath, "bogus").Code).To(Equal(http.StatusUnauthorized))
+		Expect(get(regionPath, getToken()).Code).To(Equal(http.StatusOK))
+	})
+
+	It("Should refuse to issue tokens to forwarded requests", func() {
+		req := httptest.NewRequest(http.MethodPut, tokenPath, nil)
+		req.Header.Set(tokenTTLHeader, "60")
+		req.Header.Set("X-Forwarded-For", "10.0.0.2")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+	})
+
+	Context("When tokens are required", func() {
+		BeforeEach(func() {
+			opts.TokensRequired = true
+		})
+
+		It("Should reject IMDSv1 requests", func() {
+			Expect(get(credentialsPath, "").Code).To(Equal(http.StatusUnauthorized))
+			Expect(get(credentialsPath, getToken()).Code).To(Equal(http.StatusOK))
+		})
+	})
+})
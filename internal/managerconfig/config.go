@@ -0,0 +1,305 @@
+package managerconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/sidecar"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// SidecarImageEnvVar sets the default sidecar image, so that deployments
+	// predating the configuration keep working.
+	SidecarImageEnvVar = "AWS_IAMRA_MANAGER_SIDECAR_IMAGE"
+	// SidecarUser is the user and group the sidecar image runs as.
+	SidecarUser = 65532
+	// DefaultMaxPodsInFlight limits the pods restarted at a time for a new
+	// sidecar image.
+	DefaultMaxPodsInFlight = 10
+)
+
+// Defaults returns the settings used when neither the file nor the resource
+// set them.
+func Defaults() v1.IamRaManagerConfigSpec {
+	return v1.IamRaManagerConfigSpec{
+		Sidecar: v1.SidecarConfig{
+			Image: os.Getenv(SidecarImageEnvVar),
+			Port:  sidecar.DefaultPort,
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: ptr.To(false),
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				RunAsNonRoot:             ptr.To(true),
+				RunAsUser:                ptr.To[int64](SidecarUser),
+				RunAsGroup:               ptr.To[int64](SidecarUser),
+				SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+		},
+		SidecarUpgrade: v1.SidecarUpgradeSpec{
+			Policy:          v1.SidecarUpgradeReport,
+			MaxPodsInFlight: DefaultMaxPodsInFlight,
+		},
+		MissedInjection: v1.MissedInjectionSpec{Policy: v1.MissedInjectionReport},
+	}
+}
+
+// Store holds the configuration in effect. It layers, from lowest to
+// highest precedence, the defaults, the configuration file and the
+// IamRaManagerConfig resource: every field set in a layer overrides the ones
+// below it. It is safe for concurrent use.
+type Store struct {
+	mu       sync.RWMutex
+	defaults v1.IamRaManagerConfigSpec
+	file     *v1.IamRaManagerConfigSpec
+	resource *v1.IamRaManagerConfigSpec
+}
+
+// NewStore returns a store with the given defaults and no other layers.
+func NewStore(defaults v1.IamRaManagerConfigSpec) *Store {
+	return &Store{defaults: defaults}
+}
+
+// SetFile replaces the settings of the configuration file, or removes them
+// if spec is nil.
+func (s *Store) SetFile(spec *v1.IamRaManagerConfigSpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = spec.DeepCopy()
+}
+
+// SetResource replaces the settings of the IamRaManagerConfig resource, or
+// removes them if spec is nil.
+func (s *Store) SetResource(spec *v1.IamRaManagerConfigSpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resource = spec.DeepCopy()
+}
+
+// Sidecar returns the sidecar settings in effect, with the overrides of a
+// profile applied if it has any.
+func (s *Store) Sidecar(overrides *v1.SidecarOverrides) v1.SidecarConfig {
+	s.mu.RLock()
+	config := *s.defaults.Sidecar.DeepCopy()
+	for _, layer := range []*v1.IamRaManagerConfigSpec{s.file, s.resource} {
+		if layer != nil {
+			mergeSidecar(&config, layer.Sidecar.DeepCopy())
+		}
+	}
+	s.mu.RUnlock()
+
+	if overrides != nil {
+		if overrides.Resources != nil {
+			config.Resources = overrides.Resources.DeepCopy()
+		}
+		if overrides.LogLevel != "" {
+			config.LogLevel = overrides.LogLevel
+		}
+	}
+	return config
+}
+
+// SidecarUpgrade returns how running pods pick up a new sidecar image.
+func (s *Store) SidecarUpgrade() v1.SidecarUpgradeSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upgrade := s.defaults.SidecarUpgrade
+	for _, layer := range []*v1.IamRaManagerConfigSpec{s.file, s.resource} {
+		if layer == nil {
+			continue
+		}
+		if layer.SidecarUpgrade.Policy != "" {
+			upgrade.Policy = layer.SidecarUpgrade.Policy
+		}
+		if layer.SidecarUpgrade.MaxPodsInFlight != 0 {
+			upgrade.MaxPodsInFlight = layer.SidecarUpgrade.MaxPodsInFlight
+		}
+	}
+	return upgrade
+}
+
+// MissedInjection returns how pods the pod webhook didn't inject are handled.
+func (s *Store) MissedInjection() v1.MissedInjectionSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	missed := s.defaults.MissedInjection
+	for _, layer := range []*v1.IamRaManagerConfigSpec{s.file, s.resource} {
+		if layer != nil && layer.MissedInjection.Policy != "" {
+			missed.Policy = layer.MissedInjection.Policy
+		}
+	}
+	return missed
+}
+
+// RequireProfileUsePermission reports whether every profile requires the use
+// permission.
+func (s *Store) RequireProfileUsePermission() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	require := s.defaults.RequireProfileUsePermission
+	for _, layer := range []*v1.IamRaManagerConfigSpec{s.file, s.resource} {
+		if layer != nil && layer.RequireProfileUsePermission != nil {
+			require = layer.RequireProfileUsePermission
+		}
+	}
+	return require != nil && *require
+}
+
+// EcrPullSecrets returns the settings of AwsIamRaEcrPullSecrets.
+func (s *Store) EcrPullSecrets() v1.EcrPullSecretsSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pullSecrets := s.defaults.EcrPullSecrets
+	for _, layer := range []*v1.IamRaManagerConfigSpec{s.file, s.resource} {
+		if layer != nil && layer.EcrPullSecrets.SourceNamespaces != nil {
+			pullSecrets.SourceNamespaces = layer.EcrPullSecrets.SourceNamespaces
+		}
+	}
+	return *pullSecrets.DeepCopy()
+}
+
+// mergeSidecar sets the fields of config that layer sets.
+func mergeSidecar(config *v1.SidecarConfig, layer *v1.SidecarConfig) {
+	if layer.Image != "" {
+		config.Image = layer.Image
+	}
+	if layer.ImagePullPolicy != "" {
+		config.ImagePullPolicy = layer.ImagePullPolicy
+	}
+	if layer.ImagePullSecrets != nil {
+		config.ImagePullSecrets = layer.ImagePullSecrets
+	}
+	if layer.Resources != nil {
+		config.Resources = layer.Resources
+	}
+	if layer.SecurityContext != nil {
+		config.SecurityContext = layer.SecurityContext
+	}
+	if layer.Port != 0 {
+		config.Port = layer.Port
+	}
+	if layer.LogLevel != "" {
+		config.LogLevel = layer.LogLevel
+	}
+}
+
+// LoadFile reads an IamRaManagerConfig from a YAML or JSON file and validates
+// it. Unknown fields are rejected, so that typos don't go unnoticed.
+func LoadFile(path string) (*v1.IamRaManagerConfigSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, path)
+}
+
+func parse(data []byte, path string) (*v1.IamRaManagerConfigSpec, error) {
+	var config v1.IamRaManagerConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	if config.Kind != "" && config.Kind != "IamRaManagerConfig" {
+		return nil, fmt.Errorf("%s holds a %s, expected an IamRaManagerConfig", path, config.Kind)
+	}
+	if err := Validate(&config.Spec); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %w", path, err)
+	}
+	return &config.Spec, nil
+}
+
+// Validate checks the settings the CRD schema can't, and those of files,
+// which don't go through it.
+func Validate(spec *v1.IamRaManagerConfigSpec) error {
+	config := spec.Sidecar
+	var errs []error
+	switch config.ImagePullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		errs = append(errs, fmt.Errorf("sidecar.imagePullPolicy %q is not one of Always, IfNotPresent, Never",
+			config.ImagePullPolicy))
+	}
+	if config.Port != 0 && (config.Port < 1024 || config.Port > 65000) {
+		errs = append(errs, fmt.Errorf("sidecar.port %d is not between 1024 and 65000", config.Port))
+	}
+	switch spec.SidecarUpgrade.Policy {
+	case "", v1.SidecarUpgradeReport, v1.SidecarUpgradeRollingRestart:
+	default:
+		errs = append(errs, fmt.Errorf("sidecarUpgrade.policy %q is not one of Report, RollingRestart",
+			spec.SidecarUpgrade.Policy))
+	}
+	if spec.SidecarUpgrade.MaxPodsInFlight < 0 {
+		errs = append(errs, fmt.Errorf("sidecarUpgrade.maxPodsInFlight %d must be positive",
+			spec.SidecarUpgrade.MaxPodsInFlight))
+	}
+	switch spec.MissedInjection.Policy {
+	case "", v1.MissedInjectionReport, v1.MissedInjectionEvict:
+	default:
+		errs = append(errs, fmt.Errorf("missedInjection.policy %q is not one of Report, Evict",
+			spec.MissedInjection.Policy))
+	}
+	if err := ValidateLogLevel(config.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("sidecar.logLevel: %w", err))
+	}
+	if config.SecurityContext != nil {
+		if err := CheckRestricted(config.SecurityContext); err != nil {
+			errs = append(errs, fmt.Errorf("sidecar.securityContext: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateLogLevel accepts the levels the sidecar's --zap-log-level does.
+func ValidateLogLevel(level v1.SidecarLogLevel) error {
+	switch level {
+	case "", "debug", "info", "error":
+		return nil
+	}
+	if n, err := strconv.Atoi(string(level)); err == nil && n > 0 {
+		return nil
+	}
+	return fmt.Errorf("%q is not one of debug, info, error or a positive integer", level)
+}
+
+// CheckRestricted reports how a container security context falls short of
+// the "restricted" Pod Security Standard. The sidecar is injected into pods
+// whose own security context is unknown, so the container's must comply on
+// its own.
+func CheckRestricted(sc *corev1.SecurityContext) error {
+	var errs []error
+	if sc.Privileged != nil && *sc.Privileged {
+		errs = append(errs, errors.New("privileged must be false"))
+	}
+	if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+		errs = append(errs, errors.New("allowPrivilegeEscalation must be false"))
+	}
+	if sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		errs = append(errs, errors.New("runAsNonRoot must be true"))
+	}
+	if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+		errs = append(errs, errors.New("runAsUser must not be 0"))
+	}
+	if sc.SeccompProfile == nil || (sc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault &&
+		sc.SeccompProfile.Type != corev1.SeccompProfileTypeLocalhost) {
+		errs = append(errs, errors.New("seccompProfile.type must be RuntimeDefault or Localhost"))
+	}
+	dropsAll := false
+	if sc.Capabilities != nil {
+		for _, capability := range sc.Capabilities.Drop {
+			dropsAll = dropsAll || capability == "ALL"
+		}
+		for _, capability := range sc.Capabilities.Add {
+			if capability != "NET_BIND_SERVICE" {
+				errs = append(errs, fmt.Errorf("capability %s can't be added", capability))
+			}
+		}
+	}
+	if !dropsAll {
+		errs = append(errs, errors.New("capabilities.drop must include ALL"))
+	}
+	return errors.Join(errs...)
+}
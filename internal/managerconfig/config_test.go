@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managerconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+)
+
+const testConfigFile = `apiVersion: cloud.dancav.io/v1
+kind: IamRaManagerConfig
+metadata:
+  name: default
+spec:
+  sidecar:
+    image: registry.example.com/iamram/sidecar:1.1.0
+    imagePullSecrets:
+    - name: registry
+    port: 10911
+    logLevel: info
+`
+
+var _ = Describe("Store", func() {
+	It("should layer the resource over the file over the defaults", func() {
+		defaults := Defaults()
+		defaults.Sidecar.Image = "ghcr.io/dancavio/aws-iamra-manager/sidecar:1.0.0"
+		store := NewStore(defaults)
+		Expect(store.Sidecar(nil).Port).To(BeEquivalentTo(9911))
+		Expect(CheckRestricted(store.Sidecar(nil).SecurityContext)).To(Succeed())
+
+		store.SetFile(&v1.IamRaManagerConfigSpec{Sidecar: v1.SidecarConfig{
+			Image:    "registry.example.com/iamram/sidecar:1.1.0",
+			Port:     10911,
+			LogLevel: "info",
+		}})
+		store.SetResource(&v1.IamRaManagerConfigSpec{Sidecar: v1.SidecarConfig{
+			Image:           "registry.example.com/iamram/sidecar:1.2.0",
+			ImagePullPolicy: corev1.PullAlways,
+		}})
+		config := store.Sidecar(nil)
+		Expect(config.Image).To(Equal("registry.example.com/iamram/sidecar:1.2.0"))
+		Expect(config.ImagePullPolicy).To(Equal(corev1.PullAlways))
+		Expect(config.Port).To(BeEquivalentTo(10911))
+		Expect(config.LogLevel).To(BeEquivalentTo("info"))
+
+		By("applying the overrides of a profile")
+		config = store.Sidecar(&v1.SidecarOverrides{
+			Resources: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5m")},
+			},
+			LogLevel: "debug",
+		})
+		Expect(config.LogLevel).To(BeEquivalentTo("debug"))
+		Expect(config.Resources.Requests.Cpu().String()).To(Equal("5m"))
+
+		By("falling back to the file once the resource is deleted")
+		store.SetResource(nil)
+		Expect(store.Sidecar(nil).Image).To(Equal("registry.example.com/iamram/sidecar:1.1.0"))
+	})
+
+	It("should let the resource turn off what the file turns on", func() {
+		store := NewStore(Defaults())
+		Expect(store.RequireProfileUsePermission()).To(BeFalse())
+		Expect(store.MissedInjection().Policy).To(Equal(v1.MissedInjectionReport))
+
+		store.SetFile(&v1.IamRaManagerConfigSpec{
+			RequireProfileUsePermission: ptr.To(true),
+			MissedInjection:             v1.MissedInjectionSpec{Policy: v1.MissedInjectionEvict},
+			SidecarUpgrade:              v1.SidecarUpgradeSpec{Policy: v1.SidecarUpgradeRollingRestart},
+		})
+		Expect(store.RequireProfileUsePermission()).To(BeTrue())
+		Expect(store.MissedInjection().Policy).To(Equal(v1.MissedInjectionEvict))
+		Expect(store.SidecarUpgrade()).To(Equal(v1.SidecarUpgradeSpec{
+			Policy:          v1.SidecarUpgradeRollingRestart,
+			MaxPodsInFlight: DefaultMaxPodsInFlight,
+		}))
+
+		store.SetResource(&v1.IamRaManagerConfigSpec{RequireProfileUsePermission: ptr.To(false)})
+		Expect(store.RequireProfileUsePermission()).To(BeFalse())
+		Expect(store.MissedInjection().Policy).To(Equal(v1.MissedInjectionEvict))
+
+		By("letting the resource empty the file's list of ECR pull secret sources")
+		store.SetFile(&v1.IamRaManagerConfigSpec{
+			EcrPullSecrets: v1.EcrPullSecretsSpec{SourceNamespaces: []string{"registry"}},
+		})
+		Expect(store.EcrPullSecrets().SourceNamespaces).To(Equal([]string{"registry"}))
+		store.SetResource(&v1.IamRaManagerConfigSpec{EcrPullSecrets: v1.EcrPullSecretsSpec{SourceNamespaces: []string{}}})
+		Expect(store.EcrPullSecrets().SourceNamespaces).To(BeEmpty())
+	})
+
+	It("should reject security contexts that aren't restricted", func() {
+		sc := Defaults().Sidecar.SecurityContext
+		sc.AllowPrivilegeEscalation = nil
+		sc.RunAsUser = ptr.To[int64](0)
+		sc.Capabilities.Add = []corev1.Capability{"NET_ADMIN"}
+		err := Validate(&v1.IamRaManagerConfigSpec{Sidecar: v1.SidecarConfig{SecurityContext: sc, LogLevel: "trace"}})
+		Expect(err).To(MatchError(ContainSubstring("allowPrivilegeEscalation must be false")))
+		Expect(err).To(MatchError(ContainSubstring("runAsUser must not be 0")))
+		Expect(err).To(MatchError(ContainSubstring("capability NET_ADMIN can't be added")))
+		Expect(err).To(MatchError(ContainSubstring(`"trace" is not one of`)))
+	})
+})
+
+var _ = Describe("FileWatcher", func() {
+	It("should reload the file when it changes and keep the settings of invalid ones", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(testConfigFile), 0o600)).To(Succeed())
+		store := NewStore(Defaults())
+		watcher := &FileWatcher{Path: path, Store: store, ResyncInterval: 50 * time.Millisecond, Logger: logr.Discard()}
+		Expect(watcher.Load()).To(Succeed())
+		Expect(store.Sidecar(nil).Image).To(Equal("registry.example.com/iamram/sidecar:1.1.0"))
+		Expect(store.Sidecar(nil).ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "registry"}}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			Expect(watcher.Start(ctx)).To(Succeed())
+		}()
+
+		By("skipping a file with unknown fields")
+		Expect(os.WriteFile(path, []byte(testConfigFile+"    imagePolicy: Always\n"), 0o600)).To(Succeed())
+		Consistently(func() string { return store.Sidecar(nil).Image }, 300*time.Millisecond).
+			Should(Equal("registry.example.com/iamram/sidecar:1.1.0"))
+
+		By("loading the fixed file")
+		Expect(os.WriteFile(path, []byte(testConfigFile+"    imagePullPolicy: Always\n"), 0o600)).To(Succeed())
+		Eventually(func() corev1.PullPolicy { return store.Sidecar(nil).ImagePullPolicy }, 5*time.Second).
+			Should(Equal(corev1.PullAlways))
+	})
+})
@@ -0,0 +1,116 @@
+package managerconfig
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultFileResyncInterval is how often the configuration file is
+	// checked for changes when no file system event arrives.
+	DefaultFileResyncInterval = time.Minute
+	// fileSettleDelay lets writers finish before the file is reloaded.
+	fileSettleDelay = time.Second
+)
+
+// FileWatcher loads the configuration file into a store, and reloads it
+// whenever it changes, as kubelet does when a mounted ConfigMap is updated.
+// An invalid file is logged and skipped, so that the settings in use stay.
+type FileWatcher struct {
+	Path  string
+	Store *Store
+	// ResyncInterval is how often the file is checked even if no file
+	// system event arrives. Defaults to DefaultFileResyncInterval.
+	ResyncInterval time.Duration
+	Logger         logr.Logger
+
+	// current holds the contents of the file the store was last loaded from,
+	// rejected those of a file that was found invalid.
+	current  []byte
+	rejected []byte
+}
+
+// Load reads the file into the store.
+func (w *FileWatcher) Load() error {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return err
+	}
+	spec, err := parse(data, w.Path)
+	if err != nil {
+		return err
+	}
+	w.Store.SetFile(spec)
+	w.current = data
+	return nil
+}
+
+// Start reloads the file until ctx is done.
+func (w *FileWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// Kubelet swaps the files of ConfigMap volumes by replacing a symlink in
+	// their directory, so the directory is watched rather than the file.
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return err
+	}
+
+	interval := w.ResyncInterval
+	if interval == 0 {
+		interval = DefaultFileResyncInterval
+	}
+	resync := time.NewTicker(interval)
+	defer resync.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			settle = time.After(fileSettleDelay)
+		case err := <-watcher.Errors:
+			w.Logger.Error(err, "problem watching configuration file")
+		case <-settle:
+			settle = nil
+			w.reload()
+		case <-resync.C:
+			w.reload()
+		}
+	}
+}
+
+// NeedLeaderElection returns false: every replica of the webhook injects
+// sidecars, so every replica needs the current settings.
+func (w *FileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload loads the file if it changed since it last did.
+func (w *FileWatcher) reload() {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		w.Logger.Error(err, "unable to read configuration file")
+		return
+	}
+	if bytes.Equal(data, w.current) || bytes.Equal(data, w.rejected) {
+		return
+	}
+	spec, err := parse(data, w.Path)
+	if err != nil {
+		w.rejected = data
+		w.Logger.Error(err, "configuration file is invalid, keeping current settings")
+		return
+	}
+	w.Store.SetFile(spec)
+	w.current = data
+	w.Logger.Info("loaded configuration file", "path", w.Path)
+}
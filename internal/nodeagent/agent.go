@@ -0,0 +1,281 @@
+package nodeagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PodIPField indexes pods by their IPs, so that callers can be looked up
+	// by the source address of their requests.
+	PodIPField = "status.podIP"
+	// maxRoleSessionName is the longest role session name CreateSession accepts.
+	maxRoleSessionName = 64
+	// serverIdleTimeout is how long the metadata server of a pod that stopped
+	// asking for credentials is kept, along with its session tokens.
+	serverIdleTimeout = 12 * time.Hour
+)
+
+// IndexPodIP returns the IPs of a pod that the agent tells it apart by. Pods
+// on the host network share the node's IP, so they are left out.
+func IndexPodIP(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil
+	}
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
+
+// Agent emulates the instance metadata service for the pods on its node whose
+// profile is delivered by the node agent. It identifies the calling pod by the
+// source IP of the request and serves the role of the pod's profile, calling
+// CreateSession with its own certificate. Every pod gets a metadata server of
+// its own, so that session tokens handed out to one pod aren't accepted from
+// another.
+type Agent struct {
+	nodeName string
+	client   client.Reader
+	logger   logr.Logger
+
+	// Endpoint overrides the Roles Anywhere endpoint derived from the trust
+	// anchor ARNs.
+	Endpoint string
+
+	mu      sync.Mutex
+	signer  *rolesanywhere.Signer
+	servers map[types.UID]*podServer
+}
+
+type podServer struct {
+	server *imds.Server
+	// version identifies the profile and pod IP the server was set up for.
+	version  string
+	lastUsed time.Time
+}
+
+// NewAgent returns an agent for the pods on nodeName, calling CreateSession
+// with signer. Pods and profiles are read with c, which must index pods by
+// PodIPField.
+func NewAgent(nodeName string, signer *rolesanywhere.Signer, c client.Reader, logger logr.Logger) *Agent {
+	return &Agent{
+		nodeName: nodeName,
+		client:   c,
+		logger:   logger,
+		signer:   signer,
+		servers:  map[types.UID]*podServer{},
+	}
+}
+
+// SetSigner switches the agent to a renewed certificate. The metadata servers
+// of pods are dropped along with their cached credentials, so pods holding a
+// session token have to get a new one.
+func (a *Agent) SetSigner(signer *rolesanywhere.Signer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.signer = signer
+	a.servers = map[types.UID]*podServer{}
+}
+
+// forbiddenError is a request the agent refuses to serve.
+type forbiddenError struct {
+	err error
+}
+
+func (e *forbiddenError) Error() string {
+	return e.err.Error()
+}
+
+func forbidden(format string, args ...any) error {
+	return &forbiddenError{err: fmt.Errorf(format, args...)}
+}
+
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Like the instance metadata service, refuse requests relayed by a proxy,
+	// which would otherwise be served the proxy's credentials.
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forwarded requests are not allowed", http.StatusForbidden)
+		return
+	}
+	server, err := a.server(r.Context(), r.RemoteAddr)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var forbiddenErr *forbiddenError
+		if errors.As(err, &forbiddenErr) {
+			status = http.StatusForbidden
+		}
+		a.logger.Info("refusing request", "remoteAddr", r.RemoteAddr, "path", r.URL.Path, "reason", err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+	server.ServeHTTP(w, r)
+}
+
+// server returns the metadata server of the pod at remoteAddr, set up for its
+// current profile.
+func (a *Agent) server(ctx context.Context, remoteAddr string) (*imds.Server, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, forbidden("unable to parse remote address %s", remoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, forbidden("unable to parse remote address %s", remoteAddr)
+	}
+	pod, err := a.caller(ctx, ip.String())
+	if err != nil {
+		return nil, err
+	}
+
+	profileNames := iamram.RoleProfileNames(pod)
+	if len(profileNames) == 0 {
+		return nil, forbidden("pod %s/%s uses no profile", pod.Namespace, pod.Name)
+	}
+	var profile v1.AwsIamRaRoleProfile
+	if err := a.client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: profileNames[0]},
+		&profile); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, forbidden("profile %s/%s not found", pod.Namespace, profileNames[0])
+		}
+		return nil, fmt.Errorf("unable to fetch profile %s/%s: %w", pod.Namespace, profileNames[0], err)
+	}
+	// The pod webhook checks the profiles of pods when they are created, so
+	// the profile must be the one the pod was admitted with and must still
+	// allow its service account.
+	if _, ok := iamram.ProfileHashes(pod.Annotations)[profile.Name]; !ok {
+		return nil, forbidden("pod %s/%s wasn't admitted with profile %s", pod.Namespace, pod.Name, profile.Name)
+	}
+	allowed, err := iamram.ServiceAccountAllowed(ctx, a.client, &profile, pod)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, forbidden("profile %s/%s doesn't allow service account %s", pod.Namespace, profile.Name,
+			iamram.ServiceAccountName(pod))
+	}
+	if profile.Spec.Suspended {
+		return nil, forbidden("profile %s/%s is suspended", pod.Namespace, profile.Name)
+	}
+	activation, err := iamram.ProfileActivation(&profile.Spec, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("profile %s/%s: %w", pod.Namespace, profile.Name, err)
+	}
+	if !activation.Active {
+		return nil, forbidden("profile %s/%s is outside its activation window", pod.Namespace, profile.Name)
+	}
+	region, err := rolesanywhere.Region(string(profile.Spec.TrustAnchorArn))
+	if err != nil {
+		return nil, fmt.Errorf("profile %s/%s: %w", pod.Namespace, profile.Name, err)
+	}
+
+	// The end of the window is part of the version, since the profile doesn't
+	// change when it moves to the next window of a schedule.
+	until := activation.Until()
+	version := string(profile.UID) + "/" + profile.ResourceVersion + "/" + ip.String() + "/" +
+		until.UTC().Format(time.RFC3339)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for uid, ps := range a.servers {
+		if now.Sub(ps.lastUsed) > serverIdleTimeout {
+			delete(a.servers, uid)
+		}
+	}
+	ps, ok := a.servers[pod.UID]
+	if !ok || ps.version != version {
+		provider := &rolesanywhere.SessionProvider{
+			Client: &rolesanywhere.Client{Signer: a.signer, Endpoint: a.Endpoint},
+			Input:  sessionInput(pod, &profile),
+			Until:  until,
+		}
+		opts := imds.OptionsFromRoleArn(string(profile.Spec.RoleArn), imds.Options{
+			Region:         region,
+			InstanceID:     pod.Name,
+			PrivateIP:      ip.String(),
+			TokensRequired: profile.Spec.ImdsV2Only,
+		})
+		if ok {
+			// Keep the session tokens the pod already holds.
+			ps.server.Reconfigure(provider, opts)
+		} else {
+			ps = &podServer{server: imds.NewServer(provider, opts,
+				a.logger.WithValues("pod", client.ObjectKeyFromObject(pod)))}
+			a.servers[pod.UID] = ps
+		}
+		ps.version = version
+	}
+	ps.lastUsed = now
+	return ps.server, nil
+}
+
+// caller returns the pod on the agent's node with the IP, which must get its
+// credentials from the node agent.
+func (a *Agent) caller(ctx context.Context, ip string) (*corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := a.client.List(ctx, &pods, client.MatchingFields{PodIPField: ip}); err != nil {
+		return nil, fmt.Errorf("unable to list pods: %w", err)
+	}
+	var candidates []*corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != a.nodeName || !pod.DeletionTimestamp.IsZero() ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, forbidden("no pod with IP %s on node %s", ip, a.nodeName)
+	case 1:
+	default:
+		// Only possible while the cache lags behind an IP being reused.
+		return nil, forbidden("several pods have IP %s", ip)
+	}
+	pod := candidates[0]
+	if !iamram.UsesNodeAgent(pod) {
+		return nil, forbidden("pod %s/%s doesn't get its credentials from the node agent", pod.Namespace, pod.Name)
+	}
+	return pod, nil
+}
+
+// sessionInput returns the session of the pod's profile. Sessions are named
+// after the pod unless the profile names them, as the sidecar does.
+func sessionInput(pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile) rolesanywhere.SessionInput {
+	spec := profile.Spec
+	input := rolesanywhere.SessionInput{
+		TrustAnchorArn:  string(spec.TrustAnchorArn),
+		ProfileArn:      string(spec.ProfileArn),
+		RoleArn:         string(spec.RoleArn),
+		DurationSeconds: spec.DurationSeconds,
+		RoleSessionName: spec.RoleSessionName,
+	}
+	if input.RoleSessionName == "" {
+		input.RoleSessionName = pod.Namespace + "@" + pod.Name
+		if len(input.RoleSessionName) > maxRoleSessionName {
+			input.RoleSessionName = input.RoleSessionName[:maxRoleSessionName]
+		}
+	}
+	return input
+}
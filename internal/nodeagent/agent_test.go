@@ -0,0 +1,268 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeagent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestSigner() *rolesanywhere.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "node-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return &rolesanywhere.Signer{Certificate: cert, PrivateKey: key}
+}
+
+// fakeCreateSession stands in for Roles Anywhere, recording the inputs of the
+// sessions it creates.
+func fakeCreateSession(inputs *[]rolesanywhere.SessionInput) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		var input rolesanywhere.SessionInput
+		Expect(json.NewDecoder(r.Body).Decode(&input)).To(Succeed())
+		*inputs = append(*inputs, input)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"credentialSet": []any{map[string]any{"credentials": rolesanywhere.Credentials{
+				AccessKeyID:     "AKIA" + input.RoleSessionName,
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+				Expiration:      time.Now().Add(time.Hour),
+			}}},
+		})
+	}))
+}
+
+func newTestPod(name, node, ip string, delivery v1.CredentialDelivery) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "team-a",
+			UID:       types.UID("uid-" + name),
+			Annotations: map[string]string{
+				v1.RoleProfilePodAnnotationKey:   "reader",
+				v1.DeliveryPodAnnotationKey:      string(delivery),
+				v1.ProfileHashesPodAnnotationKey: "reader=0123456789",
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			PodIP:  ip,
+			PodIPs: []corev1.PodIP{{IP: ip}},
+		},
+	}
+}
+
+var _ = Describe("Agent", func() {
+	var (
+		inputs  []rolesanywhere.SessionInput
+		server  *httptest.Server
+		c       client.Client
+		profile *v1.AwsIamRaRoleProfile
+		agent   *Agent
+	)
+
+	BeforeEach(func() {
+		inputs = nil
+		server = fakeCreateSession(&inputs)
+		profile = &v1.AwsIamRaRoleProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "team-a"},
+			Spec: v1.AwsIamRaRoleProfileSpec{
+				TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+				ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+				RoleArn:        "arn:aws:iam::123456789012:role/reader",
+				ImdsV2Only:     true,
+				Delivery:       v1.CredentialDeliveryNodeAgent,
+			},
+		}
+
+		scheme := runtime.NewScheme()
+		Expect(v1.AddToScheme(scheme)).To(Succeed())
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		hostNetwork := newTestPod("host-1", "node-a", "10.0.0.1", v1.CredentialDeliveryNodeAgent)
+		hostNetwork.Spec.HostNetwork = true
+		c = clientfake.NewClientBuilder().WithScheme(scheme).
+			WithIndex(&corev1.Pod{}, PodIPField, IndexPodIP).
+			WithObjects(
+				profile,
+				newTestPod("web-1", "node-a", "10.0.0.5", v1.CredentialDeliveryNodeAgent),
+				newTestPod("web-2", "node-a", "10.0.0.7", v1.CredentialDeliveryNodeAgent),
+				newTestPod("sidecar-1", "node-a", "10.0.0.6", v1.CredentialDeliverySidecar),
+				newTestPod("remote-1", "node-b", "10.0.1.5", v1.CredentialDeliveryNodeAgent),
+				hostNetwork,
+			).Build()
+		agent = NewAgent("node-a", newTestSigner(), c, logr.Discard())
+		agent.Endpoint = server.URL
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	request := func(remoteIP, method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remoteIP + ":41234"
+		if token != "" {
+			r.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		if method == http.MethodPut {
+			r.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+		}
+		recorder := httptest.NewRecorder()
+		agent.ServeHTTP(recorder, r)
+		return recorder
+	}
+	getToken := func(remoteIP string) string {
+		response := request(remoteIP, http.MethodPut, "/latest/api/token", "")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		return response.Body.String()
+	}
+
+	It("should serve the role of the calling pod's profile", func() {
+		token := getToken("10.0.0.5")
+		response := request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/", token)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(Equal("reader"))
+
+		response = request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", token)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(ContainSubstring("AKIAteam-a@web-1"))
+		Expect(inputs).To(ConsistOf(rolesanywhere.SessionInput{
+			TrustAnchorArn:  "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:      "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:         "arn:aws:iam::123456789012:role/reader",
+			RoleSessionName: "team-a@web-1",
+		}))
+		Expect(request("10.0.0.5", http.MethodGet, "/latest/meta-data/instance-id", token).Body.String()).
+			To(Equal("web-1"))
+
+		By("rejecting the token of one pod from another")
+		response = request("10.0.0.7", http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", token)
+		Expect(response.Code).To(Equal(http.StatusUnauthorized))
+
+		By("serving the updated profile with the tokens already handed out")
+		profile.Spec.RoleArn = "arn:aws:iam::123456789012:role/writer"
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/writer", token)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(inputs).To(HaveLen(2))
+		Expect(inputs[1].RoleArn).To(Equal("arn:aws:iam::123456789012:role/writer"))
+
+		By("refusing pods while their profile is outside its activation window")
+		profile.Spec.Schedules = []v1.ProfileSchedule{{
+			Start: time.Now().UTC().Add(2 * time.Hour).Format("15:04"),
+			End:   time.Now().UTC().Add(3 * time.Hour).Format("15:04"),
+		}}
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/writer", token)
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("is outside its activation window"))
+		profile.Spec.Schedules = nil
+
+		By("refusing pods while their profile is suspended")
+		profile.Spec.Suspended = true
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/writer", token)
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("is suspended"))
+	})
+
+	It("should only serve pods on its node that get their credentials from it", func() {
+		for ip, reason := range map[string]string{
+			"10.0.0.9": "no pod with IP 10.0.0.9",
+			"10.0.1.5": "no pod with IP 10.0.1.5",
+			"10.0.0.1": "no pod with IP 10.0.0.1",
+			"10.0.0.6": "doesn't get its credentials from the node agent",
+		} {
+			response := request(ip, http.MethodPut, "/latest/api/token", "")
+			Expect(response.Code).To(Equal(http.StatusForbidden), ip)
+			Expect(response.Body.String()).To(ContainSubstring(reason), ip)
+		}
+
+		By("refusing requests relayed by a proxy")
+		r := httptest.NewRequest(http.MethodPut, "/latest/api/token", nil)
+		r.RemoteAddr = "10.0.0.5:41234"
+		r.Header.Set("X-Forwarded-For", "192.0.2.10")
+		recorder := httptest.NewRecorder()
+		agent.ServeHTTP(recorder, r)
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(inputs).To(BeEmpty())
+	})
+
+	It("should only serve profiles the pod was admitted with and that allow its service account", func() {
+		By("refusing profiles the pod was switched to after it was admitted")
+		pod := &corev1.Pod{}
+		Expect(c.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "web-1"}, pod)).
+			To(Succeed())
+		pod.Annotations[v1.ProfileHashesPodAnnotationKey] = "writer=0123456789"
+		Expect(c.Update(context.Background(), pod)).To(Succeed())
+		response := request("10.0.0.5", http.MethodPut, "/latest/api/token", "")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("wasn't admitted with profile reader"))
+
+		By("refusing profiles that no longer allow the service account")
+		profile.Spec.AllowedServiceAccounts = &v1.AllowedServiceAccounts{Names: []string{"deployer"}}
+		Expect(c.Update(context.Background(), profile)).To(Succeed())
+		response = request("10.0.0.7", http.MethodPut, "/latest/api/token", "")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+		Expect(response.Body.String()).To(ContainSubstring("doesn't allow service account default"))
+		Expect(inputs).To(BeEmpty())
+	})
+
+	It("should drop cached credentials with a renewed certificate", func() {
+		token := getToken("10.0.0.5")
+		Expect(request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", token).Code).
+			To(Equal(http.StatusOK))
+		agent.SetSigner(newTestSigner())
+		token = getToken("10.0.0.5")
+		Expect(request("10.0.0.5", http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", token).Code).
+			To(Equal(http.StatusOK))
+		Expect(inputs).To(HaveLen(2))
+	})
+})
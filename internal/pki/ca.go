@@ -0,0 +1,166 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+)
+
+// clockSkew is how far back the validity of issued certificates starts, to
+// tolerate clocks running behind the controller's.
+const clockSkew = 5 * time.Minute
+
+// CA is an intermediate certificate authority issuing workload certificates.
+type CA struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	PrivateKey  crypto.Signer
+}
+
+// ParseCA builds a CA from a PEM-encoded CA certificate, optionally followed
+// by the rest of its chain, and its private key.
+func ParseCA(certPEM, keyPEM []byte) (*CA, error) {
+	signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !signer.Certificate.IsCA || signer.Certificate.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("certificate is not allowed to sign certificates")
+	}
+	return &CA{Certificate: signer.Certificate, Chain: signer.Chain, PrivateKey: signer.PrivateKey}, nil
+}
+
+// Identity is the workload a certificate is issued to.
+type Identity struct {
+	Namespace      string
+	ServiceAccount string
+	Pod            string
+}
+
+// Subject encodes the identity as the certificate subject, so that trust
+// policies can match it with the x509Subject/O, OU and CN principal tags.
+func (id Identity) Subject() pkix.Name {
+	return pkix.Name{
+		Organization:       []string{id.Namespace},
+		OrganizationalUnit: []string{id.ServiceAccount},
+		CommonName:         id.Pod,
+	}
+}
+
+// IdentityFromSubject is the reverse of Identity.Subject.
+func IdentityFromSubject(subject pkix.Name) (Identity, error) {
+	if len(subject.Organization) != 1 || len(subject.OrganizationalUnit) != 1 || subject.CommonName == "" {
+		return Identity{}, errors.New("subject must have exactly one O, OU and CN")
+	}
+	return Identity{
+		Namespace:      subject.Organization[0],
+		ServiceAccount: subject.OrganizationalUnit[0],
+		Pod:            subject.CommonName,
+	}, nil
+}
+
+// URI returns the SPIFFE ID of the identity's service account in trustDomain.
+func (id Identity) URI(trustDomain string) *url.URL {
+	return &url.URL{
+		Scheme: "spiffe",
+		Host:   trustDomain,
+		Path:   fmt.Sprintf("/ns/%s/sa/%s", id.Namespace, id.ServiceAccount),
+	}
+}
+
+// Issue returns a new PEM-encoded private key and a certificate for it,
+// followed by the CA's chain, valid for duration.
+func (ca *CA) Issue(id Identity, trustDomain string, duration time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM, err = ca.Sign(id, trustDomain, key.Public(), duration)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// Sign returns a PEM-encoded certificate for id and publicKey, followed by
+// the CA's chain, valid for duration.
+func (ca *CA) Sign(id Identity, trustDomain string, publicKey crypto.PublicKey, duration time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      id.Subject(),
+		URIs:         []*url.URL{id.URI(trustDomain)},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(duration),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if template.NotAfter.After(ca.Certificate.NotAfter) {
+		template.NotAfter = ca.Certificate.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, publicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign certificate: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	for _, cert := range append([]*x509.Certificate{ca.Certificate}, ca.Chain...) {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return certPEM, nil
+}
+
+// RenewAt returns when cert should be replaced: two thirds into its lifetime.
+func RenewAt(cert *x509.Certificate) time.Time {
+	return cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3)
+}
+
+// NewCertificateRequest returns a new private key and a PEM-encoded
+// certificate signing request for id.
+func NewCertificateRequest(id Identity) (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: id.Subject()}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCertificateRequest parses a PEM-encoded certificate signing request
+// and checks its signature.
+func ParseCertificateRequest(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no certificate request found in PEM data")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	return csr, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// newTestCA returns a PEM-encoded self-signed certificate and its key.
+func newTestCA(isCA bool) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+var _ = Describe("CA", func() {
+	id := Identity{Namespace: "team-a", ServiceAccount: "builder", Pod: "builder-7d9f"}
+
+	It("Should issue certificates identifying the workload", func() {
+		ca, err := ParseCA(newTestCA(true))
+		Expect(err).NotTo(HaveOccurred())
+
+		certPEM, keyPEM, err := ca.Issue(id, "cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Chain).To(HaveLen(1))
+
+		cert := signer.Certificate
+		Expect(cert.Subject.Organization).To(Equal([]string{"team-a"}))
+		Expect(cert.Subject.OrganizationalUnit).To(Equal([]string{"builder"}))
+		Expect(cert.Subject.CommonName).To(Equal("builder-7d9f"))
+		Expect(cert.URIs).To(HaveLen(1))
+		Expect(cert.URIs[0].String()).To(Equal("spiffe://cluster.local/ns/team-a/sa/builder"))
+		Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Certificate)
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(RenewAt(cert)).To(BeTemporally("~", time.Now().Add(38*time.Minute), time.Minute))
+	})
+
+	It("Should not issue certificates outliving the CA", func() {
+		ca, err := ParseCA(newTestCA(true))
+		Expect(err).NotTo(HaveOccurred())
+		certPEM, keyPEM, err := ca.Issue(id, "cluster.local", 48*time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Certificate.NotAfter).To(Equal(ca.Certificate.NotAfter))
+	})
+
+	It("Should reject certificates that can't sign certificates", func() {
+		_, err := ParseCA(newTestCA(false))
+		Expect(err).To(MatchError(ContainSubstring("not allowed to sign")))
+	})
+})
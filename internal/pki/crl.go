@@ -0,0 +1,54 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// FormatSerial returns the canonical form of a certificate serial number:
+// lowercase hexadecimal without separators or leading zeros.
+func FormatSerial(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// ParseSerial parses a hexadecimal serial number, optionally with the colons
+// separating bytes that openssl prints.
+func ParseSerial(s string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+	if !ok || serial.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid serial number %q", s)
+	}
+	return serial, nil
+}
+
+// Issued reports whether cert was signed by the CA.
+func (ca *CA) Issued(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.Certificate) == nil
+}
+
+// RevocationList returns a PEM-encoded CRL of entries signed by the CA, valid
+// until nextUpdate. The CA certificate must allow signing CRLs.
+func (ca *CA) RevocationList(
+	entries []x509.RevocationListEntry, number *big.Int, nextUpdate time.Time,
+) ([]byte, error) {
+	if ca.Certificate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, errors.New("CA certificate is not allowed to sign CRLs")
+	}
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                time.Now().Add(-clockSkew),
+		NextUpdate:                nextUpdate,
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.Certificate, ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign CRL: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CRL", func() {
+	It("Should format and parse serial numbers", func() {
+		serial, err := ParseSerial("0A:1b:FF")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serial).To(Equal(big.NewInt(0x0a1bff)))
+		Expect(FormatSerial(serial)).To(Equal("a1bff"))
+
+		for _, invalid := range []string{"", "0", "xyz", "-1"} {
+			_, err := ParseSerial(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+
+	It("Should sign revocation lists of certificates it issued", func() {
+		ca, err := ParseCA(newTestCA(true))
+		Expect(err).NotTo(HaveOccurred())
+		certPEM, keyPEM, err := ca.Issue(Identity{Namespace: "a", ServiceAccount: "b", Pod: "c"}, "cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ca.Issued(signer.Certificate)).To(BeTrue())
+
+		other, err := ParseCA(newTestCA(true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Issued(signer.Certificate)).To(BeFalse())
+
+		revokedAt := time.Now().Truncate(time.Second)
+		crlPEM, err := ca.RevocationList([]x509.RevocationListEntry{{
+			SerialNumber:   signer.Certificate.SerialNumber,
+			RevocationTime: revokedAt,
+			ReasonCode:     1,
+		}}, big.NewInt(7), time.Now().Add(24*time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		block, _ := pem.Decode(crlPEM)
+		Expect(block).NotTo(BeNil())
+		Expect(block.Type).To(Equal("X509 CRL"))
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crl.CheckSignatureFrom(ca.Certificate)).To(Succeed())
+		Expect(crl.Number).To(Equal(big.NewInt(7)))
+		Expect(crl.RevokedCertificateEntries).To(HaveLen(1))
+		entry := crl.RevokedCertificateEntries[0]
+		Expect(entry.SerialNumber).To(Equal(signer.Certificate.SerialNumber))
+		Expect(entry.RevocationTime).To(BeTemporally("==", revokedAt))
+		Expect(entry.ReasonCode).To(Equal(1))
+	})
+
+	It("Should refuse to sign revocation lists without the CRL sign key usage", func() {
+		ca, err := ParseCA(newTestCA(true))
+		Expect(err).NotTo(HaveOccurred())
+		ca.Certificate.KeyUsage &^= x509.KeyUsageCRLSign
+		_, err = ca.RevocationList(nil, big.NewInt(1), time.Now().Add(time.Hour))
+		Expect(err).To(MatchError(ContainSubstring("not allowed to sign CRLs")))
+	})
+})
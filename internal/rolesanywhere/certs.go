@@ -0,0 +1,249 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// SignerFiles names the files a Signer is loaded from: a PEM-encoded
+// certificate and private key, or a PKCS#12 bundle holding both.
+type SignerFiles struct {
+	CertPath   string
+	KeyPath    string
+	PKCS12Path string
+	// ChainPaths hold PEM-encoded intermediate certificates, sent after those
+	// that follow the certificate in CertPath or PKCS12Path.
+	ChainPaths []string
+	// Passphrase decrypts an encrypted private key or the PKCS#12 bundle.
+	Passphrase []byte
+}
+
+// Load reads the files into a Signer.
+func (f SignerFiles) Load() (*Signer, error) {
+	var signer *Signer
+	if f.PKCS12Path != "" {
+		data, err := os.ReadFile(f.PKCS12Path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read PKCS#12 bundle: %w", err)
+		}
+		if signer, err = ParsePKCS12Signer(data, f.Passphrase); err != nil {
+			return nil, err
+		}
+	} else {
+		certPEM, err := os.ReadFile(f.CertPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate: %w", err)
+		}
+		keyPEM, err := os.ReadFile(f.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %w", err)
+		}
+		if signer, err = ParseEncryptedSigner(certPEM, keyPEM, f.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+	for _, chainPath := range f.ChainPaths {
+		chainPEM, err := os.ReadFile(chainPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate chain: %w", err)
+		}
+		chain, err := parseCertificates(chainPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate chain %s: %w", chainPath, err)
+		}
+		signer.appendChain(chain)
+	}
+	return signer, nil
+}
+
+// LoadSigner reads a PEM-encoded certificate and private key from disk. The
+// certificates in chainPaths, if any, are sent after those following the
+// first one in certPath.
+func LoadSigner(certPath, keyPath string, chainPaths ...string) (*Signer, error) {
+	return SignerFiles{CertPath: certPath, KeyPath: keyPath, ChainPaths: chainPaths}.Load()
+}
+
+// ParseSigner builds a Signer from a PEM-encoded certificate and private key.
+// Certificates following the first one are sent as the chain.
+func ParseSigner(certPEM, keyPEM []byte) (*Signer, error) {
+	return ParseEncryptedSigner(certPEM, keyPEM, nil)
+}
+
+// ParseEncryptedSigner is ParseSigner for private keys that may be encrypted
+// with passphrase, either as an encrypted PKCS#8 key or in the legacy
+// OpenSSL format.
+func ParseEncryptedSigner(certPEM, keyPEM, passphrase []byte) (*Signer, error) {
+	key, err := parsePrivateKey(keyPEM, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(certPEM, key)
+}
+
+// ParsePKCS12Signer builds a Signer from a PKCS#12 bundle holding the
+// certificate, its private key and optionally its chain.
+func ParsePKCS12Signer(data, passphrase []byte) (*Signer, error) {
+	key, cert, chain, err := pkcs12.DecodeChain(data, string(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#12 bundle: %w", err)
+	}
+	privateKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if err := checkKeyMatches(cert, privateKey); err != nil {
+		return nil, err
+	}
+	signer := &Signer{Certificate: cert, PrivateKey: privateKey}
+	signer.appendChain(chain)
+	return signer, nil
+}
+
+// NewSigner builds a Signer from a PEM-encoded certificate, optionally
+// followed by its chain, and the private key it was issued for.
+func NewSigner(certPEM []byte, key crypto.Signer) (*Signer, error) {
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyMatches(certs[0], key); err != nil {
+		return nil, err
+	}
+	return &Signer{Certificate: certs[0], Chain: certs[1:], PrivateKey: key}, nil
+}
+
+func checkKeyMatches(cert *x509.Certificate, key crypto.Signer) error {
+	publicKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(key.Public()) {
+		return errors.New("certificate doesn't match the private key")
+	}
+	return nil
+}
+
+// appendChain adds intermediate certificates to the chain. Certificates
+// already in it are skipped, as are self-signed roots, which Roles Anywhere
+// gets from the trust anchor: chain files like a Secret's ca.crt often hold
+// one of either.
+func (s *Signer) appendChain(chain []*x509.Certificate) {
+	for _, cert := range chain {
+		if cert.Equal(s.Certificate) || isSelfSigned(cert) || slices.ContainsFunc(s.Chain, cert.Equal) {
+			continue
+		}
+		s.Chain = append(s.Chain, cert)
+	}
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// CheckValidity returns an error unless the certificate is valid at now.
+func (s *Signer) CheckValidity(now time.Time) error {
+	if now.Before(s.Certificate.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", s.Certificate.NotBefore)
+	}
+	if now.After(s.Certificate.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", s.Certificate.NotAfter)
+	}
+	return nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found in PEM data")
+	}
+	return certs, nil
+}
+
+func parsePrivateKey(data, passphrase []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found in PEM data")
+		}
+		der := block.Bytes
+		//nolint:staticcheck // Keys encrypted by OpenSSL's traditional format are still common.
+		if x509.IsEncryptedPEMBlock(block) {
+			if len(passphrase) == 0 {
+				return nil, errors.New("private key is encrypted, but no passphrase was given")
+			}
+			var err error
+			//nolint:staticcheck // See above.
+			if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+				return nil, fmt.Errorf("unable to decrypt private key: %w", err)
+			}
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(der)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(der)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				return nil, err
+			}
+			return pkcs8Signer(key)
+		case "ENCRYPTED PRIVATE KEY":
+			if len(passphrase) == 0 {
+				return nil, errors.New("private key is encrypted, but no passphrase was given")
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKey(der, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt private key: %w", err)
+			}
+			return pkcs8Signer(key)
+		}
+	}
+}
+
+func pkcs8Signer(key any) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
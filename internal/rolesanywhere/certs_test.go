@@ -0,0 +1,180 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// issueTestCertificate signs a certificate for key with the parent
+// certificate and key, or self-signs it when parent is nil.
+func issueTestCertificate(name string, key crypto.Signer, isCA bool,
+	parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert
+}
+
+func newTestECKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	return key
+}
+
+func encodeCertificates(certs ...*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+var _ = Describe("Signer certificates", func() {
+	var (
+		root, intermediate, leaf *x509.Certificate
+		leafKey                  *ecdsa.PrivateKey
+	)
+
+	BeforeEach(func() {
+		rootKey, intermediateKey := newTestECKey(), newTestECKey()
+		leafKey = newTestECKey()
+		root = issueTestCertificate("root", rootKey, true, nil, nil)
+		intermediate = issueTestCertificate("intermediate", intermediateKey, true, root, rootKey)
+		leaf = issueTestCertificate("leaf", leafKey, false, intermediate, intermediateKey)
+	})
+
+	It("should parse RSA and ECDSA keys in PKCS#1, PKCS#8 and SEC1 form", func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		rsaCert := issueTestCertificate("rsa", rsaKey, false, nil, nil)
+		rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+		Expect(err).NotTo(HaveOccurred())
+		ecSEC1, err := x509.MarshalECPrivateKey(leafKey)
+		Expect(err).NotTo(HaveOccurred())
+		ecPKCS8, err := x509.MarshalPKCS8PrivateKey(leafKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, pair := range []struct {
+			cert  *x509.Certificate
+			block *pem.Block
+		}{
+			{rsaCert, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+			{rsaCert, &pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}},
+			{leaf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSEC1}},
+			{leaf, &pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}},
+		} {
+			signer, err := ParseSigner(encodeCertificates(pair.cert), pem.EncodeToMemory(pair.block))
+			Expect(err).NotTo(HaveOccurred(), pair.block.Type)
+			Expect(signer.Certificate.Equal(pair.cert)).To(BeTrue())
+		}
+	})
+
+	It("should decrypt encrypted PKCS#8 and legacy OpenSSL keys with the passphrase", func() {
+		passphrase := []byte("correct horse")
+		encrypted, err := pkcs8.MarshalPrivateKey(leafKey, passphrase, nil)
+		Expect(err).NotTo(HaveOccurred())
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted})
+
+		_, err = ParseSigner(encodeCertificates(leaf), keyPEM)
+		Expect(err).To(MatchError(ContainSubstring("no passphrase")))
+		_, err = ParseEncryptedSigner(encodeCertificates(leaf), keyPEM, []byte("wrong"))
+		Expect(err).To(HaveOccurred())
+		signer, err := ParseEncryptedSigner(encodeCertificates(leaf), keyPEM, passphrase)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.PrivateKey.Public()).To(Equal(leafKey.Public()))
+
+		sec1, err := x509.MarshalECPrivateKey(leafKey)
+		Expect(err).NotTo(HaveOccurred())
+		//nolint:staticcheck // Tests keys in OpenSSL's traditional encrypted format.
+		legacy, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", sec1, passphrase, x509.PEMCipherAES256)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ParseEncryptedSigner(encodeCertificates(leaf), pem.EncodeToMemory(legacy), passphrase)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should read PKCS#12 bundles, leaving out the root", func() {
+		bundle, err := pkcs12.Modern.Encode(leafKey, leaf, []*x509.Certificate{intermediate, root}, "secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = ParsePKCS12Signer(bundle, []byte("wrong"))
+		Expect(err).To(MatchError(ContainSubstring("invalid PKCS#12 bundle")))
+		signer, err := ParsePKCS12Signer(bundle, []byte("secret"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Certificate.Equal(leaf)).To(BeTrue())
+		Expect(signer.Chain).To(HaveLen(1))
+		Expect(signer.Chain[0].Equal(intermediate)).To(BeTrue())
+	})
+
+	It("should append chain files without duplicates or roots", func() {
+		dir := GinkgoT().TempDir()
+		keyDer, err := x509.MarshalPKCS8PrivateKey(leafKey)
+		Expect(err).NotTo(HaveOccurred())
+		files := SignerFiles{
+			CertPath:   filepath.Join(dir, "tls.crt"),
+			KeyPath:    filepath.Join(dir, "tls.key"),
+			ChainPaths: []string{filepath.Join(dir, "ca.crt")},
+		}
+		Expect(os.WriteFile(files.CertPath, encodeCertificates(leaf, intermediate), 0o600)).To(Succeed())
+		Expect(os.WriteFile(files.KeyPath,
+			pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600)).To(Succeed())
+		Expect(os.WriteFile(files.ChainPaths[0], encodeCertificates(intermediate, root), 0o600)).To(Succeed())
+
+		signer, err := files.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Chain).To(HaveLen(1))
+		Expect(signer.Chain[0].Equal(intermediate)).To(BeTrue())
+
+		By("sending the intermediate from the chain file only")
+		Expect(os.WriteFile(files.CertPath, encodeCertificates(leaf), 0o600)).To(Succeed())
+		signer, err = files.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Chain).To(HaveLen(1))
+		Expect(signer.Chain[0].Equal(intermediate)).To(BeTrue())
+	})
+})
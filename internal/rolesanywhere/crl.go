@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Crl is a certificate revocation list imported into Roles Anywhere.
+type Crl struct {
+	CrlID          string `json:"crlId"`
+	CrlArn         string `json:"crlArn"`
+	Name           string `json:"name"`
+	Enabled        bool   `json:"enabled"`
+	TrustAnchorArn string `json:"trustAnchorArn"`
+}
+
+type crlOutput struct {
+	Crl Crl `json:"crl"`
+}
+
+// CrlClient calls the Roles Anywhere ImportCrl and UpdateCrl APIs with
+// temporary credentials.
+type CrlClient struct {
+	Credentials CredentialsProvider
+	HTTPClient  *http.Client
+	// Endpoint overrides the regional endpoint derived from the trust anchor ARN.
+	Endpoint string
+}
+
+// ImportCrl imports a CRL for the trust anchor, enabled, and returns it. The
+// CRL must be signed by a CA of the trust anchor.
+func (c *CrlClient) ImportCrl(ctx context.Context, name, trustAnchorArn string, crlData []byte) (*Crl, error) {
+	return c.call(ctx, "ImportCrl", http.MethodPost, "/crls", trustAnchorArn, map[string]any{
+		"name":           name,
+		"crlData":        crlData,
+		"trustAnchorArn": trustAnchorArn,
+		"enabled":        true,
+	})
+}
+
+// UpdateCrl replaces the content of the CRL crlID, which belongs to the trust
+// anchor.
+func (c *CrlClient) UpdateCrl(ctx context.Context, crlID, trustAnchorArn string, crlData []byte) (*Crl, error) {
+	return c.call(ctx, "UpdateCrl", http.MethodPatch, "/crl/"+url.PathEscape(crlID), trustAnchorArn,
+		map[string]any{"crlData": crlData})
+}
+
+func (c *CrlClient) call(
+	ctx context.Context, operation, method, path, trustAnchorArn string, input any,
+) (*Crl, error) {
+	if c.Credentials == nil {
+		return nil, errors.New("client has no credentials")
+	}
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, region, err := endpointFor(trustAnchorArn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust anchor ARN: %w", err)
+	}
+	if c.Endpoint != "" {
+		endpoint = c.Endpoint
+	}
+
+	// []byte fields, like crlData, are encoded as base64 blobs.
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	payloadHash := sha256.Sum256(payload)
+	if err := v4.NewSigner().SignHTTP(ctx, aws.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, req, hex.EncodeToString(payloadHash[:]), serviceName, region, time.Now()); err != nil {
+		return nil, fmt.Errorf("unable to sign request: %w", err)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed with status %d: %s", operation, resp.StatusCode, string(body))
+	}
+
+	var output crlOutput
+	if err := json.Unmarshal(body, &output); err != nil {
+		return nil, fmt.Errorf("unable to parse %s response: %w", operation, err)
+	}
+	if output.Crl.CrlID == "" {
+		return nil, fmt.Errorf("%s returned no CRL", operation)
+	}
+	return &output.Crl, nil
+}
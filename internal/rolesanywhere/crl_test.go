@@ -0,0 +1,143 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type staticProvider struct {
+	creds Credentials
+}
+
+func (p staticProvider) Retrieve(context.Context) (*Credentials, error) {
+	return &p.creds, nil
+}
+
+// fakeCrlAPI stands in for the Roles Anywhere CRL API: it checks the request
+// signature by signing the request again with the expected credentials, and
+// keeps the imported CRLs by ID.
+type fakeCrlAPI struct {
+	*httptest.Server
+	creds Credentials
+
+	mu   sync.Mutex
+	crls map[string]map[string]any
+}
+
+func newFakeCrlAPI(creds Credentials) *fakeCrlAPI {
+	api := &fakeCrlAPI{creds: creds, crls: map[string]map[string]any{}}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+func (a *fakeCrlAPI) serve(w http.ResponseWriter, r *http.Request) {
+	defer GinkgoRecover()
+	body, err := io.ReadAll(r.Body)
+	Expect(err).NotTo(HaveOccurred())
+
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	Expect(err).NotTo(HaveOccurred())
+	expected, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.Path, nil)
+	Expect(err).NotTo(HaveOccurred())
+	expected.ContentLength = r.ContentLength
+	expected.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	payloadHash := sha256.Sum256(body)
+	Expect(v4.NewSigner().SignHTTP(context.Background(), aws.Credentials{
+		AccessKeyID:     a.creds.AccessKeyID,
+		SecretAccessKey: a.creds.SecretAccessKey,
+		SessionToken:    a.creds.SessionToken,
+	}, expected, hex.EncodeToString(payloadHash[:]), "rolesanywhere", "us-west-2", signedAt)).To(Succeed())
+	if r.Header.Get("Authorization") != expected.Header.Get("Authorization") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var input map[string]any
+	Expect(json.Unmarshal(body, &input)).To(Succeed())
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var crl map[string]any
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/crls":
+		input["crlId"] = "crl-1"
+		a.crls["crl-1"] = input
+		crl = input
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/crl/"):
+		if crl = a.crls[strings.TrimPrefix(r.URL.Path, "/crl/")]; crl == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		crl["crlData"] = input["crlData"]
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"crl": crl})
+}
+
+var _ = Describe("CrlClient", func() {
+	const trustAnchorArn = "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta"
+	creds := Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+
+	It("should import a CRL and update it", func() {
+		api := newFakeCrlAPI(creds)
+		defer api.Close()
+		client := &CrlClient{Credentials: staticProvider{creds}, Endpoint: api.URL}
+
+		crl, err := client.ImportCrl(context.Background(), "iamram", trustAnchorArn, []byte("first"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crl.CrlID).To(Equal("crl-1"))
+		Expect(crl.Enabled).To(BeTrue())
+		Expect(crl.TrustAnchorArn).To(Equal(trustAnchorArn))
+		// crlData is a base64 blob
+		Expect(api.crls["crl-1"]["crlData"]).To(Equal("Zmlyc3Q="))
+
+		_, err = client.UpdateCrl(context.Background(), "crl-1", trustAnchorArn, []byte("second"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.crls["crl-1"]["crlData"]).To(Equal("c2Vjb25k"))
+
+		_, err = client.UpdateCrl(context.Background(), "crl-2", trustAnchorArn, []byte("second"))
+		Expect(err).To(MatchError(ContainSubstring("UpdateCrl failed with status 404")))
+	})
+
+	It("should fail with the wrong credentials", func() {
+		api := newFakeCrlAPI(creds)
+		defer api.Close()
+		client := &CrlClient{
+			Credentials: staticProvider{Credentials{AccessKeyID: "AKID", SecretAccessKey: "wrong"}},
+			Endpoint:    api.URL,
+		}
+		_, err := client.ImportCrl(context.Background(), "iamram", trustAnchorArn, []byte("crl"))
+		Expect(err).To(MatchError(ContainSubstring("ImportCrl failed with status 403")))
+	})
+})
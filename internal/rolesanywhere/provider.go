@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RefreshWindow is how long before expiry cached credentials are replaced.
+const RefreshWindow = 5 * time.Minute
+
+const (
+	// defaultDurationSeconds is the duration of sessions that don't set one.
+	defaultDurationSeconds = 3600
+	// minDurationSeconds is the shortest session CreateSession accepts.
+	minDurationSeconds = 900
+)
+
+// CredentialsProvider returns AWS credentials, refreshing them as needed.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (*Credentials, error)
+}
+
+// SessionProvider caches the credentials of a CreateSession call until they
+// are close to expiring.
+type SessionProvider struct {
+	Client *Client
+	Input  SessionInput
+	// Until, if set, is when the credentials stop being served. Sessions are
+	// requested for no longer than what is left until then, within the
+	// shortest duration CreateSession accepts, and their expiration is
+	// reported as Until at the latest.
+	Until time.Time
+
+	mu     sync.Mutex
+	cached *Credentials
+}
+
+var _ CredentialsProvider = &SessionProvider{}
+
+func (p *SessionProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.Until.IsZero() && !time.Now().Before(p.Until) {
+		return nil, fmt.Errorf("credentials may only be served until %s", p.Until.UTC().Format(time.RFC3339))
+	}
+	if p.cached == nil || time.Until(p.cached.Expiration) <= RefreshWindow {
+		creds, err := p.Client.CreateSession(ctx, p.sessionInput())
+		if err != nil {
+			return nil, err
+		}
+		p.cached = creds
+	}
+	return CapExpiration(p.cached, p.Until), nil
+}
+
+func (p *SessionProvider) sessionInput() SessionInput {
+	input := p.Input
+	if p.Until.IsZero() {
+		return input
+	}
+	duration := input.DurationSeconds
+	if duration == 0 {
+		duration = defaultDurationSeconds
+	}
+	left := int32(time.Until(p.Until).Seconds()) + 1
+	input.DurationSeconds = min(duration, max(left, minDurationSeconds))
+	return input
+}
+
+// CapExpiration returns creds with their expiration moved to until if they
+// expire later, so that clients refresh them by then. A zero until leaves
+// them as they are.
+func CapExpiration(creds *Credentials, until time.Time) *Credentials {
+	if until.IsZero() || !creds.Expiration.After(until) {
+		return creds
+	}
+	capped := *creds
+	capped.Expiration = until
+	return &capped
+}
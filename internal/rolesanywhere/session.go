@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rolesanywhere is a client of IAM Roles Anywhere: it signs
+// CreateSession calls with the AWS4-X509 scheme and turns the sessions into
+// AWS credentials. It takes the place of aws_signing_helper, which only signs
+// with certificates and keys read from files and runs as a separate process,
+// because the same client serves the sidecar, whose requested certificates
+// keep their keys in memory and whose mounted certificates are checked
+// before being swapped in, and the controller, broker and node agent, which
+// create sessions for pods in-process and cap them at the end of the
+// profile's activation window.
+package rolesanywhere
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// SessionInput holds the parameters of a CreateSession call.
+type SessionInput struct {
+	TrustAnchorArn  string `json:"trustAnchorArn"`
+	ProfileArn      string `json:"profileArn"`
+	RoleArn         string `json:"roleArn"`
+	DurationSeconds int32  `json:"durationSeconds,omitempty"`
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+}
+
+// Credentials are the temporary AWS credentials returned by CreateSession.
+type Credentials struct {
+	AccessKeyID     string    `json:"accessKeyId"`
+	SecretAccessKey string    `json:"secretAccessKey"`
+	SessionToken    string    `json:"sessionToken"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+type createSessionOutput struct {
+	CredentialSet []struct {
+		Credentials Credentials `json:"credentials"`
+	} `json:"credentialSet"`
+}
+
+// Client calls the IAM Roles Anywhere CreateSession API.
+type Client struct {
+	Signer     *Signer
+	HTTPClient *http.Client
+	// Endpoint overrides the regional endpoint derived from the trust anchor ARN.
+	Endpoint string
+}
+
+// Region returns the region of the given trust anchor ARN.
+func Region(trustAnchorArn string) (string, error) {
+	parsed, err := arn.Parse(trustAnchorArn)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Region == "" {
+		return "", fmt.Errorf("ARN %s has no region", trustAnchorArn)
+	}
+	return parsed.Region, nil
+}
+
+func endpointFor(trustAnchorArn string) (string, string, error) {
+	parsed, err := arn.Parse(trustAnchorArn)
+	if err != nil {
+		return "", "", err
+	}
+	domain := "amazonaws.com"
+	if parsed.Partition == "aws-cn" {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://rolesanywhere.%s.%s", parsed.Region, domain), parsed.Region, nil
+}
+
+// CreateSession exchanges the signer's certificate for temporary credentials.
+func (c *Client) CreateSession(ctx context.Context, input SessionInput) (*Credentials, error) {
+	if c.Signer == nil {
+		return nil, errors.New("client has no signer")
+	}
+	endpoint, region, err := endpointFor(input.TrustAnchorArn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust anchor ARN: %w", err)
+	}
+	if c.Endpoint != "" {
+		endpoint = c.Endpoint
+	}
+
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/sessions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.Signer.Sign(req, payload, region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CreateSession failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var output createSessionOutput
+	if err := json.Unmarshal(body, &output); err != nil {
+		return nil, fmt.Errorf("unable to parse CreateSession response: %w", err)
+	}
+	if len(output.CredentialSet) == 0 {
+		return nil, errors.New("CreateSession returned no credentials")
+	}
+	return &output.CredentialSet[0].Credentials, nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func newTestSigner(key crypto.Signer) *Signer {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return &Signer{Certificate: cert, PrivateKey: key}
+}
+
+var authorizationPattern = regexp.MustCompile(
+	`^(\S+) Credential=(\d+)/(\d{8})/([a-z0-9-]+)/rolesanywhere/aws4_request, SignedHeaders=(\S+), Signature=([0-9a-f]+)$`)
+
+// referenceCanonicalHeaders canonicalizes the named headers of r the way the
+// Signature Version 4 documentation describes it, independently of the
+// signer: one "name:value" line per header, with its values trimmed, runs of
+// spaces collapsed and values of repeated headers joined with commas.
+func referenceCanonicalHeaders(r *http.Request, names []string) string {
+	spaces := regexp.MustCompile(`\s+`)
+	var lines []string
+	for _, name := range names {
+		values := []string{r.Host}
+		if name != "host" {
+			values = nil
+			for key, headerValues := range r.Header {
+				if strings.EqualFold(key, name) {
+					values = append(values, headerValues...)
+				}
+			}
+		}
+		for i, value := range values {
+			values[i] = spaces.ReplaceAllString(strings.TrimSpace(value), " ")
+		}
+		lines = append(lines, name+":"+strings.Join(values, ",")+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+// fakeRolesAnywhere verifies request signatures the way the real service does
+// and returns fixed credentials.
+func fakeRolesAnywhere(expiration time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		Expect(r.URL.Path).To(Equal("/sessions"))
+		body, err := io.ReadAll(r.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		der, err := base64.StdEncoding.DecodeString(r.Header.Get(x509Header))
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+
+		match := authorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+		Expect(match).NotTo(BeNil())
+		Expect(match[2]).To(Equal(cert.SerialNumber.String()))
+
+		signedHeaders := match[5]
+		Expect(signedHeaders).To(Equal("content-type;host;x-amz-date;x-amz-x509"))
+		canonicalHeaders := referenceCanonicalHeaders(r, strings.Split(signedHeaders, ";"))
+		payloadHash := sha256.Sum256(body)
+		canonicalRequest := strings.Join([]string{r.Method, "/sessions", "", canonicalHeaders,
+			signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+		requestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := strings.Join([]string{match[1], r.Header.Get(dateHeader),
+			match[3] + "/" + match[4] + "/rolesanywhere/aws4_request", hex.EncodeToString(requestHash[:])}, "\n")
+		digest := sha256.Sum256([]byte(stringToSign))
+		signature, err := hex.DecodeString(match[6])
+		Expect(err).NotTo(HaveOccurred())
+
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			Expect(match[1]).To(Equal("AWS4-X509-RSA-SHA256"))
+			Expect(rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)).To(Succeed())
+		case *ecdsa.PublicKey:
+			Expect(match[1]).To(Equal("AWS4-X509-ECDSA-SHA256"))
+			Expect(ecdsa.VerifyASN1(pub, digest[:], signature)).To(BeTrue())
+		}
+
+		var input SessionInput
+		Expect(json.Unmarshal(body, &input)).To(Succeed())
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"credentialSet": []map[string]any{{
+				"credentials": map[string]any{
+					"accessKeyId":     "AKIA",
+					"secretAccessKey": "secret",
+					"sessionToken":    input.RoleArn,
+					"expiration":      expiration.UTC().Format(time.RFC3339),
+				},
+			}},
+		})
+	}))
+}
+
+var _ = Describe("Signer", func() {
+	It("Should sign the canonical request of the AWS4-X509 scheme", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		signer := newTestSigner(key)
+		payload := []byte("{}")
+		req := httptest.NewRequest(http.MethodPost, "https://rolesanywhere.us-east-1.amazonaws.com/sessions", nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Custom", "  a   b ")
+		Expect(signer.Sign(req, payload, "us-east-1", time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC))).
+			To(Succeed())
+
+		// Written out by hand from the AWS documentation, rather than with the
+		// signer's helpers.
+		canonicalRequest := "POST\n" +
+			"/sessions\n" +
+			"\n" +
+			"content-type:application/json\n" +
+			"host:rolesanywhere.us-east-1.amazonaws.com\n" +
+			"x-amz-date:20240102T030405Z\n" +
+			"x-amz-x509:" + base64.StdEncoding.EncodeToString(signer.Certificate.Raw) + "\n" +
+			"x-custom:a b\n" +
+			"\n" +
+			"content-type;host;x-amz-date;x-amz-x509;x-custom\n" +
+			// SHA-256 of "{}".
+			"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+		requestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := "AWS4-X509-RSA-SHA256\n" +
+			"20240102T030405Z\n" +
+			"20240102/us-east-1/rolesanywhere/aws4_request\n" +
+			hex.EncodeToString(requestHash[:])
+
+		prefix := "AWS4-X509-RSA-SHA256 Credential=42/20240102/us-east-1/rolesanywhere/aws4_request, " +
+			"SignedHeaders=content-type;host;x-amz-date;x-amz-x509;x-custom, Signature="
+		authorization := req.Header.Get("Authorization")
+		Expect(authorization).To(HavePrefix(prefix))
+		signature, err := hex.DecodeString(strings.TrimPrefix(authorization, prefix))
+		Expect(err).NotTo(HaveOccurred())
+		digest := sha256.Sum256([]byte(stringToSign))
+		Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature)).To(Succeed())
+	})
+})
+
+var _ = Describe("CreateSession", func() {
+	input := SessionInput{
+		TrustAnchorArn: "arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/ta",
+		ProfileArn:     "arn:aws:rolesanywhere:us-east-1:123456789012:profile/p",
+		RoleArn:        "arn:aws:iam::123456789012:role/r",
+	}
+
+	It("Should sign requests with an RSA key", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		server := fakeRolesAnywhere(time.Now().Add(time.Hour))
+		defer server.Close()
+
+		client := &Client{Signer: newTestSigner(key), Endpoint: server.URL}
+		creds, err := client.CreateSession(context.Background(), input)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.AccessKeyID).To(Equal("AKIA"))
+		Expect(creds.SessionToken).To(Equal(input.RoleArn))
+	})
+
+	It("Should sign requests with an ECDSA key", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		server := fakeRolesAnywhere(time.Now().Add(time.Hour))
+		defer server.Close()
+
+		client := &Client{Signer: newTestSigner(key), Endpoint: server.URL}
+		_, err = client.CreateSession(context.Background(), input)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should derive the region from the trust anchor ARN", func() {
+		Expect(Region(input.TrustAnchorArn)).To(Equal("us-east-1"))
+		_, err := Region("not-an-arn")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should cache credentials until they are close to expiring", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		server := fakeRolesAnywhere(time.Now().Add(time.Hour))
+		defer server.Close()
+
+		provider := &SessionProvider{
+			Client: &Client{Signer: newTestSigner(key), Endpoint: server.URL},
+			Input:  input,
+		}
+		first, err := provider.Retrieve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		second, err := provider.Retrieve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+	It("Should not serve credentials beyond Until", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		server := fakeRolesAnywhere(time.Now().Add(time.Hour))
+		defer server.Close()
+
+		until := time.Now().Add(20 * time.Minute).Truncate(time.Second)
+		provider := &SessionProvider{
+			Client: &Client{Signer: newTestSigner(key), Endpoint: server.URL},
+			Input:  input,
+			Until:  until,
+		}
+		Expect(provider.sessionInput().DurationSeconds).To(BeNumerically("~", 20*60, 2))
+		creds, err := provider.Retrieve(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.Expiration).To(BeTemporally("==", until))
+
+		provider.Until = time.Now().Add(time.Minute)
+		Expect(provider.sessionInput().DurationSeconds).To(BeEquivalentTo(minDurationSeconds))
+		provider.Until = time.Now().Add(-time.Second)
+		_, err = provider.Retrieve(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("may only be served until")))
+	})
+})
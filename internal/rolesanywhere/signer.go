@@ -0,0 +1,161 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rolesanywhere
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+	serviceName     = "rolesanywhere"
+
+	x509Header      = "X-Amz-X509"
+	x509ChainHeader = "X-Amz-X509-Chain"
+	dateHeader      = "X-Amz-Date"
+)
+
+// Signer signs IAM Roles Anywhere requests with an X.509 certificate and its
+// private key, following the AWS4-X509 variant of Signature Version 4.
+type Signer struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	PrivateKey  crypto.Signer
+}
+
+func (s *Signer) algorithm() (string, error) {
+	switch s.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return "AWS4-X509-RSA-SHA256", nil
+	case *ecdsa.PublicKey:
+		return "AWS4-X509-ECDSA-SHA256", nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", s.PrivateKey.Public())
+	}
+}
+
+// Sign adds the X.509 and Authorization headers to req. The payload must be
+// the exact request body.
+func (s *Signer) Sign(req *http.Request, payload []byte, region string, now time.Time) error {
+	if s.Certificate == nil || s.PrivateKey == nil {
+		return errors.New("signer requires a certificate and a private key")
+	}
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return err
+	}
+
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	req.Header.Set(dateHeader, amzDate)
+	req.Header.Set(x509Header, base64.StdEncoding.EncodeToString(s.Certificate.Raw))
+	if len(s.Chain) > 0 {
+		var encoded []string
+		for _, cert := range s.Chain {
+			encoded = append(encoded, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		req.Header.Set(x509ChainHeader, strings.Join(encoded, ","))
+	}
+
+	names := signedHeaderNames(req)
+	signedHeaders := strings.Join(names, ";")
+	canonicalHeaders := canonicalizeHeaders(req, names)
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(shortDateFormat), region, serviceName, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := s.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("unable to sign request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.Certificate.SerialNumber.String(), scope, signedHeaders, hex.EncodeToString(signature)))
+	return nil
+}
+
+func canonicalURI(req *http.Request) string {
+	if path := req.URL.EscapedPath(); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// signedHeaderNames returns the sorted, lower-cased names of the headers to
+// sign: host plus every header set on the request before signing.
+func signedHeaderNames(req *http.Request) []string {
+	names := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if name != "authorization" && name != "user-agent" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func canonicalizeHeaders(req *http.Request, names []string) string {
+	var canonical strings.Builder
+	for _, name := range names {
+		value := req.Host
+		if name != "host" {
+			var trimmed []string
+			for _, v := range req.Header.Values(name) {
+				trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(trimmed, ",")
+		} else if value == "" {
+			value = req.URL.Host
+		}
+		canonical.WriteString(name)
+		canonical.WriteString(":")
+		canonical.WriteString(value)
+		canonical.WriteString("\n")
+	}
+	return canonical.String()
+}
@@ -0,0 +1,117 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+)
+
+// BrokerCAEnvVar holds the PEM-encoded certificates the sidecar verifies the
+// credential broker's serving certificate with, instead of the system roots.
+const BrokerCAEnvVar = "IAMRAM_BROKER_CA"
+
+// BrokerClient gets the credentials of the pod's profiles from the credential
+// broker, authenticating with a projected service account token.
+type BrokerClient struct {
+	URL string
+	// TokenPath is read for every request, since kubelet rotates the token.
+	TokenPath  string
+	HTTPClient *http.Client
+}
+
+// NewBrokerClient returns a client for the broker at brokerURL, trusting the
+// PEM-encoded caPEM if set.
+func NewBrokerClient(brokerURL, tokenPath string, caPEM []byte) (*BrokerClient, error) {
+	c := &BrokerClient{URL: brokerURL, TokenPath: tokenPath}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in the broker CA")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		c.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+	return c, nil
+}
+
+// Credentials returns credentials for the profile.
+func (c *BrokerClient) Credentials(ctx context.Context, profile string) (*rolesanywhere.Credentials, error) {
+	token, err := os.ReadFile(c.TokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %w", err)
+	}
+	endpoint := strings.TrimSuffix(c.URL, "/") + v1.BrokerCredentialsPath + "?profile=" + url.QueryEscape(profile)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("broker refused credentials with status %d: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var creds rolesanywhere.Credentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return nil, fmt.Errorf("unable to parse broker response: %w", err)
+	}
+	return &creds, nil
+}
+
+// brokerProvider caches the credentials of a profile from the broker until
+// they are close to expiring. The broker caps them to the activation window
+// of the profile, which the provider also stops serving them after.
+type brokerProvider struct {
+	client  *BrokerClient
+	profile string
+	until   time.Time
+
+	mu     sync.Mutex
+	cached *rolesanywhere.Credentials
+}
+
+func (p *brokerProvider) Retrieve(ctx context.Context) (*rolesanywhere.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.until.IsZero() && !time.Now().Before(p.until) {
+		return nil, fmt.Errorf("profile %s is outside its activation window", p.profile)
+	}
+	if p.cached != nil && time.Until(p.cached.Expiration) > rolesanywhere.RefreshWindow {
+		return p.cached, nil
+	}
+	creds, err := p.client.Credentials(ctx, p.profile)
+	if err != nil {
+		return nil, err
+	}
+	p.cached = creds
+	return creds, nil
+}
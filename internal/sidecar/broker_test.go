@@ -0,0 +1,94 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBroker serves credentials to requests with the token, counting them.
+func fakeBroker(token string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		Expect(r.URL.Path).To(Equal(v1.BrokerCredentialsPath))
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		*requests++
+		_ = json.NewEncoder(w).Encode(rolesanywhere.Credentials{
+			AccessKeyID:     "AKIA" + r.URL.Query().Get("profile"),
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+			Expiration:      time.Now().Add(time.Hour),
+		})
+	}))
+}
+
+var _ = Describe("BrokerClient", func() {
+	It("should serve credentials from the broker with the current token", func() {
+		var requests int
+		broker := fakeBroker("rotated", &requests)
+		defer broker.Close()
+		tokenPath := filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenPath, []byte("expired\n"), 0o600)).To(Succeed())
+		client, err := NewBrokerClient(broker.URL, tokenPath, nil)
+		Expect(err).NotTo(HaveOccurred())
+		server, err := NewBrokerProfileServer(ProfileConfig{
+			Name:           "reader",
+			TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:        "arn:aws:iam::123456789012:role/reader",
+		}, filepath.Join(GinkgoT().TempDir(), "config.env"), client, imds.Options{}, logr.Discard())
+		Expect(err).NotTo(HaveOccurred())
+
+		get := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+				"/latest/meta-data/iam/security-credentials/reader", nil))
+			return recorder
+		}
+		Expect(get().Code).To(Equal(http.StatusInternalServerError))
+
+		By("reading the token rotated by kubelet")
+		Expect(os.WriteFile(tokenPath, []byte("rotated\n"), 0o600)).To(Succeed())
+		response := get()
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(ContainSubstring("AKIAreader"))
+
+		By("caching the credentials until they are close to expiring")
+		Expect(get().Code).To(Equal(http.StatusOK))
+		Expect(requests).To(Equal(1))
+	})
+
+	It("should reject a broker CA without certificates", func() {
+		_, err := NewBrokerClient("https://broker", "token", []byte("not a certificate"))
+		Expect(err).To(MatchError(ContainSubstring("no certificates")))
+	})
+})
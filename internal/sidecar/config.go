@@ -0,0 +1,179 @@
+package sidecar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AdditionalProfilesEnvVar holds a JSON list of ProfileConfig for the
+	// profiles served next to the pod's default profile.
+	AdditionalProfilesEnvVar = "IAMRAM_ADDITIONAL_PROFILES"
+	// DefaultPort is the port of the pod's default profile. Additional
+	// profiles are served on the ports following it.
+	DefaultPort = 9911
+)
+
+// ProfileConfig is the configuration of the credential server for a single
+// profile. The fields mirror the options of the serve-credentials and
+// update-config scripts.
+type ProfileConfig struct {
+	Name            string `json:"name"`
+	Port            int    `json:"port"`
+	TrustAnchorArn  string `json:"trustAnchorArn"`
+	ProfileArn      string `json:"profileArn"`
+	RoleArn         string `json:"roleArn"`
+	DurationSeconds int32  `json:"durationSeconds,omitempty"`
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+	ImdsV2Only      bool   `json:"imdsV2Only,omitempty"`
+	// Suspended and ActiveUntil are the state the server starts in, until
+	// suspend-profile overrides it.
+	Suspended   bool       `json:"suspended,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+}
+
+func (c ProfileConfig) validate() error {
+	if c.TrustAnchorArn == "" || c.ProfileArn == "" || c.RoleArn == "" {
+		return fmt.Errorf("profile %q: trust anchor, profile and role ARNs are required", c.Name)
+	}
+	return nil
+}
+
+// ConfigFilePath is where update-config writes the overrides for an
+// additional profile. The default profile keeps the location used by older
+// sidecars, which is returned for an empty name.
+func ConfigFilePath(dir, name string) string {
+	if name == "" {
+		return filepath.Join(dir, "config.env")
+	}
+	return filepath.Join(dir, "config.d", name+".env")
+}
+
+// ReadConfigFile returns cfg updated with the settings from a config file
+// written by update-config. Optional settings missing from the file are reset.
+func ReadConfigFile(path string, cfg ProfileConfig) (ProfileConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	updated := ProfileConfig{Name: cfg.Name, Port: cfg.Port, Suspended: cfg.Suspended, ActiveUntil: cfg.ActiveUntil}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "trust_anchor_arn":
+			updated.TrustAnchorArn = value
+		case "profile_arn":
+			updated.ProfileArn = value
+		case "role_arn":
+			updated.RoleArn = value
+		case "duration_seconds":
+			duration, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid duration_seconds %q: %w", value, err)
+			}
+			updated.DurationSeconds = int32(duration)
+		case "role_session_name":
+			updated.RoleSessionName = value
+		case "imds_v2_only":
+			updated.ImdsV2Only = value == "true"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return cfg, err
+	}
+	if err := updated.validate(); err != nil {
+		return cfg, err
+	}
+	return updated, nil
+}
+
+// RevokedFilePath is where revoke-certificates writes the serial numbers of
+// revoked certificates.
+func RevokedFilePath(dir string) string {
+	return filepath.Join(dir, "revoked")
+}
+
+// ReadRevokedFile returns the serial numbers, in the form of
+// pki.FormatSerial, listed in a file written by revoke-certificates. A missing
+// file means no certificate is revoked.
+func ReadRevokedFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revoked := map[string]bool{}
+	for _, line := range strings.Fields(string(data)) {
+		revoked[strings.ToLower(line)] = true
+	}
+	return revoked, nil
+}
+
+// SuspendedDirPath is where suspend-profile writes whether profiles are
+// suspended, one file per profile.
+func SuspendedDirPath(dir string) string {
+	return filepath.Join(dir, "suspended.d")
+}
+
+// ServingState is whether the server serves a profile's credentials, as
+// recorded by suspend-profile.
+type ServingState struct {
+	Suspended bool
+	// Until is when the server stops serving them, zero for never.
+	Until time.Time
+}
+
+// ReadSuspendedDir returns the state suspend-profile recorded for each
+// profile in dir, by profile name. Profiles missing from it keep the state
+// they were started with.
+func ReadSuspendedDir(dir string) (map[string]ServingState, error) {
+	entries, err := os.ReadDir(dir)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]ServingState{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		// The file holds true or false, then the time the profile is served
+		// until, if any.
+		fields := strings.Fields(string(data))
+		var state ServingState
+		if len(fields) > 0 {
+			state.Suspended = fields[0] == "true"
+		}
+		if len(fields) > 1 {
+			if state.Until, err = time.Parse(time.RFC3339, fields[1]); err != nil {
+				return nil, fmt.Errorf("invalid state of profile %s: %w", entry.Name(), err)
+			}
+		}
+		states[entry.Name()] = state
+	}
+	return states, nil
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config files", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("Should keep the legacy location for the default profile", func() {
+		Expect(ConfigFilePath(dir, "")).To(Equal(filepath.Join(dir, "config.env")))
+		Expect(ConfigFilePath(dir, "writer")).To(Equal(filepath.Join(dir, "config.d", "writer.env")))
+	})
+
+	It("Should apply the settings written by update-config", func() {
+		path := filepath.Join(dir, "config.env")
+		Expect(os.WriteFile(path, []byte("trust_anchor_arn=ta\nprofile_arn=p\nrole_arn=r\n"+
+			"duration_seconds=900\nimds_v2_only=true\n"), 0o600)).To(Succeed())
+
+		cfg, err := ReadConfigFile(path, ProfileConfig{
+			Name: "reader", Port: 9912, TrustAnchorArn: "old", ProfileArn: "old", RoleArn: "old",
+			RoleSessionName: "old-session",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(Equal(ProfileConfig{
+			Name: "reader", Port: 9912, TrustAnchorArn: "ta", ProfileArn: "p", RoleArn: "r",
+			DurationSeconds: 900, ImdsV2Only: true,
+		}))
+	})
+
+	It("Should reject config files missing required settings", func() {
+		path := filepath.Join(dir, "config.env")
+		Expect(os.WriteFile(path, []byte("role_arn=r\n"), 0o600)).To(Succeed())
+		original := ProfileConfig{Name: "reader", TrustAnchorArn: "ta", ProfileArn: "p", RoleArn: "old"}
+		cfg, err := ReadConfigFile(path, original)
+		Expect(err).To(HaveOccurred())
+		Expect(cfg).To(Equal(original))
+	})
+})
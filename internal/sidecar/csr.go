@@ -0,0 +1,99 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	csrPollInterval    = 2 * time.Second
+	csrTimeout         = 5 * time.Minute
+	renewRetryInterval = 30 * time.Second
+)
+
+// CertificateRequester obtains the sidecar's certificate through a Kubernetes
+// CertificateSigningRequest. The private key is generated in memory, and
+// never leaves the pod.
+type CertificateRequester struct {
+	Client   kubernetes.Interface
+	Identity pki.Identity
+	Logger   logr.Logger
+}
+
+// Request generates a new private key and waits for the controller to sign a
+// certificate for it.
+func (r *CertificateRequester) Request(ctx context.Context) (*rolesanywhere.Signer, error) {
+	key, request, err := pki.NewCertificateRequest(r.Identity)
+	if err != nil {
+		return nil, err
+	}
+	csrs := r.Client.CertificatesV1().CertificateSigningRequests()
+	csr, err := csrs.Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: fmt.Sprintf("iamra-%s-", r.Identity.Pod)},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    request,
+			SignerName: v1.CertificateSignerName,
+			Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageClientAuth},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create certificate signing request: %w", err)
+	}
+	r.Logger.Info("requested certificate", "csr", csr.Name)
+
+	var certPEM []byte
+	err = wait.PollUntilContextTimeout(ctx, csrPollInterval, csrTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := csrs.Get(ctx, csr.Name, metav1.GetOptions{})
+		if err != nil {
+			r.Logger.Error(err, "unable to get certificate signing request", "csr", csr.Name)
+			return false, nil
+		}
+		for _, condition := range current.Status.Conditions {
+			if condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed {
+				return false, fmt.Errorf("certificate signing request %s %s: %s",
+					csr.Name, strings.ToLower(string(condition.Type)), condition.Message)
+			}
+		}
+		certPEM = current.Status.Certificate
+		return len(certPEM) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rolesanywhere.NewSigner(certPEM, key)
+}
+
+// Renew requests a new certificate whenever the current one is due for
+// renewal, and passes it to update, until ctx is done.
+func (r *CertificateRequester) Renew(ctx context.Context, current *rolesanywhere.Signer,
+	update func(*rolesanywhere.Signer)) {
+	renewAt := pki.RenewAt(current.Certificate)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(renewAt)):
+		}
+		renewed, err := r.Request(ctx)
+		if err != nil {
+			r.Logger.Error(err, "unable to renew certificate, will retry", "notAfter", current.Certificate.NotAfter)
+			renewAt = time.Now().Add(renewRetryInterval)
+			continue
+		}
+		r.Logger.Info("renewed certificate", "notAfter", renewed.Certificate.NotAfter)
+		current = renewed
+		renewAt = pki.RenewAt(current.Certificate)
+		update(current)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestCA() *pki.CA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := pki.ParseCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+	Expect(err).NotTo(HaveOccurred())
+	return ca
+}
+
+// fakeSigner answers certificate signing requests as soon as they are
+// created, by signing them or, when deny is set, denying them.
+func fakeSigner(ca *pki.CA, deny bool) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "certificatesigningrequests",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			defer GinkgoRecover()
+			csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+			Expect(csr.Spec.SignerName).To(Equal(v1.CertificateSignerName))
+			csr.Name = csr.GenerateName + "abcde"
+			if deny {
+				csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+					Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue, Message: "not allowed",
+				})
+				return false, nil, nil
+			}
+			request, err := pki.ParseCertificateRequest(csr.Spec.Request)
+			Expect(err).NotTo(HaveOccurred())
+			id, err := pki.IdentityFromSubject(request.Subject)
+			Expect(err).NotTo(HaveOccurred())
+			csr.Status.Certificate, err = ca.Sign(id, "cluster.local", request.PublicKey, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			return false, nil, nil
+		})
+	return clientset
+}
+
+var _ = Describe("CertificateRequester", func() {
+	id := pki.Identity{Namespace: "team-a", ServiceAccount: "builder", Pod: "builder-0"}
+
+	It("Should return a signer for the signed certificate", func() {
+		ca := newTestCA()
+		requester := &CertificateRequester{Client: fakeSigner(ca, false), Identity: id, Logger: logr.Discard()}
+		signer, err := requester.Request(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Certificate.Subject.CommonName).To(Equal("builder-0"))
+		Expect(signer.Chain).To(ConsistOf(ca.Certificate))
+	})
+
+	It("Should fail when the request is denied", func() {
+		requester := &CertificateRequester{Client: fakeSigner(newTestCA(), true), Identity: id, Logger: logr.Discard()}
+		_, err := requester.Request(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("denied: not allowed")))
+	})
+})
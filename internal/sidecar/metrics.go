@@ -0,0 +1,40 @@
+package sidecar
+
+import (
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const (
+	reloadSucceeded = "success"
+	reloadFailed    = "failure"
+)
+
+// Metrics is the registry of the metrics the sidecar exposes.
+var Metrics = prometheus.NewRegistry()
+
+var (
+	certificateReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "iamram_sidecar_certificate_reloads_total",
+		Help: "Number of certificate rotations the sidecar picked up, by result.",
+	}, []string{"result"})
+	certificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "iamram_sidecar_certificate_expiration_timestamp_seconds",
+		Help: "Time the certificate in use expires, in seconds since the epoch.",
+	})
+)
+
+func init() {
+	Metrics.MustRegister(
+		certificateReloads,
+		certificateExpiry,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveCertificate records the expiry of the certificate in use.
+func ObserveCertificate(signer *rolesanywhere.Signer) {
+	certificateExpiry.Set(float64(signer.Certificate.NotAfter.Unix()))
+}
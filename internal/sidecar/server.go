@@ -0,0 +1,201 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+)
+
+// ProfileServer serves the credentials of one profile, and picks up config
+// changes pushed by the controller when reloaded.
+type ProfileServer struct {
+	*imds.Server
+
+	// mu serializes reloads and certificate renewals.
+	mu         sync.Mutex
+	config     ProfileConfig
+	configFile string
+	signer     *rolesanywhere.Signer
+	broker     *BrokerClient
+	revoked    map[string]bool
+	suspended  bool
+	until      time.Time
+	instance   imds.Options
+	logger     logr.Logger
+}
+
+// NewProfileServer returns a server for cfg, reloaded from configFile. instance
+// describes the pod the server runs in; the role, region and token settings
+// come from cfg.
+func NewProfileServer(
+	cfg ProfileConfig, configFile string, signer *rolesanywhere.Signer, instance imds.Options, logger logr.Logger,
+) (*ProfileServer, error) {
+	return newProfileServer(cfg, &ProfileServer{
+		configFile: configFile,
+		signer:     signer,
+		instance:   instance,
+		logger:     logger,
+	})
+}
+
+// NewBrokerProfileServer is NewProfileServer for sidecars that get their
+// credentials from the credential broker instead of signing CreateSession
+// calls themselves.
+func NewBrokerProfileServer(
+	cfg ProfileConfig, configFile string, broker *BrokerClient, instance imds.Options, logger logr.Logger,
+) (*ProfileServer, error) {
+	return newProfileServer(cfg, &ProfileServer{
+		configFile: configFile,
+		broker:     broker,
+		instance:   instance,
+		logger:     logger,
+	})
+}
+
+func newProfileServer(cfg ProfileConfig, p *ProfileServer) (*ProfileServer, error) {
+	p.logger = p.logger.WithValues("profile", cfg.Name, "port", cfg.Port)
+	p.Server = imds.NewServer(nil, p.instance, p.logger)
+	p.suspended = cfg.Suspended
+	if cfg.ActiveUntil != nil {
+		p.until = *cfg.ActiveUntil
+	}
+	if err := p.apply(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Config returns the configuration currently in use.
+func (p *ProfileServer) Config() ProfileConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// SetSigner switches the server to a renewed certificate.
+func (p *ProfileServer) SetSigner(signer *rolesanywhere.Signer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.signer = signer
+	return p.apply(p.config)
+}
+
+// SetRevoked sets the serial numbers of revoked certificates. Credentials
+// aren't served while the server's certificate is revoked.
+func (p *ProfileServer) SetRevoked(revoked map[string]bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revoked = revoked
+	return p.apply(p.config)
+}
+
+// SetSuspended applies the states written by suspend-profile, by profile
+// name. A profile missing from them goes back to the state it was started
+// with. Switching state drops the cached credentials.
+func (p *ProfileServer) SetSuspended(states map[string]ServingState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, ok := states[p.config.Name]
+	if !ok {
+		state = ServingState{Suspended: p.config.Suspended}
+		if p.config.ActiveUntil != nil {
+			state.Until = *p.config.ActiveUntil
+		}
+	}
+	p.suspended = state.Suspended
+	p.until = state.Until
+	return p.apply(p.config)
+}
+
+// Reload re-reads the profile's config file, if update-config has written one.
+func (p *ProfileServer) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cfg, err := ReadConfigFile(p.configFile, p.config)
+	if isNotExist(err) {
+		p.logger.Info("no config file found, keeping current config", "path", p.configFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.apply(cfg)
+}
+
+func (p *ProfileServer) apply(cfg ProfileConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	region, err := rolesanywhere.Region(cfg.TrustAnchorArn)
+	if err != nil {
+		return err
+	}
+
+	// The broker decides on the session itself, from the profile.
+	var provider rolesanywhere.CredentialsProvider = &brokerProvider{client: p.broker, profile: cfg.Name, until: p.until}
+	var serial string
+	if p.broker == nil {
+		provider = &rolesanywhere.SessionProvider{
+			Client: &rolesanywhere.Client{Signer: p.signer},
+			Input: rolesanywhere.SessionInput{
+				TrustAnchorArn:  cfg.TrustAnchorArn,
+				ProfileArn:      cfg.ProfileArn,
+				RoleArn:         cfg.RoleArn,
+				DurationSeconds: cfg.DurationSeconds,
+				RoleSessionName: cfg.RoleSessionName,
+			},
+			Until: p.until,
+		}
+		serial = pki.FormatSerial(p.signer.Certificate.SerialNumber)
+		if p.revoked[serial] {
+			provider = revokedProvider{serial}
+		}
+	}
+	if p.suspended {
+		provider = suspendedProvider{cfg.Name}
+	}
+	opts := p.instance
+	opts.Region = region
+	opts.TokensRequired = cfg.ImdsV2Only
+	p.Server.Reconfigure(provider, imds.OptionsFromRoleArn(cfg.RoleArn, opts))
+	p.config = cfg
+	if p.suspended {
+		p.logger.Info("profile suspended, not serving credentials")
+		return nil
+	}
+	if p.revoked[serial] {
+		p.logger.Info("certificate revoked, not serving credentials", "serial", serial)
+		return nil
+	}
+	if !p.until.IsZero() {
+		p.logger.Info("serving credentials until the end of the activation window", "roleArn", cfg.RoleArn,
+			"region", region, "broker", p.broker != nil, "until", p.until)
+		return nil
+	}
+	p.logger.Info("serving credentials", "roleArn", cfg.RoleArn, "region", region, "broker", p.broker != nil)
+	return nil
+}
+
+// revokedProvider fails every request for credentials.
+type revokedProvider struct {
+	serial string
+}
+
+func (p revokedProvider) Retrieve(context.Context) (*rolesanywhere.Credentials, error) {
+	return nil, fmt.Errorf("certificate %s has been revoked", p.serial)
+}
+
+// suspendedProvider fails every request for credentials.
+type suspendedProvider struct {
+	profile string
+}
+
+func (p suspendedProvider) Retrieve(context.Context) (*rolesanywhere.Credentials, error) {
+	return nil, fmt.Errorf("profile %s is suspended", p.profile)
+}
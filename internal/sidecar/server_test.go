@@ -0,0 +1,165 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProfileServer", func() {
+	It("should not serve credentials while its certificate is revoked", func() {
+		ca := newTestCA()
+		certPEM, keyPEM, err := ca.Issue(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "web"},
+			"cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		server, err := NewProfileServer(ProfileConfig{
+			Name:           "default",
+			TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:        "arn:aws:iam::123456789012:role/reader",
+		}, filepath.Join(GinkgoT().TempDir(), "config.env"), signer, imds.Options{}, logr.Discard())
+		Expect(err).NotTo(HaveOccurred())
+
+		dir := GinkgoT().TempDir()
+		path := RevokedFilePath(dir)
+		revoked, err := ReadRevokedFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(BeEmpty())
+		Expect(os.WriteFile(path, []byte("ff\n"+pki.FormatSerial(signer.Certificate.SerialNumber)+"\n"), 0o600)).
+			To(Succeed())
+		revoked, err = ReadRevokedFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revoked).To(HaveLen(2))
+		Expect(server.SetRevoked(revoked)).To(Succeed())
+
+		get := func(path string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			return recorder
+		}
+		Expect(get("/latest/meta-data/iam/security-credentials/").Body.String()).To(Equal("reader"))
+		Expect(get("/latest/meta-data/iam/security-credentials/reader").Code).
+			To(Equal(http.StatusInternalServerError))
+	})
+
+	It("should not serve credentials while its profile is suspended", func() {
+		ca := newTestCA()
+		certPEM, keyPEM, err := ca.Issue(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "web"},
+			"cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		var logs []string
+		logger := funcr.New(func(_, args string) { logs = append(logs, args) }, funcr.Options{})
+		server, err := NewProfileServer(ProfileConfig{
+			Name:           "reader",
+			TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:        "arn:aws:iam::123456789012:role/reader",
+			Suspended:      true,
+		}, filepath.Join(GinkgoT().TempDir(), "config.env"), signer, imds.Options{}, logger)
+		Expect(err).NotTo(HaveOccurred())
+		// The certificate is revoked so that the server refuses the requests
+		// it doesn't refuse as suspended, without calling CreateSession. The
+		// reason is only logged, the response doesn't tell.
+		Expect(server.SetRevoked(map[string]bool{pki.FormatSerial(signer.Certificate.SerialNumber): true})).
+			To(Succeed())
+		refusedAsSuspended := func() bool {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder,
+				httptest.NewRequest(http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", nil))
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			return strings.Contains(logs[len(logs)-1], "profile reader is suspended")
+		}
+		Expect(refusedAsSuspended()).To(BeTrue())
+
+		By("following the state suspend-profile records")
+		dir := SuspendedDirPath(GinkgoT().TempDir())
+		suspended, err := ReadSuspendedDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(suspended).To(BeEmpty())
+		Expect(os.MkdirAll(dir, 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "reader"), []byte("false\n"), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "writer.tmp"), []byte("true\n"), 0o600)).To(Succeed())
+		suspended, err = ReadSuspendedDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(suspended).To(Equal(map[string]ServingState{"reader": {}}))
+		Expect(server.SetSuspended(suspended)).To(Succeed())
+		Expect(refusedAsSuspended()).To(BeFalse())
+
+		Expect(server.SetSuspended(map[string]ServingState{"reader": {Suspended: true}})).To(Succeed())
+		Expect(refusedAsSuspended()).To(BeTrue())
+		By("going back to the state it was started with")
+		Expect(server.SetSuspended(map[string]ServingState{"reader": {}})).To(Succeed())
+		Expect(server.SetSuspended(nil)).To(Succeed())
+		Expect(refusedAsSuspended()).To(BeTrue())
+	})
+	It("should stop serving credentials at the end of the activation window", func() {
+		ca := newTestCA()
+		certPEM, keyPEM, err := ca.Issue(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "web"},
+			"cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := rolesanywhere.ParseSigner(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+		var logs []string
+		logger := funcr.New(func(_, args string) { logs = append(logs, args) }, funcr.Options{})
+		ended := time.Now().Add(-time.Minute).Truncate(time.Second)
+		server, err := NewProfileServer(ProfileConfig{
+			Name:           "reader",
+			TrustAnchorArn: "arn:aws:rolesanywhere:us-west-2:123456789012:trust-anchor/ta",
+			ProfileArn:     "arn:aws:rolesanywhere:us-west-2:123456789012:profile/p",
+			RoleArn:        "arn:aws:iam::123456789012:role/reader",
+			ActiveUntil:    &ended,
+		}, filepath.Join(GinkgoT().TempDir(), "config.env"), signer, imds.Options{}, logger)
+		Expect(err).NotTo(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodGet, "/latest/meta-data/iam/security-credentials/reader", nil))
+		Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		Expect(logs[len(logs)-1]).To(ContainSubstring("may only be served until"))
+
+		By("reading the end of the window suspend-profile records")
+		dir := SuspendedDirPath(GinkgoT().TempDir())
+		Expect(os.MkdirAll(dir, 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "reader"), []byte("false 2026-10-20T18:00:00Z\n"), 0o600)).
+			To(Succeed())
+		states, err := ReadSuspendedDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(states).To(Equal(map[string]ServingState{
+			"reader": {Until: time.Date(2026, time.October, 20, 18, 0, 0, 0, time.UTC)},
+		}))
+		Expect(server.SetSuspended(states)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "reader"), []byte("false tomorrow\n"), 0o600)).To(Succeed())
+		_, err = ReadSuspendedDir(dir)
+		Expect(err).To(MatchError(ContainSubstring("invalid state of profile reader")))
+	})
+})
@@ -0,0 +1,190 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultCertificateResyncInterval is how often the certificate files are
+	// checked for changes when no file system event arrives.
+	DefaultCertificateResyncInterval = time.Minute
+	// certificateSettleDelay lets writers that update several files finish
+	// before the certificate is reloaded.
+	certificateSettleDelay = time.Second
+)
+
+// CertificateWatcher reloads the sidecar's certificate when the mounted files
+// change, as kubelet does when the cert Secret is rotated. A new certificate
+// is only used if it matches the new private key and is currently valid.
+type CertificateWatcher struct {
+	CertPath string
+	KeyPath  string
+	// PKCS12Path is a PKCS#12 bundle read instead of CertPath and KeyPath.
+	PKCS12Path string
+	// ChainPath holds intermediate certificates. It is skipped if the file
+	// doesn't exist, as keys like ca.crt are optional in cert Secrets.
+	ChainPath string
+	// PassphrasePath holds the passphrase of an encrypted private key or of
+	// the PKCS#12 bundle.
+	PassphrasePath string
+	// ResyncInterval is how often the files are checked even if no file
+	// system event arrives. Defaults to DefaultCertificateResyncInterval.
+	ResyncInterval time.Duration
+	Logger         logr.Logger
+
+	// current is the digest of the files the signer in use was loaded from,
+	// rejected the one of files that were found invalid.
+	current  [sha256.Size]byte
+	rejected [sha256.Size]byte
+}
+
+// Load reads the certificate and private key.
+func (w *CertificateWatcher) Load() (*rolesanywhere.Signer, error) {
+	digest, err := w.digest()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := w.read()
+	if err != nil {
+		return nil, err
+	}
+	w.current = digest
+	return signer, nil
+}
+
+// Watch passes the certificate to update whenever the files change and hold
+// a valid certificate, until ctx is done. Invalid certificates are logged and
+// skipped, so that the current one stays in use.
+func (w *CertificateWatcher) Watch(ctx context.Context, update func(*rolesanywhere.Signer)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// Kubelet swaps the files of Secret volumes by replacing a symlink in
+	// their directory, so the directories are watched rather than the files.
+	dirs := map[string]bool{}
+	for _, path := range w.paths() {
+		dir := filepath.Dir(path)
+		if !dirs[dir] {
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			dirs[dir] = true
+		}
+	}
+
+	interval := w.ResyncInterval
+	if interval == 0 {
+		interval = DefaultCertificateResyncInterval
+	}
+	resync := time.NewTicker(interval)
+	defer resync.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			settle = time.After(certificateSettleDelay)
+		case err := <-watcher.Errors:
+			w.Logger.Error(err, "problem watching certificate files")
+		case <-settle:
+			settle = nil
+			w.reload(update)
+		case <-resync.C:
+			w.reload(update)
+		}
+	}
+}
+
+// reload loads the certificate if the files changed since it last did.
+func (w *CertificateWatcher) reload(update func(*rolesanywhere.Signer)) {
+	digest, err := w.digest()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			w.Logger.Error(err, "unable to read certificate files")
+		}
+		return
+	}
+	if digest == w.current || digest == w.rejected {
+		return
+	}
+	signer, err := w.read()
+	if err == nil {
+		err = signer.CheckValidity(time.Now())
+	}
+	if err != nil {
+		w.rejected = digest
+		certificateReloads.WithLabelValues(reloadFailed).Inc()
+		w.Logger.Error(err, "rotated certificate is invalid, keeping current certificate")
+		return
+	}
+	w.current = digest
+	certificateReloads.WithLabelValues(reloadSucceeded).Inc()
+	w.Logger.Info("loaded rotated certificate",
+		"serial", signer.Certificate.SerialNumber.String(), "notAfter", signer.Certificate.NotAfter)
+	update(signer)
+}
+
+func (w *CertificateWatcher) read() (*rolesanywhere.Signer, error) {
+	files := rolesanywhere.SignerFiles{CertPath: w.CertPath, KeyPath: w.KeyPath, PKCS12Path: w.PKCS12Path}
+	if w.ChainPath != "" {
+		if _, err := os.Stat(w.ChainPath); err == nil {
+			files.ChainPaths = []string{w.ChainPath}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if w.PassphrasePath != "" {
+		passphrase, err := os.ReadFile(w.PassphrasePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase: %w", err)
+		}
+		// Passphrases written with echo end with a newline.
+		files.Passphrase = bytes.TrimRight(passphrase, "\r\n")
+	}
+	return files.Load()
+}
+
+// digest returns a hash of the contents of the certificate files.
+func (w *CertificateWatcher) digest() ([sha256.Size]byte, error) {
+	hash := sha256.New()
+	for _, path := range w.paths() {
+		data, err := os.ReadFile(path)
+		if err != nil && !(path == w.ChainPath && errors.Is(err, os.ErrNotExist)) {
+			return [sha256.Size]byte{}, err
+		}
+		hash.Write(data)
+		hash.Write([]byte{0})
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest, nil
+}
+
+func (w *CertificateWatcher) paths() []string {
+	var paths []string
+	if w.PKCS12Path != "" {
+		paths = append(paths, w.PKCS12Path)
+	} else {
+		paths = append(paths, w.CertPath, w.KeyPath)
+	}
+	for _, path := range []string{w.ChainPath, w.PassphrasePath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dancav.io/aws-iamra-manager/internal/pki"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/youmark/pkcs8"
+)
+
+var _ = Describe("CertificateWatcher", func() {
+	var (
+		ca      *pki.CA
+		dir     string
+		watcher *CertificateWatcher
+	)
+
+	writeCertificate := func(certPEM, keyPEM []byte) {
+		Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0o600)).To(Succeed())
+	}
+	issue := func() ([]byte, []byte) {
+		certPEM, keyPEM, err := ca.Issue(pki.Identity{Namespace: "default", ServiceAccount: "builder", Pod: "web"},
+			"cluster.local", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		return certPEM, keyPEM
+	}
+
+	BeforeEach(func() {
+		ca = newTestCA()
+		dir = GinkgoT().TempDir()
+		writeCertificate(issue())
+		watcher = &CertificateWatcher{
+			CertPath:       filepath.Join(dir, "tls.crt"),
+			KeyPath:        filepath.Join(dir, "tls.key"),
+			ResyncInterval: 100 * time.Millisecond,
+			Logger:         logr.Discard(),
+		}
+	})
+
+	It("should switch to a rotated certificate and keep the current one if the new pair is invalid", func() {
+		current, err := watcher.Load()
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		updates := make(chan *rolesanywhere.Signer, 10)
+		go func() {
+			defer GinkgoRecover()
+			Expect(watcher.Watch(ctx, func(signer *rolesanywhere.Signer) { updates <- signer })).To(Succeed())
+		}()
+
+		By("rotating the certificate and key")
+		certPEM, keyPEM := issue()
+		writeCertificate(certPEM, keyPEM)
+		var rotated *rolesanywhere.Signer
+		Eventually(updates, 5*time.Second).Should(Receive(&rotated))
+		Expect(rotated.Certificate.SerialNumber).NotTo(Equal(current.Certificate.SerialNumber))
+
+		By("writing a certificate that doesn't match the key")
+		failures := testutil.ToFloat64(certificateReloads.WithLabelValues(reloadFailed))
+		otherCertPEM, _ := issue()
+		writeCertificate(otherCertPEM, keyPEM)
+		Eventually(func() float64 {
+			return testutil.ToFloat64(certificateReloads.WithLabelValues(reloadFailed))
+		}, 5*time.Second).Should(BeNumerically(">", failures))
+		Consistently(updates, 500*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should send the chain file after the certificate's own chain", func() {
+		watcher.ChainPath = filepath.Join(dir, "ca.crt")
+		_, err := watcher.Load()
+		Expect(err).NotTo(HaveOccurred(), "the chain file is optional")
+
+		Expect(os.WriteFile(watcher.ChainPath, ca.Certificate.Raw, 0o600)).To(Succeed())
+		_, err = watcher.Load()
+		Expect(err).To(MatchError(ContainSubstring("invalid certificate chain")))
+
+		certPEM, _ := issue()
+		Expect(os.WriteFile(watcher.ChainPath, certPEM, 0o600)).To(Succeed())
+		signer, err := watcher.Load()
+		Expect(err).NotTo(HaveOccurred())
+		// Certificates issued by the CA are followed by the CA certificate,
+		// which isn't sent twice.
+		Expect(signer.Chain).To(HaveLen(2))
+	})
+
+	It("should decrypt the private key with the passphrase file", func() {
+		certPEM, keyPEM := issue()
+		block, _ := pem.Decode(keyPEM)
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		encrypted, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		writeCertificate(certPEM, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}))
+		_, err = watcher.Load()
+		Expect(err).To(MatchError(ContainSubstring("no passphrase")))
+
+		watcher.PassphrasePath = filepath.Join(dir, "passphrase")
+		Expect(os.WriteFile(watcher.PassphrasePath, []byte("secret\n"), 0o600)).To(Succeed())
+		signer, err := watcher.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.PrivateKey.Public()).To(Equal(key.(crypto.Signer).Public()))
+	})
+})
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupAwsIamRaCredentialSecretWebhookWithManager registers the webhook for AwsIamRaCredentialSecret in the manager.
+func SetupAwsIamRaCredentialSecretWebhookWithManager(mgr ctrl.Manager) error {
+	logger := logf.Log.WithName("awsiamracredentialsecret-webhook")
+
+	return ctrl.NewWebhookManagedBy(mgr).For(&v1.AwsIamRaCredentialSecret{}).
+		WithDefaulter(&AwsIamRaCredentialSecretCustomDefaulter{logger}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-cloud-dancav-io-v1-awsiamracredentialsecret,mutating=true,failurePolicy=fail,sideEffects=None,groups=cloud.dancav.io,resources=awsiamracredentialsecrets,verbs=create;update,versions=v1,name=mawsiamracredentialsecret-v1.kb.io,admissionReviewVersions=v1
+
+// AwsIamRaCredentialSecretCustomDefaulter records the user creating or
+// changing an AwsIamRaCredentialSecret, so that the controller only issues
+// credentials of profiles they may use.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as it is used only for temporary operations and does not need to be deeply copied.
+type AwsIamRaCredentialSecretCustomDefaulter struct {
+	logger logr.Logger
+}
+
+var _ webhook.CustomDefaulter = &AwsIamRaCredentialSecretCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind AwsIamRaCredentialSecret.
+func (d *AwsIamRaCredentialSecretCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	credSecret, ok := obj.(*v1.AwsIamRaCredentialSecret)
+	if !ok {
+		return fmt.Errorf("expected an AwsIamRaCredentialSecret object but got %T", obj)
+	}
+	d.logger.Info("Recording the requester of AwsIamRaCredentialSecret", "name", credSecret.GetName())
+
+	return recordRequester(ctx, credSecret, &v1.AwsIamRaCredentialSecret{}, func(obj client.Object) any {
+		return obj.(*v1.AwsIamRaCredentialSecret).Spec
+	})
+}
+
+// recordRequester records the user making the request in the annotations of
+// obj, for the controller to check their permissions. Updates leaving what
+// specOf returns alone keep the user recorded before, so that neither the
+// finalizers the controller adds nor edits of the annotation itself pass for
+// a request of the user making them.
+func recordRequester(ctx context.Context, obj, old client.Object, specOf func(client.Object) any) error {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("unable to decode the object being updated: %w", err)
+		}
+		if equality.Semantic.DeepEqual(specOf(obj), specOf(old)) {
+			annotations := obj.GetAnnotations()
+			if requester, ok := old.GetAnnotations()[v1.RequesterAnnotationKey]; ok {
+				if annotations == nil {
+					annotations = map[string]string{}
+				}
+				annotations[v1.RequesterAnnotationKey] = requester
+			} else {
+				delete(annotations, v1.RequesterAnnotationKey)
+			}
+			obj.SetAnnotations(annotations)
+			return nil
+		}
+	}
+	return iamram.SetRequester(obj, req.UserInfo)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+)
+
+// asRequest returns a context holding an admission request of the user,
+// updating old unless it is nil.
+func asRequest(username string, old client.Object) context.Context {
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UserInfo: authenticationv1.UserInfo{Username: username, Groups: []string{"team-a"}},
+	}}
+	if old != nil {
+		raw, err := json.Marshal(old)
+		Expect(err).NotTo(HaveOccurred())
+		req.OldObject = apimachineryruntime.RawExtension{Raw: raw}
+	}
+	return admission.NewContextWithRequest(ctx, req)
+}
+
+// requesterOf returns the name of the user recorded as the requester of obj.
+func requesterOf(obj client.Object) string {
+	user, err := iamram.Requester(obj)
+	Expect(err).NotTo(HaveOccurred())
+	if user == nil {
+		return ""
+	}
+	return user.Username
+}
+
+var _ = Describe("AwsIamRaCredentialSecret Webhook", func() {
+	var (
+		obj       *v1.AwsIamRaCredentialSecret
+		defaulter AwsIamRaCredentialSecretCustomDefaulter
+	)
+
+	BeforeEach(func() {
+		obj = &v1.AwsIamRaCredentialSecret{Spec: v1.AwsIamRaCredentialSecretSpec{
+			ProfileName:    "my-profile",
+			CertSecretName: "my-cert",
+		}}
+		defaulter = AwsIamRaCredentialSecretCustomDefaulter{logr.Discard()}
+	})
+
+	Context("When creating or updating AwsIamRaCredentialSecret under Defaulting Webhook", func() {
+		It("Should record the user who last changed the spec", func() {
+			Expect(defaulter.Default(asRequest("alice", nil), obj)).To(Succeed())
+			user, err := iamram.Requester(obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(user).To(Equal(&authenticationv1.UserInfo{Username: "alice", Groups: []string{"team-a"}}))
+
+			By("keeping it for changes leaving the spec alone")
+			finalized := obj.DeepCopy()
+			finalized.Finalizers = []string{"example.com/cleanup"}
+			Expect(defaulter.Default(asRequest("system:serviceaccount:iamram:controller", obj), finalized)).
+				To(Succeed())
+			Expect(requesterOf(finalized)).To(Equal("alice"))
+
+			forged := finalized.DeepCopy()
+			Expect(iamram.SetRequester(forged, authenticationv1.UserInfo{Username: "admin"})).To(Succeed())
+			Expect(defaulter.Default(asRequest("mallory", finalized), forged)).To(Succeed())
+			Expect(requesterOf(forged)).To(Equal("alice"))
+
+			By("recording the user changing it")
+			changed := forged.DeepCopy()
+			changed.Spec.ProfileName = "admin-profile"
+			Expect(defaulter.Default(asRequest("mallory", forged), changed)).To(Succeed())
+			Expect(requesterOf(changed)).To(Equal("mallory"))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"fmt"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// SetupAwsIamRaEcrPullSecretWebhookWithManager registers the webhook for AwsIamRaEcrPullSecret in the manager.
+func SetupAwsIamRaEcrPullSecretWebhookWithManager(mgr ctrl.Manager) error {
+	logger := logf.Log.WithName("awsiamraecrpullsecret-webhook")
+
+	return ctrl.NewWebhookManagedBy(mgr).For(&v1.AwsIamRaEcrPullSecret{}).
+		WithDefaulter(&AwsIamRaEcrPullSecretCustomDefaulter{logger}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-cloud-dancav-io-v1-awsiamraecrpullsecret,mutating=true,failurePolicy=fail,sideEffects=None,groups=cloud.dancav.io,resources=awsiamraecrpullsecrets,verbs=create;update,versions=v1,name=mawsiamraecrpullsecret-v1.kb.io,admissionReviewVersions=v1
+
+// AwsIamRaEcrPullSecretCustomDefaulter records the user creating or changing
+// an AwsIamRaEcrPullSecret, so that the controller only logs in to ECR with
+// profiles they may use.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as it is used only for temporary operations and does not need to be deeply copied.
+type AwsIamRaEcrPullSecretCustomDefaulter struct {
+	logger logr.Logger
+}
+
+var _ webhook.CustomDefaulter = &AwsIamRaEcrPullSecretCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind AwsIamRaEcrPullSecret.
+func (d *AwsIamRaEcrPullSecretCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	pullSecret, ok := obj.(*v1.AwsIamRaEcrPullSecret)
+	if !ok {
+		return fmt.Errorf("expected an AwsIamRaEcrPullSecret object but got %T", obj)
+	}
+	d.logger.Info("Recording the requester of AwsIamRaEcrPullSecret", "name", pullSecret.GetName())
+
+	return recordRequester(ctx, pullSecret, &v1.AwsIamRaEcrPullSecret{}, func(obj client.Object) any {
+		return obj.(*v1.AwsIamRaEcrPullSecret).Spec
+	})
+}
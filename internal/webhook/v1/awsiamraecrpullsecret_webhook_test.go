@@ -0,0 +1,51 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+)
+
+var _ = Describe("AwsIamRaEcrPullSecret Webhook", func() {
+	Context("When creating or updating AwsIamRaEcrPullSecret under Defaulting Webhook", func() {
+		It("Should record the user who last changed the spec", func() {
+			defaulter := AwsIamRaEcrPullSecretCustomDefaulter{logr.Discard()}
+			obj := &v1.AwsIamRaEcrPullSecret{Spec: v1.AwsIamRaEcrPullSecretSpec{
+				ProfileName:    "ecr-reader",
+				CertSecretName: "my-cert",
+			}}
+			Expect(defaulter.Default(asRequest("alice", nil), obj)).To(Succeed())
+			Expect(requesterOf(obj)).To(Equal("alice"))
+
+			finalized := obj.DeepCopy()
+			finalized.Finalizers = []string{"cloud.dancav.io/ecr-pull-secrets"}
+			Expect(defaulter.Default(asRequest("system:serviceaccount:iamram:controller", obj), finalized)).
+				To(Succeed())
+			Expect(requesterOf(finalized)).To(Equal("alice"))
+
+			selecting := finalized.DeepCopy()
+			selecting.Spec.NamespaceSelector = &metav1.LabelSelector{}
+			Expect(defaulter.Default(asRequest("bob", finalized), selecting)).To(Succeed())
+			Expect(requesterOf(selecting)).To(Equal("bob"))
+		})
+	})
+})
@@ -18,12 +18,19 @@ package v1
 
 import (
 	"context"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"encoding/json"
 	"fmt"
+	"github.com/cert-manager/cert-manager/pkg/apis/certmanager"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -38,7 +45,7 @@ func SetupAwsIamRaRoleProfileWebhookWithManager(mgr ctrl.Manager) error {
 	logger := logf.Log.WithName("awsiamraroleprofile-webhook")
 
 	return ctrl.NewWebhookManagedBy(mgr).For(&v1.AwsIamRaRoleProfile{}).
-		WithValidator(&AwsIamRaRoleProfileCustomValidator{logger}).
+		WithValidator(&AwsIamRaRoleProfileCustomValidator{logger: logger, client: mgr.GetClient()}).
 		WithDefaulter(&AwsIamRaRoleProfileCustomDefaulter{logger}).
 		Complete()
 }
@@ -57,7 +64,7 @@ type AwsIamRaRoleProfileCustomDefaulter struct {
 var _ webhook.CustomDefaulter = &AwsIamRaRoleProfileCustomDefaulter{}
 
 // Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind AwsIamRaRoleProfile.
-func (d *AwsIamRaRoleProfileCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+func (d *AwsIamRaRoleProfileCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
 	profile, ok := obj.(*v1.AwsIamRaRoleProfile)
 	if !ok {
 		return fmt.Errorf("expected an AwsIamRaRoleProfile object but got %T", obj)
@@ -68,20 +75,46 @@ func (d *AwsIamRaRoleProfileCustomDefaulter) Default(_ context.Context, obj runt
 		profile.Spec.DurationSeconds = defaultSessionDurationSeconds
 	}
 
+	return recordSuspensionChange(ctx, profile)
+}
+
+// recordSuspensionChange records the user suspending or resuming the profile
+// in its annotations, for the controller to report.
+func recordSuspensionChange(ctx context.Context, profile *v1.AwsIamRaRoleProfile) error {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	var old v1.AwsIamRaRoleProfile
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return fmt.Errorf("unable to decode the profile being updated: %w", err)
+		}
+	}
+	if old.Spec.Suspended == profile.Spec.Suspended {
+		return nil
+	}
+	if profile.Annotations == nil {
+		profile.Annotations = map[string]string{}
+	}
+	profile.Annotations[v1.SuspensionChangedByAnnotationKey] = req.UserInfo.Username
 	return nil
 }
 
 // NOTE: The 'path' attribute must follow a specific pattern and should not be modified directly here.
 // Modifying the path for an invalid path can cause API server errors; failing to locate the webhook.
+// +kubebuilder:rbac:groups=cloud.dancav.io,resources=iamrapolicies,verbs=get;list;watch
+
 // +kubebuilder:webhook:path=/validate-cloud-dancav-io-v1-awsiamraroleprofile,mutating=false,failurePolicy=fail,sideEffects=None,groups=cloud.dancav.io,resources=awsiamraroleprofiles,verbs=create;update,versions=v1,name=vawsiamraroleprofile-v1.kb.io,admissionReviewVersions=v1
 
 // AwsIamRaRoleProfileCustomValidator struct is responsible for validating the AwsIamRaRoleProfile resource
-// when it is created, updated, or deleted.
+// when it is created, updated, or deleted, and for enforcing the IamRaPolicies selecting its namespace.
 //
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as this struct is used only for temporary operations and does not need to be deeply copied.
 type AwsIamRaRoleProfileCustomValidator struct {
 	logger logr.Logger
+	client client.Reader
 }
 
 var _ webhook.CustomValidator = &AwsIamRaRoleProfileCustomValidator{}
@@ -93,7 +126,7 @@ func (v *AwsIamRaRoleProfileCustomValidator) ValidateCreate(ctx context.Context,
 		return nil, fmt.Errorf("expected an AwsIamRaRoleProfile object but got %T", obj)
 	}
 	v.logger.Info("Performing creation validation for AwsIamRaRoleProfile", "name", profile.GetName())
-	return validateProfile(ctx, profile)
+	return v.validateProfile(ctx, profile)
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type AwsIamRaRoleProfile.
@@ -103,7 +136,7 @@ func (v *AwsIamRaRoleProfileCustomValidator) ValidateUpdate(ctx context.Context,
 		return nil, fmt.Errorf("expected an AwsIamRaRoleProfile object for the newObj but got %T", newObj)
 	}
 	v.logger.Info("Performing update validation for AwsIamRaRoleProfile", "name", profile.GetName())
-	return validateProfile(ctx, profile)
+	return v.validateProfile(ctx, profile)
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type AwsIamRaRoleProfile.
@@ -115,7 +148,9 @@ func (v *AwsIamRaRoleProfileCustomValidator) ValidateDelete(_ context.Context, o
 	return nil, nil
 }
 
-func validateProfile(_ context.Context, profile *v1.AwsIamRaRoleProfile) (admission.Warnings, error) {
+func (v *AwsIamRaRoleProfileCustomValidator) validateProfile(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile,
+) (admission.Warnings, error) {
 	taRegion, taErr := validateARN(field.NewPath("spec").Child("trustAnchorArn"), profile.Spec.TrustAnchorArn)
 	profRegion, profErr := validateARN(field.NewPath("spec").Child("profileArn"), profile.Spec.ProfileArn)
 	_, roleErr := validateARN(field.NewPath("spec").Child("roleArn"), profile.Spec.RoleArn)
@@ -132,6 +167,22 @@ func validateProfile(_ context.Context, profile *v1.AwsIamRaRoleProfile) (admiss
 		allErrs = append(allErrs, err)
 	}
 
+	allErrs = append(allErrs, validateCertificate(field.NewPath("spec").Child("certificate"), profile)...)
+	if profile.Spec.Delivery == v1.CredentialDeliveryNodeAgent && profile.Spec.Certificate != nil {
+		// The node agent calls CreateSession with its own certificate.
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("certificate"),
+			"can't be used with the NodeAgent delivery"))
+	}
+
+	allErrs = append(allErrs, validatePodSelection(field.NewPath("spec"), &profile.Spec)...)
+	allErrs = append(allErrs, validateActivationWindow(field.NewPath("spec"), &profile.Spec)...)
+
+	policyErrs, err := v.checkPolicies(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, policyErrs...)
+
 	if len(allErrs) == 0 {
 		return nil, nil
 	}
@@ -139,6 +190,136 @@ func validateProfile(_ context.Context, profile *v1.AwsIamRaRoleProfile) (admiss
 	return nil, apierrors.NewInvalid(v1.AwsIamRaRoleProfileGroupKind, profile.Name, allErrs)
 }
 
+// checkPolicies checks the profile against the IamRaPolicies selecting its
+// namespace. Each of them must allow it.
+func (v *AwsIamRaRoleProfileCustomValidator) checkPolicies(
+	ctx context.Context, profile *v1.AwsIamRaRoleProfile,
+) ([]*field.Error, error) {
+	var policies v1.IamRaPolicyList
+	if err := v.client.List(ctx, &policies); err != nil {
+		return nil, fmt.Errorf("unable to list IamRaPolicies: %w", err)
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+	var namespace corev1.Namespace
+	if err := v.client.Get(ctx, client.ObjectKey{Name: profile.Namespace}, &namespace); err != nil {
+		return nil, fmt.Errorf("unable to get namespace %s: %w", profile.Namespace, err)
+	}
+	var allErrs []*field.Error
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		selected, err := iamram.PolicySelectsNamespace(policy, namespace.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			allErrs = append(allErrs, iamram.PolicyViolations(policy, profile)...)
+		}
+	}
+	return allErrs, nil
+}
+
+// validatePodSelection checks the podSelector and serviceAccountNames the
+// pod webhook selects pods with, and the allowedServiceAccounts it admits.
+func validatePodSelection(path *field.Path, spec *v1.AwsIamRaRoleProfileSpec) []*field.Error {
+	var allErrs []*field.Error
+	if spec.PodSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(spec.PodSelector,
+			metav1validation.LabelSelectorValidationOptions{}, path.Child("podSelector"))...)
+	}
+	allErrs = append(allErrs, validateServiceAccountNames(path.Child("serviceAccountNames"),
+		spec.ServiceAccountNames)...)
+	if allowed := spec.AllowedServiceAccounts; allowed != nil {
+		allowedPath := path.Child("allowedServiceAccounts")
+		allErrs = append(allErrs, validateServiceAccountNames(allowedPath.Child("names"), allowed.Names)...)
+		if allowed.Selector != nil {
+			allErrs = append(allErrs, metav1validation.ValidateLabelSelector(allowed.Selector,
+				metav1validation.LabelSelectorValidationOptions{}, allowedPath.Child("selector"))...)
+		}
+	}
+	return allErrs
+}
+
+// validateActivationWindow checks that activeUntil comes after activeFrom and
+// that the schedules can be parsed.
+func validateActivationWindow(path *field.Path, spec *v1.AwsIamRaRoleProfileSpec) []*field.Error {
+	var allErrs []*field.Error
+	if spec.ActiveFrom != nil && spec.ActiveUntil != nil && !spec.ActiveUntil.After(spec.ActiveFrom.Time) {
+		allErrs = append(allErrs, field.Invalid(path.Child("activeUntil"), spec.ActiveUntil,
+			"must be after activeFrom"))
+	}
+	for i, schedule := range spec.Schedules {
+		if err := iamram.ValidateSchedule(schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("schedules").Index(i), schedule, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+func validateServiceAccountNames(path *field.Path, names []string) []*field.Error {
+	var allErrs []*field.Error
+	for i, name := range names {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(path.Index(i), name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateCertificate checks that profiles using cert-manager reference an
+// issuer and have subject templates that render, and that PKCS#12 bundles and
+// passphrases are only set for certificates the controller doesn't issue and
+// pods actually mount.
+func validateCertificate(path *field.Path, profile *v1.AwsIamRaRoleProfile) []*field.Error {
+	var allErrs []*field.Error
+	switch source := profile.Spec.CertificateSource(); source {
+	case v1.CertificateSourceControllerCA, v1.CertificateSourceCertificateSigningRequest,
+		v1.CertificateSourceBroker:
+		// The controller issues unencrypted PEM certificates and keys, and the
+		// broker reads its own certificate.
+		if profile.Spec.Certificate.PKCS12Key != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("pkcs12Key"),
+				fmt.Sprintf("can't be used with the %s source", source)))
+		}
+		if profile.Spec.Certificate.PassphraseSecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(path.Child("passphraseSecretRef"),
+				fmt.Sprintf("can't be used with the %s source", source)))
+		}
+		return allErrs
+	case v1.CertificateSourceCertManager:
+	default:
+		return nil
+	}
+	spec := profile.Spec.Certificate.CertManager
+	if spec == nil {
+		return []*field.Error{field.Required(path.Child("certManager"),
+			"must be set when the source is CertManager")}
+	}
+	issuerPath := path.Child("certManager", "issuerRef")
+	if spec.IssuerRef.Name == "" {
+		allErrs = append(allErrs, field.Required(issuerPath.Child("name"), "must name an issuer"))
+	}
+	if group := spec.IssuerRef.Group; group == "" || group == certmanager.GroupName {
+		if kind := spec.IssuerRef.Kind; kind != "" && kind != "Issuer" && kind != "ClusterIssuer" {
+			allErrs = append(allErrs, field.NotSupported(issuerPath.Child("kind"), kind,
+				[]string{"Issuer", "ClusterIssuer"}))
+		}
+	}
+	sample := iamram.CertificateSubjectData{
+		Namespace:      profile.Namespace,
+		ServiceAccount: "default",
+		Workload:       "workload",
+		WorkloadKind:   "Deployment",
+		Profile:        profile.Name,
+		TrustDomain:    "cluster.local",
+	}
+	if _, err := iamram.RenderCertificateSubject(profile, sample); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("certManager", "subject"), spec.Subject, err.Error()))
+	}
+	return allErrs
+}
+
 func validateARN(path *field.Path, arn v1.ARN) (string, *field.Error) {
 	if !arn.IsValid() {
 		return "", field.Invalid(path, arn, "must be a valid ARN")
@@ -17,12 +17,39 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"dancav.io/aws-iamra-manager/api/v1"
 )
 
+func newFakeValidator(objs ...apimachineryruntime.Object) AwsIamRaRoleProfileCustomValidator {
+	scheme := apimachineryruntime.NewScheme()
+	Expect(v1.AddToScheme(scheme)).To(Succeed())
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "tenant-a", Labels: map[string]string{"tenant": "true"},
+	}}
+	defaultNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	return AwsIamRaRoleProfileCustomValidator{
+		logger: logr.Discard(),
+		client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(tenant, defaultNamespace).
+			WithRuntimeObjects(objs...).Build(),
+	}
+}
+
 var _ = Describe("AwsIamRaRoleProfile Webhook", func() {
 	var (
 		obj       *v1.AwsIamRaRoleProfile
@@ -34,7 +61,7 @@ var _ = Describe("AwsIamRaRoleProfile Webhook", func() {
 	BeforeEach(func() {
 		obj = &v1.AwsIamRaRoleProfile{}
 		oldObj = &v1.AwsIamRaRoleProfile{}
-		validator = AwsIamRaRoleProfileCustomValidator{}
+		validator = newFakeValidator()
 		Expect(validator).NotTo(BeNil(), "Expected validator to be initialized")
 		defaulter = AwsIamRaRoleProfileCustomDefaulter{}
 		Expect(defaulter).NotTo(BeNil(), "Expected defaulter to be initialized")
@@ -58,6 +85,39 @@ var _ = Describe("AwsIamRaRoleProfile Webhook", func() {
 		//     By("checking that the default values are set")
 		//     Expect(obj.SomeFieldWithDefault).To(Equal("default_value"))
 		// })
+
+		It("Should record who suspends and resumes the profile", func() {
+			request := func(username string, old *v1.AwsIamRaRoleProfile) context.Context {
+				req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: username},
+				}}
+				if old != nil {
+					raw, err := json.Marshal(old)
+					Expect(err).NotTo(HaveOccurred())
+					req.OldObject = apimachineryruntime.RawExtension{Raw: raw}
+				}
+				return admission.NewContextWithRequest(ctx, req)
+			}
+
+			Expect(defaulter.Default(request("alice", nil), obj)).To(Succeed())
+			Expect(obj.Annotations).NotTo(HaveKey(v1.SuspensionChangedByAnnotationKey))
+
+			updated := obj.DeepCopy()
+			updated.Spec.Suspended = true
+			Expect(defaulter.Default(request("alice", obj), updated)).To(Succeed())
+			Expect(updated.Annotations).To(HaveKeyWithValue(v1.SuspensionChangedByAnnotationKey, "alice"))
+
+			By("leaving it alone for other changes")
+			relabeled := updated.DeepCopy()
+			relabeled.Labels = map[string]string{"team": "a"}
+			Expect(defaulter.Default(request("bob", updated), relabeled)).To(Succeed())
+			Expect(relabeled.Annotations).To(HaveKeyWithValue(v1.SuspensionChangedByAnnotationKey, "alice"))
+
+			resumed := relabeled.DeepCopy()
+			resumed.Spec.Suspended = false
+			Expect(defaulter.Default(request("bob", relabeled), resumed)).To(Succeed())
+			Expect(resumed.Annotations).To(HaveKeyWithValue(v1.SuspensionChangedByAnnotationKey, "bob"))
+		})
 	})
 
 	Context("When creating or updating AwsIamRaRoleProfile under Validating Webhook", func() {
@@ -86,6 +146,148 @@ var _ = Describe("AwsIamRaRoleProfile Webhook", func() {
 			obj.Spec.RoleArn = "baz"
 			Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
 		})
+
+		It("Should deny cert-manager certificates without an issuer or with invalid templates", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			obj.Spec.Certificate = &v1.CertificateSpec{Source: v1.CertificateSourceCertManager}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("certManager")))
+
+			obj.Spec.Certificate.CertManager = &v1.CertManagerCertificateSpec{
+				IssuerRef: v1.IssuerReference{Name: "iamra-ca", Kind: "ClusterIssuer"},
+				Subject:   &v1.CertificateSubjectTemplate{CommonName: "{{.Workload}}.{{.Namespace}}"},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.Certificate.CertManager.Subject.CommonName = "{{.Cluster}}"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("commonName")))
+		})
+
+		It("Should deny PKCS#12 bundles and passphrases for certificates issued by the controller", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			obj.Spec.Certificate = &v1.CertificateSpec{
+				PKCS12Key:           "keystore.p12",
+				PassphraseSecretRef: &v1.SecretKeyReference{Name: "keystore-password"},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.Certificate.Source = v1.CertificateSourceControllerCA
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(And(
+				MatchError(ContainSubstring("pkcs12Key")), MatchError(ContainSubstring("passphraseSecretRef"))))
+
+			obj.Spec.Certificate.Source = v1.CertificateSourceBroker
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("Broker source")))
+		})
+
+		It("Should deny certificate settings for profiles delivered by the node agent", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			obj.Spec.Delivery = v1.CredentialDeliveryNodeAgent
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.Certificate = &v1.CertificateSpec{Source: v1.CertificateSourceControllerCA}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(MatchError(ContainSubstring("NodeAgent delivery")))
+		})
+
+		It("Should deny invalid pod selectors and service account names", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			obj.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			obj.Spec.ServiceAccountNames = []string{"web"}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.PodSelector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Near"}}
+			obj.Spec.ServiceAccountNames = []string{"Web_App"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(And(
+				MatchError(ContainSubstring("spec.podSelector.matchExpressions[0].operator")),
+				MatchError(ContainSubstring("spec.serviceAccountNames[0]"))))
+		})
+
+		It("Should deny invalid allowed service accounts", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			obj.Spec.AllowedServiceAccounts = &v1.AllowedServiceAccounts{
+				Names:    []string{"web"},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.AllowedServiceAccounts.Names = []string{"web", "Web_App"}
+			obj.Spec.AllowedServiceAccounts.Selector.MatchLabels = map[string]string{"team": "pay ments"}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(And(
+				MatchError(ContainSubstring("spec.allowedServiceAccounts.names[1]")),
+				MatchError(ContainSubstring("spec.allowedServiceAccounts.selector.matchLabels"))))
+		})
+
+		It("Should deny activation windows ending before they start and invalid schedules", func() {
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/baz"
+			from := metav1.Date(2026, time.November, 2, 9, 0, 0, 0, time.UTC)
+			obj.Spec.ActiveFrom = &from
+			obj.Spec.ActiveUntil = &metav1.Time{Time: from.AddDate(0, 1, 0)}
+			obj.Spec.Schedules = []v1.ProfileSchedule{
+				{Days: []v1.Weekday{"Monday", "Friday"}, Start: "09:00", End: "17:30", TimeZone: "Europe/Paris"},
+				{Start: "22:00", End: "02:00"},
+			}
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.ActiveUntil = &from
+			obj.Spec.Schedules[0].TimeZone = "Europe/Atlantis"
+			obj.Spec.Schedules[1].End = "24:00"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(And(
+				MatchError(ContainSubstring("spec.activeUntil")),
+				MatchError(ContainSubstring("spec.schedules[0]")),
+				MatchError(ContainSubstring(`unknown time zone "Europe/Atlantis"`)),
+				MatchError(ContainSubstring(`invalid time of day "24:00"`))))
+		})
+
+		It("Should deny profiles that an IamRaPolicy of their namespace doesn't allow", func() {
+			tenants := &v1.IamRaPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenants"},
+				Spec: v1.IamRaPolicySpec{
+					NamespaceSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+					AllowedTrustAnchors: []v1.ARNPattern{"arn:aws:rolesanywhere:us-east-1:123:trust-anchor/*"},
+					AllowedRoles:        []v1.ARNPattern{"arn:aws:iam::123:role/tenants/*"},
+					MaxDurationSeconds:  3600,
+					RoleSessionNames:    []string{"{{.Namespace}}-*"},
+				},
+			}
+			accounts := &v1.IamRaPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "accounts"},
+				Spec:       v1.IamRaPolicySpec{AllowedAccounts: []string{"123"}},
+			}
+			validator = newFakeValidator(tenants, accounts)
+			obj.Namespace = "tenant-a"
+			obj.Spec.TrustAnchorArn = "arn:aws:rolesanywhere:us-east-1:123:trust-anchor/foo"
+			obj.Spec.ProfileArn = "arn:aws:rolesanywhere:us-east-1:123:profile/bar"
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/tenants/a/reader"
+			obj.Spec.DurationSeconds = 3600
+			obj.Spec.RoleSessionName = "tenant-a-reader"
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+
+			obj.Spec.RoleArn = "arn:aws:iam::123:role/admin"
+			obj.Spec.DurationSeconds = 7200
+			obj.Spec.RoleSessionName = "tenant-b-reader"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(And(
+				MatchError(ContainSubstring("spec.roleArn: Invalid value: \"arn:aws:iam::123:role/admin\": "+
+					"IamRaPolicy tenants only allows arn:aws:iam::123:role/tenants/*")),
+				MatchError(ContainSubstring("IamRaPolicy tenants only allows up to 3600 seconds")),
+				MatchError(ContainSubstring("IamRaPolicy tenants only allows session names matching tenant-a-*"))))
+
+			By("applying policies to the namespaces they select only")
+			obj.Namespace = "default"
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+			obj.Spec.RoleArn = "arn:aws:iam::456:role/admin"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(
+				MatchError(ContainSubstring("IamRaPolicy accounts only allows the accounts 123")))
+		})
 	})
 
 })
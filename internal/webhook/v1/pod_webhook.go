@@ -19,49 +19,146 @@ package v1
 import (
 	"context"
 	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
+	"dancav.io/aws-iamra-manager/internal/sidecar"
+	"encoding/json"
 	"fmt"
 	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"os"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	certSecretVolumeName        = "aws-iamra-cert-secret"
-	sidecarContainerImageEnvVar = "AWS_IAMRA_MANAGER_SIDECAR_IMAGE"
-	sidecarContainerName        = "aws-iamra-manager"
-	sidecarCertMountPath        = "/iamram/certs"
-	imdsEndpointEnvVar          = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
-	imdsEndpoint                = "http://127.0.0.1:9911/"
+	certSecretVolumeName       = "aws-iamra-cert-secret"
+	passphraseVolumeName       = "aws-iamra-cert-passphrase"
+	sidecarPassphraseMountPath = "/iamram/passphrase"
+	passphraseFileName         = "passphrase"
+	sidecarContainerName       = iamram.SidecarContainerName
+	sidecarCertMountPath       = "/iamram/certs"
+	brokerTokenVolumeName      = "aws-iamra-broker-token"
+	sidecarBrokerMountPath     = "/iamram/broker"
+	brokerTokenExpirationSecs  = 3600
+	imdsEndpointEnvVar         = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+	regionEnvVar               = "AWS_REGION"
+	awsConfigFileEnvVar        = "AWS_CONFIG_FILE"
+	awsConfigVolumeName        = "aws-iamra-aws-config"
+	awsConfigMountPath         = "/iamram/aws"
+	awsConfigAnnotationKey     = "cloud.dancav.io/aws-iamra-aws-config"
+	podNameEnvVar              = "POD_NAME"
+	podIPEnvVar                = "POD_IP"
+	podNamespaceEnvVar         = "POD_NAMESPACE"
+	podServiceAccountEnvVar    = "POD_SERVICE_ACCOUNT"
+	hostIPEnvVar               = "IAMRAM_HOST_IP"
+	issuedCertSecretPrefix     = "aws-iamra-cert-"
 )
 
-var (
-	sidecarContainerImage         string
-	sidecarContainerRestartPolicy = corev1.ContainerRestartPolicyAlways
+var sidecarContainerRestartPolicy = corev1.ContainerRestartPolicyAlways
+
+// immutablePodAnnotationKeys are the annotations deciding which profiles and
+// certificate a pod uses. The pod webhook checks them when the pod is
+// created, so the validating webhook keeps them from changing afterwards.
+var immutablePodAnnotationKeys = []string{
+	v1.RoleProfilePodAnnotationKey,
+	v1.ContainerProfilesPodAnnotationKey,
+	v1.CertSecretPodAnnotationKey,
+	v1.IssuedCertSecretPodAnnotationKey,
+	v1.CertManagerCertificatePodAnnotationKey,
+	v1.DeliveryPodAnnotationKey,
+	awsConfigAnnotationKey,
+}
+
+// controllerPodAnnotationKeys are the annotations the controller keeps up to
+// date on pods, which the broker and the sidecars trust. The validating
+// webhook only lets the manager change them.
+var controllerPodAnnotationKeys = []string{
+	v1.ProfileHashesPodAnnotationKey,
+	v1.SuspendedProfilesPodAnnotationKey,
+	v1.ActiveUntilPodAnnotationKey,
+}
+
+// BrokerOptions tell the sidecars of pods whose profile uses the Broker
+// certificate source where to find the credential broker.
+type BrokerOptions struct {
+	// URL of the broker. Pods can't use the Broker source without one.
+	URL string
+	// CA holds the PEM-encoded certificates the broker's serving certificate
+	// is verified with. The system roots are used if empty.
+	CA []byte
+}
+
+// PodWebhookOptions configure how pods get their credentials.
+type PodWebhookOptions struct {
+	Broker BrokerOptions
+	// NodeAgentEndpoint is the metadata service endpoint of the node agent,
+	// for pods whose profile is delivered by it. It may refer to the IP of the
+	// pod's node as $(IAMRAM_HOST_IP). Pods can't use the NodeAgent delivery
+	// without one.
+	NodeAgentEndpoint string
+	// Config holds the sidecar settings. Defaults to managerconfig.Defaults.
+	Config *managerconfig.Store
+	// ManagerUsername is the user the manager authenticates as, the only one
+	// allowed to change the annotations the controller sets on pods.
+	ManagerUsername string
+}
+
+// sidecarMode is how the sidecar gets the credentials it serves.
+type sidecarMode int
+
+const (
+	// mountedCertificate signs CreateSession calls with the mounted cert volume.
+	mountedCertificate sidecarMode = iota
+	// requestedCertificate signs them with a certificate the sidecar requests
+	// through a CertificateSigningRequest.
+	requestedCertificate
+	// brokeredCredentials gets credentials from the credential broker.
+	brokeredCredentials
 )
 
 // SetupPodWebhookWithManager registers the webhook for Pod in the manager.
-func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
-	var ok bool
-	if sidecarContainerImage, ok = os.LookupEnv(sidecarContainerImageEnvVar); !ok {
-		return fmt.Errorf("%s environment variable must be set", sidecarContainerImageEnvVar)
-	}
+func SetupPodWebhookWithManager(mgr ctrl.Manager, opts PodWebhookOptions) error {
 	logger := logf.Log.WithName("pod-webhook")
+	config := opts.Config
+	if config == nil {
+		config = managerconfig.NewStore(managerconfig.Defaults())
+	}
 
 	return ctrl.NewWebhookManagedBy(mgr).For(&corev1.Pod{}).
 		WithDefaulter(&PodCustomDefaulter{
-			client: mgr.GetClient(),
-			logger: logger,
+			client:            mgr.GetClient(),
+			reader:            mgr.GetAPIReader(),
+			logger:            logger,
+			broker:            opts.Broker,
+			nodeAgentEndpoint: opts.NodeAgentEndpoint,
+			config:            config,
 		}).
+		WithValidator(&PodCustomValidator{logger: logger, manager: opts.ManagerUsername}).
 		Complete()
 }
 
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+// +kubebuilder:rbac:groups=apps,resources=deployments;replicasets;statefulsets;daemonsets,verbs=get
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get
+
 // +kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,reinvocationPolicy=IfNeeded,failurePolicy=fail,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create,versions=v1,name=mpod-v1.kb.io,admissionReviewVersions=v1
 
 // PodCustomDefaulter struct is responsible for setting default values on the custom resource of the
@@ -70,8 +167,12 @@ func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as it is used only for temporary operations and does not need to be deeply copied.
 type PodCustomDefaulter struct {
-	client client.Client
-	logger logr.Logger
+	client            client.Client
+	reader            client.Reader
+	logger            logr.Logger
+	broker            BrokerOptions
+	nodeAgentEndpoint string
+	config            *managerconfig.Store
 }
 
 var _ webhook.CustomDefaulter = &PodCustomDefaulter{}
@@ -83,102 +184,866 @@ func (d *PodCustomDefaulter) Default(ctx context.Context, obj runtime.Object) er
 		return fmt.Errorf("expected a Pod object but got %T", obj)
 	}
 
-	if profileName, ok := pod.Annotations[v1.RoleProfilePodAnnotationKey]; ok {
+	_, hasRoleProfiles := pod.Annotations[v1.RoleProfilePodAnnotationKey]
+	_, hasContainerProfiles := pod.Annotations[v1.ContainerProfilesPodAnnotationKey]
+	if !hasRoleProfiles && !hasContainerProfiles {
+		var err error
+		if hasRoleProfiles, err = d.applySelectingProfile(ctx, pod); err != nil {
+			return err
+		}
+		if !hasRoleProfiles {
+			if hasRoleProfiles, err = d.applyDefaultProfile(ctx, pod); err != nil {
+				return err
+			}
+		}
+	}
+	if hasRoleProfiles || hasContainerProfiles {
+		profileNames := iamram.ProfileNames(pod)
 		d.logger.Info("injecting AWS IAM RA credential server into new pod",
-			"profileName", profileName, "pod", pod.GenerateName)
-		return d.mutatePodSpec(ctx, pod, profileName)
+			"profileNames", profileNames, "pod", pod.GenerateName)
+		return d.mutatePodSpec(ctx, pod, profileNames)
 	}
 
 	return nil
 }
 
-func (d *PodCustomDefaulter) mutatePodSpec(ctx context.Context, pod *corev1.Pod, profileName string) error {
-	var certSecretName string
-	var ok bool
-	if certSecretName, ok = pod.Annotations[v1.CertSecretPodAnnotationKey]; !ok {
-		return fmt.Errorf("must specify annotation %s", v1.CertSecretPodAnnotationKey)
+// applySelectingProfile names the profile selecting the pod in the pod's
+// annotations, and reports whether there is one. A pod selected by several
+// profiles is rejected, rather than given whichever role comes first.
+func (d *PodCustomDefaulter) applySelectingProfile(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if pod.Namespace == "" {
+		return false, nil
 	}
-	foundVol := false
-	for _, vol := range pod.Spec.Volumes {
-		if vol.Name == certSecretVolumeName {
-			foundVol = true
-			break
+	var profiles v1.AwsIamRaRoleProfileList
+	if err := d.client.List(ctx, &profiles, client.InNamespace(pod.Namespace)); err != nil {
+		return false, fmt.Errorf("unable to list profiles in namespace %s: %w", pod.Namespace, err)
+	}
+	var selecting []string
+	for i := range profiles.Items {
+		if iamram.SelectsPod(&profiles.Items[i], pod) {
+			selecting = append(selecting, profiles.Items[i].Name)
+		}
+	}
+	switch len(selecting) {
+	case 0:
+		return false, nil
+	case 1:
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[v1.RoleProfilePodAnnotationKey] = selecting[0]
+		return true, nil
+	}
+	sort.Strings(selecting)
+	return false, fmt.Errorf("pod is selected by profiles %s and %s, name the one to use in annotation %s",
+		strings.Join(selecting[:len(selecting)-1], ", "), selecting[len(selecting)-1], v1.RoleProfilePodAnnotationKey)
+}
+
+// applyDefaultProfile names the default profile of the pod's namespace in the
+// pod's annotations, unless the pod opts out, so that the pod is injected as
+// if it named the profile itself. It reports whether it did.
+func (d *PodCustomDefaulter) applyDefaultProfile(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if pod.Namespace == "" || pod.Annotations[v1.InjectPodAnnotationKey] == "false" {
+		return false, nil
+	}
+	namespace, err := d.namespaceOf(ctx, pod)
+	if err != nil || namespace == nil {
+		return false, err
+	}
+	profileName := namespaceDefault(namespace, v1.DefaultProfileNamespaceKey)
+	if profileName == "" {
+		return false, nil
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[v1.RoleProfilePodAnnotationKey] = profileName
+	return true, nil
+}
+
+// namespaceOf fetches the pod's namespace, or returns nil if it doesn't exist.
+func (d *PodCustomDefaulter) namespaceOf(ctx context.Context, pod *corev1.Pod) (*corev1.Namespace, error) {
+	var namespace corev1.Namespace
+	if err := d.client.Get(ctx, types.NamespacedName{Name: pod.Namespace}, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to fetch namespace %s: %w", pod.Namespace, err)
+	}
+	return &namespace, nil
+}
+
+// namespaceDefault returns the namespace's annotation with the key or, failing
+// that, its label. Annotations can hold any name, labels are easier to select
+// namespaces by.
+func namespaceDefault(namespace *corev1.Namespace, key string) string {
+	if value := namespace.Annotations[key]; value != "" {
+		return value
+	}
+	return namespace.Labels[key]
+}
+
+func (d *PodCustomDefaulter) mutatePodSpec(ctx context.Context, pod *corev1.Pod, profileNames []string) error {
+	if len(profileNames) == 0 {
+		return fmt.Errorf("annotation %s or %s must name at least one profile",
+			v1.RoleProfilePodAnnotationKey, v1.ContainerProfilesPodAnnotationKey)
+	}
+	containerProfiles, err := containerProfileIndexes(pod, profileNames)
+	if err != nil {
+		return err
+	}
+
+	profiles := make([]v1.AwsIamRaRoleProfile, len(profileNames))
+	for i, profileName := range profileNames {
+		profileNsName := types.NamespacedName{
+			Namespace: pod.Namespace,
+			Name:      profileName,
+		}
+		if err := d.client.Get(ctx, profileNsName, &profiles[i]); err != nil {
+			d.logger.Info("unable to fetch AwsIamRaRoleProfile", "profileName", profileName)
+			return err
+		}
+	}
+
+	for i := range profiles {
+		allowed, err := iamram.ServiceAccountAllowed(ctx, d.client, &profiles[i], pod)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("profile %s doesn't allow service account %s, see its spec.allowedServiceAccounts",
+				profiles[i].Name, iamram.ServiceAccountName(pod))
+		}
+	}
+	if err := d.checkUsePermission(ctx, pod, profiles); err != nil {
+		return err
+	}
+
+	// Whatever the creator of the pod set in the annotations the controller
+	// keeps up to date is replaced by the state of the profiles admitted.
+	for _, key := range controllerPodAnnotationKeys {
+		delete(pod.Annotations, key)
+	}
+	for i := range profiles {
+		iamram.SetProfileHash(pod.Annotations, profiles[i].Name, iamram.ProfileHash(&profiles[i]))
+	}
+
+	delivery, err := d.credentialDelivery(ctx, pod, &profiles[0])
+	if err != nil {
+		return err
+	}
+	pod.Annotations[v1.DeliveryPodAnnotationKey] = string(delivery)
+	if delivery == v1.CredentialDeliveryNodeAgent {
+		return d.configureForNodeAgent(pod, profiles)
+	}
+
+	// Pods of suspended profiles, or of profiles outside their activation
+	// window, are admitted, but their sidecar starts with the profiles
+	// suspended until the controller tells it otherwise. Within the window,
+	// the sidecar stops serving a profile by itself when the window closes.
+	now := time.Now()
+	for i := range profiles {
+		activation, err := iamram.ProfileActivation(&profiles[i].Spec, now)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profiles[i].Name, err)
 		}
+		iamram.SetProfileSuspended(pod.Annotations, profiles[i].Name, profiles[i].Spec.Suspended || !activation.Active)
+		iamram.SetProfileActiveUntil(pod.Annotations, profiles[i].Name, activation.Until())
+	}
+
+	sidecarConfig := d.config.Sidecar(profiles[0].Spec.Sidecar)
+	if sidecarConfig.Image == "" {
+		return fmt.Errorf("no sidecar image is configured, set sidecar.image in the IamRaManagerConfig or %s",
+			managerconfig.SidecarImageEnvVar)
+	}
+
+	if err := d.applyDefaultCertSecret(ctx, pod, &profiles[0]); err != nil {
+		return err
+	}
+	certVolume, err := certVolumeSource(pod, &profiles[0])
+	if err != nil {
+		return err
 	}
-	if !foundVol {
-		pod.Spec.Volumes = append(pod.Spec.Volumes,
-			corev1.Volume{
-				Name: certSecretVolumeName,
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: certSecretName,
+	mode := mountedCertificate
+	switch {
+	case certVolume != nil:
+		addVolumeIfMissing(pod, corev1.Volume{
+			Name:         certSecretVolumeName,
+			VolumeSource: *certVolume,
+		})
+	case profiles[0].Spec.CertificateSource() == v1.CertificateSourceBroker:
+		if d.broker.URL == "" {
+			return fmt.Errorf("profile %s uses the credential broker, but the controller has no --broker-url",
+				profiles[0].Name)
+		}
+		mode = brokeredCredentials
+		addVolumeIfMissing(pod, brokerTokenVolume())
+	default:
+		if automount := pod.Spec.AutomountServiceAccountToken; automount != nil && !*automount {
+			return fmt.Errorf("profile %s requests certificates with the service account token, "+
+				"which the pod doesn't mount", profiles[0].Name)
+		}
+		mode = requestedCertificate
+	}
+
+	// Role profiles come first in the list of profiles the sidecar serves, and
+	// apply to every container that isn't assigned a profile of its own.
+	roleProfiles := profiles[:len(iamram.RoleProfileNames(pod))]
+	if len(roleProfiles) > 1 {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[awsConfigAnnotationKey] = renderAwsConfig(roleProfiles, int(sidecarConfig.Port))
+		addVolumeIfMissing(pod, corev1.Volume{
+			Name: awsConfigVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				DownwardAPI: &corev1.DownwardAPIVolumeSource{
+					Items: []corev1.DownwardAPIVolumeFile{
+						{
+							Path: "config",
+							FieldRef: &corev1.ObjectFieldSelector{
+								FieldPath: fmt.Sprintf("metadata.annotations['%s']", awsConfigAnnotationKey),
+							},
+						},
 					},
 				},
 			},
-		)
+		})
 	}
 
+	excluded := iamram.ExcludedContainers(pod)
 	for i := range pod.Spec.Containers {
 		container := &pod.Spec.Containers[i]
-		foundEnv := false
-		for _, env := range container.Env {
-			if env.Name == imdsEndpointEnvVar {
-				foundEnv = true
-				break
+		if excluded[container.Name] {
+			continue
+		}
+		if index, ok := containerProfiles[container.Name]; ok {
+			configureContainer(container, profiles[index:index+1], int(sidecarConfig.Port), index)
+		} else {
+			configureContainer(container, roleProfiles, int(sidecarConfig.Port), 0)
+		}
+	}
+
+	return d.injectSidecar(pod, profiles, mode, &sidecarConfig)
+}
+
+// checkUsePermission denies the pod if it uses a profile requiring the use
+// permission that the user creating it doesn't have. Pods of workloads are
+// created by built-in controllers, so for them it is the user who last
+// changed the workload's pod template, as the workload webhook recorded on
+// the workload, or on the one controlling it.
+func (d *PodCustomDefaulter) checkUsePermission(
+	ctx context.Context, pod *corev1.Pod, profiles []v1.AwsIamRaRoleProfile,
+) error {
+	requireAll := d.config.RequireProfileUsePermission()
+	var required []*v1.AwsIamRaRoleProfile
+	for i := range profiles {
+		if iamram.UsePermissionRequired(&profiles[i], requireAll) {
+			required = append(required, &profiles[i])
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to tell who creates the pod: %w", err)
+	}
+
+	var requester *authenticationv1.UserInfo
+	var workload string
+	for _, profile := range required {
+		allowed, err := iamram.MayUseProfile(ctx, d.client, req.UserInfo, profile)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			continue
+		}
+		if !builtInController(req.UserInfo) || metav1.GetControllerOf(pod) == nil {
+			return fmt.Errorf("%s may not use profile %s: grant them the %q verb on awsiamraroleprofiles/%s "+
+				"in namespace %s", req.UserInfo.Username, profile.Name, v1.ProfileUseVerb, profile.Name,
+				profile.Namespace)
+		}
+		if requester == nil {
+			if requester, workload, err = d.workloadRequester(ctx, pod); err != nil {
+				return err
+			}
+		}
+		if allowed, err = iamram.MayUseProfile(ctx, d.client, *requester, profile); err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("%s, who last changed the pod template of %s, may not use profile %s: grant them "+
+				"the %q verb on awsiamraroleprofiles/%s in namespace %s", requester.Username, workload,
+				profile.Name, v1.ProfileUseVerb, profile.Name, profile.Namespace)
+		}
+	}
+	return nil
+}
+
+// workloadRequester returns the requester recorded on the workload the pod
+// belongs to, following controller references from the pod until a workload
+// has one, and names that workload.
+func (d *PodCustomDefaulter) workloadRequester(
+	ctx context.Context, pod *corev1.Pod,
+) (*authenticationv1.UserInfo, string, error) {
+	var obj metav1.Object = pod
+	// Pods are at most two controllers away from the workload a user changed,
+	// as with the ReplicaSets of Deployments and the Jobs of CronJobs.
+	for depth := 0; depth < 2; depth++ {
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil {
+			break
+		}
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid controller reference: %w", err)
+		}
+		gvk := gv.WithKind(owner.Kind)
+		name := owner.Kind + " " + owner.Name
+		newWorkload, ok := workloadKinds[gvk.GroupKind()]
+		if !ok {
+			return nil, "", fmt.Errorf("no requester is recorded on %s: pods of a %s only use profiles "+
+				"requiring the use permission if the user creating them may use them", name, gvk.GroupKind())
+		}
+		workload := newWorkload()
+		if err := d.reader.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name},
+			workload); err != nil {
+			return nil, "", fmt.Errorf("unable to fetch %s: %w", name, err)
+		}
+		if workload.GetUID() != owner.UID {
+			return nil, "", fmt.Errorf("%s no longer exists", name)
+		}
+		requester, err := iamram.Requester(workload)
+		if err != nil {
+			return nil, "", err
+		}
+		if requester != nil {
+			hash := iamram.PodTemplateHash(podTemplate(workload))
+			if workload.GetAnnotations()[v1.RequesterTemplateAnnotationKey] != hash {
+				return nil, "", fmt.Errorf("the requester recorded on %s is for another pod template, "+
+					"which likely changed while the workload webhook was unavailable: change it again", name)
 			}
+			return requester, name, nil
+		}
+		obj = workload
+	}
+	return nil, "", fmt.Errorf("no requester is recorded in annotation %s of the workload of the pod",
+		v1.RequesterAnnotationKey)
+}
+
+// credentialDelivery returns how the pod gets its credentials, as its first
+// profile or, failing that, its namespace says.
+func (d *PodCustomDefaulter) credentialDelivery(
+	ctx context.Context, pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile,
+) (v1.CredentialDelivery, error) {
+	if profile.Spec.Delivery != "" {
+		return profile.Spec.Delivery, nil
+	}
+	namespace, err := d.namespaceOf(ctx, pod)
+	if err != nil {
+		return "", err
+	}
+	if namespace == nil {
+		return v1.CredentialDeliverySidecar, nil
+	}
+	return profile.Spec.CredentialDelivery(namespace.Labels), nil
+}
+
+// applyDefaultCertSecret names the default cert Secret of the pod's namespace
+// in the pod's annotations, if the profile mounts a Secret and the pod
+// doesn't name one.
+func (d *PodCustomDefaulter) applyDefaultCertSecret(
+	ctx context.Context, pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile,
+) error {
+	if _, ok := pod.Annotations[v1.CertSecretPodAnnotationKey]; ok ||
+		profile.Spec.CertificateSource() != v1.CertificateSourceSecret {
+		return nil
+	}
+	namespace, err := d.namespaceOf(ctx, pod)
+	if err != nil || namespace == nil {
+		return err
+	}
+	if secretName := namespaceDefault(namespace, v1.DefaultCertSecretNamespaceKey); secretName != "" {
+		pod.Annotations[v1.CertSecretPodAnnotationKey] = secretName
+	}
+	return nil
+}
+
+// configureForNodeAgent points the containers of the pod at the node agent
+// instead of injecting a sidecar. The agent tells pods apart by their IP and
+// serves a single profile per pod.
+func (d *PodCustomDefaulter) configureForNodeAgent(pod *corev1.Pod, profiles []v1.AwsIamRaRoleProfile) error {
+	profile := profiles[0]
+	switch {
+	case d.nodeAgentEndpoint == "":
+		return fmt.Errorf("profile %s is delivered by the node agent, but the controller has no "+
+			"--node-agent-endpoint", profile.Name)
+	case len(profiles) > 1 || pod.Annotations[v1.ContainerProfilesPodAnnotationKey] != "":
+		return fmt.Errorf("profile %s is delivered by the node agent, which serves a single profile to "+
+			"every container of a pod", profile.Name)
+	case pod.Annotations[v1.CertSecretPodAnnotationKey] != "":
+		return fmt.Errorf("profile %s is delivered by the node agent, which doesn't use annotation %s",
+			profile.Name, v1.CertSecretPodAnnotationKey)
+	case pod.Spec.HostNetwork:
+		return fmt.Errorf("profile %s is delivered by the node agent, which can't tell pods on the host "+
+			"network apart", profile.Name)
+	}
+
+	excluded := iamram.ExcludedContainers(pod)
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if excluded[container.Name] {
+			continue
 		}
-		if !foundEnv {
-			container.Env = append(container.Env, corev1.EnvVar{
-				Name:  imdsEndpointEnvVar,
-				Value: imdsEndpoint,
+		// Variables can only refer to the ones defined before them.
+		if strings.Contains(d.nodeAgentEndpoint, "$("+hostIPEnvVar+")") {
+			setEnvIfMissing(container, corev1.EnvVar{
+				Name: hostIPEnvVar,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"},
+				},
 			})
 		}
+		setEnvIfMissing(container, corev1.EnvVar{Name: imdsEndpointEnvVar, Value: d.nodeAgentEndpoint})
+		if region, err := rolesanywhere.Region(string(profile.Spec.TrustAnchorArn)); err == nil {
+			setEnvIfMissing(container, corev1.EnvVar{Name: regionEnvVar, Value: region})
+		}
+	}
+	return nil
+}
+
+// certVolumeSource returns the volume the sidecar reads the pod's certificate
+// from: the cert Secret named in the pod annotation or, when the profile uses
+// the controller's CA, the Secret the controller issues a certificate for the
+// pod into. The pod can't start until that Secret exists. It returns nil when
+// the sidecar requests its certificate itself or uses the credential broker.
+func certVolumeSource(pod *corev1.Pod, profile *v1.AwsIamRaRoleProfile) (*corev1.VolumeSource, error) {
+	if certSecretName, ok := pod.Annotations[v1.CertSecretPodAnnotationKey]; ok {
+		return &corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: certSecretName,
+			},
+		}, nil
+	}
+	switch profile.Spec.CertificateSource() {
+	case v1.CertificateSourceCertificateSigningRequest, v1.CertificateSourceBroker:
+		return nil, nil
+	case v1.CertificateSourceCertManager:
+		certificateName, ok := pod.Annotations[v1.CertManagerCertificatePodAnnotationKey]
+		if !ok {
+			data, err := iamram.CertificateSubjectDataFor(pod, profile, "")
+			if err != nil {
+				return nil, err
+			}
+			certificateName = iamram.CertManagerCertificateName(data)
+			pod.Annotations[v1.CertManagerCertificatePodAnnotationKey] = certificateName
+		}
+		return projectedCertVolumeSource(certificateName), nil
+	case v1.CertificateSourceControllerCA:
+		issuedSecretName, ok := pod.Annotations[v1.IssuedCertSecretPodAnnotationKey]
+		if !ok {
+			// The pod may not have a name yet, so the Secret gets a random one.
+			issuedSecretName = issuedCertSecretPrefix + utilrand.String(8)
+			pod.Annotations[v1.IssuedCertSecretPodAnnotationKey] = issuedSecretName
+		}
+		return projectedCertVolumeSource(issuedSecretName), nil
+	default:
+		return nil, fmt.Errorf("must specify annotation %s", v1.CertSecretPodAnnotationKey)
+	}
+}
+
+// projectedCertVolumeSource mounts a kubernetes.io/tls Secret created after
+// the pod. Every key is mounted, so that the sidecar finds the certificate
+// chain in ca.crt or any PKCS#12 bundle cert-manager writes.
+func projectedCertVolumeSource(secretName string) *corev1.VolumeSource {
+	return &corev1.VolumeSource{
+		Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					},
+				},
+			},
+		},
+	}
+}
+
+// brokerTokenVolume mounts a service account token for the credential broker.
+// Its audience keeps the broker from accepting tokens meant for the API
+// server, and kubelet rotates it before it expires.
+func brokerTokenVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: brokerTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          v1.BrokerTokenAudience,
+							ExpirationSeconds: ptr.To[int64](brokerTokenExpirationSecs),
+							Path:              "token",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// passphraseVolume mounts the passphrase of the certificate's private key
+// from its own Secret, so that it isn't stored next to the key.
+func passphraseVolume(ref *v1.SecretKeyReference) corev1.Volume {
+	key := ref.Key
+	if key == "" {
+		key = passphraseFileName
+	}
+	return corev1.Volume{
+		Name: passphraseVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: ref.Name,
+				Items:      []corev1.KeyToPath{{Key: key, Path: passphraseFileName}},
+			},
+		},
+	}
+}
+
+// containerProfileIndexes maps the containers with a profile of their own to
+// the index of that profile, rejecting assignments that don't add up.
+func containerProfileIndexes(pod *corev1.Pod, profileNames []string) (map[string]int, error) {
+	mappings, err := iamram.ContainerProfiles(pod)
+	if err != nil {
+		return nil, err
+	}
+	containers := map[string]bool{}
+	for _, container := range pod.Spec.Containers {
+		containers[container.Name] = true
+	}
+	excluded := iamram.ExcludedContainers(pod)
+
+	indexes := map[string]int{}
+	for _, mapping := range mappings {
+		if !containers[mapping.Container] {
+			return nil, fmt.Errorf("annotation %s assigns a profile to unknown container %s",
+				v1.ContainerProfilesPodAnnotationKey, mapping.Container)
+		}
+		if excluded[mapping.Container] {
+			return nil, fmt.Errorf("container %s is assigned a profile in %s but excluded in %s",
+				mapping.Container, v1.ContainerProfilesPodAnnotationKey, v1.ExcludedContainersPodAnnotationKey)
+		}
+		for i, name := range profileNames {
+			if name == mapping.Profile {
+				indexes[mapping.Container] = i
+			}
+		}
+	}
+	return indexes, nil
+}
+
+// configureContainer points a container at its profiles. The sidecar serves
+// the profile at index i on port basePort+i, and the first of the container's
+// profiles has index firstIndex.
+//
+// With a single profile, the container gets the metadata service endpoint
+// through the environment. With several, that variable would override the
+// endpoint of every profile, so the container gets the shared config file
+// instead.
+func configureContainer(
+	container *corev1.Container, profiles []v1.AwsIamRaRoleProfile, basePort, firstIndex int,
+) {
+	switch {
+	case len(profiles) == 0:
+		return
+	case len(profiles) > 1:
+		addVolumeMountIfMissing(container, corev1.VolumeMount{
+			Name:      awsConfigVolumeName,
+			ReadOnly:  true,
+			MountPath: awsConfigMountPath,
+		})
+		setEnvIfMissing(container, corev1.EnvVar{Name: awsConfigFileEnvVar, Value: awsConfigMountPath + "/config"})
+	default:
+		setEnvIfMissing(container, corev1.EnvVar{Name: imdsEndpointEnvVar, Value: iamram.ImdsEndpoint(basePort, firstIndex)})
+	}
+	if region, err := rolesanywhere.Region(string(profiles[0].Spec.TrustAnchorArn)); err == nil {
+		setEnvIfMissing(container, corev1.EnvVar{Name: regionEnvVar, Value: region})
+	}
+}
+
+// renderAwsConfig returns a shared config file with a named AWS profile for
+// each role profile, pointing at the port the sidecar serves it on. The
+// first profile is also the default AWS profile. Profiles set the metadata
+// endpoint, since container credentials can't be set per profile and
+// credential_process would need a helper in the application image.
+func renderAwsConfig(profiles []v1.AwsIamRaRoleProfile, basePort int) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "[default]\nec2_metadata_service_endpoint = %s\n", iamram.ImdsEndpoint(basePort, 0))
+	for i, profile := range profiles {
+		fmt.Fprintf(&config, "\n[profile %s]\nec2_metadata_service_endpoint = %s\n",
+			profile.Name, iamram.ImdsEndpoint(basePort, i))
+	}
+	return config.String()
+}
+
+func addVolumeIfMissing(pod *corev1.Pod, volume corev1.Volume) {
+	for _, existing := range pod.Spec.Volumes {
+		if existing.Name == volume.Name {
+			return
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+}
+
+func addVolumeMountIfMissing(container *corev1.Container, mount corev1.VolumeMount) {
+	for _, existing := range container.VolumeMounts {
+		if existing.Name == mount.Name {
+			return
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+}
+
+func setEnvIfMissing(container *corev1.Container, env corev1.EnvVar) {
+	for _, existing := range container.Env {
+		if existing.Name == env.Name {
+			return
+		}
 	}
+	container.Env = append(container.Env, env)
+}
 
-	return d.injectSidecar(ctx, pod, profileName)
+func addImagePullSecretIfMissing(pod *corev1.Pod, secret corev1.LocalObjectReference) {
+	for _, existing := range pod.Spec.ImagePullSecrets {
+		if existing.Name == secret.Name {
+			return
+		}
+	}
+	pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, secret)
 }
 
-func (d *PodCustomDefaulter) injectSidecar(ctx context.Context, pod *corev1.Pod, profileName string) error {
+// injectSidecar adds the credential server. With requestedCertificate, the
+// sidecar generates its private key and requests a certificate through a
+// CertificateSigningRequest instead of mounting the cert volume, and with
+// brokeredCredentials it mounts a token for the credential broker instead.
+// Otherwise it reads the cert volume as the first profile's certificate
+// settings say. The container is set up as config says.
+func (d *PodCustomDefaulter) injectSidecar(
+	pod *corev1.Pod, profiles []v1.AwsIamRaRoleProfile, mode sidecarMode, config *v1.SidecarConfig,
+) error {
 	for _, ctr := range pod.Spec.InitContainers {
 		if ctr.Name == sidecarContainerName {
 			return nil
 		}
 	}
 
-	profileNsName := types.NamespacedName{
-		Namespace: pod.Namespace,
-		Name:      profileName,
-	}
-	var profile v1.AwsIamRaRoleProfile
-	if err := d.client.Get(ctx, profileNsName, &profile); err != nil {
-		d.logger.Info("unable to fetch AwsIamRaRoleProfile")
-		return err
-	}
+	profile := profiles[0]
 	command := []string{
 		"serve-credentials",
 		"-t", string(profile.Spec.TrustAnchorArn),
 		"-p", string(profile.Spec.ProfileArn),
 		"-r", string(profile.Spec.RoleArn),
 		"-d", strconv.Itoa(int(profile.Spec.DurationSeconds)),
+		"-P", profile.Name,
 	}
 	if profile.Spec.RoleSessionName != "" {
 		command = append(command, "-n", profile.Spec.RoleSessionName)
 	}
+	if profile.Spec.ImdsV2Only {
+		command = append(command, "-v")
+	}
+	if iamram.ProfileSuspended(pod.Annotations, profile.Name) {
+		command = append(command, "-S")
+	}
+	if until := iamram.ProfileActiveUntil(pod.Annotations, profile.Name); !until.IsZero() {
+		command = append(command, "-U", until.Format(time.RFC3339))
+	}
+	command = append(command, "-o", strconv.Itoa(int(config.Port)))
+	if config.LogLevel != "" {
+		command = append(command, "-l", string(config.LogLevel))
+	}
 
-	d.logger.Info("creating sidecar container", "command", command)
-	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
-		Name:          sidecarContainerName,
-		Image:         sidecarContainerImage,
-		RestartPolicy: &sidecarContainerRestartPolicy,
-		Command:       command,
-		VolumeMounts: []corev1.VolumeMount{
-			{
-				Name:      certSecretVolumeName,
-				ReadOnly:  true,
-				MountPath: sidecarCertMountPath,
+	env := []corev1.EnvVar{
+		{
+			Name: podNameEnvVar,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+		{
+			Name: podIPEnvVar,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
 			},
 		},
-	})
+	}
+	var volumeMounts []corev1.VolumeMount
+	switch mode {
+	case brokeredCredentials:
+		command = append(command, "-B", d.broker.URL)
+		if len(d.broker.CA) > 0 {
+			env = append(env, corev1.EnvVar{Name: sidecar.BrokerCAEnvVar, Value: string(d.broker.CA)})
+		}
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      brokerTokenVolumeName,
+			ReadOnly:  true,
+			MountPath: sidecarBrokerMountPath,
+		})
+	case requestedCertificate:
+		command = append(command, "-k")
+		env = append(env,
+			corev1.EnvVar{
+				Name: podNamespaceEnvVar,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+			corev1.EnvVar{
+				Name: podServiceAccountEnvVar,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.serviceAccountName"},
+				},
+			})
+	default:
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      certSecretVolumeName,
+			ReadOnly:  true,
+			MountPath: sidecarCertMountPath,
+		})
+		if spec := profile.Spec.Certificate; spec != nil {
+			if spec.ChainKey != "" {
+				command = append(command, "-c", spec.ChainKey)
+			}
+			if spec.PKCS12Key != "" {
+				command = append(command, "-b", spec.PKCS12Key)
+			}
+			if spec.PassphraseSecretRef != nil {
+				command = append(command, "-s")
+				addVolumeIfMissing(pod, passphraseVolume(spec.PassphraseSecretRef))
+				volumeMounts = append(volumeMounts, corev1.VolumeMount{
+					Name:      passphraseVolumeName,
+					ReadOnly:  true,
+					MountPath: sidecarPassphraseMountPath,
+				})
+			}
+		}
+	}
+	if len(profiles) > 1 {
+		var additional []sidecar.ProfileConfig
+		for i, profile := range profiles[1:] {
+			var activeUntil *time.Time
+			if until := iamram.ProfileActiveUntil(pod.Annotations, profile.Name); !until.IsZero() {
+				activeUntil = &until
+			}
+			additional = append(additional, sidecar.ProfileConfig{
+				Name:            profile.Name,
+				Port:            iamram.ProfilePort(int(config.Port), i+1),
+				TrustAnchorArn:  string(profile.Spec.TrustAnchorArn),
+				ProfileArn:      string(profile.Spec.ProfileArn),
+				RoleArn:         string(profile.Spec.RoleArn),
+				DurationSeconds: profile.Spec.DurationSeconds,
+				RoleSessionName: profile.Spec.RoleSessionName,
+				ImdsV2Only:      profile.Spec.ImdsV2Only,
+				Suspended:       iamram.ProfileSuspended(pod.Annotations, profile.Name),
+				ActiveUntil:     activeUntil,
+			})
+		}
+		encoded, err := json.Marshal(additional)
+		if err != nil {
+			return err
+		}
+		env = append(env, corev1.EnvVar{Name: sidecar.AdditionalProfilesEnvVar, Value: string(encoded)})
+	}
+
+	for _, secret := range config.ImagePullSecrets {
+		addImagePullSecretIfMissing(pod, secret)
+	}
+
+	d.logger.Info("creating sidecar container", "command", command, "image", config.Image)
+	container := corev1.Container{
+		Name:            sidecarContainerName,
+		Image:           config.Image,
+		ImagePullPolicy: config.ImagePullPolicy,
+		RestartPolicy:   &sidecarContainerRestartPolicy,
+		Command:         command,
+		Env:             env,
+		VolumeMounts:    volumeMounts,
+		SecurityContext: config.SecurityContext,
+	}
+	if config.Resources != nil {
+		container.Resources = *config.Resources
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
 
 	return nil
 }
+
+// +kubebuilder:webhook:path=/validate--v1-pod,mutating=false,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=update,versions=v1,name=vpod-v1.kb.io,admissionReviewVersions=v1
+
+// PodCustomValidator keeps the annotations the pod webhook checked when a pod
+// was created from changing, so that nobody who may update pods can switch
+// them to a profile or certificate they weren't admitted with. It also keeps
+// anyone but the manager from changing the annotations the controller sets.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as this struct is used only for temporary operations and does not need to be deeply copied.
+type PodCustomValidator struct {
+	logger logr.Logger
+	// manager is the user the manager authenticates as.
+	manager string
+}
+
+var _ webhook.CustomValidator = &PodCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type Pod.
+func (v *PodCustomValidator) ValidateCreate(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type Pod.
+func (v *PodCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("expected a Pod object for the oldObj but got %T", oldObj)
+	}
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("expected a Pod object for the newObj but got %T", newObj)
+	}
+	if key, changed := changedAnnotation(oldPod, pod, immutablePodAnnotationKeys); changed {
+		v.logger.Info("Rejecting a change of an immutable pod annotation", "pod", client.ObjectKeyFromObject(pod),
+			"annotation", key)
+		return nil, apierrors.NewForbidden(corev1.Resource("pods"), pod.Name,
+			fmt.Errorf("annotation %s can't be changed once the pod is created", key))
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err == nil && v.manager != "" && req.UserInfo.Username == v.manager {
+		return nil, nil
+	}
+	if key, changed := changedAnnotation(oldPod, pod, controllerPodAnnotationKeys); changed {
+		v.logger.Info("Rejecting a change of a pod annotation set by the controller",
+			"pod", client.ObjectKeyFromObject(pod), "annotation", key)
+		return nil, apierrors.NewForbidden(corev1.Resource("pods"), pod.Name,
+			fmt.Errorf("annotation %s is set by the controller", key))
+	}
+	return nil, nil
+}
+
+// changedAnnotation returns the first of the annotations that was added,
+// removed or changed by an update of the pod.
+func changedAnnotation(oldPod, pod *corev1.Pod, keys []string) (string, bool) {
+	for _, key := range keys {
+		oldValue, hadKey := oldPod.Annotations[key]
+		value, hasKey := pod.Annotations[key]
+		if hadKey != hasKey || oldValue != value {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type Pod.
+func (v *PodCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -17,11 +17,131 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"dancav.io/aws-iamra-manager/internal/imds"
+	"dancav.io/aws-iamra-manager/internal/managerconfig"
+	"dancav.io/aws-iamra-manager/internal/rolesanywhere"
 )
 
+type staticProvider struct {
+	creds *rolesanywhere.Credentials
+}
+
+func (p *staticProvider) Retrieve(_ context.Context) (*rolesanywhere.Credentials, error) {
+	return p.creds, nil
+}
+
+// listenOnConsecutivePorts listens on n consecutive ports of the loopback
+// interface, as the sidecar serves profiles.
+func listenOnConsecutivePorts(n int) []net.Listener {
+	for attempt := 0; attempt < 100; attempt++ {
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		listeners := []net.Listener{first}
+		for i := 1; i < n; i++ {
+			port := first.Addr().(*net.TCPAddr).Port + i
+			listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				break
+			}
+			listeners = append(listeners, listener)
+		}
+		if len(listeners) == n {
+			return listeners
+		}
+		for _, listener := range listeners {
+			Expect(listener.Close()).To(Succeed())
+		}
+	}
+	Fail("no consecutive free ports")
+	return nil
+}
+
+func newFakeDefaulter(objs ...apimachineryruntime.Object) PodCustomDefaulter {
+	scheme := apimachineryruntime.NewScheme()
+	Expect(v1.AddToScheme(scheme)).To(Succeed())
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+	Expect(batchv1.AddToScheme(scheme)).To(Succeed())
+	defaults := managerconfig.Defaults()
+	defaults.Sidecar.Image = "ghcr.io/dancavio/aws-iamra-manager/sidecar:test"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	return PodCustomDefaulter{
+		client: c,
+		reader: c,
+		logger: logr.Discard(),
+		config: managerconfig.NewStore(defaults),
+	}
+}
+
+func newTestProfile(name ...string) *v1.AwsIamRaRoleProfile {
+	profileName := "test-profile"
+	if len(name) > 0 {
+		profileName = name[0]
+	}
+	return &v1.AwsIamRaRoleProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: profileName, Namespace: "default"},
+		Spec: v1.AwsIamRaRoleProfileSpec{
+			TrustAnchorArn:  "arn:aws:rolesanywhere:us-west-2:123:trust-anchor/foo",
+			ProfileArn:      "arn:aws:rolesanywhere:us-west-2:123:profile/bar",
+			RoleArn:         "arn:aws:iam::123:role/baz",
+			DurationSeconds: 3600,
+		},
+	}
+}
+
+func newAnnotatedPod(containers ...corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+			Annotations: map[string]string{
+				v1.RoleProfilePodAnnotationKey: "test-profile",
+				v1.CertSecretPodAnnotationKey:  "test-secret",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: containers},
+	}
+}
+
+func findEnv(env []corev1.EnvVar, name string) *corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == name {
+			return &env[i]
+		}
+	}
+	return nil
+}
+
 var _ = Describe("Pod Webhook", func() {
 	var (
 		obj       *corev1.Pod
@@ -58,6 +178,645 @@ var _ = Describe("Pod Webhook", func() {
 			Expect(pod.Spec.Containers[0].Env[0].Name).To(Equal("FOO"))
 			Expect(pod.Spec.Containers[0].Env[0].Value).To(Equal("bar"))
 		})
+
+		It("Should inject the region unless the container already sets it", func() {
+			defaulter = newFakeDefaulter(newTestProfile())
+			pod := newAnnotatedPod(
+				corev1.Container{Name: "app"},
+				corev1.Container{Name: "other", Env: []corev1.EnvVar{{Name: regionEnvVar, Value: "eu-west-1"}}},
+			)
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(findEnv(pod.Spec.Containers[0].Env, regionEnvVar).Value).To(Equal("us-west-2"))
+			Expect(findEnv(pod.Spec.Containers[1].Env, regionEnvVar).Value).To(Equal("eu-west-1"))
+			Expect(findEnv(pod.Spec.Containers[0].Env, imdsEndpointEnvVar).Value).To(Equal("http://127.0.0.1:9911/"))
+		})
+
+		It("Should pass the pod identity and IMDSv2 setting to the sidecar", func() {
+			profile := newTestProfile()
+			profile.Spec.ImdsV2Only = true
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).To(ContainElement("-v"))
+			Expect(findEnv(sidecar.Env, podNameEnvVar).ValueFrom.FieldRef.FieldPath).To(Equal("metadata.name"))
+		})
+
+		It("Should start the sidecar with suspended profiles suspended", func() {
+			reader := newTestProfile("reader")
+			writer := newTestProfile("writer")
+			writer.Spec.Suspended = true
+			defaulter = newFakeDefaulter(reader, writer)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "reader,writer"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).NotTo(ContainElement("-S"))
+			Expect(findEnv(sidecar.Env, "IAMRAM_ADDITIONAL_PROFILES").Value).To(ContainSubstring(`"suspended":true`))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.SuspendedProfilesPodAnnotationKey, "writer"))
+
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "writer"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers[0].Command).To(ContainElement("-S"))
+		})
+
+		It("Should start the sidecar with profiles outside their activation window suspended", func() {
+			until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			reader := newTestProfile("reader")
+			reader.Spec.ActiveUntil = &metav1.Time{Time: until}
+			writer := newTestProfile("writer")
+			writer.Spec.ActiveFrom = &metav1.Time{Time: until}
+			defaulter = newFakeDefaulter(reader, writer)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "reader,writer"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).NotTo(ContainElement("-S"))
+			Expect(sidecar.Command).To(ContainElements("-U", until.Format(time.RFC3339)))
+			Expect(findEnv(sidecar.Env, "IAMRAM_ADDITIONAL_PROFILES").Value).To(ContainSubstring(`"suspended":true`))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.SuspendedProfilesPodAnnotationKey, "writer"))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.ActiveUntilPodAnnotationKey,
+				"reader="+until.Format(time.RFC3339)))
+		})
+
+		It("Should serve each listed profile on its own port through a shared config file", func() {
+			defaulter = newFakeDefaulter(newTestProfile("reader"), newTestProfile("writer"))
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "reader, writer"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			Expect(pod.Annotations[awsConfigAnnotationKey]).To(Equal(
+				"[default]\nec2_metadata_service_endpoint = http://127.0.0.1:9911/\n" +
+					"\n[profile reader]\nec2_metadata_service_endpoint = http://127.0.0.1:9911/\n" +
+					"\n[profile writer]\nec2_metadata_service_endpoint = http://127.0.0.1:9912/\n"))
+			app := pod.Spec.Containers[0]
+			Expect(findEnv(app.Env, imdsEndpointEnvVar)).To(BeNil())
+			Expect(findEnv(app.Env, awsConfigFileEnvVar).Value).To(Equal("/iamram/aws/config"))
+			Expect(app.VolumeMounts).To(ContainElement(HaveField("Name", awsConfigVolumeName)))
+
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).To(ContainElements("-P", "reader"))
+			Expect(findEnv(sidecar.Env, "IAMRAM_ADDITIONAL_PROFILES").Value).To(ContainSubstring(`"port":9912`))
+
+			By("running the webhook again, as on reinvocation")
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.Volumes).To(HaveLen(2))
+		})
+
+		It("Should let SDKs resolve each named profile to the port its sidecar serves it on", func() {
+			// Shared config files can't configure container credentials, which only
+			// environment variables do, and credential_process would need a helper in
+			// the app image, so named profiles point at their own metadata endpoint.
+			for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_PROFILE",
+				"AWS_EC2_METADATA_SERVICE_ENDPOINT", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+				"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+				GinkgoT().Setenv(key, "")
+			}
+			listeners := listenOnConsecutivePorts(2)
+			basePort := listeners[0].Addr().(*net.TCPAddr).Port
+			for i, name := range []string{"reader", "writer"} {
+				server := httptest.NewUnstartedServer(imds.NewServer(&staticProvider{&rolesanywhere.Credentials{
+					AccessKeyID:     "AKID-" + name,
+					SecretAccessKey: "secret",
+					SessionToken:    "token",
+					Expiration:      time.Now().Add(time.Hour),
+				}}, imds.Options{RoleName: name}, logr.Discard()))
+				server.Listener = listeners[i]
+				server.Start()
+				DeferCleanup(server.Close)
+			}
+			dir := GinkgoT().TempDir()
+			configFile := filepath.Join(dir, "config")
+			Expect(os.WriteFile(configFile, []byte(renderAwsConfig(
+				[]v1.AwsIamRaRoleProfile{*newTestProfile("reader"), *newTestProfile("writer")}, basePort)), 0o600)).
+				To(Succeed())
+
+			for profile, accessKeyID := range map[string]string{
+				"default": "AKID-reader", "reader": "AKID-reader", "writer": "AKID-writer",
+			} {
+				cfg, err := awsconfig.LoadDefaultConfig(ctx,
+					awsconfig.WithSharedConfigFiles([]string{configFile}),
+					awsconfig.WithSharedCredentialsFiles([]string{filepath.Join(dir, "credentials")}),
+					awsconfig.WithSharedConfigProfile(profile),
+					awsconfig.WithRegion("us-west-2"))
+				Expect(err).NotTo(HaveOccurred())
+				creds, err := cfg.Credentials.Retrieve(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(creds.AccessKeyID).To(Equal(accessKeyID), "profile %s", profile)
+			}
+		})
+
+		It("Should serve container profiles on their own ports and skip excluded containers", func() {
+			defaulter = newFakeDefaulter(newTestProfile("reader"), newTestProfile("writer"))
+			pod := newAnnotatedPod(
+				corev1.Container{Name: "app"},
+				corev1.Container{Name: "fluentbit"},
+				corev1.Container{Name: "istio-proxy"},
+			)
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "reader"
+			pod.Annotations[v1.ContainerProfilesPodAnnotationKey] = "app=writer"
+			pod.Annotations[v1.ExcludedContainersPodAnnotationKey] = "istio-proxy"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			Expect(findEnv(pod.Spec.Containers[0].Env, imdsEndpointEnvVar).Value).To(Equal("http://127.0.0.1:9912/"))
+			Expect(findEnv(pod.Spec.Containers[1].Env, imdsEndpointEnvVar).Value).To(Equal("http://127.0.0.1:9911/"))
+			Expect(pod.Spec.Containers[2].Env).To(BeEmpty())
+			Expect(pod.Annotations).NotTo(HaveKey(awsConfigAnnotationKey))
+			Expect(findEnv(pod.Spec.InitContainers[0].Env, "IAMRAM_ADDITIONAL_PROFILES").Value).To(
+				ContainSubstring(`"name":"writer","port":9912`))
+		})
+
+		It("Should mount a certificate issued by the controller when the profile uses its CA", func() {
+			profile := newTestProfile()
+			profile.Spec.Certificate = &v1.CertificateSpec{Source: v1.CertificateSourceControllerCA}
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			secretName := pod.Annotations[v1.IssuedCertSecretPodAnnotationKey]
+			Expect(secretName).To(HavePrefix(issuedCertSecretPrefix))
+			Expect(pod.Spec.Volumes).To(HaveLen(1))
+			Expect(pod.Spec.Volumes[0].Projected.Sources[0].Secret.Name).To(Equal(secretName))
+
+			By("running the webhook again, as on reinvocation")
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations[v1.IssuedCertSecretPodAnnotationKey]).To(Equal(secretName))
+		})
+
+		It("Should require a cert secret unless the profile uses the controller's CA", func() {
+			defaulter = newFakeDefaulter(newTestProfile())
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring(v1.CertSecretPodAnnotationKey)))
+		})
+
+		It("Should let the sidecar request its certificate when the profile uses CSRs", func() {
+			profile := newTestProfile()
+			profile.Spec.Certificate = &v1.CertificateSpec{Source: v1.CertificateSourceCertificateSigningRequest}
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			Expect(pod.Spec.Volumes).To(BeEmpty())
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).To(ContainElement("-k"))
+			Expect(sidecar.VolumeMounts).To(BeEmpty())
+			Expect(findEnv(sidecar.Env, podServiceAccountEnvVar).ValueFrom.FieldRef.FieldPath).
+				To(Equal("spec.serviceAccountName"))
+
+			By("rejecting pods that don't mount their service account token")
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			pod.Spec.AutomountServiceAccountToken = ptr.To(false)
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("service account token")))
+		})
+
+		It("Should mount a token for the credential broker when the profile uses it", func() {
+			profile := newTestProfile()
+			profile.Spec.Certificate = &v1.CertificateSpec{Source: v1.CertificateSourceBroker}
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("--broker-url")))
+
+			defaulter.broker = BrokerOptions{URL: "https://broker.iamram.svc", CA: []byte("ca")}
+			pod.Spec.AutomountServiceAccountToken = ptr.To(false)
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.Volumes).To(HaveLen(1))
+			token := pod.Spec.Volumes[0].Projected.Sources[0].ServiceAccountToken
+			Expect(token.Audience).To(Equal(v1.BrokerTokenAudience))
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).To(ContainElements("-B", "https://broker.iamram.svc"))
+			Expect(sidecar.Command).NotTo(ContainElement("-k"))
+			Expect(sidecar.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+				Name: brokerTokenVolumeName, ReadOnly: true, MountPath: sidecarBrokerMountPath,
+			}))
+			Expect(findEnv(sidecar.Env, "IAMRAM_BROKER_CA").Value).To(Equal("ca"))
+		})
+
+		It("Should point pods at the node agent instead of injecting a sidecar", func() {
+			profile := newTestProfile()
+			profile.Spec.Delivery = v1.CredentialDeliveryNodeAgent
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("--node-agent-endpoint")))
+
+			defaulter.nodeAgentEndpoint = "http://$(IAMRAM_HOST_IP):9920"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+			Expect(pod.Spec.Volumes).To(BeEmpty())
+			Expect(pod.Annotations[v1.DeliveryPodAnnotationKey]).To(Equal("NodeAgent"))
+			env := pod.Spec.Containers[0].Env
+			Expect(env[0].Name).To(Equal("IAMRAM_HOST_IP"))
+			Expect(env[0].ValueFrom.FieldRef.FieldPath).To(Equal("status.hostIP"))
+			Expect(findEnv(env, "AWS_EC2_METADATA_SERVICE_ENDPOINT").Value).To(Equal("http://$(IAMRAM_HOST_IP):9920"))
+			Expect(findEnv(env, "AWS_REGION").Value).To(Equal("us-west-2"))
+
+			By("rejecting pods the node agent can't tell apart or serve")
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			pod.Spec.HostNetwork = true
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("host network")))
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring(v1.CertSecretPodAnnotationKey)))
+		})
+
+		It("Should use the delivery of the namespace for profiles that don't set one", func() {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "default",
+				Labels: map[string]string{v1.DeliveryNamespaceLabelKey: "NodeAgent"},
+			}}
+			defaulter = newFakeDefaulter(newTestProfile(), namespace)
+			defaulter.nodeAgentEndpoint = "http://169.254.170.23"
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			pod.Annotations[v1.ProfileHashesPodAnnotationKey] = "admin-profile=0123456789"
+			pod.Annotations[v1.ActiveUntilPodAnnotationKey] = "admin-profile=2030-01-01T00:00:00Z"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.ProfileHashesPodAnnotationKey,
+				"test-profile="+iamram.ProfileHash(newTestProfile())))
+			Expect(pod.Annotations).NotTo(HaveKey(v1.ActiveUntilPodAnnotationKey))
+			Expect(pod.Spec.Containers[0].Env).To(ConsistOf(
+				corev1.EnvVar{Name: "AWS_EC2_METADATA_SERVICE_ENDPOINT", Value: "http://169.254.170.23"},
+				corev1.EnvVar{Name: "AWS_REGION", Value: "us-west-2"}))
+
+			By("letting profiles opt back into a sidecar")
+			profile := newTestProfile()
+			profile.Spec.Delivery = v1.CredentialDeliverySidecar
+			defaulter = newFakeDefaulter(profile, namespace)
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Annotations[v1.DeliveryPodAnnotationKey]).To(Equal("Sidecar"))
+		})
+
+		It("Should inject pods of a namespace with a default profile unless they opt out", func() {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "default",
+				Annotations: map[string]string{
+					v1.DefaultProfileNamespaceKey:    "test-profile",
+					v1.DefaultCertSecretNamespaceKey: "team-secret",
+				},
+			}}
+			defaulter = newFakeDefaulter(newTestProfile(), newTestProfile("other"), namespace)
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "test-profile"))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.CertSecretPodAnnotationKey, "team-secret"))
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.Volumes).To(ContainElement(corev1.Volume{
+				Name:         certSecretVolumeName,
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "team-secret"}},
+			}))
+
+			By("keeping the profile and cert Secret the pod names")
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "other"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "other"))
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.CertSecretPodAnnotationKey, "test-secret"))
+
+			By("leaving pods that opt out alone")
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: map[string]string{v1.InjectPodAnnotationKey: "false"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).NotTo(HaveKey(v1.RoleProfilePodAnnotationKey))
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+
+			By("reading the default profile from a label too")
+			namespace.Annotations = nil
+			namespace.Labels = map[string]string{v1.DefaultProfileNamespaceKey: "other"}
+			defaulter = newFakeDefaulter(newTestProfile("other"), namespace)
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.RoleProfilePodAnnotationKey)
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "other"))
+		})
+
+		It("Should inject pods selected by a profile and reject pods selected by several", func() {
+			web := newTestProfile("web")
+			web.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			jobs := newTestProfile("jobs")
+			jobs.Spec.ServiceAccountNames = []string{"batch"}
+			defaulter = newFakeDefaulter(web, jobs)
+			newPod := func(labels map[string]string, serviceAccount string) *corev1.Pod {
+				pod := newAnnotatedPod(corev1.Container{Name: "app"})
+				delete(pod.Annotations, v1.RoleProfilePodAnnotationKey)
+				pod.Labels = labels
+				pod.Spec.ServiceAccountName = serviceAccount
+				return pod
+			}
+
+			pod := newPod(map[string]string{"app": "web"}, "")
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "web"))
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+
+			pod = newPod(map[string]string{"app": "worker"}, "batch")
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "jobs"))
+
+			pod = newPod(map[string]string{"app": "worker"}, "")
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+
+			By("leaving pods that name a profile to it")
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "jobs"
+			pod.Labels = map[string]string{"app": "web"}
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations).To(HaveKeyWithValue(v1.RoleProfilePodAnnotationKey, "jobs"))
+
+			By("rejecting pods selected by both profiles")
+			pod = newPod(map[string]string{"app": "web"}, "batch")
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("selected by profiles jobs and web")))
+		})
+
+		It("Should only admit pods of the service accounts a profile allows", func() {
+			profile := newTestProfile()
+			profile.Spec.AllowedServiceAccounts = &v1.AllowedServiceAccounts{
+				Names:    []string{"web"},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			}
+			labeled := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name: "billing", Namespace: "default", Labels: map[string]string{"team": "payments"},
+			}}
+			unlabeled := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"}}
+			defaulter = newFakeDefaulter(profile, labeled, unlabeled)
+			newPod := func(serviceAccount string) *corev1.Pod {
+				pod := newAnnotatedPod(corev1.Container{Name: "app"})
+				pod.Spec.ServiceAccountName = serviceAccount
+				return pod
+			}
+
+			Expect(defaulter.Default(ctx, newPod("web"))).To(Succeed())
+			Expect(defaulter.Default(ctx, newPod("billing"))).To(Succeed())
+			Expect(defaulter.Default(ctx, newPod("batch"))).To(MatchError(
+				"profile test-profile doesn't allow service account batch, see its spec.allowedServiceAccounts"))
+			Expect(defaulter.Default(ctx, newPod(""))).To(MatchError(ContainSubstring("service account default")))
+		})
+
+		It("Should only admit pods whose creator or workload requester may use profiles requiring it", func() {
+			profile := newTestProfile()
+			profile.Spec.RequireUsePermission = true
+			deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+				Name: "web", Namespace: "default", UID: "uid-web",
+			}}
+			Expect(iamram.SetWorkloadRequester(deployment, &deployment.Spec.Template,
+				authenticationv1.UserInfo{Username: "alice"})).To(Succeed())
+			replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+				Name: "web-5d8f7c", Namespace: "default", UID: "uid-web-5d8f7c",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "Deployment", Name: "web", UID: "uid-web", Controller: ptr.To(true),
+				}},
+			}}
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", UID: "uid-backup"}}
+			Expect(iamram.SetWorkloadRequester(job, &job.Spec.Template,
+				authenticationv1.UserInfo{Username: "mallory"})).To(Succeed())
+			changed := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+				Name: "api", Namespace: "default", UID: "uid-api",
+			}}
+			Expect(iamram.SetWorkloadRequester(changed, &changed.Spec.Template,
+				authenticationv1.UserInfo{Username: "alice"})).To(Succeed())
+			changed.Spec.Template.Spec.ServiceAccountName = "admin"
+			defaulter = newFakeDefaulter(profile, newTestProfile("other"), deployment, replicaSet, job, changed)
+			var reviews []authorizationv1.SubjectAccessReviewSpec
+			defaulter.client = interceptor.NewClient(defaulter.client.(client.WithWatch), interceptor.Funcs{
+				Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+					review := obj.(*authorizationv1.SubjectAccessReview)
+					reviews = append(reviews, review.Spec)
+					review.Status.Allowed = review.Spec.User == "alice"
+					return nil
+				},
+			})
+			asUser := func(username string) context.Context {
+				return admission.NewContextWithRequest(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: username},
+				}})
+			}
+			ownedBy := func(apiVersion, kind, name string) *corev1.Pod {
+				pod := newAnnotatedPod(corev1.Container{Name: "app"})
+				pod.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID("uid-" + name),
+					Controller: ptr.To(true),
+				}}
+				return pod
+			}
+
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(asUser("alice"), pod)).To(Succeed())
+			Expect(reviews).To(HaveLen(1))
+			Expect(reviews[0].ResourceAttributes).To(Equal(&authorizationv1.ResourceAttributes{
+				Namespace: "default",
+				Verb:      "use",
+				Group:     "cloud.dancav.io",
+				Resource:  "awsiamraroleprofiles",
+				Name:      "test-profile",
+			}))
+
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Spec.ServiceAccountName = "trusted"
+			Expect(defaulter.Default(asUser("mallory"), pod)).To(MatchError(
+				`mallory may not use profile test-profile: grant them the "use" verb on ` +
+					`awsiamraroleprofiles/test-profile in namespace default`))
+
+			By("checking the requester of the workload for pods its controller creates")
+			controller := asUser("system:serviceaccount:kube-system:replicaset-controller")
+			Expect(defaulter.Default(controller, ownedBy("apps/v1", "ReplicaSet", "web-5d8f7c"))).To(Succeed())
+			Expect(reviews[len(reviews)-1].User).To(Equal("alice"))
+			Expect(defaulter.Default(asUser("system:serviceaccount:kube-system:job-controller"),
+				ownedBy("batch/v1", "Job", "backup"))).To(MatchError(ContainSubstring(
+				"mallory, who last changed the pod template of Job backup, may not use profile test-profile")))
+
+			By("rejecting pods of workloads that were replaced or record no requester")
+			replaced := ownedBy("apps/v1", "ReplicaSet", "web-5d8f7c")
+			replaced.OwnerReferences[0].UID = "uid-old"
+			Expect(defaulter.Default(controller, replaced)).To(MatchError(ContainSubstring("no longer exists")))
+			Expect(defaulter.Default(controller, ownedBy("v1", "ReplicationController", "web"))).
+				To(MatchError(ContainSubstring("no requester is recorded")))
+			Expect(defaulter.Default(asUser("system:serviceaccount:kube-system:deployment-controller"),
+				ownedBy("apps/v1", "Deployment", "api"))).To(MatchError(ContainSubstring(
+				"the requester recorded on Deployment api is for another pod template")))
+			Expect(defaulter.Default(controller, newAnnotatedPod(corev1.Container{Name: "app"}))).
+				To(MatchError(ContainSubstring("replicaset-controller may not use profile test-profile")))
+			Expect(defaulter.Default(asUser("mallory"), ownedBy("apps/v1", "ReplicaSet", "web-5d8f7c"))).
+				To(MatchError(ContainSubstring("mallory may not use profile test-profile")))
+
+			By("leaving profiles that don't require it alone unless the configuration does")
+			reviews = nil
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "other"
+			Expect(defaulter.Default(asUser("mallory"), pod)).To(Succeed())
+			Expect(reviews).To(BeEmpty())
+			defaulter.config.SetResource(&v1.IamRaManagerConfigSpec{RequireProfileUsePermission: ptr.To(true)})
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Annotations[v1.RoleProfilePodAnnotationKey] = "other"
+			Expect(defaulter.Default(asUser("mallory"), pod)).To(MatchError(ContainSubstring("may not use profile other")))
+		})
+
+		It("Should mount the Secret of the service account's cert-manager Certificate", func() {
+			profile := newTestProfile()
+			profile.Spec.Certificate = &v1.CertificateSpec{
+				Source:      v1.CertificateSourceCertManager,
+				CertManager: &v1.CertManagerCertificateSpec{IssuerRef: v1.IssuerReference{Name: "iamra-ca"}},
+			}
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			pod.Spec.ServiceAccountName = "builder"
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			Expect(pod.Annotations[v1.CertManagerCertificatePodAnnotationKey]).
+				To(Equal("aws-iamra-test-profile-sa-builder"))
+			Expect(pod.Spec.Volumes[0].Projected.Sources[0].Secret.Name).To(Equal("aws-iamra-test-profile-sa-builder"))
+
+			By("sharing a Certificate between the pods of a Deployment with the Workload scope")
+			profile.Spec.Certificate.CertManager.Scope = v1.CertificateScopeWorkload
+			defaulter = newFakeDefaulter(profile)
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.CertSecretPodAnnotationKey)
+			pod.Name = ""
+			pod.Labels = map[string]string{"pod-template-hash": "5d8f7c"}
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7c", Controller: ptr.To(true)},
+			}
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+			Expect(pod.Annotations[v1.CertManagerCertificatePodAnnotationKey]).
+				To(Equal("aws-iamra-test-profile-deployment-web"))
+		})
+
+		It("Should pass the chain key and PKCS#12 bundle and mount the passphrase Secret", func() {
+			profile := newTestProfile()
+			profile.Spec.Certificate = &v1.CertificateSpec{
+				ChainKey:            "chain.pem",
+				PKCS12Key:           "keystore.p12",
+				PassphraseSecretRef: &v1.SecretKeyReference{Name: "keystore-password"},
+			}
+			defaulter = newFakeDefaulter(profile)
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Command).To(ContainElements("-c", "chain.pem", "-b", "keystore.p12", "-s"))
+			Expect(sidecar.VolumeMounts).To(ContainElement(corev1.VolumeMount{
+				Name: passphraseVolumeName, ReadOnly: true, MountPath: sidecarPassphraseMountPath,
+			}))
+			var passphrase *corev1.Volume
+			for i := range pod.Spec.Volumes {
+				if pod.Spec.Volumes[i].Name == passphraseVolumeName {
+					passphrase = &pod.Spec.Volumes[i]
+				}
+			}
+			Expect(passphrase).NotTo(BeNil())
+			Expect(passphrase.Secret.SecretName).To(Equal("keystore-password"))
+			Expect(passphrase.Secret.Items).To(Equal([]corev1.KeyToPath{{Key: "passphrase", Path: "passphrase"}}))
+		})
+
+		It("Should set up the sidecar as the controller's configuration and the profile say", func() {
+			profile := newTestProfile()
+			profile.Spec.Sidecar = &v1.SidecarOverrides{
+				Resources: &corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+				},
+				LogLevel: "debug",
+			}
+			defaulter = newFakeDefaulter(profile)
+			defaulter.config.SetResource(&v1.IamRaManagerConfigSpec{Sidecar: v1.SidecarConfig{
+				Image:            "registry.example.com/iamram/sidecar:1.2.0",
+				ImagePullPolicy:  corev1.PullIfNotPresent,
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+				Resources: &corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi")},
+				},
+				Port:     10911,
+				LogLevel: "error",
+			}})
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+			Expect(defaulter.Default(ctx, pod)).To(Succeed())
+
+			sidecar := pod.Spec.InitContainers[0]
+			Expect(sidecar.Image).To(Equal("registry.example.com/iamram/sidecar:1.2.0"))
+			Expect(sidecar.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+			Expect(sidecar.Resources.Limits.Memory().String()).To(Equal("64Mi"))
+			Expect(sidecar.Command).To(ContainElements("-o", "10911", "-l", "debug"))
+			Expect(managerconfig.CheckRestricted(sidecar.SecurityContext)).To(Succeed())
+			Expect(pod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{
+				{Name: "registry"}, {Name: "mirror"},
+			}))
+			Expect(findEnv(pod.Spec.Containers[0].Env, imdsEndpointEnvVar).Value).
+				To(Equal("http://127.0.0.1:10911/"))
+
+			By("rejecting pods while no sidecar image is configured")
+			defaulter.config = managerconfig.NewStore(v1.IamRaManagerConfigSpec{})
+			pod = newAnnotatedPod(corev1.Container{Name: "app"})
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("no sidecar image is configured")))
+		})
+
+		It("Should reject profiles assigned to unknown containers", func() {
+			defaulter = newFakeDefaulter(newTestProfile("writer"))
+			pod := newAnnotatedPod(corev1.Container{Name: "app"})
+			delete(pod.Annotations, v1.RoleProfilePodAnnotationKey)
+			pod.Annotations[v1.ContainerProfilesPodAnnotationKey] = "ap=writer"
+			Expect(defaulter.Default(ctx, pod)).To(MatchError(ContainSubstring("unknown container ap")))
+		})
+	})
+
+	Context("When updating Pod under Validating Webhook", func() {
+		It("Should keep the profiles and certificate of the pod from changing", func() {
+			manager := "system:serviceaccount:aws-iamram-system:aws-iamram-controller-manager"
+			validator := PodCustomValidator{logger: logr.Discard(), manager: manager}
+			asUser := func(username string) context.Context {
+				return admission.NewContextWithRequest(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: username},
+				}})
+			}
+			oldObj = newAnnotatedPod(corev1.Container{Name: "app"})
+			oldObj.Annotations[v1.IssuedCertSecretPodAnnotationKey] = "aws-iamra-cert-abc"
+			obj = oldObj.DeepCopy()
+			obj.Labels = map[string]string{"app": "web"}
+			iamram.SetProfileHash(obj.Annotations, "test-profile", "0123456789")
+			Expect(validator.ValidateUpdate(asUser(manager), oldObj, obj)).To(BeNil())
+
+			for _, key := range []string{v1.RoleProfilePodAnnotationKey, v1.IssuedCertSecretPodAnnotationKey} {
+				changed := obj.DeepCopy()
+				changed.Annotations[key] = "admin-profile"
+				Expect(validator.ValidateUpdate(asUser(manager), oldObj, changed)).Error().
+					To(MatchError(ContainSubstring("annotation " + key + " can't be changed")))
+			}
+			added := obj.DeepCopy()
+			added.Annotations[v1.ContainerProfilesPodAnnotationKey] = "app=admin-profile"
+			Expect(validator.ValidateUpdate(asUser(manager), oldObj, added)).Error().To(HaveOccurred())
+
+			By("only letting the manager change the annotations the controller sets")
+			Expect(validator.ValidateUpdate(asUser("alice"), oldObj, obj)).Error().To(MatchError(ContainSubstring(
+				"annotation " + v1.ProfileHashesPodAnnotationKey + " is set by the controller")))
+			for _, key := range []string{v1.SuspendedProfilesPodAnnotationKey, v1.ActiveUntilPodAnnotationKey} {
+				changed := oldObj.DeepCopy()
+				changed.Annotations[key] = "test-profile"
+				Expect(validator.ValidateUpdate(asUser(manager), oldObj, changed)).To(BeNil())
+				Expect(validator.ValidateUpdate(asUser("alice"), oldObj, changed)).Error().To(HaveOccurred())
+			}
+			relabeled := oldObj.DeepCopy()
+			relabeled.Labels = map[string]string{"app": "web"}
+			Expect(validator.ValidateUpdate(asUser("alice"), oldObj, relabeled)).To(BeNil())
+		})
 	})
 
 })
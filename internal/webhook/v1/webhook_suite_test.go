@@ -125,7 +125,16 @@ var _ = BeforeSuite(func() {
 	err = SetupAwsIamRaRoleProfileWebhookWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
-	err = SetupPodWebhookWithManager(mgr)
+	err = SetupPodWebhookWithManager(mgr, PodWebhookOptions{})
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupAwsIamRaCredentialSecretWebhookWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupAwsIamRaEcrPullSecretWebhookWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupWorkloadWebhookWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:webhook
@@ -0,0 +1,204 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+)
+
+// workloadKinds are the workloads whose requester the workload webhook
+// records, and that the pod webhook looks them up on.
+var workloadKinds = map[schema.GroupKind]func() client.Object{
+	appsv1.SchemeGroupVersion.WithKind("Deployment").GroupKind():  func() client.Object { return &appsv1.Deployment{} },
+	appsv1.SchemeGroupVersion.WithKind("ReplicaSet").GroupKind():  func() client.Object { return &appsv1.ReplicaSet{} },
+	appsv1.SchemeGroupVersion.WithKind("StatefulSet").GroupKind(): func() client.Object { return &appsv1.StatefulSet{} },
+	appsv1.SchemeGroupVersion.WithKind("DaemonSet").GroupKind():   func() client.Object { return &appsv1.DaemonSet{} },
+	batchv1.SchemeGroupVersion.WithKind("Job").GroupKind():        func() client.Object { return &batchv1.Job{} },
+	batchv1.SchemeGroupVersion.WithKind("CronJob").GroupKind():    func() client.Object { return &batchv1.CronJob{} },
+}
+
+// builtInControllers are the controllers of kube-controller-manager that
+// create workloads or pods for another workload, by the name of their service
+// account in kube-system.
+var builtInControllers = sets.New(
+	"deployment-controller", "replicaset-controller", "statefulset-controller", "daemon-set-controller",
+	"job-controller", "cronjob-controller",
+)
+
+// restartAnnotationKeys are the pod template annotations the controller sets
+// to restart workloads, which leave the requester of the workload as it is.
+var restartAnnotationKeys = []string{v1.ProfileHashesPodAnnotationKey, v1.SidecarImagePodAnnotationKey}
+
+// SetupWorkloadWebhookWithManager registers the webhook for the workloads creating pods in the manager.
+func SetupWorkloadWebhookWithManager(mgr ctrl.Manager) error {
+	logger := logf.Log.WithName("workload-webhook")
+
+	for _, newWorkload := range workloadKinds {
+		if err := ctrl.NewWebhookManagedBy(mgr).For(newWorkload()).
+			WithDefaulter(&WorkloadCustomDefaulter{logger}).
+			Complete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-apps-v1-deployment,mutating=true,failurePolicy=ignore,sideEffects=None,groups=apps,resources=deployments,verbs=create;update,versions=v1,name=mdeployment-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+// +kubebuilder:webhook:path=/mutate-apps-v1-replicaset,mutating=true,failurePolicy=ignore,sideEffects=None,groups=apps,resources=replicasets,verbs=create;update,versions=v1,name=mreplicaset-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+// +kubebuilder:webhook:path=/mutate-apps-v1-statefulset,mutating=true,failurePolicy=ignore,sideEffects=None,groups=apps,resources=statefulsets,verbs=create;update,versions=v1,name=mstatefulset-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+// +kubebuilder:webhook:path=/mutate-apps-v1-daemonset,mutating=true,failurePolicy=ignore,sideEffects=None,groups=apps,resources=daemonsets,verbs=create;update,versions=v1,name=mdaemonset-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+// +kubebuilder:webhook:path=/mutate-batch-v1-job,mutating=true,failurePolicy=ignore,sideEffects=None,groups=batch,resources=jobs,verbs=create;update,versions=v1,name=mjob-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+// +kubebuilder:webhook:path=/mutate-batch-v1-cronjob,mutating=true,failurePolicy=ignore,sideEffects=None,groups=batch,resources=cronjobs,verbs=create;update,versions=v1,name=mcronjob-v1.kb.io,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded
+
+// WorkloadCustomDefaulter records the user creating or changing the pod
+// template of a workload, so that the pod webhook checks whether they may use
+// the profiles of the pods the workload's controller creates. Workloads that
+// built-in controllers create for another one, like the ReplicaSets of a
+// Deployment, record no requester: the pod webhook looks it up on the
+// workload controlling them.
+//
+// The webhook fails open, so that workloads can be written while the manager
+// is down. The hash of the pod template recorded along with the requester
+// keeps templates changed meanwhile from passing for the requester's: the pod
+// webhook, which fails closed, refuses their pods.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as it is used only for temporary operations and does not need to be deeply copied.
+type WorkloadCustomDefaulter struct {
+	logger logr.Logger
+}
+
+var _ webhook.CustomDefaulter = &WorkloadCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the workload kinds.
+func (d *WorkloadCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	if podTemplate(obj) == nil {
+		return fmt.Errorf("expected a workload object but got %T", obj)
+	}
+	workload := obj.(client.Object)
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	annotations := workload.GetAnnotations()
+	if builtInController(req.UserInfo) && metav1.GetControllerOf(workload) != nil {
+		// Built-in controllers copy the annotations of the workload they
+		// create others for, which therefore can't be told apart from one
+		// recorded by the webhook.
+		delete(annotations, v1.RequesterAnnotationKey)
+		delete(annotations, v1.RequesterTemplateAnnotationKey)
+		workload.SetAnnotations(annotations)
+		return nil
+	}
+
+	if len(req.OldObject.Raw) > 0 {
+		old := reflect.New(reflect.TypeOf(workload).Elem()).Interface().(client.Object)
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("unable to decode the object being updated: %w", err)
+		}
+		if sameTemplate(podTemplate(workload), podTemplate(old)) {
+			keepRequester(workload, old)
+			return nil
+		}
+	}
+	d.logger.Info("Recording the requester of workload", "kind", fmt.Sprintf("%T", obj), "name", workload.GetName())
+	return iamram.SetWorkloadRequester(workload, podTemplate(workload), req.UserInfo)
+}
+
+// keepRequester keeps the requester recorded on the old version of an
+// updated workload, and the pod template it was recorded for. A requester
+// recorded for the old template carries over to the new one, which only
+// differs by the controller's restart annotations, or not at all.
+func keepRequester(workload, old client.Object) {
+	annotations := workload.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	oldAnnotations := old.GetAnnotations()
+	for _, key := range []string{v1.RequesterAnnotationKey, v1.RequesterTemplateAnnotationKey} {
+		if value, ok := oldAnnotations[key]; ok {
+			annotations[key] = value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	if oldAnnotations[v1.RequesterTemplateAnnotationKey] == iamram.PodTemplateHash(podTemplate(old)) {
+		annotations[v1.RequesterTemplateAnnotationKey] = iamram.PodTemplateHash(podTemplate(workload))
+	}
+	workload.SetAnnotations(annotations)
+}
+
+// sameTemplate reports whether the pod templates only differ by the
+// controller's restart annotations.
+func sameTemplate(template, old *corev1.PodTemplateSpec) bool {
+	template, old = template.DeepCopy(), old.DeepCopy()
+	for _, key := range restartAnnotationKeys {
+		delete(template.Annotations, key)
+		delete(old.Annotations, key)
+	}
+	return equality.Semantic.DeepEqual(template, old)
+}
+
+// podTemplate returns the template of the pods the workload creates, or nil
+// if obj isn't a workload.
+func podTemplate(obj runtime.Object) *corev1.PodTemplateSpec {
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		return &workload.Spec.Template
+	case *appsv1.ReplicaSet:
+		return &workload.Spec.Template
+	case *appsv1.StatefulSet:
+		return &workload.Spec.Template
+	case *appsv1.DaemonSet:
+		return &workload.Spec.Template
+	case *batchv1.Job:
+		return &workload.Spec.Template
+	case *batchv1.CronJob:
+		return &workload.Spec.JobTemplate.Spec.Template
+	}
+	return nil
+}
+
+// builtInController reports whether the user is one of the controllers of
+// kube-controller-manager creating workloads or pods for others, with or
+// without their own service account.
+func builtInController(user authenticationv1.UserInfo) bool {
+	name, ok := strings.CutPrefix(user.Username, "system:serviceaccount:kube-system:")
+	return user.Username == "system:kube-controller-manager" || ok && builtInControllers.Has(name)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"dancav.io/aws-iamra-manager/api/v1"
+	"dancav.io/aws-iamra-manager/internal/iamram"
+)
+
+var _ = Describe("Workload Webhook", func() {
+	var (
+		deployment *appsv1.Deployment
+		defaulter  WorkloadCustomDefaulter
+	)
+
+	BeforeEach(func() {
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To[int32](2),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app:1"}},
+				}},
+			},
+		}
+		defaulter = WorkloadCustomDefaulter{logr.Discard()}
+	})
+
+	Context("When creating or updating workloads under Defaulting Webhook", func() {
+		It("Should record the user who last changed the pod template", func() {
+			Expect(defaulter.Default(asRequest("alice", nil), deployment)).To(Succeed())
+			Expect(requesterOf(deployment)).To(Equal("alice"))
+
+			By("keeping it for changes leaving the pod template alone")
+			scaled := deployment.DeepCopy()
+			scaled.Spec.Replicas = ptr.To[int32](5)
+			Expect(iamram.SetRequester(scaled, authenticationv1.UserInfo{Username: "admin"})).To(Succeed())
+			Expect(defaulter.Default(asRequest("mallory", deployment), scaled)).To(Succeed())
+			Expect(requesterOf(scaled)).To(Equal("alice"))
+
+			By("recording the user changing it")
+			changed := scaled.DeepCopy()
+			changed.Spec.Template.Spec.Containers[0].Image = "app:2"
+			Expect(defaulter.Default(asRequest("mallory", scaled), changed)).To(Succeed())
+			Expect(requesterOf(changed)).To(Equal("mallory"))
+
+			By("keeping it for the restarts of the controller")
+			restarted := changed.DeepCopy()
+			restarted.Spec.Template.Annotations = map[string]string{
+				v1.ProfileHashesPodAnnotationKey: `{"web":"1a2b"}`,
+				v1.SidecarImagePodAnnotationKey:  "sidecar:2",
+			}
+			manager := "system:serviceaccount:aws-iamram-system:aws-iamram-controller-manager"
+			Expect(defaulter.Default(asRequest(manager, changed), restarted)).To(Succeed())
+			Expect(requesterOf(restarted)).To(Equal("mallory"))
+			Expect(restarted.Annotations).To(HaveKeyWithValue(v1.RequesterTemplateAnnotationKey,
+				iamram.PodTemplateHash(&restarted.Spec.Template)))
+
+			By("keeping the pod template it was recorded for when it was changed without the webhook")
+			unseen := restarted.DeepCopy()
+			unseen.Spec.Template.Spec.ServiceAccountName = "admin"
+			restartedUnseen := unseen.DeepCopy()
+			restartedUnseen.Spec.Template.Annotations[v1.SidecarImagePodAnnotationKey] = "sidecar:3"
+			Expect(defaulter.Default(asRequest(manager, unseen), restartedUnseen)).To(Succeed())
+			Expect(requesterOf(restartedUnseen)).To(Equal("mallory"))
+			Expect(restartedUnseen.Annotations).To(HaveKeyWithValue(v1.RequesterTemplateAnnotationKey,
+				iamram.PodTemplateHash(&restarted.Spec.Template)))
+
+			cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"}}
+			Expect(defaulter.Default(asRequest("bob", nil), cronJob)).To(Succeed())
+			Expect(requesterOf(cronJob)).To(Equal("bob"))
+		})
+
+		It("Should leave the requester of workloads built-in controllers create to the workload controlling them", func() {
+			Expect(defaulter.Default(asRequest("alice", nil), deployment)).To(Succeed())
+			replicaSet := &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "web-5d8f7c",
+					Namespace:   "default",
+					Annotations: deployment.Annotations,
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1", Kind: "Deployment", Name: "web", UID: "uid-web",
+						Controller: ptr.To(true),
+					}},
+				},
+				Spec: appsv1.ReplicaSetSpec{Template: deployment.Spec.Template},
+			}
+			for _, controller := range []string{
+				"system:serviceaccount:kube-system:deployment-controller", "system:kube-controller-manager",
+			} {
+				created := replicaSet.DeepCopy()
+				Expect(requesterOf(created)).To(Equal("alice"))
+				Expect(defaulter.Default(asRequest(controller, nil), created)).To(Succeed())
+				Expect(requesterOf(created)).To(BeEmpty())
+			}
+
+			By("recording the other service accounts of kube-system creating them")
+			created := replicaSet.DeepCopy()
+			Expect(defaulter.Default(asRequest("system:serviceaccount:kube-system:mallory", nil), created)).
+				To(Succeed())
+			Expect(requesterOf(created)).To(Equal("system:serviceaccount:kube-system:mallory"))
+
+			By("recording other users creating them")
+			Expect(defaulter.Default(asRequest("mallory", nil), replicaSet)).To(Succeed())
+			Expect(requesterOf(replicaSet)).To(Equal("mallory"))
+		})
+	})
+})